@@ -0,0 +1,292 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+/*
+The admin API lets operators of a running node inspect its channels,
+connected clients and message broker via JSON-RPC. It listens on its own
+address and requires a bearer token and/or a TLS client certificate.
+*/
+
+package admin
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/hyper"
+	"github.com/kiprotect/hyper/helpers"
+	"github.com/kiprotect/hyper/jsonrpc"
+	"io/ioutil"
+	"net"
+	"strings"
+)
+
+const Path = "/admin"
+
+type Server struct {
+	settings      *hyper.AdminSettings
+	server        *helpers.Server
+	token         string
+	jsonrpcServer *jsonrpc.JSONRPCServer
+}
+
+// Reads the token from the given file, surrounding whitespace is ignored
+func ReadToken(tokenFile string) (string, error) {
+
+	data, err := ioutil.ReadFile(tokenFile)
+
+	if err != nil {
+		return "", fmt.Errorf("error reading admin token: %w", err)
+	}
+
+	token := strings.TrimSpace(string(data))
+
+	if token == "" {
+		return "", fmt.Errorf("admin token file '%s' is empty", tokenFile)
+	}
+
+	return token, nil
+}
+
+// Checks the 'Authorization' header against the expected bearer token
+func Authorized(header, token string) bool {
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(token)) == 1
+}
+
+var EmptyForm = forms.Form{
+	Fields: []forms.Field{},
+}
+
+type EmptyParams struct {
+}
+
+func (s *Server) getChannels(context *jsonrpc.Context, params *EmptyParams) *jsonrpc.Response {
+	return context.Result(s.server.ChannelStatus())
+}
+
+type ClientStatus struct {
+	Channel string `json:"channel"`
+	*hyper.ClientConnection
+}
+
+func (s *Server) getClients(context *jsonrpc.Context, params *EmptyParams) *jsonrpc.Response {
+
+	clients := make([]*ClientStatus, 0)
+
+	for _, channel := range s.server.Broker().Channels() {
+		if clientsChannel, ok := channel.(hyper.ClientsChannel); ok {
+			for _, connection := range clientsChannel.ClientConnections() {
+				clients = append(clients, &ClientStatus{
+					Channel:          channel.Name(),
+					ClientConnection: connection,
+				})
+			}
+		}
+	}
+
+	return context.Result(clients)
+}
+
+var DisconnectClientForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "operator",
+			Validators: []forms.Validator{
+				forms.IsString{},
+			},
+		},
+		{
+			// if omitted we disconnect the operator from all channels
+			Name: "channel",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+	},
+}
+
+type DisconnectClientParams struct {
+	Operator string `json:"operator"`
+	Channel  string `json:"channel"`
+}
+
+func (s *Server) disconnectClient(context *jsonrpc.Context, params *DisconnectClientParams) *jsonrpc.Response {
+
+	disconnected := make([]string, 0)
+
+	for _, channel := range s.server.Broker().Channels() {
+		if params.Channel != "" && channel.Name() != params.Channel {
+			continue
+		}
+		if clientsChannel, ok := channel.(hyper.ClientsChannel); ok {
+			if clientsChannel.DisconnectClient(params.Operator) {
+				disconnected = append(disconnected, channel.Name())
+			}
+		}
+	}
+
+	if len(disconnected) == 0 {
+		return context.NotFound()
+	}
+
+	hyper.Log.Infof("Disconnected operator '%s' via the admin API", params.Operator)
+
+	return context.Result(map[string]interface{}{"channels": disconnected})
+}
+
+type DirectoryStatus struct {
+	Name        string `json:"name"`
+	Refreshable bool   `json:"refreshable"`
+	Tip         string `json:"tip,omitempty"`
+}
+
+func (s *Server) directoryStatus() *DirectoryStatus {
+
+	directory := s.server.Directory().Current()
+
+	status := &DirectoryStatus{
+		Name: directory.Name(),
+	}
+
+	if refreshableDirectory, ok := directory.(hyper.RefreshableDirectory); ok {
+		status.Refreshable = true
+		status.Tip = refreshableDirectory.LoadedTip()
+	}
+
+	return status
+}
+
+func (s *Server) getDirectory(context *jsonrpc.Context, params *EmptyParams) *jsonrpc.Response {
+	return context.Result(s.directoryStatus())
+}
+
+func (s *Server) refreshDirectory(context *jsonrpc.Context, params *EmptyParams) *jsonrpc.Response {
+
+	refreshableDirectory, ok := s.server.Directory().Current().(hyper.RefreshableDirectory)
+
+	if !ok {
+		return context.Error(400, "directory cannot be refreshed", nil)
+	}
+
+	if err := refreshableDirectory.Refresh(); err != nil {
+		hyper.Log.Error(err)
+		return context.Error(500, "error refreshing directory", err.Error())
+	}
+
+	return context.Result(s.directoryStatus())
+}
+
+func (s *Server) getStats(context *jsonrpc.Context, params *EmptyParams) *jsonrpc.Response {
+	return context.Result(s.server.Broker().Stats())
+}
+
+// checks the bearer token before passing the request on
+func (s *Server) authenticate(handler jsonrpc.Handler) jsonrpc.Handler {
+	return func(context *jsonrpc.Context) *jsonrpc.Response {
+		if s.token != "" && !Authorized(context.HTTPContext.Request.Header.Get("Authorization"), s.token) {
+			return context.Error(401, "unauthorized", nil)
+		}
+		return handler(context)
+	}
+}
+
+func MakeServer(settings *hyper.AdminSettings, server *helpers.Server) (*Server, error) {
+
+	s := &Server{
+		settings: settings,
+		server:   server,
+	}
+
+	if settings.TokenFile != "" {
+		token, err := ReadToken(settings.TokenFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.token = token
+	}
+
+	// we never expose the admin API without authentication
+	if s.token == "" && (settings.TLS == nil || !settings.TLS.VerifyClient) {
+		return nil, fmt.Errorf("the admin API requires a token file or TLS with client verification")
+	}
+
+	methods := map[string]*jsonrpc.Method{
+		"getChannels": {
+			Form:    &EmptyForm,
+			Handler: s.getChannels,
+		},
+		"getClients": {
+			Form:    &EmptyForm,
+			Handler: s.getClients,
+		},
+		"disconnectClient": {
+			Form:    &DisconnectClientForm,
+			Handler: s.disconnectClient,
+		},
+		"getDirectory": {
+			Form:    &EmptyForm,
+			Handler: s.getDirectory,
+		},
+		"refreshDirectory": {
+			Form:    &EmptyForm,
+			Handler: s.refreshDirectory,
+		},
+		"getStats": {
+			Form:    &EmptyForm,
+			Handler: s.getStats,
+		},
+	}
+
+	handler, err := jsonrpc.MethodsHandler(methods)
+
+	if err != nil {
+		return nil, err
+	}
+
+	jsonrpcServer, err := jsonrpc.MakeJSONRPCServer(&jsonrpc.JSONRPCServerSettings{
+		BindAddress: settings.BindAddress,
+		TLS:         settings.TLS,
+		Path:        Path,
+	}, s.authenticate(handler))
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.jsonrpcServer = jsonrpcServer
+
+	return s, nil
+}
+
+// serves the admin API on the given listener instead of the bind address
+func (s *Server) SetListener(listener net.Listener) {
+	s.jsonrpcServer.HTTPServer().SetListener(listener)
+}
+
+func (s *Server) Start() error {
+	hyper.Log.Infof("Serving the admin API on %s...", s.settings.BindAddress)
+	return s.jsonrpcServer.Start()
+}
+
+func (s *Server) Stop() error {
+	return s.jsonrpcServer.Stop()
+}
@@ -0,0 +1,318 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/hyper"
+	"github.com/kiprotect/hyper/helpers"
+	"github.com/kiprotect/hyper/jsonrpc"
+	th "github.com/kiprotect/hyper/testing"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAuthorized(t *testing.T) {
+
+	for _, header := range []string{"", "secret", "Bearer", "Bearer secre", "Bearer secrets", "Basic secret"} {
+		if Authorized(header, "secret") {
+			t.Fatalf("expected header '%s' to be rejected", header)
+		}
+	}
+
+	if !Authorized("Bearer secret", "secret") {
+		t.Fatalf("expected the token to be accepted")
+	}
+}
+
+func TestMakeServerRequiresAuthentication(t *testing.T) {
+	if _, err := MakeServer(&hyper.AdminSettings{BindAddress: hyper.DefaultAdminBindAddress}, nil); err == nil {
+		t.Fatalf("expected an error for an admin API without authentication")
+	}
+}
+
+// a directory whose tip changes with every refresh
+type refreshableDirectory struct {
+	*th.MemoryDirectory
+	refreshes int
+	mutex     sync.Mutex
+}
+
+func (d *refreshableDirectory) Refresh() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.refreshes++
+	return nil
+}
+
+func (d *refreshableDirectory) LoadedTip() string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return fmt.Sprintf("tip-%d", d.refreshes)
+}
+
+// a channel that other operators are connected to
+type clientsChannel struct {
+	*th.MemoryChannel
+	clients map[string]time.Time
+	mutex   sync.Mutex
+}
+
+func (c *clientsChannel) ClientConnections() []*hyper.ClientConnection {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	connections := make([]*hyper.ClientConnection, 0, len(c.clients))
+	for operator, connectedAt := range c.clients {
+		connections = append(connections, &hyper.ClientConnection{Operator: operator, ConnectedAt: connectedAt})
+	}
+	return connections
+}
+
+func (c *clientsChannel) DisconnectClient(operator string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.clients[operator]; !ok {
+		return false
+	}
+	delete(c.clients, operator)
+	return true
+}
+
+type adminFixture struct {
+	directory *refreshableDirectory
+	channel   *clientsChannel
+	endpoint  string
+	client    *jsonrpc.Client
+}
+
+// calls the method and decodes the result, or returns the error
+func (f *adminFixture) call(t *testing.T, method string, params map[string]interface{}, result interface{}) *jsonrpc.Error {
+
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+
+	response, err := f.client.Call(jsonrpc.MakeRequest(method, "", params))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Error != nil {
+		return response.Error
+	}
+
+	if data, err := json.Marshal(response.Result); err != nil {
+		t.Fatal(err)
+	} else if err := json.Unmarshal(data, result); err != nil {
+		t.Fatal(err)
+	}
+
+	return nil
+}
+
+// Starts a node with a channel that op-2 and op-3 are connected to, and the
+// admin API for it
+func startAdmin(t *testing.T) *adminFixture {
+
+	fixture := &adminFixture{
+		directory: &refreshableDirectory{MemoryDirectory: th.MakeMemoryDirectory("op-1", th.Entries("op-1", "op-2", "op-3")...)},
+		channel: &clientsChannel{
+			MemoryChannel: th.MakeMemoryChannel(func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+				return &hyper.Response{ID: &address.ID, Result: map[string]interface{}{}}, nil
+			}, "op-2", "op-3"),
+			clients: map[string]time.Time{"op-2": time.Now(), "op-3": time.Now()},
+		},
+	}
+
+	server, err := helpers.MakeServer(&hyper.Settings{
+		Name: "op-1",
+		Definitions: &hyper.Definitions{
+			DirectoryDefinitions: hyper.DirectoryDefinitions{
+				"memory": {
+					Maker: func(name string, settings interface{}) (hyper.Directory, error) {
+						return fixture.directory, nil
+					},
+				},
+			},
+			ChannelDefinitions: hyper.ChannelDefinitions{
+				"clients": {
+					Maker: func(settings interface{}) (hyper.Channel, error) {
+						return fixture.channel, nil
+					},
+				},
+			},
+		},
+		Directory: &hyper.DirectorySettings{Type: "memory"},
+		Channels:  []*hyper.ChannelSettings{{Name: "clients", Type: "clients"}},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { server.Shutdown() })
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	adminServer, err := MakeServer(&hyper.AdminSettings{BindAddress: "127.0.0.1:0", TokenFile: tokenFile}, server)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminServer.SetListener(listener)
+
+	if err := adminServer.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { adminServer.Stop() })
+
+	fixture.endpoint = fmt.Sprintf("http://%s%s", listener.Addr(), Path)
+	fixture.client = jsonrpc.MakeClient(&jsonrpc.JSONRPCClientSettings{Endpoint: fixture.endpoint, Token: "secret"})
+
+	return fixture
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+
+	fixture := startAdmin(t)
+
+	fixture.client = jsonrpc.MakeClient(&jsonrpc.JSONRPCClientSettings{Endpoint: fixture.endpoint, Token: "wrong"})
+
+	if err := fixture.call(t, "getStats", nil, nil); err == nil || err.Code != 401 {
+		t.Fatalf("expected the request to be rejected")
+	}
+}
+
+func TestGetChannels(t *testing.T) {
+
+	fixture := startAdmin(t)
+
+	var channels []*helpers.ChannelStatus
+
+	if err := fixture.call(t, "getChannels", nil, &channels); err != nil {
+		t.Fatalf("unexpected error: %s", err.Message)
+	}
+
+	if len(channels) != 1 || channels[0].Name != "clients" || channels[0].Type != "memory" || channels[0].State != "open" {
+		t.Fatalf("unexpected channels: %v", channels)
+	}
+
+	if channels[0].Clients == nil || *channels[0].Clients != 2 {
+		t.Fatalf("expected two connected clients")
+	}
+}
+
+func TestGetAndDisconnectClients(t *testing.T) {
+
+	fixture := startAdmin(t)
+
+	clients := func() map[string]string {
+		var statuses []map[string]interface{}
+		if err := fixture.call(t, "getClients", nil, &statuses); err != nil {
+			t.Fatalf("unexpected error: %s", err.Message)
+		}
+		clients := map[string]string{}
+		for _, status := range statuses {
+			clients[status["operator"].(string)] = status["channel"].(string)
+		}
+		return clients
+	}
+
+	if connected := clients(); len(connected) != 2 || connected["op-2"] != "clients" || connected["op-3"] != "clients" {
+		t.Fatalf("unexpected clients: %v", connected)
+	}
+
+	var result struct {
+		Channels []string `json:"channels"`
+	}
+
+	if err := fixture.call(t, "disconnectClient", map[string]interface{}{"operator": "op-2", "channel": "clients"}, &result); err != nil {
+		t.Fatalf("unexpected error: %s", err.Message)
+	} else if len(result.Channels) != 1 || result.Channels[0] != "clients" {
+		t.Fatalf("unexpected result: %v", result)
+	}
+
+	if connected := clients(); len(connected) != 1 || connected["op-3"] == "" {
+		t.Fatalf("expected op-2 to be disconnected, got %v", connected)
+	}
+
+	// the operator isn't connected anymore
+	if err := fixture.call(t, "disconnectClient", map[string]interface{}{"operator": "op-2"}, &result); err == nil || err.Code != 404 {
+		t.Fatalf("expected the operator not to be found")
+	}
+
+	// op-3 isn't connected to the given channel
+	if err := fixture.call(t, "disconnectClient", map[string]interface{}{"operator": "op-3", "channel": "other"}, &result); err == nil || err.Code != 404 {
+		t.Fatalf("expected the operator not to be found")
+	}
+}
+
+func TestRefreshDirectory(t *testing.T) {
+
+	fixture := startAdmin(t)
+
+	var status DirectoryStatus
+
+	if err := fixture.call(t, "getDirectory", nil, &status); err != nil {
+		t.Fatalf("unexpected error: %s", err.Message)
+	} else if status.Name != "op-1" || !status.Refreshable || status.Tip != "tip-0" {
+		t.Fatalf("unexpected directory status: %+v", status)
+	}
+
+	if err := fixture.call(t, "refreshDirectory", nil, &status); err != nil {
+		t.Fatalf("unexpected error: %s", err.Message)
+	} else if status.Tip != "tip-1" {
+		t.Fatalf("expected the tip to change, got %+v", status)
+	}
+}
+
+func TestGetStats(t *testing.T) {
+
+	fixture := startAdmin(t)
+
+	var stats hyper.BrokerStats
+
+	if err := fixture.call(t, "getStats", nil, &stats); err != nil {
+		t.Fatalf("unexpected error: %s", err.Message)
+	}
+
+	if stats.Channels != 1 || stats.Draining || stats.RequestsInFlight != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,102 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+import (
+	"time"
+)
+
+const (
+	AuditEntryType uint8 = 3
+)
+
+type AuditSettings struct {
+	Datastore *DatastoreSettings `json:"datastore"`
+}
+
+// describes the outcome of a single brokered request
+type AuditRecord struct {
+	RequestID string       `json:"request_id"`
+	Caller    string       `json:"caller"`
+	Callee    string       `json:"callee"`
+	Method    string       `json:"method"`
+	Code      int          `json:"code"`
+	Error     string       `json:"error,omitempty"`
+	StartedAt HashableTime `json:"started_at"`
+	// duration of the delivery in seconds
+	Duration     float64 `json:"duration"`
+	ParamsDigest string  `json:"params_digest"`
+	// the signatures of the operators that sent the request and the response
+	RequestSignature  *Signature `json:"request_signature,omitempty"`
+	ResponseSignature *Signature `json:"response_signature,omitempty"`
+}
+
+// An audit record that is chained to its predecessor via the parent hash,
+// the hash covers all other fields so that records cannot be altered,
+// removed or reordered without breaking the chain
+type AuditChainRecord struct {
+	Index      int64        `json:"index"`
+	ParentHash string       `json:"parent_hash"`
+	Hash       string       `json:"hash"`
+	Record     *AuditRecord `json:"record"`
+}
+
+type AuditLog interface {
+	// Appends a record for the given request to the log
+	Append(request *Request, record *AuditRecord) error
+}
+
+// Enables auditing of all requests passing through the broker
+func (b *BasicMessageBroker) SetAuditLog(auditLog AuditLog) {
+	b.auditLog = auditLog
+}
+
+func (b *BasicMessageBroker) audit(address *Address, request *Request, clientInfo *ClientInfo, startedAt time.Time, response *Response, err error) {
+
+	callee := address.Operator
+
+	if address.Group != "" {
+		callee = GroupPrefix + address.Group
+	}
+
+	record := &AuditRecord{
+		RequestID:        request.ID,
+		Caller:           clientInfo.Name,
+		Callee:           callee,
+		Method:           address.Method,
+		StartedAt:        HashableTime{startedAt},
+		Duration:         time.Since(startedAt).Seconds(),
+		RequestSignature: request.Signature,
+	}
+
+	if response != nil {
+		record.ResponseSignature = response.Signature
+	}
+
+	if err != nil {
+		// this isn't a JSON-RPC code but it can't be confused with one
+		record.Code = -1
+		record.Error = err.Error()
+	} else if response != nil && response.Error != nil {
+		record.Code = response.Error.Code
+		record.Error = response.Error.Message
+	}
+
+	if err := b.auditLog.Append(request, record); err != nil {
+		Log.Errorf("Error writing audit record for request %s: %v", request.ID, err)
+	}
+}
@@ -0,0 +1,391 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+import (
+	"container/list"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	CacheEntryType             uint8 = 4
+	CacheInvalidationEntryType uint8 = 5
+)
+
+// Published by operators for methods whose responses may be reused
+type CacheHints struct {
+	// seconds for which a response may be reused
+	TTL int64 `json:"ttl"`
+	// whether callers may share responses, otherwise the cache key includes
+	// the caller
+	Shared bool `json:"shared"`
+}
+
+type ResponseCacheSettings struct {
+	// optional, without it responses are only kept in memory
+	Datastore  *DatastoreSettings `json:"datastore"`
+	MaxEntries int64              `json:"max_entries"`
+}
+
+type ResponseCacheEntry struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Method   string `json:"method"`
+	// we store the serialized result so that callers can't modify it
+	Result    json.RawMessage `json:"result"`
+	ExpiresAt time.Time       `json:"expires_at"`
+}
+
+// Removes the cached responses of the given operator and method, empty
+// values match all operators or methods
+type ResponseCacheInvalidation struct {
+	Operator string `json:"operator"`
+	Method   string `json:"method"`
+}
+
+func (i *ResponseCacheInvalidation) matches(entry *ResponseCacheEntry) bool {
+	return (i.Operator == "" || i.Operator == entry.Operator) && (i.Method == "" || i.Method == entry.Method)
+}
+
+type CacheStats struct {
+	Entries int    `json:"entries"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+}
+
+// A bounded cache for the responses of cacheable methods of other operators
+type ResponseCache struct {
+	settings  *ResponseCacheSettings
+	datastore Datastore
+	entries   map[string]*list.Element
+	// least recently used entries are at the back
+	lru *list.List
+	// the number of records in the datastore
+	records int
+	hits    uint64
+	misses  uint64
+	mutex   sync.Mutex
+}
+
+// The datastore is optional, without it entries are only kept in memory
+func MakeResponseCache(settings *ResponseCacheSettings, datastore Datastore) (*ResponseCache, error) {
+
+	cache := &ResponseCache{
+		settings:  settings,
+		datastore: datastore,
+		entries:   make(map[string]*list.Element),
+		lru:       list.New(),
+	}
+
+	if datastore != nil {
+
+		if err := datastore.Init(); err != nil {
+			return nil, fmt.Errorf("error initializing response cache datastore: %w", err)
+		}
+
+		if err := cache.load(); err != nil {
+			return nil, fmt.Errorf("error loading response cache entries: %w", err)
+		}
+	}
+
+	return cache, nil
+}
+
+// Returns the cache key for a call, the client info that the broker adds to
+// the parameters is not part of it. An empty caller means that the response
+// is shared by all callers.
+func ResponseCacheKey(operator, method, caller string, params map[string]interface{}) (string, error) {
+
+	filteredParams := make(map[string]interface{}, len(params))
+
+	for k, v := range params {
+		if k == "_client" {
+			continue
+		}
+		filteredParams[k] = v
+	}
+
+	// map keys are sorted, so equal parameters give the same data
+	data, err := json.Marshal([]interface{}{operator, method, caller, filteredParams})
+
+	if err != nil {
+		return "", fmt.Errorf("error serializing cache key: %w", err)
+	}
+
+	hash := sha256.Sum256(data)
+
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// later entries and invalidations replace earlier ones
+func (c *ResponseCache) load() error {
+
+	dataEntries, err := c.datastore.Read()
+
+	if err != nil {
+		return err
+	}
+
+	c.records = len(dataEntries)
+
+	for _, dataEntry := range dataEntries {
+		switch dataEntry.Type {
+		case CacheEntryType:
+			entry := &ResponseCacheEntry{}
+			if err := json.Unmarshal(dataEntry.Data, entry); err != nil {
+				return err
+			}
+			c.add(entry)
+		case CacheInvalidationEntryType:
+			invalidation := &ResponseCacheInvalidation{}
+			if err := json.Unmarshal(dataEntry.Data, invalidation); err != nil {
+				return err
+			}
+			c.invalidate(invalidation)
+		}
+	}
+
+	Log.Debugf("Loaded %d response cache entries", c.lru.Len())
+
+	// we don't want to replay the same history on the next start
+	c.compact()
+
+	return nil
+}
+
+func cacheDataEntry(entryType uint8, value interface{}) (*DataEntry, error) {
+
+	data, err := json.Marshal(value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	return &DataEntry{
+		Type: entryType,
+		ID:   id,
+		Data: data,
+	}, nil
+}
+
+// writes the entry or invalidation to the datastore, needs to be called with
+// the mutex held
+func (c *ResponseCache) persist(entryType uint8, value interface{}) error {
+
+	dataEntry, err := cacheDataEntry(entryType, value)
+
+	if err != nil {
+		return err
+	}
+
+	if err := c.datastore.Write(dataEntry); err != nil {
+		return err
+	}
+
+	c.records++
+
+	return nil
+}
+
+// replaced, evicted, expired and invalidated entries stay in the datastore,
+// so we replace its contents with the current entries from time to time.
+// Needs to be called with the mutex held.
+func (c *ResponseCache) compact() {
+
+	datastore, ok := c.datastore.(CompactableDatastore)
+
+	if !ok {
+		return
+	}
+
+	if c.records <= 2*c.lru.Len()+CompactionSlack {
+		return
+	}
+
+	now := time.Now()
+
+	// expired entries are only removed when they are retrieved, so we drop
+	// them here as well
+	for element := c.lru.Back(); element != nil; {
+		previous := element.Prev()
+		if entry := element.Value.(*ResponseCacheEntry); now.After(entry.ExpiresAt) {
+			c.lru.Remove(element)
+			delete(c.entries, entry.Key)
+		}
+		element = previous
+	}
+
+	dataEntries := make([]*DataEntry, 0, c.lru.Len())
+
+	// we write the least recently used entries first, so that they are
+	// evicted first when we load the datastore again
+	for element := c.lru.Back(); element != nil; element = element.Prev() {
+		if dataEntry, err := cacheDataEntry(CacheEntryType, element.Value.(*ResponseCacheEntry)); err != nil {
+			Log.Errorf("Error serializing response cache entry: %v", err)
+			return
+		} else {
+			dataEntries = append(dataEntries, dataEntry)
+		}
+	}
+
+	if err := datastore.Compact(dataEntries); err != nil {
+		Log.Errorf("Error compacting response cache datastore: %v", err)
+		return
+	}
+
+	Log.Debugf("Compacted response cache datastore from %d to %d records", c.records, len(dataEntries))
+
+	c.records = len(dataEntries)
+}
+
+func (c *ResponseCache) add(entry *ResponseCacheEntry) {
+
+	if time.Now().After(entry.ExpiresAt) {
+		return
+	}
+
+	if element, ok := c.entries[entry.Key]; ok {
+		c.lru.Remove(element)
+	}
+
+	c.entries[entry.Key] = c.lru.PushFront(entry)
+
+	for int64(c.lru.Len()) > c.settings.MaxEntries {
+		oldest := c.lru.Remove(c.lru.Back()).(*ResponseCacheEntry)
+		delete(c.entries, oldest.Key)
+	}
+}
+
+func (c *ResponseCache) invalidate(invalidation *ResponseCacheInvalidation) int {
+	invalidated := 0
+	for key, element := range c.entries {
+		if invalidation.matches(element.Value.(*ResponseCacheEntry)) {
+			c.lru.Remove(element)
+			delete(c.entries, key)
+			invalidated++
+		}
+	}
+	return invalidated
+}
+
+// Returns a response with the cached result for the given key, or nil
+func (c *ResponseCache) Get(key, requestID string) *Response {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+
+	if ok && time.Now().After(element.Value.(*ResponseCacheEntry).ExpiresAt) {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+		ok = false
+	}
+
+	if !ok {
+		c.misses++
+		return nil
+	}
+
+	result := make(map[string]interface{})
+
+	if err := json.Unmarshal(element.Value.(*ResponseCacheEntry).Result, &result); err != nil {
+		// this should not happen as we serialized the result ourselves
+		Log.Errorf("Error deserializing cached result: %v", err)
+		c.misses++
+		return nil
+	}
+
+	c.hits++
+	c.lru.MoveToFront(element)
+
+	return &Response{Result: result, ID: &requestID}
+}
+
+// Stores the result of the response for the given time
+func (c *ResponseCache) Store(key, operator, method string, response *Response, ttl time.Duration) error {
+
+	result, err := json.Marshal(response.Result)
+
+	if err != nil {
+		return fmt.Errorf("error serializing result: %w", err)
+	}
+
+	entry := &ResponseCacheEntry{
+		Key:       key,
+		Operator:  operator,
+		Method:    method,
+		Result:    result,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.add(entry)
+
+	if c.datastore != nil {
+		if err := c.persist(CacheEntryType, entry); err != nil {
+			return err
+		}
+		c.compact()
+	}
+
+	return nil
+}
+
+// Removes the matching entries and returns how many there were
+func (c *ResponseCache) Invalidate(invalidation *ResponseCacheInvalidation) (int, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	invalidated := c.invalidate(invalidation)
+
+	// we also persist the invalidation so that the entries don't come back
+	// when we load the datastore again
+	if c.datastore != nil {
+		if err := c.persist(CacheInvalidationEntryType, invalidation); err != nil {
+			return invalidated, err
+		}
+		c.compact()
+	}
+
+	return invalidated, nil
+}
+
+func (c *ResponseCache) Stats() *CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return &CacheStats{
+		Entries: c.lru.Len(),
+		Hits:    c.hits,
+		Misses:  c.misses,
+	}
+}
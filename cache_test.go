@@ -0,0 +1,265 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/kiprotect/hyper"
+	th "github.com/kiprotect/hyper/testing"
+	"testing"
+	"time"
+)
+
+func TestResponseCacheKey(t *testing.T) {
+
+	key := func(caller string, params map[string]interface{}) string {
+		if key, err := hyper.ResponseCacheKey("op-1", "lookup", caller, params); err != nil {
+			t.Fatal(err)
+			return ""
+		} else {
+			return key
+		}
+	}
+
+	if key("", map[string]interface{}{"a": 1}) != key("", map[string]interface{}{"a": 1, "_client": map[string]interface{}{"name": "op-2"}}) {
+		t.Fatalf("expected the client info to be ignored")
+	}
+
+	if key("", map[string]interface{}{"a": 1}) == key("op-2", map[string]interface{}{"a": 1}) {
+		t.Fatalf("expected the caller to be part of the key")
+	}
+
+	if key("", map[string]interface{}{"a": 1}) == key("", map[string]interface{}{"a": 2}) {
+		t.Fatalf("expected the parameters to be part of the key")
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+
+	datastore := &th.MemoryDatastore{}
+
+	cache, err := hyper.MakeResponseCache(&hyper.ResponseCacheSettings{MaxEntries: 2}, datastore)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := func(key, method string, ttl time.Duration) {
+		if err := cache.Store(key, "op-1", method, &hyper.Response{Result: map[string]interface{}{"key": key}}, ttl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store("a", "lookup", time.Minute)
+	store("b", "config", time.Minute)
+
+	if response := cache.Get("a", "id"); response == nil || response.Result["key"] != "a" || *response.ID != "id" {
+		t.Fatalf("expected the cached response")
+	}
+
+	// 'b' is the least recently used entry
+	store("c", "lookup", time.Minute)
+
+	if cache.Get("b", "id") != nil {
+		t.Fatalf("expected the least recently used entry to be evicted")
+	}
+
+	store("d", "config", -time.Second)
+
+	if cache.Get("d", "id") != nil {
+		t.Fatalf("expected an expired entry to be ignored")
+	}
+
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	if invalidated, err := cache.Invalidate(&hyper.ResponseCacheInvalidation{Method: "lookup"}); err != nil {
+		t.Fatal(err)
+	} else if invalidated != 2 {
+		t.Fatalf("expected two invalidated entries, got %d", invalidated)
+	}
+
+	store("e", "config", time.Minute)
+
+	// invalidations are persisted as well
+	loadedCache, err := hyper.MakeResponseCache(&hyper.ResponseCacheSettings{MaxEntries: 10}, datastore)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loadedCache.Get("a", "id") != nil || loadedCache.Get("c", "id") != nil {
+		t.Fatalf("expected invalidated entries to stay invalidated")
+	}
+
+	if loadedCache.Get("e", "id") == nil {
+		t.Fatalf("expected the entry to be loaded from the datastore")
+	}
+}
+
+func TestResponseCacheCompaction(t *testing.T) {
+
+	settings := &hyper.ResponseCacheSettings{MaxEntries: 2}
+	datastore := &th.MemoryDatastore{}
+
+	cache, err := hyper.MakeResponseCache(settings, datastore)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// most of these entries get evicted right away
+	for i := 0; i < 2*hyper.CompactionSlack; i++ {
+		key := fmt.Sprintf("%d", i)
+		if err := cache.Store(key, "op-1", "lookup", &hyper.Response{Result: map[string]interface{}{"key": key}}, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dataEntries, err := datastore.Read()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dataEntries) > 2*int(settings.MaxEntries)+hyper.CompactionSlack {
+		t.Fatalf("expected the datastore to be compacted, got %d records", len(dataEntries))
+	}
+
+	reloadedCache, err := hyper.MakeResponseCache(settings, datastore)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reloadedCache.Get("0", "id") != nil {
+		t.Fatalf("expected evicted entries to be dropped")
+	}
+
+	last := fmt.Sprintf("%d", 2*hyper.CompactionSlack-1)
+
+	if reloadedCache.Get(last, "id") == nil {
+		t.Fatalf("expected the latest entry to survive the compaction")
+	}
+}
+
+func TestBrokerCachesResponses(t *testing.T) {
+
+	method := func(name string, shared bool) *hyper.ServiceMethod {
+		return &hyper.ServiceMethod{Name: name, Cache: &hyper.CacheHints{TTL: 60, Shared: shared}}
+	}
+
+	services := []*hyper.OperatorService{
+		{
+			Name:        "data",
+			Permissions: []*hyper.Permission{{Group: "*", Rights: []string{"call"}}},
+			Methods:     []*hyper.ServiceMethod{method("countries", true), method("profile", false), method("fail", true), method("list", true)},
+		},
+	}
+
+	// op-3 may send its calls to op-2 through us
+	broker, channel := th.MakeMemoryBroker(t, th.MakeMemoryDirectory("op-1",
+		&hyper.DirectoryEntry{Name: "op-1", Services: services},
+		&hyper.DirectoryEntry{Name: "op-2", Services: services},
+		&hyper.DirectoryEntry{Name: "op-3"},
+	), func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+		switch address.Method {
+		case "fail":
+			return &hyper.Response{ID: &address.ID, Error: &hyper.Error{Code: 404, Message: "not found"}}, nil
+		case "list":
+			return &hyper.Response{ID: &address.ID, Stream: th.MakeMemoryStream(context.Background(), 1)}, nil
+		}
+		client, _ := request.Params["_client"].(map[string]interface{})
+		return &hyper.Response{ID: &address.ID, Result: map[string]interface{}{"caller": client["name"]}}, nil
+	}, "op-2")
+
+	cache, err := hyper.MakeResponseCache(&hyper.ResponseCacheSettings{MaxEntries: 10}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker.SetResponseCache(cache)
+
+	call := func(caller, method string, stream bool) *hyper.Response {
+		response, err := broker.DeliverRequest(&hyper.Request{
+			ID:     fmt.Sprintf("op-2.%s(1)", method),
+			Method: "op-2." + method,
+			Params: map[string]interface{}{"country": "DE"},
+			Stream: stream,
+		}, &hyper.ClientInfo{Name: caller})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Stream != nil {
+			response.Stream.Close()
+		}
+		return response
+	}
+
+	// returns how often the channel delivered the method so far
+	deliveries := func(method string) int {
+		n := 0
+		for _, request := range channel.Requests() {
+			if request.Method == "op-2."+method {
+				n++
+			}
+		}
+		return n
+	}
+
+	for i := 0; i < 2; i++ {
+		if response := call("op-1", "countries", false); response.Error != nil || response.Result["caller"] != "op-1" {
+			t.Fatalf("expected the response of op-2, got %v", response)
+		}
+	}
+
+	if deliveries("countries") != 1 {
+		t.Fatalf("expected the second call to be answered from the cache")
+	}
+
+	// shared responses are reused for other callers
+	if response := call("op-3", "countries", false); response.Result["caller"] != "op-1" || deliveries("countries") != 1 {
+		t.Fatalf("expected the shared response to be reused")
+	}
+
+	call("op-1", "profile", false)
+	call("op-1", "profile", false)
+
+	// other responses are only reused for the same caller
+	if response := call("op-3", "profile", false); response.Result["caller"] != "op-3" || deliveries("profile") != 2 {
+		t.Fatalf("expected a separate response for every caller")
+	}
+
+	call("op-1", "fail", false)
+	call("op-1", "fail", false)
+
+	if deliveries("fail") != 2 {
+		t.Fatalf("expected error responses not to be cached")
+	}
+
+	for _, stream := range []bool{true, false} {
+		call("op-1", "list", stream)
+		call("op-1", "list", stream)
+	}
+
+	if deliveries("list") != 4 {
+		t.Fatalf("expected streamed responses not to be cached")
+	}
+}
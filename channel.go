@@ -17,9 +17,24 @@
 package hyper
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"time"
 )
 
+// Channels return this (wrapped with NotSent) if they fail before the request
+// was handed over to the recipient, e.g. because they cannot connect to it.
+// Only then can the broker try another channel without risking that the
+// recipient processes the request twice.
+var RequestNotSent = errors.New("request was not sent")
+
+// Marks an error that occurred before the request was handed over to the
+// recipient
+func NotSent(err error) error {
+	return fmt.Errorf("%w: %w", RequestNotSent, err)
+}
+
 type ChannelDefinition struct {
 	Name              string            `json:"name"`
 	Description       string            `json:"description"`
@@ -33,6 +48,12 @@ type ChannelMaker func(settings interface{}) (Channel, error)
 // A channel can deliver and accept message
 type Channel interface {
 	Type() string
+	// the name identifies the channel, it defaults to the type
+	Name() string
+	SetName(string)
+	// channels with a higher priority are tried first
+	Priority() int64
+	SetPriority(int64)
 	MessageBroker() MessageBroker
 	SetMessageBroker(MessageBroker) error
 	CanDeliverTo(*Address) bool
@@ -47,9 +68,61 @@ type ProxyChannel interface {
 	HandleConnectionRequest(address *Address, request *Request) (*Response, error)
 }
 
+// Channels that accept connections implement this to shut down gracefully
+type DrainingChannel interface {
+	// Stops accepting new connections and tells connected peers that we're
+	// going away, requests that are being handled are not affected
+	Drain() error
+	// Closes the channel once the requests that it handles are done, or
+	// right away when the context is done
+	Shutdown(ctx context.Context) error
+}
+
+// Channels that listen on a local address implement this. As the address can
+// only be bound once, such a channel has to be closed before a replacement can
+// be opened.
+type ListeningChannel interface {
+	BindAddress() string
+}
+
+// A connection that another operator opened to one of our channels
+type ClientConnection struct {
+	Operator    string    `json:"operator"`
+	ConnectedAt time.Time `json:"connected_at"`
+	// requests that we sent to the operator and that still await a response
+	PendingRequests int `json:"pending_requests"`
+}
+
+// Channels that keep the connections of other operators open implement this
+// so that they can be inspected by the admin API
+type ClientsChannel interface {
+	ClientConnections() []*ClientConnection
+	// Closes the connection of the given operator, returns false if the
+	// operator isn't connected
+	DisconnectClient(operator string) bool
+}
+
 type BaseChannel struct {
 	broker    MessageBroker
 	directory Directory
+	name      string
+	priority  int64
+}
+
+func (b *BaseChannel) Name() string {
+	return b.name
+}
+
+func (b *BaseChannel) SetName(name string) {
+	b.name = name
+}
+
+func (b *BaseChannel) Priority() int64 {
+	return b.priority
+}
+
+func (b *BaseChannel) SetPriority(priority int64) {
+	b.priority = priority
 }
 
 func (b *BaseChannel) OperatorEntry(name string) (*DirectoryEntry, error) {
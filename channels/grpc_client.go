@@ -33,6 +33,7 @@ type GRPCClientChannel struct {
 	hyper.BaseChannel
 	Settings    grpc.GRPCClientSettings
 	connections map[string]*GRPCServerConnection
+	pool        *GRPCClientPool
 	stop        chan bool
 	mutex       sync.Mutex
 }
@@ -196,6 +197,7 @@ func MakeGRPCClientChannel(settings interface{}) (hyper.Channel, error) {
 	return &GRPCClientChannel{
 		Settings:    settings.(grpc.GRPCClientSettings),
 		connections: make(map[string]*GRPCServerConnection),
+		pool:        MakeGRPCClientPool(),
 		stop:        make(chan bool),
 	}, nil
 }
@@ -212,6 +214,7 @@ func (c *GRPCClientChannel) Open() error {
 func (c *GRPCClientChannel) Close() error {
 	c.stop <- true
 	<-c.stop
+	c.pool.Close()
 	return c.closeConnections()
 }
 
@@ -287,6 +290,8 @@ func (c *GRPCClientChannel) backgroundTask() {
 			if err := c.openConnections(); err != nil {
 				hyper.Log.Error(err)
 			}
+			// we close pooled connections that haven't been used in a while
+			c.pool.Evict(time.Duration(c.Settings.PoolIdleTimeout) * time.Second)
 		}
 	}
 }
@@ -368,7 +373,9 @@ func (c *GRPCClientChannel) openConnection(address, name string) error {
 }
 
 func (c *GRPCClientChannel) HandleRequest(request *hyper.Request, clientInfo *hyper.ClientInfo) (*hyper.Response, error) {
-	return c.MessageBroker().DeliverRequest(request, clientInfo)
+	return traced(request, "grpc_client.handle", hyper.SpanKindServer, func() (*hyper.Response, error) {
+		return c.MessageBroker().DeliverRequest(request, clientInfo)
+	})
 }
 
 type RequestConnectionResponse struct {
@@ -394,31 +401,37 @@ var RequestConnectionResponseForm = forms.Form{
 }
 
 func (c *GRPCClientChannel) DeliverRequest(request *hyper.Request) (*hyper.Response, error) {
+	return traced(request, "grpc_client.deliver", hyper.SpanKindClient, func() (*hyper.Response, error) {
+		return c.deliverRequest(request)
+	})
+}
+
+func (c *GRPCClientChannel) deliverRequest(request *hyper.Request) (*hyper.Response, error) {
 
 	address, err := hyper.GetAddress(request.ID)
 
 	if err != nil {
-		return nil, fmt.Errorf("error parsing address: %w", err)
+		return nil, hyper.NotSent(fmt.Errorf("error parsing address: %w", err))
 	}
 
 	entry, err := c.DirectoryEntry(address.Operator, "grpc_server")
 
 	if err != nil {
-		return nil, err
+		return nil, hyper.NotSent(err)
 	}
 
 	if entry == nil {
-		return nil, fmt.Errorf("cannot deliver gRPC request: recipient does not have a gRPC server")
+		return nil, hyper.NotSent(fmt.Errorf("cannot deliver gRPC request: recipient does not have a gRPC server"))
 	}
 
 	if len(entry.Channels) == 0 {
-		return nil, fmt.Errorf("cannot find channel")
+		return nil, hyper.NotSent(fmt.Errorf("cannot find channel"))
 	}
 
 	settings, err := getEntrySettings(entry.Channel("grpc_server").Settings)
 
 	if err != nil {
-		return nil, fmt.Errorf("error retrieving entry settings: %w", err)
+		return nil, hyper.NotSent(fmt.Errorf("error retrieving entry settings: %w", err))
 	}
 
 	var dialer grpc.Dialer
@@ -428,7 +441,7 @@ func (c *GRPCClientChannel) DeliverRequest(request *hyper.Request) (*hyper.Respo
 		hyper.Log.Tracef("Destination is only reachable via proxy '%s'...", settings.Proxy)
 
 		if !c.Settings.UseProxy {
-			return nil, fmt.Errorf("destination is only reachable via proxy but proxying is disabled")
+			return nil, hyper.NotSent(fmt.Errorf("destination is only reachable via proxy but proxying is disabled"))
 		}
 
 		dialer = func(context context.Context, addr string) (net.Conn, error) {
@@ -463,7 +476,17 @@ func (c *GRPCClientChannel) DeliverRequest(request *hyper.Request) (*hyper.Respo
 				},
 			}
 
-			if response, err := c.MessageBroker().DeliverRequest(request, clientInfo); err != nil {
+			request.SetContext(context)
+
+			// the connection is needed for a request that we accepted, so
+			// it must not be rejected while we drain
+			deliver := c.MessageBroker().DeliverRequest
+
+			if broker, ok := c.MessageBroker().(hyper.InternalMessageBroker); ok {
+				deliver = broker.DeliverInternalRequest
+			}
+
+			if response, err := deliver(request, clientInfo); err != nil {
 				return nil, err
 			} else if response.Error != nil {
 				return nil, fmt.Errorf(response.Error.Message)
@@ -492,27 +515,67 @@ func (c *GRPCClientChannel) DeliverRequest(request *hyper.Request) (*hyper.Respo
 		}
 	}
 
-	if client, err := grpc.MakeClient(&c.Settings, dialer, c.Directory()); err != nil {
-		return nil, fmt.Errorf("error creating gRPC client: %w", err)
-	} else if err := client.Connect(settings.Address, entry.Name); err != nil {
-		return nil, fmt.Errorf("error connecting gRPC client: %w", err)
-	} else {
+	connect := func() (*grpc.Client, error) {
+		if client, err := grpc.MakeClient(&c.Settings, dialer, c.Directory()); err != nil {
+			return nil, fmt.Errorf("error creating gRPC client: %w", err)
+		} else if err := client.Connect(settings.Address, entry.Name); err != nil {
+			return nil, fmt.Errorf("error connecting gRPC client: %w", err)
+		} else {
+			return client, nil
+		}
+	}
+
+	if c.Settings.PoolIdleTimeout == 0 {
+		// pooling is disabled, we use a new connection for every request
+		client, err := connect()
+
+		if err != nil {
+			return nil, hyper.NotSent(err)
+		}
 
-		// we ensure the client will always be closed
-		defer func() {
+		// the client is closed as soon as it isn't needed anymore
+		return c.sendRequest(client, request, func() {
 			if err := client.Close(); err != nil {
 				hyper.Log.Error(err)
 			}
-		}()
+		})
+	}
 
-		response, err := client.SendRequest(request)
-		if err != nil {
-			return nil, fmt.Errorf("error sending request: %w", err)
-		}
+	// we reuse an existing connection if the connection details are unchanged
+	client, release, err := c.pool.Get(entry.Name, GRPCClientPoolKey(entry, settings), connect)
+
+	if err != nil {
+		return nil, hyper.NotSent(err)
+	}
+
+	return c.sendRequest(client, request, release)
+}
+
+// sends the request and calls 'done' as soon as the client isn't needed
+// anymore, which for streamed responses is when the stream ends
+func (c *GRPCClientChannel) sendRequest(client *grpc.Client, request *hyper.Request, done func()) (*hyper.Response, error) {
 
-		return response, nil
+	var response *hyper.Response
+	var err error
 
+	if request.Stream {
+		response, err = client.StreamRequest(request)
+	} else {
+		response, err = client.SendRequest(request)
+	}
+
+	if err != nil {
+		done()
+		return nil, fmt.Errorf("error sending request: %w", err)
 	}
+
+	if response.Stream != nil {
+		response.Stream = hyper.FinalizeStream(response.Stream, func(error) { done() })
+	} else {
+		done()
+	}
+
+	return response, nil
 }
 
 func (c *GRPCClientChannel) CanDeliverTo(address *hyper.Address) bool {
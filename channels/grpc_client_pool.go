@@ -0,0 +1,187 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package channels
+
+import (
+	"fmt"
+	"github.com/kiprotect/hyper"
+	"github.com/kiprotect/hyper/grpc"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type pooledClient struct {
+	client   *grpc.Client
+	key      string
+	users    int
+	lastUsed time.Time
+	// stale clients will be closed as soon as they are no longer in use
+	stale bool
+}
+
+func (p *pooledClient) close() {
+	if err := p.client.Close(); err != nil {
+		hyper.Log.Error(err)
+	}
+}
+
+// A connection attempt that other callers for the same operator wait for
+type pendingDial struct {
+	key  string
+	done chan struct{}
+	err  error
+}
+
+// A pool of outgoing gRPC client connections, one per operator
+type GRPCClientPool struct {
+	clients map[string]*pooledClient
+	dialing map[string]*pendingDial
+	mutex   sync.Mutex
+}
+
+func MakeGRPCClientPool() *GRPCClientPool {
+	return &GRPCClientPool{
+		clients: make(map[string]*pooledClient),
+		dialing: make(map[string]*pendingDial),
+	}
+}
+
+// Returns a key that changes whenever the connection details of the given
+// entry change, in which case we need to rebuild the connection
+func GRPCClientPoolKey(entry *hyper.DirectoryEntry, settings *GRPCServerEntrySettings) string {
+	fingerprints := make([]string, 0, len(entry.Certificates))
+	for _, certificate := range entry.Certificates {
+		if certificate.KeyUsage != "encryption" {
+			continue
+		}
+		fingerprints = append(fingerprints, certificate.Fingerprint)
+	}
+	sort.Strings(fingerprints)
+	return fmt.Sprintf("%s|%s|%s", settings.Address, settings.Proxy, strings.Join(fingerprints, ","))
+}
+
+// Returns a client for the given operator, reusing a pooled one if it is
+// healthy and its connection details are still valid. The returned function
+// needs to be called when the client is no longer used. Only one caller
+// connects to a given operator at a time, the others wait for the result.
+func (p *GRPCClientPool) Get(operator, key string, connect func() (*grpc.Client, error)) (*grpc.Client, func(), error) {
+
+	for {
+
+		p.mutex.Lock()
+
+		pc, ok := p.clients[operator]
+
+		if ok && (pc.key != key || !pc.client.Healthy()) {
+			hyper.Log.Tracef("Discarding pooled gRPC connection to '%s'...", operator)
+			// the connection details changed or the connection failed
+			p.discard(operator, pc)
+			ok = false
+		}
+
+		if ok {
+			pc.users++
+			pc.lastUsed = time.Now()
+			p.mutex.Unlock()
+			return pc.client, func() { p.release(pc) }, nil
+		}
+
+		if dial, ok := p.dialing[operator]; ok {
+			p.mutex.Unlock()
+			<-dial.done
+			// we only share the outcome of a dial with the same details
+			if dial.key == key && dial.err != nil {
+				return nil, nil, dial.err
+			}
+			continue
+		}
+
+		dial := &pendingDial{key: key, done: make(chan struct{})}
+		p.dialing[operator] = dial
+
+		p.mutex.Unlock()
+
+		// connecting may take a while, so we don't block other operators
+		client, err := connect()
+
+		p.mutex.Lock()
+
+		delete(p.dialing, operator)
+		dial.err = err
+		close(dial.done)
+
+		if err != nil {
+			p.mutex.Unlock()
+			return nil, nil, err
+		}
+
+		pc = &pooledClient{
+			client:   client,
+			key:      key,
+			users:    1,
+			lastUsed: time.Now(),
+		}
+
+		p.clients[operator] = pc
+
+		p.mutex.Unlock()
+
+		return pc.client, func() { p.release(pc) }, nil
+	}
+}
+
+func (p *GRPCClientPool) release(pc *pooledClient) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	pc.users--
+	pc.lastUsed = time.Now()
+	if pc.stale && pc.users == 0 {
+		pc.close()
+	}
+}
+
+// removes a client from the pool, closing it right away if it isn't in use
+func (p *GRPCClientPool) discard(operator string, pc *pooledClient) {
+	delete(p.clients, operator)
+	if pc.users == 0 {
+		pc.close()
+	} else {
+		pc.stale = true
+	}
+}
+
+// Closes all connections that have not been used for the given duration
+func (p *GRPCClientPool) Evict(idleTimeout time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for operator, pc := range p.clients {
+		if pc.users == 0 && time.Since(pc.lastUsed) > idleTimeout {
+			hyper.Log.Tracef("Closing idle gRPC connection to '%s'...", operator)
+			p.discard(operator, pc)
+		}
+	}
+}
+
+func (p *GRPCClientPool) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for operator, pc := range p.clients {
+		p.discard(operator, pc)
+	}
+}
@@ -0,0 +1,240 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package channels_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/kiprotect/hyper/channels"
+	"github.com/kiprotect/hyper/grpc"
+	th "github.com/kiprotect/hyper/testing"
+	"github.com/kiprotect/hyper/tls"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// returns a connect function that counts its calls, the connections never
+// complete so the clients stay healthy until they are closed
+func makeConnect(calls *int32, release chan bool) func() (*grpc.Client, error) {
+	return func() (*grpc.Client, error) {
+
+		atomic.AddInt32(calls, 1)
+
+		if release != nil {
+			<-release
+		}
+
+		client, err := grpc.MakeClient(&grpc.GRPCClientSettings{TLS: &tls.TLSSettings{}}, func(ctx context.Context, address string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}, th.MakeMemoryDirectory("op-1"))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := client.Connect("op-2:9999", "op-2"); err != nil {
+			return nil, err
+		}
+
+		return client, nil
+	}
+}
+
+func TestGRPCClientPoolReuse(t *testing.T) {
+
+	pool := channels.MakeGRPCClientPool()
+	defer pool.Close()
+
+	var calls int32
+
+	connect := makeConnect(&calls, nil)
+
+	client, release, err := pool.Get("op-2", "a", connect)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherClient, otherRelease, err := pool.Get("op-2", "a", connect)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if client != otherClient || calls != 1 {
+		t.Fatalf("expected the pooled client to be reused")
+	}
+
+	otherRelease()
+
+	// the connection details changed, so we need a new client
+	newClient, newRelease, err := pool.Get("op-2", "b", connect)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer newRelease()
+
+	if newClient == client || calls != 2 {
+		t.Fatalf("expected a new client")
+	}
+
+	// the old client stays open until it is released
+	if !client.Healthy() {
+		t.Fatalf("expected the old client to stay open while in use")
+	}
+
+	release()
+
+	if client.Healthy() {
+		t.Fatalf("expected the old client to be closed")
+	}
+}
+
+func TestGRPCClientPoolConcurrentDial(t *testing.T) {
+
+	pool := channels.MakeGRPCClientPool()
+	defer pool.Close()
+
+	var calls, otherCalls int32
+
+	dialing := make(chan bool)
+	connect := makeConnect(&calls, dialing)
+
+	var wg sync.WaitGroup
+
+	clients := make([]*grpc.Client, 5)
+
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			client, release, err := pool.Get("op-2", "a", connect)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer release()
+			clients[i] = client
+		}(i)
+	}
+
+	for i := 0; atomic.LoadInt32(&calls) == 0; i++ {
+		if i > 100 {
+			t.Fatalf("expected a connection attempt")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a slow connection doesn't block connections to other operators
+	done := make(chan error, 1)
+
+	go func() {
+		_, release, err := pool.Get("op-3", "a", makeConnect(&otherCalls, nil))
+		if err == nil {
+			release()
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the pool not to block while connecting")
+	}
+
+	close(dialing)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("expected a single connection attempt, got %d", calls)
+	}
+
+	for _, client := range clients {
+		if client == nil || client != clients[0] {
+			t.Fatalf("expected all callers to share the client")
+		}
+	}
+}
+
+func TestGRPCClientPoolDialError(t *testing.T) {
+
+	pool := channels.MakeGRPCClientPool()
+	defer pool.Close()
+
+	var calls int32
+
+	dialing := make(chan bool)
+
+	failingConnect := func() (*grpc.Client, error) {
+		atomic.AddInt32(&calls, 1)
+		<-dialing
+		return nil, fmt.Errorf("unreachable")
+	}
+
+	errors := make(chan error, 3)
+
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, _, err := pool.Get("op-2", "a", failingConnect)
+			errors <- err
+		}()
+	}
+
+	for i := 0; atomic.LoadInt32(&calls) == 0; i++ {
+		if i > 100 {
+			t.Fatalf("expected a connection attempt")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// we give the other callers time to wait for the attempt
+	time.Sleep(50 * time.Millisecond)
+
+	close(dialing)
+
+	for i := 0; i < 3; i++ {
+		if err := <-errors; err == nil {
+			t.Fatalf("expected the connection error")
+		}
+	}
+
+	if calls != 1 {
+		t.Fatalf("expected the callers to share the failed attempt, got %d", calls)
+	}
+
+	// the next caller tries again
+	_, release, err := pool.Get("op-2", "a", makeConnect(&calls, nil))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release()
+
+	if calls != 2 {
+		t.Fatalf("expected a new connection attempt")
+	}
+}
@@ -17,6 +17,7 @@
 package channels
 
 import (
+	"context"
 	"fmt"
 	"github.com/kiprotect/hyper"
 	"github.com/kiprotect/hyper/grpc"
@@ -83,7 +84,35 @@ func (c *GRPCServerChannel) HandleConnectionRequest(address *hyper.Address, requ
 }
 
 func (c *GRPCServerChannel) HandleRequest(request *hyper.Request, clientInfo *hyper.ClientInfo) (*hyper.Response, error) {
-	return c.MessageBroker().DeliverRequest(request, clientInfo)
+	return traced(request, "grpc_server.handle", hyper.SpanKindServer, func() (*hyper.Response, error) {
+		return c.MessageBroker().DeliverRequest(request, clientInfo)
+	})
+}
+
+func (c *GRPCServerChannel) ClientConnections() []*hyper.ClientConnection {
+
+	connections := make([]*hyper.ClientConnection, 0)
+
+	if c.server == nil {
+		return connections
+	}
+
+	for _, client := range c.server.ConnectedClients() {
+		connections = append(connections, &hyper.ClientConnection{
+			Operator:        client.Info.Name,
+			ConnectedAt:     client.ConnectedAt,
+			PendingRequests: client.PendingRequests(),
+		})
+	}
+
+	return connections
+}
+
+func (c *GRPCServerChannel) DisconnectClient(operator string) bool {
+	if c.server == nil {
+		return false
+	}
+	return c.server.DisconnectClient(operator)
 }
 
 type ProxyListener struct {
@@ -163,11 +192,34 @@ func (c *GRPCServerChannel) Open() error {
 }
 
 func (c *GRPCServerChannel) Close() error {
+	if c.server == nil {
+		return nil
+	}
+	return c.server.Stop()
+}
+
+func (c *GRPCServerChannel) Drain() error {
+	if c.server != nil {
+		c.server.Drain()
+	}
 	return nil
 }
 
+func (c *GRPCServerChannel) Shutdown(ctx context.Context) error {
+	if c.server == nil {
+		return nil
+	}
+	return c.server.Shutdown(ctx)
+}
+
+func (c *GRPCServerChannel) BindAddress() string {
+	return c.Settings.BindAddress
+}
+
 func (c *GRPCServerChannel) DeliverRequest(request *hyper.Request) (*hyper.Response, error) {
-	return c.server.DeliverRequest(request)
+	return traced(request, "grpc_server.deliver", hyper.SpanKindClient, func() (*hyper.Response, error) {
+		return c.server.DeliverRequest(request)
+	})
 }
 
 func (c *GRPCServerChannel) CanDeliverTo(address *hyper.Address) bool {
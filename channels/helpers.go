@@ -42,3 +42,11 @@ func parseRequestConnectionResponse(response map[string]interface{}) (*RequestCo
 		return &requestConnectionResponse, nil
 	}
 }
+
+// records a span for a delivery step of a channel
+func traced(request *hyper.Request, name string, kind hyper.SpanKind, step func() (*hyper.Response, error)) (*hyper.Response, error) {
+	span := hyper.StartSpan(request, name, kind)
+	response, err := step()
+	span.Finish(response, err)
+	return response, err
+}
@@ -22,6 +22,23 @@ import (
 	"github.com/kiprotect/hyper/jsonrpc"
 )
 
+// adapts a JSON-RPC stream reader to a response stream
+type jsonrpcStream struct {
+	reader *jsonrpc.StreamReader
+}
+
+func (j *jsonrpcStream) Next() (*hyper.Response, error) {
+	if response, err := j.reader.Next(); err != nil {
+		return nil, err
+	} else {
+		return response.ToHyperResponse(), nil
+	}
+}
+
+func (j *jsonrpcStream) Close() error {
+	return j.reader.Close()
+}
+
 type JSONRPCClientChannel struct {
 	hyper.BaseChannel
 	Settings *jsonrpc.JSONRPCClientSettings
@@ -59,6 +76,12 @@ func (c *JSONRPCClientChannel) Close() error {
 }
 
 func (c *JSONRPCClientChannel) DeliverRequest(request *hyper.Request) (*hyper.Response, error) {
+	return traced(request, "jsonrpc_client.deliver", hyper.SpanKindClient, func() (*hyper.Response, error) {
+		return c.deliverRequest(request)
+	})
+}
+
+func (c *JSONRPCClientChannel) deliverRequest(request *hyper.Request) (*hyper.Response, error) {
 
 	hyper.Log.Info("Delivering request via JSON-RPC...")
 
@@ -73,7 +96,16 @@ func (c *JSONRPCClientChannel) DeliverRequest(request *hyper.Request) (*hyper.Re
 		jsonrpcRequest.Method = groups[2]
 	}
 
-	jsonrpcResponse, err := client.Call(jsonrpcRequest)
+	if request.Stream {
+		reader, err := client.CallStream(request.Context(), jsonrpcRequest)
+		if err != nil {
+			hyper.Log.Error(err)
+			return nil, fmt.Errorf("error calling JSON-RPC server: %w", err)
+		}
+		return &hyper.Response{ID: &request.ID, Stream: &jsonrpcStream{reader: reader}}, nil
+	}
+
+	jsonrpcResponse, err := client.CallContext(request.Context(), jsonrpcRequest)
 	if err != nil {
 		hyper.Log.Error(err)
 		return nil, fmt.Errorf("error calling JSON-RPC server: %w", err)
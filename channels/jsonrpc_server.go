@@ -17,6 +17,7 @@
 package channels
 
 import (
+	"context"
 	"fmt"
 	"github.com/kiprotect/hyper"
 	"github.com/kiprotect/hyper/forms"
@@ -73,6 +74,23 @@ func (c *JSONRPCServerChannel) handler(context *jsonrpc.Context) *jsonrpc.Respon
 		return context.InternalError()
 	}
 
+	// the deadline travels with the request to the recipient
+	request.Deadline = context.Request.Deadline
+	request.IdempotencyKey = context.Request.IdempotencyKey
+	request.Stream = context.Request.Stream
+
+	if traceParent := context.Request.TraceParent; traceParent != "" {
+		if trace, err := hyper.ParseTraceParent(traceParent); err != nil {
+			hyper.Log.Warning(err)
+		} else {
+			request.Trace = trace
+		}
+	}
+
+	ctx, cancel := context.Context()
+	defer cancel()
+	request.SetContext(ctx)
+
 	// we replace the ID with an addressable ID that we can use to reconstruct
 	// the sender of the request later
 	request.ID = fmt.Sprintf("%s(%s)", request.Method, request.ID)
@@ -89,12 +107,19 @@ func (c *JSONRPCServerChannel) handler(context *jsonrpc.Context) *jsonrpc.Respon
 		clientInfo.Entry = entry
 	}
 
-	if response, err := c.MessageBroker().DeliverRequest(request, clientInfo); err != nil {
+	span := hyper.StartSpan(request, "jsonrpc_server.handle", hyper.SpanKindServer)
+	response, err := c.MessageBroker().DeliverRequest(request, clientInfo)
+	span.Finish(response, err)
+
+	if err != nil {
 		return context.Error(1, err.Error(), err)
 	} else {
 		if response == nil {
 			return context.Result(map[string]interface{}{"message": "submitted"})
 		}
+		if response.Stream != nil {
+			return context.Stream(response.Stream)
+		}
 		jsonrpcResponse := jsonrpc.FromHyperResponse(response)
 		if jsonrpcResponse.Error != nil {
 			return context.Error(jsonrpcResponse.Error.Code, jsonrpcResponse.Error.Message, jsonrpcResponse.Error.Data)
@@ -116,6 +141,19 @@ func (c *JSONRPCServerChannel) Close() error {
 	return c.Server.Stop()
 }
 
+func (c *JSONRPCServerChannel) Drain() error {
+	c.Server.Drain()
+	return nil
+}
+
+func (c *JSONRPCServerChannel) Shutdown(ctx context.Context) error {
+	return c.Server.Shutdown(ctx)
+}
+
+func (c *JSONRPCServerChannel) BindAddress() string {
+	return c.Settings.BindAddress
+}
+
 func (c *JSONRPCServerChannel) DeliverRequest(request *hyper.Request) (*hyper.Response, error) {
 	return nil, nil
 }
@@ -153,6 +153,10 @@ func (q *QUICChannel) Type() string {
 	return "quic"
 }
 
+func (q *QUICChannel) BindAddress() string {
+	return q.Settings.BindAddress
+}
+
 func (q *QUICChannel) CanDeliverTo(*hyper.Address) bool {
 	return false
 }
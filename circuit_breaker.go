@@ -0,0 +1,189 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+type CircuitBreakerSettings struct {
+	// consecutive failures after which we stop delivering to an operator
+	FailureThreshold int64 `json:"failure_threshold"`
+	// seconds after which we let a trial request through
+	OpenFor int64 `json:"open_for"`
+}
+
+type RetrySettings struct {
+	// includes the first attempt
+	MaxAttempts int64 `json:"max_attempts"`
+	// milliseconds, doubled after each attempt
+	InitialBackoff int64 `json:"initial_backoff"`
+	MaxBackoff     int64 `json:"max_backoff"`
+}
+
+// Returns the time to wait before the next attempt. We wait for at least
+// half of the exponential backoff, so that retries of concurrent requests
+// don't all hit the operator at the same time.
+func (r *RetrySettings) Backoff(attempt int64) time.Duration {
+	backoff := time.Duration(r.InitialBackoff) * time.Millisecond
+	maxBackoff := time.Duration(r.MaxBackoff) * time.Millisecond
+	for i := int64(1); i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	if backoff <= 1 {
+		return backoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+}
+
+var CircuitOpen = fmt.Errorf("the circuit for the recipient is open")
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpened   = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+type DeliveryOutcome int
+
+const (
+	DeliverySucceeded DeliveryOutcome = iota
+	DeliveryFailed
+	// the request was not delivered for reasons unrelated to the recipient,
+	// e.g. because the caller gave up
+	DeliveryAborted
+)
+
+type circuit struct {
+	state    string
+	failures int64
+	openedAt time.Time
+	// whether the trial request of a half-open circuit is underway
+	trial bool
+}
+
+// Keeps track of failed deliveries per operator. After too many consecutive
+// failures the circuit of the operator opens and requests fail right away.
+// Once the circuit has been open for a while we let a single trial request
+// through, which closes the circuit again if it succeeds.
+type CircuitBreaker struct {
+	settings *CircuitBreakerSettings
+	circuits map[string]*circuit
+	mutex    sync.Mutex
+}
+
+func MakeCircuitBreaker(settings *CircuitBreakerSettings) *CircuitBreaker {
+	return &CircuitBreaker{
+		settings: settings,
+		circuits: make(map[string]*circuit),
+	}
+}
+
+// Returns nil if requests to the operator should fail right away, otherwise
+// a function that needs to be called with the outcome of the delivery
+func (c *CircuitBreaker) Acquire(operator string) func(DeliveryOutcome) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	operatorCircuit, ok := c.circuits[operator]
+
+	if !ok {
+		operatorCircuit = &circuit{state: CircuitClosed}
+		c.circuits[operator] = operatorCircuit
+	}
+
+	trial := false
+
+	switch operatorCircuit.state {
+	case CircuitOpened:
+		if time.Since(operatorCircuit.openedAt) < time.Duration(c.settings.OpenFor)*time.Second {
+			return nil
+		}
+		Log.Infof("Circuit for operator '%s' is half-open, trying a request...", operator)
+		operatorCircuit.state = CircuitHalfOpen
+		fallthrough
+	case CircuitHalfOpen:
+		if operatorCircuit.trial {
+			return nil
+		}
+		operatorCircuit.trial = true
+		trial = true
+	}
+
+	return func(outcome DeliveryOutcome) {
+		c.record(operator, operatorCircuit, trial, outcome)
+	}
+}
+
+func (c *CircuitBreaker) record(operator string, operatorCircuit *circuit, trial bool, outcome DeliveryOutcome) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if trial {
+		operatorCircuit.trial = false
+	}
+
+	switch outcome {
+	case DeliverySucceeded:
+		if operatorCircuit.state != CircuitClosed {
+			Log.Infof("Circuit for operator '%s' is closed again", operator)
+		}
+		operatorCircuit.state = CircuitClosed
+		operatorCircuit.failures = 0
+	case DeliveryFailed:
+		operatorCircuit.failures++
+		// a failed trial opens the circuit right away
+		if operatorCircuit.state == CircuitHalfOpen || (operatorCircuit.state == CircuitClosed && operatorCircuit.failures >= c.settings.FailureThreshold) {
+			Log.Warningf("Opening circuit for operator '%s' after %d failed deliveries", operator, operatorCircuit.failures)
+			operatorCircuit.state = CircuitOpened
+			operatorCircuit.openedAt = time.Now()
+		}
+	}
+}
+
+// Returns the state of the circuit for the given operator
+func (c *CircuitBreaker) State(operator string) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if operatorCircuit, ok := c.circuits[operator]; ok {
+		return operatorCircuit.state
+	}
+	return CircuitClosed
+}
+
+// Returns the operators whose circuits aren't closed
+func (c *CircuitBreaker) OpenCircuits() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	operators := make([]string, 0)
+	for operator, operatorCircuit := range c.circuits {
+		if operatorCircuit.state != CircuitClosed {
+			operators = append(operators, operator)
+		}
+	}
+	sort.Strings(operators)
+	return operators
+}
@@ -0,0 +1,77 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+
+	breaker := MakeCircuitBreaker(&CircuitBreakerSettings{FailureThreshold: 2, OpenFor: 1})
+
+	for i := 0; i < 2; i++ {
+		if done := breaker.Acquire("op-1"); done == nil {
+			t.Fatalf("expected the circuit to be closed")
+		} else {
+			done(DeliveryFailed)
+		}
+	}
+
+	if breaker.State("op-1") != CircuitOpened || breaker.Acquire("op-1") != nil {
+		t.Fatalf("expected the circuit to be open")
+	}
+
+	// other operators are not affected
+	if done := breaker.Acquire("op-2"); done == nil {
+		t.Fatalf("expected the circuit of another operator to be closed")
+	} else {
+		done(DeliverySucceeded)
+	}
+
+	// we pretend that the circuit has been open for long enough
+	breaker.circuits["op-1"].openedAt = time.Now().Add(-2 * time.Second)
+
+	done := breaker.Acquire("op-1")
+
+	if done == nil {
+		t.Fatalf("expected a trial request to be allowed")
+	}
+
+	if breaker.State("op-1") != CircuitHalfOpen || breaker.Acquire("op-1") != nil {
+		t.Fatalf("expected only a single trial request")
+	}
+
+	done(DeliverySucceeded)
+
+	if breaker.State("op-1") != CircuitClosed || len(breaker.OpenCircuits()) != 0 {
+		t.Fatalf("expected the circuit to be closed again")
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+
+	retries := &RetrySettings{MaxAttempts: 5, InitialBackoff: 100, MaxBackoff: 300}
+
+	for attempt, max := range []time.Duration{100, 200, 300, 300} {
+		backoff := retries.Backoff(int64(attempt + 1))
+		if backoff < max*time.Millisecond/2 || backoff > max*time.Millisecond {
+			t.Fatalf("unexpected backoff for attempt %d: %v", attempt+1, backoff)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+/*
+The client package lets Go programs call Hyper services with typed
+parameters and results, either through the JSON-RPC server of a local node
+or through a message broker that runs in the same process.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/hyper"
+	"github.com/kiprotect/hyper/helpers"
+	"github.com/kiprotect/hyper/jsonrpc"
+)
+
+// A transport delivers a request to a method of an operator and returns
+// the response of the operator
+type Transport interface {
+	Deliver(ctx context.Context, operator, method string, params map[string]interface{}) (*hyper.Response, error)
+}
+
+type Client struct {
+	transport Transport
+}
+
+func MakeClient(transport Transport) *Client {
+	return &Client{
+		transport: transport,
+	}
+}
+
+// Returns a client that talks to the JSON-RPC server channel of a node
+func MakeJSONRPCClient(settings *jsonrpc.JSONRPCClientSettings) *Client {
+	return MakeClient(&JSONRPCTransport{
+		client: jsonrpc.MakeClient(settings),
+	})
+}
+
+// Returns a client that delivers requests through an embedded broker
+func MakeBrokerClient(broker hyper.MessageBroker, directory hyper.Directory) *Client {
+	return MakeClient(&BrokerTransport{
+		broker:    broker,
+		directory: directory,
+	})
+}
+
+// Calls the method of the operator with the given parameters, which can be
+// a map or a struct that encodes to a JSON object. If result is not nil the
+// result of the call is decoded into it. Errors returned by the operator are
+// of type *Error.
+func (c *Client) Call(ctx context.Context, operator, method string, params interface{}, result interface{}) error {
+
+	paramsMap, err := toMap(params)
+
+	if err != nil {
+		return fmt.Errorf("invalid parameters: %w", err)
+	}
+
+	response, err := c.transport.Deliver(ctx, operator, method, paramsMap)
+
+	if err != nil {
+		return err
+	}
+
+	if response == nil {
+		return fmt.Errorf("no response received")
+	}
+
+	if response.Stream != nil {
+		response.Stream.Close()
+		return fmt.Errorf("streamed responses are not supported")
+	}
+
+	if response.Error != nil {
+		return FromHyperError(response.Error)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	// results that aren't objects are wrapped by the JSON-RPC server
+	var value interface{} = response.Result
+	if wrapped, ok := response.Result["_"]; ok && len(response.Result) == 1 {
+		value = wrapped
+	}
+
+	if data, err := json.Marshal(value); err != nil {
+		return fmt.Errorf("error marshalling result: %w", err)
+	} else if err := json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("error decoding result: %w", err)
+	}
+
+	return nil
+}
+
+func toMap(value interface{}) (map[string]interface{}, error) {
+
+	if value == nil {
+		return map[string]interface{}{}, nil
+	}
+
+	if mapValue, ok := value.(map[string]interface{}); ok {
+		return mapValue, nil
+	}
+
+	data, err := json.Marshal(value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var mapValue map[string]interface{}
+
+	if err := json.Unmarshal(data, &mapValue); err != nil {
+		return nil, err
+	}
+
+	if mapValue == nil {
+		mapValue = map[string]interface{}{}
+	}
+
+	return mapValue, nil
+}
+
+func requestID() (string, error) {
+	if id, err := helpers.RandomID(8); err != nil {
+		return "", err
+	} else {
+		return hex.EncodeToString(id), nil
+	}
+}
+
+type JSONRPCTransport struct {
+	client *jsonrpc.Client
+}
+
+func (t *JSONRPCTransport) Deliver(ctx context.Context, operator, method string, params map[string]interface{}) (*hyper.Response, error) {
+
+	id, err := requestID()
+
+	if err != nil {
+		return nil, err
+	}
+
+	request := jsonrpc.MakeRequest(fmt.Sprintf("%s.%s", operator, method), id, params)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		request.Deadline = &deadline
+	}
+
+	response, err := t.client.CallContext(ctx, request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return response.ToHyperResponse(), nil
+}
+
+type BrokerTransport struct {
+	broker    hyper.MessageBroker
+	directory hyper.Directory
+}
+
+func (t *BrokerTransport) Deliver(ctx context.Context, operator, method string, params map[string]interface{}) (*hyper.Response, error) {
+
+	id, err := requestID()
+
+	if err != nil {
+		return nil, err
+	}
+
+	address := fmt.Sprintf("%s.%s", operator, method)
+
+	request := &hyper.Request{
+		Method: address,
+		Params: params,
+		// the broker reconstructs the recipient from the ID
+		ID: fmt.Sprintf("%s(%s)", address, id),
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		request.Deadline = &deadline
+	}
+
+	request.SetContext(ctx)
+
+	// the request comes from the node itself
+	clientInfo := &hyper.ClientInfo{
+		Name: t.directory.Name(),
+	}
+
+	if entry, err := t.directory.OwnEntry(); err != nil {
+		return nil, fmt.Errorf("error retrieving own directory entry: %w", err)
+	} else {
+		clientInfo.Entry = entry
+	}
+
+	return t.broker.DeliverRequest(request, clientInfo)
+}
@@ -0,0 +1,136 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"github.com/kiprotect/hyper"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+type testTransport struct {
+	operator string
+	method   string
+	params   map[string]interface{}
+	response *hyper.Response
+}
+
+func (t *testTransport) Deliver(ctx context.Context, operator, method string, params map[string]interface{}) (*hyper.Response, error) {
+	t.operator, t.method, t.params = operator, method, params
+	return t.response, nil
+}
+
+func TestCall(t *testing.T) {
+
+	transport := &testTransport{
+		response: &hyper.Response{Result: map[string]interface{}{"countries": []interface{}{"DE", "FR"}}},
+	}
+
+	client := MakeClient(transport)
+
+	type params struct {
+		URL   string `json:"url"`
+		Limit int    `json:"limit"`
+	}
+
+	var result struct {
+		Countries []string `json:"countries"`
+	}
+
+	if err := client.Call(context.Background(), "op-2", "countries", &params{URL: "x", Limit: 2}, &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if transport.operator != "op-2" || transport.method != "countries" || transport.params["url"] != "x" || transport.params["limit"] != 2.0 {
+		t.Fatalf("unexpected request: %s.%s %v", transport.operator, transport.method, transport.params)
+	}
+
+	if len(result.Countries) != 2 || result.Countries[1] != "FR" {
+		t.Fatalf("unexpected result: %v", result)
+	}
+
+	transport.response = &hyper.Response{Error: &hyper.Error{Code: 403, Message: "not allowed"}}
+
+	err := client.Call(context.Background(), "op-2", "countries", nil, nil)
+
+	if !errors.Is(err, ErrPermissionDenied) || errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a permission error, got %v", err)
+	}
+
+	var clientErr *Error
+
+	if !errors.As(err, &clientErr) || clientErr.Message != "not allowed" {
+		t.Fatalf("expected the original message, got %v", err)
+	}
+
+	// queued requests have no result yet
+	transport.response = hyper.RequestQueued(nil, map[string]interface{}{"status": "queued"})
+
+	if err := client.Call(context.Background(), "op-2", "countries", nil, &result); !errors.Is(err, ErrRequestQueued) {
+		t.Fatalf("expected a queued request, got %v", err)
+	}
+}
+
+func TestGenerateStubs(t *testing.T) {
+
+	document := &hyper.OpenRPCDocument{
+		Info: &hyper.OpenRPCInfo{Title: "op-2"},
+		Methods: []*hyper.OpenRPCMethod{
+			{
+				Name: "get-countries",
+				Tags: []*hyper.OpenRPCTag{{Name: "lookup"}},
+				Params: []*hyper.OpenRPCContentDescriptor{
+					{Name: "url", Required: true, Schema: map[string]interface{}{"type": "string"}},
+					{Name: "limit", Schema: map[string]interface{}{"type": "integer"}},
+					{Name: "codes", Schema: map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}},
+				},
+			},
+			// internal methods are left out
+			{Name: "_ping"},
+		},
+	}
+
+	source, err := GenerateStubs("lookup", document)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parser.ParseFile(token.NewFileSet(), "stubs.go", source, 0); err != nil {
+		t.Fatalf("generated code doesn't parse: %v", err)
+	}
+
+	for _, expected := range []string{
+		"type Op2Client struct",
+		"func (c *Op2Client) GetCountries(ctx context.Context, params *Op2ClientGetCountriesParams, result interface{}) error",
+		"Url string `json:\"url\"`",
+		"Limit *int64 `json:\"limit,omitempty\"`",
+		"Codes []string `json:\"codes,omitempty\"`",
+	} {
+		if !strings.Contains(strings.Join(strings.Fields(string(source)), " "), expected) {
+			t.Errorf("expected '%s' in generated code:\n%s", expected, source)
+		}
+	}
+
+	if strings.Contains(string(source), "_ping") {
+		t.Errorf("internal method in generated code")
+	}
+}
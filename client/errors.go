@@ -0,0 +1,63 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"fmt"
+	"github.com/kiprotect/hyper"
+)
+
+// An error returned by the called operator or by a broker on the way.
+// Use errors.Is with the error variables below to check for a given code.
+type Error struct {
+	Code    int
+	Message string
+	Data    map[string]interface{}
+}
+
+var (
+	ErrInvalidParams      = &Error{Code: -32602, Message: "invalid params"}
+	ErrMethodNotFound     = &Error{Code: -32601, Message: "method not found"}
+	ErrRequestQueued      = &Error{Code: 202, Message: "request queued"}
+	ErrPermissionDenied   = &Error{Code: 403, Message: "permission denied"}
+	ErrNotFound           = &Error{Code: 404, Message: "not found"}
+	ErrRateLimitExceeded  = &Error{Code: 429, Message: "rate limit exceeded"}
+	ErrRequestCancelled   = &Error{Code: 499, Message: "request cancelled"}
+	ErrChannel            = &Error{Code: 500, Message: "channel error"}
+	ErrServiceUnavailable = &Error{Code: 503, Message: "service unavailable"}
+	ErrDeadlineExceeded   = &Error{Code: 504, Message: "deadline exceeded"}
+)
+
+func FromHyperError(err *hyper.Error) *Error {
+	return &Error{
+		Code:    err.Code,
+		Message: err.Message,
+		Data:    err.Data,
+	}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// Errors are equal if their codes match
+func (e *Error) Is(target error) bool {
+	if targetError, ok := target.(*Error); ok {
+		return targetError.Code == e.Code
+	}
+	return false
+}
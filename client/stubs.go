@@ -0,0 +1,216 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/kiprotect/hyper"
+	"go/format"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+var stubsTemplate = template.Must(template.New("stubs").Parse(`// Code generated by "hyper sd stubs"; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+	"github.com/kiprotect/hyper/client"
+)
+
+// Calls the methods of the '{{.Operator}}' operator
+type {{.Client}} struct {
+	client   *client.Client
+	operator string
+}
+
+func Make{{.Client}}(c *client.Client) *{{.Client}} {
+	return &{{.Client}}{
+		client:   c,
+		operator: {{printf "%q" .Operator}},
+	}
+}
+{{range .Methods}}
+type {{.Params}} struct {
+{{- range .Fields}}
+	{{.Name}} {{.Type}} ` + "`" + `json:"{{.JSONName}}{{if not .Required}},omitempty{{end}}"` + "`" + `
+{{- end}}
+}
+
+// Calls '{{.Name}}' of the '{{.Service}}' service and decodes the result into result
+func (c *{{$.Client}}) {{.GoName}}(ctx context.Context, params *{{.Params}}, result interface{}) error {
+	return c.client.Call(ctx, c.operator, {{printf "%q" .Name}}, params, result)
+}
+{{end}}`))
+
+type stubField struct {
+	Name     string
+	JSONName string
+	Type     string
+	Required bool
+}
+
+type stubMethod struct {
+	Name    string
+	GoName  string
+	Service string
+	Params  string
+	Fields  []*stubField
+}
+
+type stubs struct {
+	Package  string
+	Operator string
+	Client   string
+	Methods  []*stubMethod
+}
+
+// Generates Go source code with a typed client for the methods in the
+// OpenRPC document. Internal methods (starting with '_') are left out.
+func GenerateStubs(packageName string, document *hyper.OpenRPCDocument) ([]byte, error) {
+
+	operator := document.Info.Title
+	clientName := identifier(operator) + "Client"
+
+	data := &stubs{
+		Package:  packageName,
+		Operator: operator,
+		Client:   clientName,
+		Methods:  make([]*stubMethod, 0, len(document.Methods)),
+	}
+
+	names := map[string]bool{}
+
+	for _, method := range document.Methods {
+
+		if strings.HasPrefix(method.Name, "_") {
+			continue
+		}
+
+		goName := identifier(method.Name)
+
+		if names[goName] {
+			return nil, fmt.Errorf("methods of '%s' map to the same Go name '%s'", operator, goName)
+		}
+
+		names[goName] = true
+
+		stub := &stubMethod{
+			Name:   method.Name,
+			GoName: goName,
+			Params: clientName + goName + "Params",
+			Fields: make([]*stubField, 0, len(method.Params)),
+		}
+
+		for _, tag := range method.Tags {
+			stub.Service = tag.Name
+		}
+
+		fieldNames := map[string]bool{}
+
+		for _, param := range method.Params {
+			field := &stubField{
+				Name:     identifier(param.Name),
+				JSONName: param.Name,
+				Type:     schemaType(param.Schema),
+				Required: param.Required,
+			}
+			if fieldNames[field.Name] {
+				return nil, fmt.Errorf("parameters of '%s' map to the same Go name '%s'", method.Name, field.Name)
+			}
+			fieldNames[field.Name] = true
+			// optional scalar values can't be told apart from zero values
+			if !field.Required && !strings.HasPrefix(field.Type, "[]") && !strings.HasPrefix(field.Type, "map[") && field.Type != "interface{}" {
+				field.Type = "*" + field.Type
+			}
+			stub.Fields = append(stub.Fields, field)
+		}
+
+		data.Methods = append(data.Methods, stub)
+	}
+
+	sort.Slice(data.Methods, func(i, j int) bool { return data.Methods[i].GoName < data.Methods[j].GoName })
+
+	var buffer bytes.Buffer
+
+	if err := stubsTemplate.Execute(&buffer, data); err != nil {
+		return nil, fmt.Errorf("error generating stubs: %w", err)
+	}
+
+	if source, err := format.Source(buffer.Bytes()); err != nil {
+		return nil, fmt.Errorf("error formatting stubs: %w", err)
+	} else {
+		return source, nil
+	}
+}
+
+// Returns the Go type for a JSON schema
+func schemaType(schema map[string]interface{}) string {
+	switch schema["type"] {
+	case "string":
+		return "string"
+	case "integer":
+		return "int64"
+	case "number":
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			return "[]" + schemaType(items)
+		}
+		return "[]interface{}"
+	case "object":
+		return "map[string]interface{}"
+	default:
+		return "interface{}"
+	}
+}
+
+// Turns a name like 'get-country_list' into an exported Go identifier
+// like 'GetCountryList'
+func identifier(name string) string {
+
+	var builder strings.Builder
+
+	upper := true
+
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if builder.Len() == 0 && unicode.IsDigit(r) {
+			builder.WriteRune('X')
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		builder.WriteRune(r)
+	}
+
+	if builder.Len() == 0 {
+		return "X"
+	}
+
+	return builder.String()
+}
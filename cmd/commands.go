@@ -30,4 +30,12 @@ var Commands = hyper.CommandsDefinitions{
 		Name:  "records",
 		Maker: helpers.RecordsCommands,
 	},
+	hyper.CommandsDefinition{
+		Name:  "audit",
+		Maker: helpers.AuditCommands,
+	},
+	hyper.CommandsDefinition{
+		Name:  "admin",
+		Maker: helpers.AdminCommands,
+	},
 }
@@ -0,0 +1,164 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/hyper"
+	"github.com/kiprotect/hyper/admin"
+	"github.com/kiprotect/hyper/jsonrpc"
+	"github.com/urfave/cli"
+	"strings"
+)
+
+func adminClient(c *cli.Context, settings *hyper.Settings) *jsonrpc.Client {
+
+	clientSettings := &jsonrpc.JSONRPCClientSettings{
+		Endpoint: c.GlobalString("endpoint"),
+	}
+
+	tokenFile := c.GlobalString("token-file")
+
+	if settings.Admin != nil {
+		// we use the server certificate as client certificate
+		clientSettings.TLS = settings.Admin.TLS
+
+		if clientSettings.Endpoint == "" {
+			scheme := "http"
+			if settings.Admin.TLS != nil {
+				scheme = "https"
+			}
+			address := settings.Admin.BindAddress
+			// we can't connect to an unspecified address
+			if strings.HasPrefix(address, ":") {
+				address = "localhost" + address
+			}
+			clientSettings.Endpoint = fmt.Sprintf("%s://%s%s", scheme, address, admin.Path)
+		}
+
+		if tokenFile == "" {
+			tokenFile = settings.Admin.TokenFile
+		}
+	}
+
+	if clientSettings.Endpoint == "" {
+		hyper.Log.Fatalf("Admin settings undefined, please specify an endpoint!")
+	}
+
+	if tokenFile != "" {
+		token, err := admin.ReadToken(tokenFile)
+
+		if err != nil {
+			hyper.Log.Fatal(err)
+		}
+
+		clientSettings.Token = token
+	}
+
+	return jsonrpc.MakeClient(clientSettings)
+}
+
+func callAdmin(c *cli.Context, settings *hyper.Settings, method string, params map[string]interface{}) error {
+
+	client := adminClient(c, settings)
+
+	response, err := client.Call(jsonrpc.MakeRequest(method, "", params))
+
+	if err != nil {
+		hyper.Log.Fatal(err)
+	}
+
+	if response.Error != nil {
+		hyper.Log.Fatalf("Admin API error %d: %s", response.Error.Code, response.Error.Message)
+	}
+
+	jsonData, err := json.MarshalIndent(response.Result, "", "  ")
+
+	if err != nil {
+		hyper.Log.Fatal(err)
+	}
+
+	fmt.Fprintln(c.App.Writer, string(jsonData))
+	return nil
+}
+
+func adminCommand(name, usage, method string, settings *hyper.Settings) cli.Command {
+	return cli.Command{
+		Name:  name,
+		Usage: usage,
+		Action: func(c *cli.Context) error {
+			return callAdmin(c, settings, method, map[string]interface{}{})
+		},
+	}
+}
+
+func disconnectClient(c *cli.Context, settings *hyper.Settings) error {
+
+	operator := c.Args().Get(0)
+
+	if operator == "" {
+		hyper.Log.Fatal("please specify an operator")
+	}
+
+	params := map[string]interface{}{"operator": operator}
+
+	if channel := c.String("channel"); channel != "" {
+		params["channel"] = channel
+	}
+
+	return callAdmin(c, settings, "disconnectClient", params)
+}
+
+func AdminCommands(settings *hyper.Settings) ([]cli.Command, error) {
+
+	return []cli.Command{
+		{
+			Name:  "admin",
+			Usage: "Inspect a running Hyper server via its admin API.",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "endpoint",
+					Usage: "URL of the admin API (defaults to the bind address from the settings)",
+				},
+				cli.StringFlag{
+					Name:  "token-file",
+					Usage: "file with the admin token (defaults to the one from the settings)",
+				},
+			},
+			Subcommands: []cli.Command{
+				adminCommand("channels", "List the open channels", "getChannels", settings),
+				adminCommand("clients", "List the operators connected to the server", "getClients", settings),
+				{
+					Name:      "disconnect",
+					Usage:     "Disconnect an operator from the server",
+					ArgsUsage: "operator",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "channel",
+							Usage: "only disconnect the operator from this channel",
+						},
+					},
+					Action: func(c *cli.Context) error { return disconnectClient(c, settings) },
+				},
+				adminCommand("directory", "Show the loaded service directory", "getDirectory", settings),
+				adminCommand("refresh-directory", "Fetch new service directory records", "refreshDirectory", settings),
+				adminCommand("stats", "Show message broker statistics", "getStats", settings),
+			},
+		},
+	}, nil
+}
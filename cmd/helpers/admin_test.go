@@ -0,0 +1,148 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package helpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/hyper"
+	"github.com/kiprotect/hyper/admin"
+	"github.com/kiprotect/hyper/helpers"
+	th "github.com/kiprotect/hyper/testing"
+	"github.com/urfave/cli"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// starts a node with a memory channel and its admin API, and returns the
+// admin endpoint and token file
+func startAdmin(t *testing.T) (string, string) {
+
+	server, err := helpers.MakeServer(&hyper.Settings{
+		Name: "op-1",
+		Definitions: &hyper.Definitions{
+			DirectoryDefinitions: hyper.DirectoryDefinitions{
+				"memory": {
+					Maker: func(name string, settings interface{}) (hyper.Directory, error) {
+						return th.MakeMemoryDirectory("op-1", th.Entries("op-1", "op-2")...), nil
+					},
+				},
+			},
+			ChannelDefinitions: hyper.ChannelDefinitions{
+				"memory": {
+					Maker: func(settings interface{}) (hyper.Channel, error) {
+						return th.MakeMemoryChannel(nil, "op-2"), nil
+					},
+				},
+			},
+		},
+		Directory: &hyper.DirectorySettings{Type: "memory"},
+		Channels:  []*hyper.ChannelSettings{{Name: "main", Type: "memory"}},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { server.Shutdown() })
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	adminServer, err := admin.MakeServer(&hyper.AdminSettings{BindAddress: "127.0.0.1:0", TokenFile: tokenFile}, server)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminServer.SetListener(listener)
+
+	if err := adminServer.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { adminServer.Stop() })
+
+	return fmt.Sprintf("http://%s%s", listener.Addr(), admin.Path), tokenFile
+}
+
+// runs 'hyper admin' with the given arguments and returns its output
+func runAdmin(t *testing.T, args ...string) []byte {
+
+	commands, err := AdminCommands(&hyper.Settings{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+
+	app := cli.NewApp()
+	app.Commands = commands
+	app.Writer = &output
+
+	if err := app.Run(append([]string{"hyper", "admin"}, args...)); err != nil {
+		t.Fatal(err)
+	}
+
+	return output.Bytes()
+}
+
+func TestAdminCommands(t *testing.T) {
+
+	endpoint, tokenFile := startAdmin(t)
+
+	var stats hyper.BrokerStats
+
+	if err := json.Unmarshal(runAdmin(t, "--endpoint", endpoint, "--token-file", tokenFile, "stats"), &stats); err != nil {
+		t.Fatal(err)
+	} else if stats.Channels != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	var channels []*helpers.ChannelStatus
+
+	if err := json.Unmarshal(runAdmin(t, "--endpoint", endpoint, "--token-file", tokenFile, "channels"), &channels); err != nil {
+		t.Fatal(err)
+	} else if len(channels) != 1 || channels[0].Name != "main" || channels[0].State != "open" {
+		t.Fatalf("unexpected channels: %v", channels)
+	}
+
+	var status admin.DirectoryStatus
+
+	if err := json.Unmarshal(runAdmin(t, "--endpoint", endpoint, "--token-file", tokenFile, "directory"), &status); err != nil {
+		t.Fatal(err)
+	} else if status.Name != "op-1" {
+		t.Fatalf("unexpected directory status: %+v", status)
+	}
+}
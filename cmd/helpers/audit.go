@@ -0,0 +1,174 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/hyper"
+	"github.com/kiprotect/hyper/helpers"
+	"github.com/urfave/cli"
+	"time"
+)
+
+func readAuditChain(settings *hyper.Settings) []*hyper.AuditChainRecord {
+
+	if settings.Audit == nil {
+		hyper.Log.Fatalf("Audit settings undefined!")
+	}
+
+	datastore, err := helpers.InitializeDatastore(settings.Audit.Datastore, settings.Definitions)
+
+	if err != nil {
+		hyper.Log.Fatal(err)
+	}
+
+	if err := datastore.Init(); err != nil {
+		hyper.Log.Fatal(err)
+	}
+
+	records, err := helpers.ReadAuditChain(datastore)
+
+	if err != nil {
+		hyper.Log.Fatal(err)
+	}
+
+	return records
+}
+
+func parseTimeFlag(c *cli.Context, name string) *time.Time {
+	value := c.String(name)
+	if value == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		hyper.Log.Fatalf("invalid value for '%s': %v", name, err)
+	}
+	return &t
+}
+
+func verifyAudit(c *cli.Context, settings *hyper.Settings) error {
+
+	records := readAuditChain(settings)
+
+	if len(records) > 0 && (records[0].Index != 0 || records[0].ParentHash != "") {
+		hyper.Log.Fatalf("audit chain does not start with the first record")
+	}
+
+	if err := helpers.VerifyAuditChain(records); err != nil {
+		hyper.Log.Fatal(err)
+	}
+
+	fmt.Printf("Verified %d audit records.\n", len(records))
+
+	return nil
+}
+
+func exportAudit(c *cli.Context, settings *hyper.Settings) error {
+
+	from := c.Int64("from")
+	to := c.Int64("to")
+	since := parseTimeFlag(c, "since")
+	until := parseTimeFlag(c, "until")
+
+	records := readAuditChain(settings)
+	first, last := -1, -1
+
+	for i, record := range records {
+		if record.Index < from || (to >= 0 && record.Index > to) {
+			continue
+		}
+		if since != nil && record.Record.StartedAt.Before(*since) {
+			continue
+		}
+		if until != nil && !record.Record.StartedAt.Before(*until) {
+			continue
+		}
+		if first == -1 {
+			first = i
+		}
+		last = i
+	}
+
+	selectedRecords := make([]*hyper.AuditChainRecord, 0)
+
+	// concurrent requests can finish out of order, so we always export the
+	// full range between the first and last matching record
+	if first != -1 {
+		selectedRecords = records[first : last+1]
+	}
+
+	// the exported records must form a valid chain on their own
+	if err := helpers.VerifyAuditChain(selectedRecords); err != nil {
+		hyper.Log.Fatal(err)
+	}
+
+	jsonData, err := json.Marshal(selectedRecords)
+
+	if err != nil {
+		hyper.Log.Fatal(err)
+	}
+
+	fmt.Println(string(jsonData))
+	return nil
+}
+
+func AuditCommands(settings *hyper.Settings) ([]cli.Command, error) {
+
+	return []cli.Command{
+		{
+			Name:    "audit",
+			Aliases: []string{"a"},
+			Flags:   []cli.Flag{},
+			Usage:   "Inspect the audit log.",
+			Subcommands: []cli.Command{
+				{
+					Name:   "verify",
+					Flags:  []cli.Flag{},
+					Usage:  "Verify the integrity of the audit log",
+					Action: func(c *cli.Context) error { return verifyAudit(c, settings) },
+				},
+				{
+					Name: "export",
+					Flags: []cli.Flag{
+						cli.Int64Flag{
+							Name:  "from",
+							Value: 0,
+							Usage: "the index of the first record to export",
+						},
+						cli.Int64Flag{
+							Name:  "to",
+							Value: -1,
+							Usage: "the index of the last record to export",
+						},
+						cli.StringFlag{
+							Name:  "since",
+							Usage: "only export records started at or after this time (RFC 3339)",
+						},
+						cli.StringFlag{
+							Name:  "until",
+							Usage: "only export records started before this time (RFC 3339)",
+						},
+					},
+					Usage:  "Export a range of audit records as JSON",
+					Action: func(c *cli.Context) error { return exportAudit(c, settings) },
+				},
+			},
+		},
+	}, nil
+}
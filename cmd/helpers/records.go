@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"github.com/kiprotect/go-helpers/forms"
 	"github.com/kiprotect/hyper"
+	"github.com/kiprotect/hyper/client"
 	hyperForms "github.com/kiprotect/hyper/forms"
 	"github.com/kiprotect/hyper/helpers"
 	"github.com/urfave/cli"
@@ -77,6 +78,83 @@ func getEntries(c *cli.Context, settings *hyper.Settings) error {
 	return nil
 }
 
+// Prints the OpenRPC document of an operator, optionally as seen by a caller
+func openRPC(c *cli.Context, settings *hyper.Settings) error {
+
+	directory, err := helpers.InitializeDirectory(settings)
+
+	if err != nil {
+		hyper.Log.Fatal(err)
+	}
+
+	name := c.String("name")
+
+	if name == "" {
+		hyper.Log.Fatal("please specify an operator name")
+	}
+
+	callee, err := directory.EntryFor(name)
+
+	if err != nil {
+		hyper.Log.Fatal(err)
+	}
+
+	var caller *hyper.DirectoryEntry
+
+	if callerName := c.String("caller"); callerName != "" {
+		if caller, err = directory.EntryFor(callerName); err != nil {
+			hyper.Log.Fatal(err)
+		}
+	}
+
+	jsonData, err := json.MarshalIndent(hyper.OpenRPC(caller, callee), "", "  ")
+
+	if err != nil {
+		hyper.Log.Fatal(err)
+	}
+
+	fmt.Println(string(jsonData))
+	return nil
+}
+
+func stubs(c *cli.Context, settings *hyper.Settings) error {
+
+	directory, err := helpers.InitializeDirectory(settings)
+
+	if err != nil {
+		hyper.Log.Fatal(err)
+	}
+
+	name := c.String("name")
+
+	if name == "" {
+		hyper.Log.Fatal("please specify an operator name")
+	}
+
+	callee, err := directory.EntryFor(name)
+
+	if err != nil {
+		hyper.Log.Fatal(err)
+	}
+
+	var caller *hyper.DirectoryEntry
+
+	if callerName := c.String("caller"); callerName != "" {
+		if caller, err = directory.EntryFor(callerName); err != nil {
+			hyper.Log.Fatal(err)
+		}
+	}
+
+	source, err := client.GenerateStubs(c.String("package"), hyper.OpenRPC(caller, callee))
+
+	if err != nil {
+		hyper.Log.Fatal(err)
+	}
+
+	fmt.Print(string(source))
+	return nil
+}
+
 type Records struct {
 	Records []*hyper.ChangeRecord `json:"records"`
 }
@@ -383,6 +461,41 @@ func RecordsCommands(settings *hyper.Settings) ([]cli.Command, error) {
 					Usage:  "Get all service diectory entries and print them as JSON",
 					Action: func(c *cli.Context) error { return getEntries(c, settings) },
 				},
+				{
+					Name: "openrpc",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "name",
+							Usage: "the name of the operator to describe",
+						},
+						cli.StringFlag{
+							Name:  "caller",
+							Usage: "only include the methods that this operator may call",
+						},
+					},
+					Usage:  "Print an OpenRPC document for the services of an operator",
+					Action: func(c *cli.Context) error { return openRPC(c, settings) },
+				},
+				{
+					Name: "stubs",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "name",
+							Usage: "the name of the operator to generate a client for",
+						},
+						cli.StringFlag{
+							Name:  "caller",
+							Usage: "only include the methods that this operator may call",
+						},
+						cli.StringFlag{
+							Name:  "package",
+							Value: "stubs",
+							Usage: "the name of the generated Go package",
+						},
+					},
+					Usage:  "Print a typed Go client for the services of an operator",
+					Action: func(c *cli.Context) error { return stubs(c, settings) },
+				},
 				{
 					Name: "submit-records",
 					Flags: []cli.Flag{
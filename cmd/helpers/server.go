@@ -18,8 +18,10 @@ package helpers
 
 import (
 	"github.com/kiprotect/hyper"
+	"github.com/kiprotect/hyper/admin"
 	"github.com/kiprotect/hyper/helpers"
 	"github.com/kiprotect/hyper/metrics"
+	"github.com/kiprotect/hyper/tracing"
 	"github.com/urfave/cli"
 	"os"
 	"os/signal"
@@ -40,40 +42,87 @@ func Server(settings *hyper.Settings) ([]cli.Command, error) {
 					Flags: []cli.Flag{},
 					Usage: "Run the Hyper server.",
 					Action: func(c *cli.Context) error {
+
+						if settings.Tracing != nil {
+							if err := tracing.StartTracing(settings.Tracing, settings.Name); err != nil {
+								hyper.Log.Fatal(err)
+							}
+						}
+
 						hyper.Log.Info("Opening all channels...")
 
-						directory, err := helpers.InitializeDirectory(settings)
+						server, err := helpers.MakeServer(settings)
 
 						if err != nil {
 							hyper.Log.Fatal(err)
 						}
 
-						broker, err := helpers.InitializeMessageBroker(settings, directory)
-
-						if err != nil {
+						if err := server.Open(); err != nil {
 							hyper.Log.Fatal(err)
 						}
 
-						channels, err := helpers.OpenChannels(broker, directory, settings)
+						// reloads go through the same channel as SIGHUP signals
+						reloads := make(chan bool, 1)
 
-						if err != nil {
-							hyper.Log.Fatal(err)
-						}
+						server.Broker().SetReloadHandler(func() error {
+							select {
+							case reloads <- true:
+							default:
+								// a reload is already pending
+							}
+							return nil
+						})
 
 						// we wait for CTRL-C / Interrupt
 						sigchan := make(chan os.Signal, 1)
-						signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+						signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 						metricsServer := metrics.MakePrometheusMetricsServer(settings.Metrics)
 
+						var adminServer *admin.Server
+
+						if settings.Admin != nil {
+							if adminServer, err = admin.MakeServer(settings.Admin, server); err != nil {
+								hyper.Log.Fatal(err)
+							} else if err := adminServer.Start(); err != nil {
+								hyper.Log.Fatal(err)
+							}
+						}
+
 						hyper.Log.Info("Waiting for CTRL-C...")
 
-						<-sigchan
+						for running := true; running; {
+							select {
+							case sig := <-sigchan:
+								if sig != syscall.SIGHUP {
+									running = false
+									continue
+								}
+							case <-reloads:
+							}
+							hyper.Log.Info("Reloading settings...")
+							if newSettings, err := Settings(settings.Definitions); err != nil {
+								hyper.Log.Errorf("Error loading settings, keeping the current ones: %v", err)
+							} else if err := server.Reload(newSettings); err != nil {
+								hyper.Log.Errorf("Error reloading settings: %v", err)
+							} else {
+								hyper.Log.Info("Settings reloaded.")
+							}
+						}
 
-						hyper.Log.Info("Stopping channels...")
+						hyper.Log.Info("Shutting down...")
 
-						// errors occuring within CloseChannels get logged automatically...
-						helpers.CloseChannels(channels)
+						// errors occuring when closing channels get logged automatically...
+						server.Shutdown()
+
+						// we export all remaining spans
+						hyper.StopTracing()
+
+						if adminServer != nil {
+							if err := adminServer.Stop(); err != nil {
+								hyper.Log.Error(err)
+							}
+						}
 
 						if metricsServer != nil {
 							if err := metricsServer.Stop(); err != nil {
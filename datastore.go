@@ -34,6 +34,19 @@ type Datastore interface {
 	Init() error
 }
 
+// users of a compactable datastore compact it once it holds this many more
+// records than they need
+const CompactionSlack = 100
+
+// A datastore that can replace its contents, which lets users that only
+// need the latest version of their entries drop the older ones
+type CompactableDatastore interface {
+	Datastore
+	// Replaces all data in the store with the given entries, later reads
+	// only return entries written after this
+	Compact([]*DataEntry) error
+}
+
 const (
 	NullType = 0
 )
@@ -465,16 +465,79 @@ func (f *FileDatastore) Read() ([]*hyper.DataEntry, error) {
 	return dataEntries, nil
 }
 
-func (f *FileDatastore) Write(entry *hyper.DataEntry) error {
+func writeEntry(file *os.File, entry *hyper.DataEntry) error {
 	if chunks, err := Split(entry); err != nil {
 		return err
 	} else {
 		for _, chunk := range chunks {
-			if err := chunk.Write(f.wfile); err != nil {
+			if err := chunk.Write(file); err != nil {
 				return err
 			}
 		}
 	}
+	return nil
+}
+
+func (f *FileDatastore) Write(entry *hyper.DataEntry) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := writeEntry(f.wfile, entry); err != nil {
+		return err
+	}
 	// we make sure the changes were all written to disk
 	return f.wfile.Sync()
 }
+
+// Writes the entries to a new file that replaces the current one
+func (f *FileDatastore) Compact(entries []*hyper.DataEntry) error {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	filename := f.settings.Filename + ".compact"
+
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0700)
+
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := writeEntry(file, entry); err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(filename, f.settings.Filename); err != nil {
+		return err
+	}
+
+	// the old handles still point to the replaced file
+	f.wfile.Close()
+	f.rfile.Close()
+
+	if f.wfile, err = os.OpenFile(f.settings.Filename, os.O_APPEND|os.O_WRONLY, 0700); err != nil {
+		return err
+	}
+
+	if f.rfile, err = os.OpenFile(f.settings.Filename, os.O_RDONLY, 0700); err != nil {
+		return err
+	}
+
+	f.chunks = make([]*DataChunk, 0, 10)
+
+	// the caller already has the compacted entries
+	_, err = f.rfile.Seek(0, io.SeekEnd)
+
+	return err
+}
@@ -0,0 +1,69 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package datastores
+
+import (
+	"github.com/kiprotect/hyper"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileCompaction(t *testing.T) {
+
+	settings := FileSettings{Filename: filepath.Join(t.TempDir(), "store.dat")}
+
+	open := func() hyper.Datastore {
+		if datastore, err := MakeFile(settings); err != nil {
+			t.Fatal(err)
+			return nil
+		} else if err := datastore.Init(); err != nil {
+			t.Fatal(err)
+			return nil
+		} else {
+			return datastore
+		}
+	}
+
+	datastore := open()
+
+	for _, data := range []string{"a", "b", "c"} {
+		if err := datastore.Write(&hyper.DataEntry{Type: 1, ID: []byte(data), Data: []byte(data)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := datastore.(hyper.CompactableDatastore).Compact([]*hyper.DataEntry{{Type: 1, ID: []byte("c"), Data: []byte("c")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := datastore.Write(&hyper.DataEntry{Type: 1, ID: []byte("d"), Data: []byte("d")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// only entries written after the compaction are new to this reader
+	if entries, err := datastore.Read(); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 1 || string(entries[0].Data) != "d" {
+		t.Fatalf("expected only the new entry, got %d entries", len(entries))
+	}
+
+	if entries, err := open().Read(); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 2 || string(entries[0].Data) != "c" || string(entries[1].Data) != "d" {
+		t.Fatalf("expected the compacted and the new entry, got %d entries", len(entries))
+	}
+}
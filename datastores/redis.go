@@ -154,6 +154,23 @@ func (d *Redis) Read() ([]*hyper.DataEntry, error) {
 	}
 }
 
+// Replaces the list with the given entries in a single transaction
+func (d *Redis) Compact(entries []*hyper.DataEntry) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, err := d.Client().TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del(d.settings.Key)
+		for _, entry := range entries {
+			pipe.RPush(d.settings.Key, string(ToBytes(entry)))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	d.index = int64(len(entries)) - 1
+	return nil
+}
+
 func (d *Redis) Init() error {
 	return nil
 }
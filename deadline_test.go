@@ -0,0 +1,181 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper_test
+
+import (
+	"github.com/kiprotect/hyper"
+	th "github.com/kiprotect/hyper/testing"
+	"testing"
+	"time"
+)
+
+func makeDeadlineBroker(t *testing.T, handler th.ChannelHandler) (*hyper.BasicMessageBroker, *th.MemoryChannel) {
+	return th.MakeMemoryBroker(t, th.MakeMemoryDirectory("op-1",
+		// other operators may cancel their requests
+		&hyper.DirectoryEntry{
+			Name: "op-1",
+			Services: []*hyper.OperatorService{
+				{
+					Name:        "requests",
+					Permissions: []*hyper.Permission{{Group: "*", Rights: []string{"call"}}},
+					Methods:     []*hyper.ServiceMethod{{Name: "_cancel"}},
+				},
+			},
+		},
+		&hyper.DirectoryEntry{Name: "op-2"},
+		&hyper.DirectoryEntry{Name: "op-3"},
+	), handler, "op-2")
+}
+
+func TestBrokerDropsExpiredRequests(t *testing.T) {
+
+	broker, channel := makeDeadlineBroker(t, func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+		return &hyper.Response{ID: &address.ID, Result: map[string]interface{}{}}, nil
+	})
+
+	deadline := time.Now().Add(-time.Second)
+
+	response, err := broker.DeliverRequest(&hyper.Request{
+		ID:       "op-2.add(1)",
+		Method:   "op-2.add",
+		Params:   map[string]interface{}{},
+		Deadline: &deadline,
+	}, &hyper.ClientInfo{Name: "op-1"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Error == nil || response.Error.Code != 504 {
+		t.Fatalf("expected a deadline error")
+	}
+
+	if len(channel.Requests()) != 0 {
+		t.Fatalf("expected the expired request not to be delivered")
+	}
+}
+
+func TestBrokerPassesDeadlines(t *testing.T) {
+
+	deadline := time.Now().Add(time.Minute)
+
+	broker, _ := makeDeadlineBroker(t, func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+		// the channel forwards the deadline and gives up when it passes
+		if request.Deadline == nil || !request.Deadline.Equal(deadline) {
+			t.Errorf("expected the deadline of the request")
+		}
+		if ctxDeadline, ok := request.Context().Deadline(); !ok || !ctxDeadline.Equal(deadline) {
+			t.Errorf("expected the deadline in the context of the request")
+		}
+		return &hyper.Response{ID: &address.ID, Result: map[string]interface{}{}}, nil
+	})
+
+	if response, err := broker.DeliverRequest(&hyper.Request{
+		ID:       "op-2.add(1)",
+		Method:   "op-2.add",
+		Params:   map[string]interface{}{},
+		Deadline: &deadline,
+	}, &hyper.ClientInfo{Name: "op-1"}); err != nil {
+		t.Fatal(err)
+	} else if response.Error != nil {
+		t.Fatalf("unexpected error: %s", response.Error.Message)
+	}
+
+	// a request that runs past its deadline is abandoned
+	slowBroker, _ := makeDeadlineBroker(t, func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+		<-request.Context().Done()
+		return nil, request.Context().Err()
+	})
+
+	deadline = time.Now().Add(50 * time.Millisecond)
+
+	if response, err := slowBroker.DeliverRequest(&hyper.Request{
+		ID:       "op-2.wait(1)",
+		Method:   "op-2.wait",
+		Params:   map[string]interface{}{},
+		Deadline: &deadline,
+	}, &hyper.ClientInfo{Name: "op-1"}); err != nil {
+		t.Fatal(err)
+	} else if response.Error == nil || response.Error.Code != 504 {
+		t.Fatalf("expected a deadline error")
+	}
+}
+
+func TestBrokerCancelsRequests(t *testing.T) {
+
+	started := make(chan bool, 1)
+
+	broker, _ := makeDeadlineBroker(t, func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+		started <- true
+		<-request.Context().Done()
+		return nil, request.Context().Err()
+	})
+
+	cancel := func(client string) *hyper.Response {
+		response, err := broker.DeliverRequest(&hyper.Request{
+			ID:     "op-1._cancel(1)",
+			Method: "op-1._cancel",
+			Params: map[string]interface{}{"id": "op-2.wait(1)"},
+		}, &hyper.ClientInfo{Name: client})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	if response := cancel("op-1"); response.Error == nil || response.Error.Code != 404 {
+		t.Fatalf("expected no request to cancel")
+	}
+
+	responses := make(chan *hyper.Response, 1)
+
+	go func() {
+		response, err := broker.DeliverRequest(&hyper.Request{
+			ID:     "op-2.wait(1)",
+			Method: "op-2.wait",
+			Params: map[string]interface{}{},
+		}, &hyper.ClientInfo{Name: "op-1"})
+		if err != nil {
+			t.Error(err)
+		}
+		responses <- response
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the request to be delivered")
+	}
+
+	// only the client that sent the request may cancel it
+	if response := cancel("op-3"); response.Error == nil || response.Error.Code != 404 {
+		t.Fatalf("expected other clients not to cancel the request")
+	}
+
+	if response := cancel("op-1"); response.Error != nil || response.Result["cancelled"] != true {
+		t.Fatalf("expected the request to be cancelled")
+	}
+
+	select {
+	case response := <-responses:
+		if response == nil || response.Error == nil || response.Error.Code != 499 {
+			t.Fatalf("expected a cancellation error, got %v", response)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the cancelled request to return")
+	}
+}
@@ -25,6 +25,7 @@ type Definitions struct {
 	DatastoreDefinitions
 	CommandsDefinitions
 	ChannelDefinitions
+	InterceptorDefinitions
 }
 
 func (d Definitions) Marshal() map[string]interface{} {
@@ -41,7 +42,11 @@ func (d Definitions) MarshalJSON() ([]byte, error) {
 
 func MergeDefinitions(a, b Definitions) Definitions {
 	c := Definitions{
-		CommandsDefinitions: CommandsDefinitions{},
+		CommandsDefinitions:    CommandsDefinitions{},
+		ChannelDefinitions:     ChannelDefinitions{},
+		DatastoreDefinitions:   DatastoreDefinitions{},
+		DirectoryDefinitions:   DirectoryDefinitions{},
+		InterceptorDefinitions: InterceptorDefinitions{},
 	}
 	for _, obj := range []Definitions{a, b} {
 		for _, v := range obj.CommandsDefinitions {
@@ -56,6 +61,9 @@ func MergeDefinitions(a, b Definitions) Definitions {
 		for k, v := range obj.DirectoryDefinitions {
 			c.DirectoryDefinitions[k] = v
 		}
+		for k, v := range obj.InterceptorDefinitions {
+			c.InterceptorDefinitions[k] = v
+		}
 	}
 	return c
 }
@@ -22,11 +22,13 @@ import (
 	"github.com/kiprotect/hyper/cmd"
 	"github.com/kiprotect/hyper/datastores"
 	"github.com/kiprotect/hyper/directories"
+	"github.com/kiprotect/hyper/interceptors"
 )
 
 var Default = hyper.Definitions{
-	DatastoreDefinitions: datastores.Definitions,
-	DirectoryDefinitions: directories.Directories,
-	CommandsDefinitions:  cmd.Commands,
-	ChannelDefinitions:   channels.Channels,
+	DatastoreDefinitions:   datastores.Definitions,
+	DirectoryDefinitions:   directories.Directories,
+	CommandsDefinitions:    cmd.Commands,
+	ChannelDefinitions:     channels.Channels,
+	InterceptorDefinitions: interceptors.Definitions,
 }
@@ -0,0 +1,61 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+// a service as seen by a specific caller
+type ServiceDescription struct {
+	Name    string               `json:"name"`
+	Methods []*MethodDescription `json:"methods"`
+}
+
+type MethodDescription struct {
+	Name       string              `json:"name"`
+	Parameters []*ServiceParameter `json:"parameters"`
+	RetrySafe  bool                `json:"retry_safe"`
+	Cache      *CacheHints         `json:"cache,omitempty"`
+}
+
+// Returns the services and methods of the callee that the caller is allowed
+// to call, services without such methods are left out
+func DescribeServices(caller, callee *DirectoryEntry) []*ServiceDescription {
+	descriptions := make([]*ServiceDescription, 0)
+	for _, service := range callee.Services {
+		description := &ServiceDescription{
+			Name:    service.Name,
+			Methods: make([]*MethodDescription, 0),
+		}
+		for _, method := range service.Methods {
+			if !CanCall(caller, callee, method.Name) {
+				continue
+			}
+			parameters := method.Parameters
+			if parameters == nil {
+				parameters = []*ServiceParameter{}
+			}
+			description.Methods = append(description.Methods, &MethodDescription{
+				Name:       method.Name,
+				Parameters: parameters,
+				RetrySafe:  method.RetrySafe,
+				Cache:      method.Cache,
+			})
+		}
+		if len(description.Methods) > 0 {
+			descriptions = append(descriptions, description)
+		}
+	}
+	return descriptions
+}
@@ -0,0 +1,63 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+import (
+	"testing"
+)
+
+func TestDescribeServices(t *testing.T) {
+
+	callee := &DirectoryEntry{
+		Name: "op-2",
+		Services: []*OperatorService{
+			{
+				Name: "calculator",
+				Methods: []*ServiceMethod{
+					{
+						Name:        "add",
+						Permissions: []*Permission{{Group: "partners", Rights: []string{"call"}}},
+						Parameters:  []*ServiceParameter{{Name: "a", Validators: []*ServiceValidator{{Type: "IsInteger"}}}},
+					},
+					{
+						Name:        "reset",
+						Permissions: []*Permission{{Group: "admins", Rights: []string{"call"}}},
+					},
+				},
+			},
+			{
+				Name:        "internals",
+				Permissions: []*Permission{{Group: "admins", Rights: []string{"call"}}},
+				Methods:     []*ServiceMethod{{Name: "_ping"}},
+			},
+		},
+	}
+
+	descriptions := DescribeServices(&DirectoryEntry{Name: "op-1", Groups: []string{"partners"}}, callee)
+
+	if len(descriptions) != 1 || descriptions[0].Name != "calculator" {
+		t.Fatalf("expected only the calculator service")
+	}
+
+	if methods := descriptions[0].Methods; len(methods) != 1 || methods[0].Name != "add" || len(methods[0].Parameters) != 1 {
+		t.Fatalf("expected only the add method with its parameters")
+	}
+
+	if descriptions := DescribeServices(&DirectoryEntry{Name: "op-3", Groups: []string{"admins"}}, callee); len(descriptions) != 2 || len(descriptions[0].Methods) != 1 || descriptions[0].Methods[0].Name != "reset" {
+		t.Fatalf("expected the reset method and the internals")
+	}
+}
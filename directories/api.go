@@ -171,7 +171,7 @@ func MakeAPIDirectory(name string, settings interface{}) (hyper.Directory, error
 	}
 
 	// we still allow the services to start even if the API is not reachable...
-	if err := d.update(); err != nil {
+	if err := d.update(false); err != nil {
 		hyper.Log.Error(err)
 	}
 
@@ -207,13 +207,13 @@ func (f *APIDirectory) Entries(query *hyper.DirectoryQuery) ([]*hyper.DirectoryE
 
 	if time.Now().Add(-time.Duration(2*f.settings.CacheEntriesFor) * time.Second).After(lastUpdate) {
 		// last update was more than 2 minutes ago, we update synchronously
-		if err := f.update(); err != nil {
+		if err := f.update(false); err != nil {
 			return nil, fmt.Errorf("error updating service directory: %w", err)
 		}
 	} else if time.Now().Add(-time.Duration(f.settings.CacheEntriesFor) * time.Second).After(lastUpdate) {
 		// last update was more than 1 minute ago, we update in the background
 		go func() {
-			if err := f.update(); err != nil {
+			if err := f.update(false); err != nil {
 				hyper.Log.Error(err)
 			}
 		}()
@@ -318,13 +318,28 @@ func (f *APIDirectory) integrate(records []*hyper.SignedChangeRecord) error {
 	return nil
 }
 
+// Fetches new change records right away, ignoring the cache period
+func (f *APIDirectory) Refresh() error {
+	return f.update(true)
+}
+
+// Returns the hash of the latest change record that we have integrated
+func (f *APIDirectory) LoadedTip() string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if len(f.records) == 0 {
+		return ""
+	}
+	return f.records[len(f.records)-1].Hash
+}
+
 // Updates the service directory with change records from the remote API
-func (f *APIDirectory) update() error {
+func (f *APIDirectory) update(force bool) error {
 
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
 
-	if time.Now().Add(-time.Duration(f.settings.CacheEntriesFor) * time.Second).Before(f.lastUpdate) {
+	if !force && time.Now().Add(-time.Duration(f.settings.CacheEntriesFor)*time.Second).Before(f.lastUpdate) {
 		// last update was less than a minute ago...
 		return nil
 	}
@@ -33,6 +33,7 @@ func MakeDirectoryEntry() *DirectoryEntry {
 		Groups:       []string{},
 		Channels:     []*OperatorChannel{},
 		Services:     []*OperatorService{},
+		Topics:       []*OperatorTopic{},
 		Certificates: []*OperatorCertificate{},
 		Settings:     []*OperatorSettings{},
 		Records:      []*SignedChangeRecord{},
@@ -45,6 +46,7 @@ type DirectoryEntry struct {
 	Groups       []string               `json:"groups"`
 	Channels     []*OperatorChannel     `json:"channels"`
 	Services     []*OperatorService     `json:"services"`
+	Topics       []*OperatorTopic       `json:"topics"`
 	Certificates []*OperatorCertificate `json:"certificates"`
 	Settings     []*OperatorSettings    `json:"settings"`
 	Preferences  []*OperatorPreferences `json:"preferences"`
@@ -80,6 +82,8 @@ type OperatorChannel struct {
 type OperatorCertificate struct {
 	Fingerprint string `json:"fingerprint"`
 	KeyUsage    string `json:"key_usage"`
+	// the PEM-encoded certificate, which senders need for encrypting payloads
+	Certificate string `json:"certificate,omitempty"`
 }
 
 type OperatorService struct {
@@ -88,10 +92,21 @@ type OperatorService struct {
 	Methods     []*ServiceMethod `json:"methods"`
 }
 
+// a topic that the operator publishes events on
+type OperatorTopic struct {
+	Name        string        `json:"name"`
+	Permissions []*Permission `json:"permissions"`
+}
+
 type ServiceMethod struct {
 	Name        string              `json:"name"`
 	Permissions []*Permission       `json:"permissions"`
 	Parameters  []*ServiceParameter `json:"parameters"`
+	// whether failed deliveries of the method may be retried, i.e. whether
+	// calling it more than once has the same effect as calling it once
+	RetrySafe bool `json:"retry_safe"`
+	// if set, callers may reuse responses of the method
+	Cache *CacheHints `json:"cache,omitempty"`
 }
 
 type Permission struct {
@@ -188,6 +203,15 @@ type WritableDirectory interface {
 	Submit([]*SignedChangeRecord) error
 }
 
+// Directories that keep a local copy of the change records implement this
+type RefreshableDirectory interface {
+	Directory
+	// fetches new change records right away
+	Refresh() error
+	// returns the hash of the latest change record that was loaded
+	LoadedTip() string
+}
+
 type BaseDirectory struct {
 	Name_ string
 }
@@ -244,9 +268,15 @@ func CanCall(caller, callee *DirectoryEntry, method string) bool {
 	return false
 }
 
-// get all groups that may call service methods of this entry
+// get all groups that may call service methods of this entry or that may
+// subscribe or publish to its topics
 func GetPeerGroups(entry *DirectoryEntry) []string {
 	groups := map[string]bool{}
+	for _, topic := range entry.Topics {
+		for _, topicPermission := range topic.Permissions {
+			groups[topicPermission.Group] = true
+		}
+	}
 	for _, service := range entry.Services {
 		for _, servicePermission := range service.Permissions {
 			groups[servicePermission.Group] = true
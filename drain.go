@@ -0,0 +1,88 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+import (
+	"context"
+	"sync"
+)
+
+// counts the requests that the broker is delivering, so that a shutdown can
+// wait for them to finish
+type inFlightRequests struct {
+	mutex    sync.Mutex
+	count    int
+	draining bool
+	// closed when the last request is done during draining
+	done chan bool
+}
+
+// registers a new request, returns false if we're draining
+func (i *inFlightRequests) enter() bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if i.draining {
+		return false
+	}
+	i.count++
+	return true
+}
+
+func (i *inFlightRequests) leave() {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.count--
+	if i.draining && i.count == 0 {
+		close(i.done)
+	}
+}
+
+func (i *inFlightRequests) status() (int, bool) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.count, i.draining
+}
+
+func (i *inFlightRequests) drain(ctx context.Context) error {
+
+	i.mutex.Lock()
+
+	if !i.draining {
+		i.draining = true
+		i.done = make(chan bool)
+		if i.count == 0 {
+			close(i.done)
+		}
+	}
+
+	done := i.done
+
+	i.mutex.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stops accepting new requests and waits until the requests in flight are
+// done. Returns an error if the context is done before that.
+func (b *BasicMessageBroker) Drain(ctx context.Context) error {
+	return b.inFlight.drain(ctx)
+}
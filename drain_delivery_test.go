@@ -0,0 +1,62 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper_test
+
+import (
+	"context"
+	"github.com/kiprotect/hyper"
+	th "github.com/kiprotect/hyper/testing"
+	"testing"
+)
+
+func TestInternalRequestsWhileDraining(t *testing.T) {
+
+	broker, channel := th.MakeMemoryBroker(t, th.MakeMemoryDirectory("op-1", th.Entries("op-1", "op-2")...), func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+		return &hyper.Response{ID: &address.ID, Result: map[string]interface{}{"endpoint": "proxy:4444"}}, nil
+	}, "op-2")
+
+	if err := broker.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func() *hyper.Request {
+		return &hyper.Request{ID: "op-2.requestConnection(1)", Method: "op-2.requestConnection", Params: map[string]interface{}{}}
+	}
+
+	if response, err := broker.DeliverRequest(request(), &hyper.ClientInfo{Name: "op-1"}); err != nil {
+		t.Fatal(err)
+	} else if response.Error == nil || response.Error.Code != 503 {
+		t.Fatalf("expected new requests to be rejected while draining")
+	}
+
+	// channels find the internal path through the broker interface
+	internalBroker, ok := hyper.MessageBroker(broker).(hyper.InternalMessageBroker)
+
+	if !ok {
+		t.Fatalf("expected the broker to deliver internal requests")
+	}
+
+	if response, err := internalBroker.DeliverInternalRequest(request(), &hyper.ClientInfo{Name: "op-1"}); err != nil {
+		t.Fatal(err)
+	} else if response.Error != nil || response.Result["endpoint"] != "proxy:4444" {
+		t.Fatalf("expected requests on behalf of accepted ones to be delivered while draining")
+	}
+
+	if len(channel.Requests()) != 1 {
+		t.Fatalf("expected a single delivery")
+	}
+}
@@ -0,0 +1,78 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInFlightRequests(t *testing.T) {
+
+	var inFlight inFlightRequests
+
+	if !inFlight.enter() {
+		t.Fatalf("expected to accept a request")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := inFlight.drain(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the drain to time out while a request is in flight")
+	}
+
+	if inFlight.enter() {
+		t.Fatalf("expected to reject requests while draining")
+	}
+
+	drained := make(chan error, 1)
+
+	go func() {
+		drained <- inFlight.drain(context.Background())
+	}()
+
+	inFlight.leave()
+
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the drain to finish after the last request")
+	}
+}
+
+func TestQueuedRequestsWhileDraining(t *testing.T) {
+
+	broker, err := MakeBasicMessageBroker(nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := broker.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the outbox needs an error to retry the request after a restart
+	if response, err := broker.deliverQueuedRequest(context.Background(), &Request{ID: "op-2.add(1)"}, &ClientInfo{Name: "op-1"}); err == nil || response != nil {
+		t.Fatalf("expected queued requests to fail while draining")
+	}
+}
@@ -0,0 +1,50 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+// the parameter that holds an end-to-end encrypted payload
+const EncryptedPayloadKey = "_encrypted"
+
+type EncryptionSettings struct {
+	// the key and certificate that we publish for encryption in the directory
+	KeyFile         string `json:"key_file"`
+	CertificateFile string `json:"certificate_file"`
+	// if set, we refuse to exchange payloads in clear with other operators
+	Required bool `json:"required"`
+}
+
+// Encrypts payloads end-to-end between the calling and the called operator,
+// so that the nodes in between only see the routing metadata
+type PayloadEncryption interface {
+	// Encrypts the parameters of a request to the recipient and returns a
+	// function that decrypts the response, or nil if the request stays in clear
+	EncryptRequest(request *Request, recipient *DirectoryEntry) (func(*Response) error, error)
+	// Decrypts the parameters of a request and returns a function that
+	// encrypts the response, or nil if the request was sent in clear
+	DecryptRequest(request *Request) (func(*Response) error, error)
+}
+
+// Returns true if the given parameters carry an encrypted payload
+func HasEncryptedPayload(params map[string]interface{}) bool {
+	_, ok := params[EncryptedPayloadKey]
+	return ok
+}
+
+// Enables end-to-end encryption of payloads exchanged with other operators
+func (b *BasicMessageBroker) SetPayloadEncryption(encryption PayloadEncryption) {
+	b.encryption = encryption
+}
@@ -0,0 +1,36 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+import (
+	"context"
+	"time"
+)
+
+// exposes internals to the tests in the hyper_test package
+
+func (o *Outbox) Backoff(attempts int) time.Duration {
+	return o.backoff(attempts)
+}
+
+func (o *Outbox) Attempt(entry *OutboxEntry, deliver OutboxDeliverer) {
+	o.attempt(context.Background(), entry, deliver)
+}
+
+func (o *Outbox) Compact() {
+	o.compact()
+}
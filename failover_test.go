@@ -0,0 +1,121 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper_test
+
+import (
+	"fmt"
+	"github.com/kiprotect/hyper"
+	th "github.com/kiprotect/hyper/testing"
+	"testing"
+)
+
+// Adds a primary and a backup channel to op-2, the primary fails with the
+// given error. The backup channel is added first but has a lower priority.
+func makeFailoverBroker(t *testing.T, primaryErr error) (*hyper.BasicMessageBroker, *th.MemoryChannel, *th.MemoryChannel) {
+
+	broker, backup := th.MakeMemoryBroker(t, th.MakeMemoryDirectory("op-1",
+		&hyper.DirectoryEntry{Name: "op-1"},
+		&hyper.DirectoryEntry{
+			Name: "op-2",
+			Services: []*hyper.OperatorService{
+				{
+					Name:    "lookup",
+					Methods: []*hyper.ServiceMethod{{Name: "get", RetrySafe: true}},
+				},
+			},
+		},
+	), func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+		return &hyper.Response{ID: &address.ID, Result: map[string]interface{}{"channel": "backup"}}, nil
+	}, "op-2")
+
+	backup.SetPriority(1)
+
+	primary := th.MakeMemoryChannel(func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+		if primaryErr != nil {
+			return nil, primaryErr
+		}
+		return &hyper.Response{ID: &address.ID, Result: map[string]interface{}{"channel": "primary"}}, nil
+	}, "op-2")
+
+	primary.SetName("primary")
+	primary.SetPriority(5)
+
+	if err := broker.AddChannel(primary); err != nil {
+		t.Fatal(err)
+	}
+
+	return broker, primary, backup
+}
+
+func deliverTo(broker *hyper.BasicMessageBroker, method string) (*hyper.Response, error) {
+	return broker.DeliverRequest(&hyper.Request{
+		ID:     fmt.Sprintf("op-2.%s(1)", method),
+		Method: "op-2." + method,
+		Params: map[string]interface{}{},
+	}, &hyper.ClientInfo{Name: "op-1"})
+}
+
+func TestChannelPriority(t *testing.T) {
+
+	broker, _, backup := makeFailoverBroker(t, nil)
+
+	if response, err := deliverTo(broker, "add"); err != nil {
+		t.Fatal(err)
+	} else if response.Result["channel"] != "primary" {
+		t.Fatalf("expected the channel with the highest priority to deliver the request")
+	}
+
+	if len(backup.Requests()) != 0 {
+		t.Fatalf("expected the backup channel not to be used")
+	}
+}
+
+func TestChannelFailover(t *testing.T) {
+
+	// the request never reached the recipient, so we can try another channel
+	broker, primary, _ := makeFailoverBroker(t, hyper.NotSent(fmt.Errorf("connection refused")))
+
+	if response, err := deliverTo(broker, "add"); err != nil {
+		t.Fatal(err)
+	} else if response.Result["channel"] != "backup" {
+		t.Fatalf("expected the backup channel to deliver the request")
+	}
+
+	if len(primary.Requests()) != 1 {
+		t.Fatalf("expected the primary channel to be tried first")
+	}
+
+	// the recipient may have processed the request already
+	broker, _, backup := makeFailoverBroker(t, fmt.Errorf("connection reset"))
+
+	if response, err := deliverTo(broker, "add"); err != nil {
+		t.Fatal(err)
+	} else if response.Error == nil {
+		t.Fatalf("expected the error of the primary channel")
+	}
+
+	if len(backup.Requests()) != 0 {
+		t.Fatalf("expected no failover for a request that may have been sent")
+	}
+
+	// unless the method may be called more than once
+	if response, err := deliverTo(broker, "get"); err != nil {
+		t.Fatal(err)
+	} else if response.Result["channel"] != "backup" {
+		t.Fatalf("expected the backup channel to deliver the retry-safe request")
+	}
+}
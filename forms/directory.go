@@ -57,6 +57,13 @@ var OperatorCertificateForm = forms.Form{
 				forms.IsString{},
 			},
 		},
+		{
+			Name: "certificate",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsString{},
+			},
+		},
 	},
 }
 
@@ -112,6 +119,41 @@ var ServiceMethodForm = forms.Form{
 				},
 			},
 		},
+		{
+			Name: "cache",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &CacheHintsForm,
+				},
+			},
+		},
+		{
+			Name: "retry_safe",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
+			},
+		},
+	},
+}
+
+var CacheHintsForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			// seconds for which a response may be reused
+			Name: "ttl",
+			Validators: []forms.Validator{
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+		{
+			Name: "shared",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
+			},
+		},
 	},
 }
 
@@ -172,7 +214,7 @@ var PermissionForm = forms.Form{
 			Validators: []forms.Validator{
 				forms.IsStringList{
 					Validators: []forms.Validator{
-						forms.IsIn{Choices: []interface{}{"call"}},
+						forms.IsIn{Choices: []interface{}{"call", "subscribe", "publish"}},
 					},
 				},
 				IsValidRightsList{},
@@ -281,6 +323,30 @@ var OperatorServiceForm = forms.Form{
 	},
 }
 
+var OperatorTopicForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "name",
+			Validators: []forms.Validator{
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "permissions",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: []interface{}{}},
+				forms.IsList{
+					Validators: []forms.Validator{
+						forms.IsStringMap{
+							Form: &PermissionForm,
+						},
+					},
+				},
+			},
+		},
+	},
+}
+
 var DirectoryEntryForm = forms.Form{
 	Fields: []forms.Field{
 		{
@@ -314,6 +380,19 @@ var DirectoryEntryForm = forms.Form{
 				},
 			},
 		},
+		{
+			Name: "topics",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: []interface{}{}},
+				forms.IsList{
+					Validators: []forms.Validator{
+						forms.IsStringMap{
+							Form: &OperatorTopicForm,
+						},
+					},
+				},
+			},
+		},
 		{
 			Name: "settings",
 			Validators: []forms.Validator{
@@ -419,7 +498,7 @@ var ChangeRecordForm = forms.Form{
 			Validators: []forms.Validator{
 				forms.IsString{},
 				forms.IsIn{
-					Choices: []interface{}{"channels", "certificates", "services", "preferences", "settings", "groups"},
+					Choices: []interface{}{"channels", "certificates", "services", "topics", "preferences", "settings", "groups"},
 				},
 			},
 		},
@@ -472,6 +551,15 @@ var ChangeRecordForm = forms.Form{
 								},
 							},
 						},
+						"topics": []forms.Validator{
+							forms.IsList{
+								Validators: []forms.Validator{
+									forms.IsStringMap{
+										Form: &OperatorTopicForm,
+									},
+								},
+							},
+						},
 						"certificates": []forms.Validator{
 							forms.IsList{
 								Validators: []forms.Validator{
@@ -0,0 +1,89 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package forms
+
+import (
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/hyper"
+)
+
+var InterceptorForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "type",
+			Validators: []forms.Validator{
+				forms.IsString{},
+				IsValidInterceptorType{},
+			},
+		},
+		{
+			Name: "settings",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{},
+				AreValidInterceptorSettings{},
+			},
+		},
+	},
+}
+
+type AreValidInterceptorSettings struct {
+}
+
+func (f AreValidInterceptorSettings) Validate(input interface{}, inputs map[string]interface{}) (interface{}, error) {
+	return nil, fmt.Errorf("cannot validate without context")
+}
+
+func (f AreValidInterceptorSettings) ValidateWithContext(input interface{}, inputs map[string]interface{}, context map[string]interface{}) (interface{}, error) {
+	definitions, ok := context["definitions"].(*hyper.Definitions)
+	if !ok {
+		return nil, fmt.Errorf("expected a 'definitions' context")
+	}
+	interceptorType := inputs["type"].(string)
+	// string type has been validated before
+	settings := input.(map[string]interface{})
+	if definition, ok := definitions.InterceptorDefinitions[interceptorType]; !ok {
+		return nil, fmt.Errorf("invalid interceptor type: '%s'", interceptorType)
+	} else if definition.SettingsValidator == nil {
+		return nil, fmt.Errorf("cannot validate settings for interceptor of type '%s'", interceptorType)
+	} else if validatedSettings, err := definition.SettingsValidator(settings); err != nil {
+		return nil, err
+	} else {
+		return validatedSettings, nil
+	}
+}
+
+type IsValidInterceptorType struct {
+}
+
+func (f IsValidInterceptorType) Validate(input interface{}, inputs map[string]interface{}) (interface{}, error) {
+	return nil, fmt.Errorf("cannot validate without context")
+}
+
+func (f IsValidInterceptorType) ValidateWithContext(input interface{}, inputs map[string]interface{}, context map[string]interface{}) (interface{}, error) {
+	definitions, ok := context["definitions"].(*hyper.Definitions)
+	if !ok {
+		return nil, fmt.Errorf("expected a 'definitions' context")
+	}
+	// string type has been validated before
+	strValue := input.(string)
+	if _, ok := definitions.InterceptorDefinitions[strValue]; !ok {
+		return nil, fmt.Errorf("invalid interceptor type: '%s'", strValue)
+	}
+	return input, nil
+}
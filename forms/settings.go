@@ -18,6 +18,8 @@ package forms
 
 import (
 	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/hyper"
+	"github.com/kiprotect/hyper/tls"
 )
 
 var DirectorySettingsForm = forms.Form{
@@ -54,6 +56,14 @@ var ChannelForm = forms.Form{
 				IsValidChannelType{},
 			},
 		},
+		{
+			// channels with a higher priority are tried first
+			Name: "priority",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 0},
+				forms.IsInteger{},
+			},
+		},
 		{
 			Name: "settings",
 			Validators: []forms.Validator{
@@ -75,6 +85,272 @@ var MetricsSettingsForm = forms.Form{
 	},
 }
 
+var OutboxSettingsForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "datastore",
+			Validators: []forms.Validator{
+				forms.IsStringMap{
+					Form: &DatastoreForm,
+				},
+			},
+		},
+		{
+			// number of seconds after which undelivered requests expire
+			Name: "ttl",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 86400},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+		{
+			Name: "initial_backoff",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 5},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+		{
+			Name: "max_backoff",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 600},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+	},
+}
+
+var TracingSettingsForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "file",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "endpoint",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsString{},
+			},
+		},
+		{
+			// number of seconds between two span exports
+			Name: "interval",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 5},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+	},
+}
+
+var EncryptionSettingsForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "key_file",
+			Validators: []forms.Validator{
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "certificate_file",
+			Validators: []forms.Validator{
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "required",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
+			},
+		},
+	},
+}
+
+var ShutdownSettingsForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "grace_period",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 30},
+				forms.IsInteger{HasMin: true, Min: 0},
+			},
+		},
+	},
+}
+
+var CircuitBreakerSettingsForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "failure_threshold",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 5},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+		{
+			// seconds before we let a trial request through
+			Name: "open_for",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 30},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+	},
+}
+
+var RetrySettingsForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "max_attempts",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 3},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+		{
+			// milliseconds
+			Name: "initial_backoff",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 100},
+				forms.IsInteger{HasMin: true, Min: 0},
+			},
+		},
+		{
+			// milliseconds
+			Name: "max_backoff",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 2000},
+				forms.IsInteger{HasMin: true, Min: 0},
+			},
+		},
+	},
+}
+
+var AdminSettingsForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "bind_address",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: hyper.DefaultAdminBindAddress},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "tls",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &tls.TLSSettingsForm,
+				},
+			},
+		},
+		{
+			Name: "token_file",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsString{},
+			},
+		},
+	},
+}
+
+var SignatureSettingsForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "required",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
+			},
+		},
+	},
+}
+
+var AuditSettingsForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "datastore",
+			Validators: []forms.Validator{
+				forms.IsStringMap{
+					Form: &DatastoreForm,
+				},
+			},
+		},
+	},
+}
+
+var IdempotencySettingsForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			// without a datastore responses are only kept in memory
+			Name: "datastore",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &DatastoreForm,
+				},
+			},
+		},
+		{
+			// number of seconds for which responses are kept
+			Name: "ttl",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 86400},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+		{
+			Name: "max_entries",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 10000},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+	},
+}
+
+var PubSubSettingsForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			// without a datastore subscriptions are only kept in memory
+			Name: "datastore",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &DatastoreForm,
+				},
+			},
+		},
+	},
+}
+
+var ResponseCacheSettingsForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			// without a datastore responses are only kept in memory
+			Name: "datastore",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &DatastoreForm,
+				},
+			},
+		},
+		{
+			Name: "max_entries",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 10000},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+	},
+}
+
 var SettingsForm = forms.Form{
 	Fields: []forms.Field{
 		{
@@ -100,6 +376,140 @@ var SettingsForm = forms.Form{
 				},
 			},
 		},
+		{
+			Name: "outbox",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &OutboxSettingsForm,
+				},
+			},
+		},
+		{
+			Name: "rate_limits",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: []interface{}{}},
+				forms.IsList{
+					Validators: []forms.Validator{
+						forms.IsStringMap{
+							Form: &hyper.RateLimitForm,
+						},
+					},
+				},
+			},
+		},
+		{
+			Name: "idempotency",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &IdempotencySettingsForm,
+				},
+			},
+		},
+		{
+			Name: "tracing",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &TracingSettingsForm,
+				},
+			},
+		},
+		{
+			Name: "encryption",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &EncryptionSettingsForm,
+				},
+			},
+		},
+		{
+			Name: "shutdown",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &ShutdownSettingsForm,
+				},
+			},
+		},
+		{
+			Name: "cache",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &ResponseCacheSettingsForm,
+				},
+			},
+		},
+		{
+			Name: "pubsub",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &PubSubSettingsForm,
+				},
+			},
+		},
+		{
+			Name: "circuit_breaker",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &CircuitBreakerSettingsForm,
+				},
+			},
+		},
+		{
+			Name: "retries",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &RetrySettingsForm,
+				},
+			},
+		},
+		{
+			Name: "admin",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &AdminSettingsForm,
+				},
+			},
+		},
+		{
+			Name: "signatures",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &SignatureSettingsForm,
+				},
+			},
+		},
+		{
+			Name: "audit",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &AuditSettingsForm,
+				},
+			},
+		},
+		{
+			Name: "interceptors",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: []interface{}{}},
+				forms.IsList{
+					Validators: []forms.Validator{
+						forms.IsStringMap{
+							Form: &InterceptorForm,
+						},
+					},
+				},
+			},
+		},
 		{
 			Name: "signing",
 			Validators: []forms.Validator{
@@ -9,6 +9,7 @@ require (
 	github.com/quic-go/quic-go v0.37.0
 	github.com/sirupsen/logrus v1.8.1
 	github.com/urfave/cli v1.22.5
+	golang.org/x/crypto v0.4.0
 	google.golang.org/grpc v1.44.0
 	google.golang.org/protobuf v1.28.0
 )
@@ -30,7 +31,6 @@ require (
 	github.com/prometheus/procfs v0.7.3 // indirect
 	github.com/quic-go/qtls-go1-20 v0.3.0 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
-	golang.org/x/crypto v0.4.0 // indirect
 	golang.org/x/exp v0.0.0-20221205204356-47842c84f3db // indirect
 	golang.org/x/mod v0.10.0 // indirect
 	golang.org/x/net v0.10.0 // indirect
@@ -25,8 +25,11 @@ import (
 	"github.com/kiprotect/hyper/protobuf"
 	"github.com/kiprotect/hyper/tls"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/structpb"
 	"io"
 	"net"
@@ -217,6 +220,24 @@ func (c *Client) Connect(address, serverName string) error {
 
 }
 
+// Returns false if the underlying connection is closed or has failed
+func (c *Client) Healthy() bool {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.connection == nil {
+		return false
+	}
+
+	switch c.connection.GetState() {
+	case connectivity.TransientFailure, connectivity.Shutdown:
+		return false
+	}
+
+	return true
+}
+
 func (c *Client) Close() error {
 
 	c.mutex.Lock()
@@ -258,6 +279,13 @@ func (c *Client) ServerCall(handler Handler, stop chan bool) error {
 		hyper.Log.Error(err)
 	}
 
+	// protects the stream against concurrent sends from the request handlers
+	var sendMutex sync.Mutex
+
+	// cancel functions of the requests that are currently being handled
+	inFlight := make(map[string]context.CancelFunc)
+	var inFlightMutex sync.Mutex
+
 	for {
 
 		done := make(chan bool, 1)
@@ -286,80 +314,103 @@ func (c *Client) ServerCall(handler Handler, stop chan bool) error {
 			return fmt.Errorf("error receiving gRPC request: %w", err)
 		}
 
-		request := &hyper.Request{
-			ID:     pbRequest.Id,
-			Params: pbRequest.Params.AsMap(),
-			Method: pbRequest.Method,
+		if pbRequest.Cancel {
+			// the server is no longer interested in the response
+			inFlightMutex.Lock()
+			if cancelRequest, ok := inFlight[pbRequest.Id]; ok {
+				cancelRequest()
+			}
+			inFlightMutex.Unlock()
+			continue
 		}
 
-		clientInfo := c.clientInfos.ClientInfo(pbRequest.ClientName)
+		requestCtx, cancelRequest := context.WithCancel(ctx)
 
-		if clientInfo == nil {
+		inFlightMutex.Lock()
+		inFlight[pbRequest.Id] = cancelRequest
+		inFlightMutex.Unlock()
 
-			pbResponse := &protobuf.Response{
-				Id: pbRequest.Id,
-			}
+		// we handle the request in the background so that a slow handler
+		// does not block other requests on the same stream
+		go func() {
+			defer func() {
+				inFlightMutex.Lock()
+				delete(inFlight, pbRequest.Id)
+				inFlightMutex.Unlock()
+				cancelRequest()
+			}()
+			c.handleServerRequest(requestCtx, handler, stream, &sendMutex, pbRequest)
+		}()
 
-			pbResponse.Error = &protobuf.Error{
-				Code:    404,
-				Message: "no matching client found",
-			}
+	}
 
-			if err := stream.Send(pbResponse); err != nil {
-				hyper.Log.Error(err)
-			}
+}
 
-			continue
-		}
+func (c *Client) handleServerRequest(ctx context.Context, handler Handler, stream protobuf.Hyper_ServerCallClient, sendMutex *sync.Mutex, pbRequest *protobuf.Request) {
 
-		response, err := handler.HandleRequest(request, clientInfo)
+	pbResponse := &protobuf.Response{
+		Id: pbRequest.Id,
+	}
 
-		pbResponse := &protobuf.Response{
-			Id: pbRequest.Id,
+	// responses may be sent in any order, the server matches them
+	// to the corresponding requests via their IDs
+	send := func() {
+		sendMutex.Lock()
+		defer sendMutex.Unlock()
+		if err := stream.Send(pbResponse); err != nil {
+			hyper.Log.Error(err)
 		}
+	}
 
-		if err != nil {
-			pbResponse.Error = &protobuf.Error{
-				Code:    -100,
-				Message: err.Error(),
-			}
-		} else if response != nil {
-			if response.Result != nil {
-				resultStruct, err := structpb.NewStruct(response.Result)
-				if err != nil {
-					hyper.Log.Error(err)
-				}
-				pbResponse.Result = resultStruct
-			}
-			if response.Error != nil {
-				pbResponse.Error = &protobuf.Error{
-					Code:    int32(response.Error.Code),
-					Message: response.Error.Message,
-				}
-
-				if response.Error.Data != nil {
-					errorStruct, err := structpb.NewStruct(response.Error.Data)
-					if err != nil {
-						hyper.Log.Error(err)
-					}
-					pbResponse.Error.Data = errorStruct
-				}
-			}
-		}
+	request := requestFromPB(pbRequest)
+	request.SetContext(ctx)
 
-		if err := stream.Send(pbResponse); err != nil {
-			hyper.Log.Error(err)
+	clientInfo := c.clientInfos.ClientInfo(pbRequest.ClientName)
+
+	if clientInfo == nil {
+
+		pbResponse.Error = &protobuf.Error{
+			Code:    404,
+			Message: "no matching client found",
 		}
 
+		send()
+		return
+	}
+
+	response, err := handler.HandleRequest(request, clientInfo)
+
+	if ctx.Err() != nil {
+		// the request was cancelled, so nobody is waiting for the response
+		hyper.Log.Debugf("Request %s was cancelled", request.ID)
+		return
 	}
 
+	if err != nil {
+		pbResponse.Error = &protobuf.Error{
+			Code:    -100,
+			Message: err.Error(),
+		}
+	} else if convertedResponse, err := responseToPB(pbRequest.Id, response); err != nil {
+		hyper.Log.Error(err)
+		pbResponse.Error = &protobuf.Error{
+			Code:    -100,
+			Message: err.Error(),
+		}
+	} else {
+		pbResponse = convertedResponse
+	}
+
+	send()
+
 }
 
 func (c *Client) SendRequest(request *hyper.Request) (*hyper.Response, error) {
 
 	client := protobuf.NewHyperClient(c.connection)
 
-	ctx, cancel := context.WithCancel(context.Background())
+	// the call is aborted when the caller is no longer interested in it
+	ctx, cancel := context.WithCancel(request.Context())
 	defer cancel()
 
 	paramsStruct, err := structpb.NewStruct(request.Params)
@@ -370,10 +421,14 @@ func (c *Client) SendRequest(request *hyper.Request) (*hyper.Response, error) {
 	}
 
 	pbRequest := &protobuf.Request{
-		ClientName: c.directory.Name(),
-		Params:     paramsStruct,
-		Method:     request.Method,
-		Id:         request.ID,
+		ClientName:     c.directory.Name(),
+		Params:         paramsStruct,
+		Method:         request.Method,
+		Id:             request.ID,
+		Deadline:       deadlineToPB(request),
+		IdempotencyKey: request.IdempotencyKey,
+		Traceparent:    traceParentToPB(request),
+		Signature:      signatureToPB(request.Signature),
 	}
 
 	pbResponse, err := client.Call(ctx, pbRequest)
@@ -383,24 +438,101 @@ func (c *Client) SendRequest(request *hyper.Request) (*hyper.Response, error) {
 		return nil, fmt.Errorf("error performing gRPC call: %w", err)
 	}
 
-	var responseError *hyper.Error
+	return responseFromPB(pbResponse), nil
 
-	if pbResponse.Error != nil {
-		responseError = &hyper.Error{
-			Code:    int(pbResponse.Error.Code),
-			Data:    pbResponse.Error.Data.AsMap(),
-			Message: pbResponse.Error.Message,
-		}
+}
+
+type clientStream struct {
+	stream protobuf.Hyper_StreamCallClient
+	first  *protobuf.Response
+	cancel context.CancelFunc
+}
+
+func (c *clientStream) Next() (*hyper.Response, error) {
+
+	if c.first != nil {
+		pbResponse := c.first
+		c.first = nil
+		return responseFromPB(pbResponse), nil
+	}
+
+	// we only receive the next chunk when the consumer asks for it, so a
+	// slow consumer applies backpressure to the server
+	pbResponse, err := c.stream.Recv()
+
+	if err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, fmt.Errorf("error receiving response chunk: %w", err)
+	}
+
+	return responseFromPB(pbResponse), nil
+}
+
+func (c *clientStream) Close() error {
+	c.cancel()
+	return nil
+}
+
+// Sends a request and returns a response whose result is streamed by the
+// server. Falls back to a normal call if the server doesn't support streams.
+func (c *Client) StreamRequest(request *hyper.Request) (*hyper.Response, error) {
+
+	client := protobuf.NewHyperClient(c.connection)
+
+	// the stream is aborted when the caller is no longer interested in it
+	// or when the stream is closed
+	ctx, cancel := context.WithCancel(request.Context())
+
+	paramsStruct, err := structpb.NewStruct(request.Params)
+
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("error serializing params for gRPC: %w", err)
+	}
+
+	pbRequest := &protobuf.Request{
+		ClientName:     c.directory.Name(),
+		Params:         paramsStruct,
+		Method:         request.Method,
+		Id:             request.ID,
+		Deadline:       deadlineToPB(request),
+		IdempotencyKey: request.IdempotencyKey,
+		Traceparent:    traceParentToPB(request),
+		Stream:         true,
+		Signature:      signatureToPB(request.Signature),
 	}
 
-	response := &hyper.Response{
-		Result: pbResponse.Result.AsMap(),
-		ID:     &pbResponse.Id,
-		Error:  responseError,
+	stream, err := client.StreamCall(ctx, pbRequest)
+
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("error performing gRPC stream call: %w", err)
 	}
 
-	return response, nil
+	// we receive the first chunk right away so that errors surface here
+	first, err := stream.Recv()
+
+	if status.Code(err) == codes.Unimplemented {
+		cancel()
+		hyper.Log.Debugf("Server does not support streams, falling back to a normal call...")
+		return c.SendRequest(request)
+	} else if err == io.EOF {
+		cancel()
+		return &hyper.Response{ID: &request.ID}, nil
+	} else if err != nil {
+		cancel()
+		return nil, fmt.Errorf("error receiving response chunk: %w", err)
+	}
 
+	return &hyper.Response{
+		ID: &request.ID,
+		Stream: &clientStream{
+			stream: stream,
+			first:  first,
+			cancel: cancel,
+		},
+	}, nil
 }
 
 func MakeClient(settings *GRPCClientSettings, dialer Dialer, directory hyper.Directory) (*Client, error) {
@@ -42,6 +42,17 @@ var GRPCClientSettingsForm = forms.Form{
 				forms.IsBoolean{},
 			},
 		},
+		{
+			// number of seconds after which unused pooled connections get closed
+			Name: "pool_idle_timeout",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 300},
+				forms.IsInteger{
+					HasMin: true,
+					Min:    0,
+				},
+			},
+		},
 		{
 			Name: "tls",
 			Validators: []forms.Validator{
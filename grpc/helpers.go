@@ -0,0 +1,156 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package grpc
+
+import (
+	"fmt"
+	"github.com/kiprotect/hyper"
+	"github.com/kiprotect/hyper/helpers"
+	"github.com/kiprotect/hyper/protobuf"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func requestFromPB(pbRequest *protobuf.Request) *hyper.Request {
+
+	request := &hyper.Request{
+		ID:             pbRequest.Id,
+		Params:         pbRequest.Params.AsMap(),
+		Method:         pbRequest.Method,
+		IdempotencyKey: pbRequest.IdempotencyKey,
+		Stream:         pbRequest.Stream,
+		Signature:      signatureFromPB(pbRequest.Signature),
+	}
+
+	if pbRequest.Traceparent != "" {
+		if trace, err := hyper.ParseTraceParent(pbRequest.Traceparent); err != nil {
+			// an invalid trace context just starts a new trace
+			hyper.Log.Warning(err)
+		} else {
+			request.Trace = trace
+		}
+	}
+
+	if pbRequest.Deadline != nil {
+		deadline := pbRequest.Deadline.AsTime()
+		request.Deadline = &deadline
+	}
+
+	return request
+}
+
+func deadlineToPB(request *hyper.Request) *timestamppb.Timestamp {
+	if request.Deadline == nil {
+		return nil
+	}
+	return timestamppb.New(*request.Deadline)
+}
+
+func traceParentToPB(request *hyper.Request) string {
+	if request.Trace == nil {
+		return ""
+	}
+	return request.Trace.TraceParent()
+}
+
+func signatureFromPB(pbSignature *protobuf.Signature) *hyper.Signature {
+	if pbSignature == nil {
+		return nil
+	}
+	return &hyper.Signature{
+		R:           pbSignature.R,
+		S:           pbSignature.S,
+		Certificate: pbSignature.Certificate,
+	}
+}
+
+func signatureToPB(signature *hyper.Signature) *protobuf.Signature {
+	if signature == nil {
+		return nil
+	}
+	return &protobuf.Signature{
+		R:           signature.R,
+		S:           signature.S,
+		Certificate: signature.Certificate,
+	}
+}
+
+func responseFromPB(pbResponse *protobuf.Response) *hyper.Response {
+
+	var responseError *hyper.Error
+
+	if pbResponse.Error != nil {
+		responseError = &hyper.Error{
+			Code:    int(pbResponse.Error.Code),
+			Data:    pbResponse.Error.Data.AsMap(),
+			Message: pbResponse.Error.Message,
+		}
+	}
+
+	return &hyper.Response{
+		Result:    pbResponse.Result.AsMap(),
+		ID:        &pbResponse.Id,
+		Error:     responseError,
+		Signature: signatureFromPB(pbResponse.Signature),
+	}
+}
+
+func responseToPB(id string, response *hyper.Response) (*protobuf.Response, error) {
+
+	pbResponse := &protobuf.Response{
+		Id: id,
+	}
+
+	if response == nil {
+		return pbResponse, nil
+	}
+
+	pbResponse.Signature = signatureToPB(response.Signature)
+
+	if response.Result != nil {
+		stringMap, err := helpers.ToStringMap(response.Result)
+		if err != nil {
+			return nil, fmt.Errorf("error converting result to string map: %w", err)
+		}
+		resultStruct, err := structpb.NewStruct(stringMap)
+		if err != nil {
+			return nil, fmt.Errorf("error serializing response for gRPC: %w", err)
+		}
+		pbResponse.Result = resultStruct
+	}
+
+	if response.Error != nil {
+		pbResponse.Error = &protobuf.Error{
+			Code:    int32(response.Error.Code),
+			Message: response.Error.Message,
+		}
+
+		if response.Error.Data != nil {
+			stringMap, err := helpers.ToStringMap(response.Error.Data)
+			if err != nil {
+				return nil, fmt.Errorf("error converting error data to string map: %w", err)
+			}
+			errorStruct, err := structpb.NewStruct(stringMap)
+			if err != nil {
+				return nil, fmt.Errorf("error serializing error data for gRPC: %w", err)
+			}
+			pbResponse.Error.Data = errorStruct
+		}
+	}
+
+	return pbResponse, nil
+}
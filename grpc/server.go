@@ -20,7 +20,6 @@ import (
 	"context"
 	"fmt"
 	"github.com/kiprotect/hyper"
-	"github.com/kiprotect/hyper/helpers"
 	"github.com/kiprotect/hyper/protobuf"
 	"github.com/kiprotect/hyper/tls"
 	"google.golang.org/grpc"
@@ -28,6 +27,7 @@ import (
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/protobuf/types/known/structpb"
+	"io"
 	"net"
 	"sync"
 	"time"
@@ -38,7 +38,24 @@ type ConnectedClient struct {
 	Stop       chan bool
 	directory  hyper.Directory
 	Info       *hyper.ClientInfo
-	mutex      sync.Mutex
+	// when the client opened the server call
+	ConnectedAt time.Time
+	// requests that were sent to the client and still await a response
+	pending   map[string]chan *protobuf.Response
+	mutex     sync.Mutex
+	sendMutex sync.Mutex
+	stopOnce  sync.Once
+}
+
+func MakeConnectedClient(info *hyper.ClientInfo, server protobuf.Hyper_ServerCallServer, directory hyper.Directory) *ConnectedClient {
+	return &ConnectedClient{
+		Info:        info,
+		ConnectedAt: time.Now(),
+		Stop:        make(chan bool),
+		CallServer:  server,
+		directory:   directory,
+		pending:     make(map[string]chan *protobuf.Response),
+	}
 }
 
 type Server struct {
@@ -50,6 +67,9 @@ type Server struct {
 	directory        hyper.Directory
 	mutex            sync.Mutex
 	handler          Handler
+	// closed when the graceful stop of the server is done
+	stopped   chan bool
+	drainOnce sync.Once
 }
 
 func (s *Server) Start() error {
@@ -62,10 +82,75 @@ func (s *Server) Start() error {
 
 }
 
+// Sends a GOAWAY to all clients and stops accepting connections, so that
+// clients make new calls elsewhere. Calls in flight continue, which includes
+// the server calls of reverse-connected clients.
+func (s *Server) Drain() {
+	s.drainOnce.Do(func() {
+		go func() {
+			s.server.GracefulStop()
+			close(s.stopped)
+		}()
+	})
+}
+
+// Drains the server and waits until reverse-connected clients have answered
+// all requests that we sent them, then closes their server calls and waits
+// for the remaining calls. Stops the server right away if the context is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+
+	s.Drain()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for s.pendingRequests() > 0 && ctx.Err() == nil {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+	}
+
+	s.closeClients()
+
+	select {
+	case <-s.stopped:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
+	}
+}
+
+// Stops the server right away, calls in flight fail
 func (s *Server) Stop() error {
+	s.closeClients()
+	s.server.Stop()
 	return nil
 }
 
+func (s *Server) closeClients() {
+	s.mutex.Lock()
+	clients := s.connectedClients
+	s.mutex.Unlock()
+	for _, client := range clients {
+		client.Close()
+	}
+}
+
+func (s *Server) pendingRequests() int {
+	s.mutex.Lock()
+	clients := s.connectedClients
+	s.mutex.Unlock()
+	pending := 0
+	for _, client := range clients {
+		client.mutex.Lock()
+		pending += len(client.pending)
+		client.mutex.Unlock()
+	}
+	return pending
+}
+
 // currently we allow messages up to 4MB in size
 var MaxMessageSize = 1024 * 1024 * 4
 
@@ -89,6 +174,7 @@ func MakeServer(settings *GRPCServerSettings, handler Handler, listener net.List
 		connectedClients: []*ConnectedClient{},
 		server:           grpc.NewServer(opts...),
 		settings:         settings,
+		stopped:          make(chan bool),
 	}
 
 	protobuf.RegisterHyperServer(server.server, server)
@@ -96,60 +182,122 @@ func MakeServer(settings *GRPCServerSettings, handler Handler, listener net.List
 	return server, nil
 }
 
-func (c *ConnectedClient) DeliverRequest(request *hyper.Request) (*hyper.Response, error) {
+// Closes the stop channel of the client, which terminates the corresponding
+// server call and fails all pending requests
+func (c *ConnectedClient) Close() {
+	c.stopOnce.Do(func() {
+		close(c.Stop)
+	})
+}
 
-	hyper.Log.Debugf("Trying to deliver request to connected client '%s'...", c.Info.Name)
+// Returns the number of requests that await a response from the client
+func (c *ConnectedClient) PendingRequests() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.pending)
+}
+
+func (c *ConnectedClient) addPending(id string) (chan *protobuf.Response, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.pending[id]; ok {
+		return nil, fmt.Errorf("a request with ID '%s' is already in flight", id)
+	}
+	// we buffer the channel so that the receiver never blocks
+	responseChannel := make(chan *protobuf.Response, 1)
+	c.pending[id] = responseChannel
+	return responseChannel, nil
+}
 
-	// we need to ensure only one goroutine calls this method at once
+func (c *ConnectedClient) removePending(id string) chan *protobuf.Response {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
+	responseChannel, ok := c.pending[id]
+	if !ok {
+		return nil
+	}
+	delete(c.pending, id)
+	return responseChannel
+}
+
+// Receives responses from the client and dispatches them to the waiting
+// requests based on the request ID. Returns when the stream fails.
+func (c *ConnectedClient) receive() error {
+	for {
+		pbResponse, err := c.CallServer.Recv()
+
+		if err != nil {
+			return err
+		}
+
+		if responseChannel := c.removePending(pbResponse.Id); responseChannel == nil {
+			hyper.Log.Warningf("Received response with unknown ID '%s' from client '%s'", pbResponse.Id, c.Info.Name)
+		} else {
+			responseChannel <- pbResponse
+		}
+	}
+}
+
+func (c *ConnectedClient) send(pbRequest *protobuf.Request) error {
+	// gRPC streams do not support concurrent calls to Send
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	return c.CallServer.Send(pbRequest)
+}
+
+func (c *ConnectedClient) DeliverRequest(request *hyper.Request) (*hyper.Response, error) {
+
+	hyper.Log.Debugf("Trying to deliver request to connected client '%s'...", c.Info.Name)
 
 	paramsStruct, err := structpb.NewStruct(request.Params)
 
 	if err != nil {
-		c.Stop <- true
 		return nil, fmt.Errorf("error serializing params for gRPC: %w", err)
 	}
 
 	pbRequest := &protobuf.Request{
-		ClientName: c.directory.Name(),
-		Params:     paramsStruct,
-		Method:     request.Method,
-		Id:         request.ID,
+		ClientName:     c.directory.Name(),
+		Params:         paramsStruct,
+		Method:         request.Method,
+		Id:             request.ID,
+		Deadline:       deadlineToPB(request),
+		IdempotencyKey: request.IdempotencyKey,
+		Traceparent:    traceParentToPB(request),
+		Signature:      signatureToPB(request.Signature),
 	}
 
-	if err := c.CallServer.Send(pbRequest); err != nil {
-		hyper.Log.Errorf("Cannot deliver request: %v", err)
-		c.Stop <- true
-		return nil, fmt.Errorf("error sending gRPC request: %w", err)
+	responseChannel, err := c.addPending(request.ID)
+
+	if err != nil {
+		return nil, err
 	}
 
-	if pbResponse, err := c.CallServer.Recv(); err != nil {
-		hyper.Log.Errorf("Cannot receive response: %v", err)
-		// we close the connection
-		c.Stop <- true
-		return nil, fmt.Errorf("error receiving gRPC response: %w", err)
-	} else {
+	// we make sure the request doesn't stay pending if we return early
+	defer c.removePending(request.ID)
 
-		var responseError *hyper.Error
+	if err := c.send(pbRequest); err != nil {
+		hyper.Log.Errorf("Cannot deliver request: %v", err)
+		// we close the connection
+		c.Close()
+		return nil, fmt.Errorf("error sending gRPC request: %w", err)
+	}
 
-		if pbResponse.Error != nil {
-			responseError = &hyper.Error{
-				Code:    int(pbResponse.Error.Code),
-				Data:    pbResponse.Error.Data.AsMap(),
-				Message: pbResponse.Error.Message,
-			}
-		}
+	var pbResponse *protobuf.Response
 
-		response := &hyper.Response{
-			ID:     &pbResponse.Id,
-			Result: pbResponse.Result.AsMap(),
-			Error:  responseError,
+	select {
+	case pbResponse = <-responseChannel:
+	case <-c.Stop:
+		return nil, fmt.Errorf("connection to client '%s' closed before a response was received", c.Info.Name)
+	case <-request.Context().Done():
+		// we tell the client that we're no longer interested in a response
+		if err := c.send(&protobuf.Request{Id: request.ID, ClientName: c.directory.Name(), Cancel: true}); err != nil {
+			hyper.Log.Errorf("Cannot cancel request: %v", err)
 		}
-
-		return response, nil
+		return nil, request.Context().Err()
 	}
 
+	return responseFromPB(pbResponse), nil
+
 }
 
 type Handler interface {
@@ -174,15 +322,12 @@ func (s *Server) DeliverRequest(request *hyper.Request) (*hyper.Response, error)
 }
 
 func (s *Server) CanDeliverTo(address *hyper.Address) bool {
-	for _, connectedClient := range s.connectedClients {
-		if connectedClient.Info.Name == address.Operator {
-			return true
-		}
-	}
-	return false
+	return s.getClient(address.Operator) != nil
 }
 
-func (s *Server) Call(context context.Context, pbRequest *protobuf.Request) (*protobuf.Response, error) {
+// returns the client info for the given name, which needs to match one of
+// the names from the client certificate
+func clientInfoFor(context context.Context, name string) (*hyper.ClientInfo, error) {
 
 	peer, ok := peer.FromContext(context)
 
@@ -196,63 +341,106 @@ func (s *Server) Call(context context.Context, pbRequest *protobuf.Request) (*pr
 		return nil, fmt.Errorf("cannot determine client info")
 	}
 
-	request := &hyper.Request{
-		ID:     pbRequest.Id,
-		Params: pbRequest.Params.AsMap(),
-		Method: pbRequest.Method,
-	}
-
-	// we make sure the name that the client has given matches with one name
-	// from the certificate...
-	clientInfo := clientInfoAuthInfo.ClientInfos.ClientInfo(pbRequest.ClientName)
+	clientInfo := clientInfoAuthInfo.ClientInfos.ClientInfo(name)
 
 	if clientInfo == nil {
 		return nil, fmt.Errorf("no matching client")
 	}
 
+	return clientInfo, nil
+}
+
+func (s *Server) Call(context context.Context, pbRequest *protobuf.Request) (*protobuf.Response, error) {
+
+	clientInfo, err := clientInfoFor(context, pbRequest.ClientName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	request := requestFromPB(pbRequest)
+
+	// the request is abandoned when the caller goes away
+	request.SetContext(context)
+
+	// a single response cannot be streamed
+	request.Stream = false
+
 	if response, err := s.handler.HandleRequest(request, clientInfo); err != nil {
 		return nil, fmt.Errorf("error handling gRPC request: %w", err)
 	} else {
+		return responseToPB(pbRequest.Id, response)
+	}
+
+}
+
+func (s *Server) StreamCall(pbRequest *protobuf.Request, server protobuf.Hyper_StreamCallServer) error {
+
+	clientInfo, err := clientInfoFor(server.Context(), pbRequest.ClientName)
+
+	if err != nil {
+		return err
+	}
+
+	request := requestFromPB(pbRequest)
+	request.SetContext(server.Context())
+	request.Stream = true
 
-		pbResponse := &protobuf.Response{
-			Id: pbRequest.Id,
+	response, err := s.handler.HandleRequest(request, clientInfo)
+
+	if err != nil {
+		return fmt.Errorf("error handling gRPC request: %w", err)
+	}
+
+	if response == nil || response.Stream == nil {
+		// the response wasn't streamed, we send it as the only chunk
+		if pbResponse, err := responseToPB(pbRequest.Id, response); err != nil {
+			return err
+		} else {
+			return server.Send(pbResponse)
+		}
+	}
+
+	defer response.Stream.Close()
+
+	for {
+		chunk, err := response.Stream.Next()
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error reading response stream: %w", err)
 		}
-		if response != nil {
-			if response.Result != nil {
-				stringMap, err := helpers.ToStringMap(response.Result)
-				if err != nil {
-					return nil, fmt.Errorf("error converting result to string map: %w", err)
-				}
-				resultStruct, err := structpb.NewStruct(stringMap)
-				if err != nil {
-					return nil, fmt.Errorf("error serializing response for gRPC: %w", err)
-				}
-				pbResponse.Result = resultStruct
-			}
-			if response.Error != nil {
-				pbResponse.Error = &protobuf.Error{
-					Code:    int32(response.Error.Code),
-					Message: response.Error.Message,
-				}
-
-				if response.Error.Data != nil {
-					stringMap, err := helpers.ToStringMap(response.Error.Data)
-					if err != nil {
-						return nil, fmt.Errorf("error converting error data to string map: %w", err)
-					}
-					errorStruct, err := structpb.NewStruct(stringMap)
-					if err != nil {
-						return nil, fmt.Errorf("error serializing error data for gRPC: %w", err)
-					}
-					pbResponse.Error.Data = errorStruct
-				}
-
-			}
+
+		pbResponse, err := responseToPB(pbRequest.Id, chunk)
+
+		if err != nil {
+			return err
 		}
-		return pbResponse, nil
 
+		// this blocks if the client doesn't keep up with the chunks
+		if err := server.Send(pbResponse); err != nil {
+			return err
+		}
 	}
+}
 
+// Returns the clients that are currently connected via a server call
+func (s *Server) ConnectedClients() []*ConnectedClient {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.connectedClients
+}
+
+// Closes the server call of the given client, which fails the requests that
+// await a response from it. Returns false if the client isn't connected.
+func (s *Server) DisconnectClient(name string) bool {
+	client := s.getClient(name)
+	if client == nil {
+		return false
+	}
+	client.Close()
+	return true
 }
 
 func (s *Server) getClient(name string) *ConnectedClient {
@@ -337,34 +525,43 @@ func (s *Server) ServerCall(server protobuf.Hyper_ServerCallServer) error {
 		return fmt.Errorf("invalid client name supplied")
 	}
 
-	client := s.getClient(name)
+	client := MakeConnectedClient(clientInfoAuthInfo.ClientInfos.ClientInfo(name), server, s.directory)
 
-	if client == nil {
-		client = &ConnectedClient{
-			Info:       clientInfoAuthInfo.ClientInfos.ClientInfo(name),
-			Stop:       make(chan bool),
-			CallServer: server,
-			directory:  s.directory,
-		}
-		s.setClient(client)
+	// if the client was already connected we replace the old connection
+	if existingClient := s.getClient(name); existingClient != nil {
+		hyper.Log.Debugf("Replacing existing connection of client '%s'", name)
+		existingClient.Close()
+		s.deleteClient(existingClient)
 	}
 
+	s.setClient(client)
+
 	hyper.Log.Debugf("Received incoming gRPC connection from client '%s' (primary name)", clientInfoAuthInfo.ClientInfos.PrimaryName())
 
-	// we update the CallServer reference in the client (in case it has been updated)
-	s.mutex.Lock()
-	client.CallServer = server
-	s.mutex.Unlock()
+	received := make(chan error, 1)
+
+	// we receive responses in the background so that we can
+	// deliver multiple requests to the client at the same time
+	go func() {
+		received <- client.receive()
+	}()
 
 	// we wait for the client to stop...
 	select {
 	case <-client.Stop:
 		break
+	// the stream failed or was closed by the client
+	case err := <-received:
+		if err != nil && err != io.EOF {
+			hyper.Log.Errorf("Cannot receive response from client '%s': %v", name, err)
+		}
+		break
 	// the server is done (e.g. because the connection was closed)
 	case <-server.Context().Done():
 		break
 	}
 
+	client.Close()
 	s.deleteClient(client)
 
 	return nil
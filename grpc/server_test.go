@@ -0,0 +1,370 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/kiprotect/hyper"
+	"github.com/kiprotect/hyper/protobuf"
+	th "github.com/kiprotect/hyper/testing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type handlerFunc func(*hyper.Request, *hyper.ClientInfo) (*hyper.Response, error)
+
+func (h handlerFunc) HandleRequest(request *hyper.Request, clientInfo *hyper.ClientInfo) (*hyper.Response, error) {
+	return h(request, clientInfo)
+}
+
+// authenticates every connection as the given clients, as we don't use
+// certificates in the tests
+type testCredentials struct {
+	credentials.TransportCredentials
+	clientInfos *ClientInfos
+}
+
+func (c testCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, authInfo, err := c.TransportCredentials.ServerHandshake(conn)
+	if err != nil {
+		return conn, authInfo, err
+	}
+	return conn, &ClientInfoAuthInfo{authInfo, c.clientInfos}, nil
+}
+
+// Connects a client of operator 'op-1' to the server of operator 'op-0'
+// via a server call, requests from the server go to the given handler
+func connectServerCall(t *testing.T, handler Handler) (*Server, func()) {
+
+	listener := bufconn.Listen(1 << 20)
+
+	serverCredentials := testCredentials{
+		TransportCredentials: insecure.NewCredentials(),
+		clientInfos:          &ClientInfos{Infos: []*hyper.ClientInfo{{Name: "op-1"}}},
+	}
+
+	server := &Server{
+		directory:        th.MakeMemoryDirectory("op-0"),
+		listener:         listener,
+		connectedClients: []*ConnectedClient{},
+		server:           grpc.NewServer(grpc.Creds(serverCredentials)),
+		stopped:          make(chan bool),
+	}
+
+	protobuf.RegisterHyperServer(server.server, server)
+
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	connection, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{
+		directory:   th.MakeMemoryDirectory("op-1"),
+		connection:  connection,
+		clientInfos: &ClientInfos{Infos: []*hyper.ClientInfo{{Name: "op-0"}}},
+	}
+
+	stop := make(chan bool)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- client.ServerCall(handler, stop)
+	}()
+
+	// we wait until the server knows the client
+	for i := 0; !server.CanDeliverTo(&hyper.Address{Operator: "op-1"}); i++ {
+		if i > 100 {
+			t.Fatalf("client did not connect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return server, func() {
+		select {
+		case stop <- true:
+			<-stop
+		case <-done:
+		}
+		connection.Close()
+		server.Stop()
+	}
+}
+
+func TestServerCall(t *testing.T) {
+
+	signature := &hyper.Signature{R: "r", S: "s", Certificate: "c"}
+
+	server, stop := connectServerCall(t, handlerFunc(func(request *hyper.Request, clientInfo *hyper.ClientInfo) (*hyper.Response, error) {
+
+		if clientInfo.Name != "op-0" {
+			return nil, fmt.Errorf("unexpected client: %s", clientInfo.Name)
+		}
+
+		a, _ := request.Params["a"].(float64)
+		b, _ := request.Params["b"].(float64)
+
+		return &hyper.Response{
+			ID:        &request.ID,
+			Result:    map[string]interface{}{"sum": a + b},
+			Signature: signature,
+		}, nil
+	}))
+
+	defer stop()
+
+	if server.CanDeliverTo(&hyper.Address{Operator: "op-2"}) {
+		t.Fatalf("expected the server not to deliver to unknown clients")
+	}
+
+	response, err := server.DeliverRequest(&hyper.Request{
+		ID:     "op-1.add(1)",
+		Method: "op-1.add",
+		Params: map[string]interface{}{"a": 1.0, "b": 2.0},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Error != nil {
+		t.Fatalf("unexpected error: %s", response.Error.Message)
+	}
+
+	if response.Result["sum"] != 3.0 {
+		t.Fatalf("expected a sum of 3, got %v", response.Result["sum"])
+	}
+
+	if response.Signature == nil || *response.Signature != *signature {
+		t.Fatalf("expected the signature of the response")
+	}
+}
+
+func TestServerCallErrors(t *testing.T) {
+
+	server, stop := connectServerCall(t, handlerFunc(func(request *hyper.Request, clientInfo *hyper.ClientInfo) (*hyper.Response, error) {
+		if request.Method == "op-1.fail" {
+			return nil, fmt.Errorf("failed")
+		}
+		return &hyper.Response{
+			ID:    &request.ID,
+			Error: &hyper.Error{Code: 404, Message: "not found", Data: map[string]interface{}{"method": request.Method}},
+		}, nil
+	}))
+
+	defer stop()
+
+	response, err := server.DeliverRequest(&hyper.Request{ID: "op-1.fail(1)", Method: "op-1.fail", Params: map[string]interface{}{}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Error == nil || response.Error.Code != -100 || response.Error.Message != "failed" {
+		t.Fatalf("expected the handler error")
+	}
+
+	response, err = server.DeliverRequest(&hyper.Request{ID: "op-1.missing(2)", Method: "op-1.missing", Params: map[string]interface{}{}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Error == nil || response.Error.Code != 404 || response.Error.Data["method"] != "op-1.missing" {
+		t.Fatalf("expected the error response of the handler")
+	}
+}
+
+func TestConcurrentServerCalls(t *testing.T) {
+
+	release := make(chan bool)
+
+	server, stop := connectServerCall(t, handlerFunc(func(request *hyper.Request, clientInfo *hyper.ClientInfo) (*hyper.Response, error) {
+		// all requests are in flight at the same time
+		<-release
+		return &hyper.Response{ID: &request.ID, Result: map[string]interface{}{"id": request.ID}}, nil
+	}))
+
+	defer stop()
+
+	var wg sync.WaitGroup
+
+	errors := make(chan error, 10)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("op-1.wait(%d)", i)
+			if response, err := server.DeliverRequest(&hyper.Request{ID: id, Method: "op-1.wait", Params: map[string]interface{}{}}); err != nil {
+				errors <- err
+			} else if response.Result["id"] != id {
+				errors <- fmt.Errorf("expected the response for %s, got %v", id, response.Result["id"])
+			}
+		}(i)
+	}
+
+	// the client list is read while clients connect and deliver requests
+	for i := 0; i < 10; i++ {
+		server.CanDeliverTo(&hyper.Address{Operator: "op-1"})
+	}
+
+	for i := 0; server.pendingRequests() < 10; i++ {
+		if i > 100 {
+			t.Fatalf("expected all requests to be pending")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(release)
+
+	wg.Wait()
+	close(errors)
+
+	for err := range errors {
+		t.Error(err)
+	}
+}
+
+func TestServerCallCancellation(t *testing.T) {
+
+	cancelled := make(chan bool, 1)
+
+	server, stop := connectServerCall(t, handlerFunc(func(request *hyper.Request, clientInfo *hyper.ClientInfo) (*hyper.Response, error) {
+		<-request.Context().Done()
+		cancelled <- true
+		return nil, request.Context().Err()
+	}))
+
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	request := &hyper.Request{ID: "op-1.wait(1)", Method: "op-1.wait", Params: map[string]interface{}{}}
+	request.SetContext(ctx)
+
+	if _, err := server.DeliverRequest(request); err != context.DeadlineExceeded {
+		t.Fatalf("expected the request to time out, got %v", err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the client to cancel the request")
+	}
+}
+
+func TestDeadline(t *testing.T) {
+
+	// answers with the deadline that arrived with the request
+	handler := handlerFunc(func(request *hyper.Request, clientInfo *hyper.ClientInfo) (*hyper.Response, error) {
+		if request.Deadline == nil {
+			return nil, fmt.Errorf("expected a deadline")
+		}
+		return &hyper.Response{ID: &request.ID, Result: map[string]interface{}{"deadline": request.Deadline.UTC().Format(time.RFC3339Nano)}}, nil
+	})
+
+	deadline := time.Now().Add(time.Minute)
+
+	server, stopServer := connectServerCall(t, handler)
+	defer stopServer()
+
+	client, stopClient := connectClient(t, handler, false)
+	defer stopClient()
+
+	// the deadline travels in both directions
+	deliver := map[string]func(*hyper.Request) (*hyper.Response, error){
+		"server call": server.DeliverRequest,
+		"call":        client.SendRequest,
+	}
+
+	for name, deliverRequest := range deliver {
+
+		response, err := deliverRequest(&hyper.Request{ID: "op-1.wait(1)", Method: "op-1.wait", Params: map[string]interface{}{}, Deadline: &deadline})
+
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		} else if response.Error != nil {
+			t.Fatalf("%s: unexpected error: %s", name, response.Error.Message)
+		}
+
+		if response.Result["deadline"] != deadline.UTC().Format(time.RFC3339Nano) {
+			t.Fatalf("%s: expected deadline %v, got %v", name, deadline, response.Result["deadline"])
+		}
+	}
+}
+
+func TestDisconnectClient(t *testing.T) {
+
+	server, stop := connectServerCall(t, handlerFunc(func(request *hyper.Request, clientInfo *hyper.ClientInfo) (*hyper.Response, error) {
+		<-request.Context().Done()
+		return nil, request.Context().Err()
+	}))
+
+	defer stop()
+
+	failed := make(chan error, 1)
+
+	go func() {
+		_, err := server.DeliverRequest(&hyper.Request{ID: "op-1.wait(1)", Method: "op-1.wait", Params: map[string]interface{}{}})
+		failed <- err
+	}()
+
+	for i := 0; server.pendingRequests() == 0; i++ {
+		if i > 100 {
+			t.Fatalf("expected a pending request")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !server.DisconnectClient("op-1") {
+		t.Fatalf("expected the client to be connected")
+	}
+
+	select {
+	case err := <-failed:
+		if err == nil {
+			t.Fatalf("expected the pending request to fail")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the pending request to fail when the client disconnects")
+	}
+
+	for i := 0; server.CanDeliverTo(&hyper.Address{Operator: "op-1"}); i++ {
+		if i > 100 {
+			t.Fatalf("expected the client to be removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
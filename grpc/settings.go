@@ -23,9 +23,10 @@ import (
 
 // Settings for the gRPC client
 type GRPCClientSettings struct {
-	TLS      *tls.TLSSettings `json:"tls"`
-	UseProxy bool             `json:"useProxy"`
-	Enabled  bool             `json:"enabled"`
+	TLS             *tls.TLSSettings `json:"tls"`
+	UseProxy        bool             `json:"useProxy"`
+	Enabled         bool             `json:"enabled"`
+	PoolIdleTimeout int64            `json:"pool_idle_timeout"`
 }
 
 // Settings for the gRPC server
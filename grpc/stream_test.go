@@ -0,0 +1,304 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/kiprotect/hyper"
+	"github.com/kiprotect/hyper/protobuf"
+	th "github.com/kiprotect/hyper/testing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a stream of large chunks that carry their own signatures
+func makeTestStream(ctx context.Context, chunks, size int) *th.MemoryStream {
+	stream := th.MakeMemoryStream(ctx, chunks)
+	stream.SetChunkMaker(func(n int) *hyper.Response {
+		return &hyper.Response{
+			Result: map[string]interface{}{
+				"chunk": float64(n),
+				"data":  strings.Repeat("x", size),
+			},
+			Signature: &hyper.Signature{R: fmt.Sprintf("r%d", n), S: "s", Certificate: "c"},
+		}
+	})
+	return stream
+}
+
+// a server that only supports unary calls, like older versions
+type unaryServer struct {
+	protobuf.UnimplementedHyperServer
+	server *Server
+}
+
+func (u *unaryServer) Call(ctx context.Context, pbRequest *protobuf.Request) (*protobuf.Response, error) {
+	return u.server.Call(ctx, pbRequest)
+}
+
+// Connects a client of operator 'op-1' to the server of operator 'op-0',
+// whose requests go to the given handler. The flow control windows are
+// fixed so that the amount of buffered data doesn't depend on the bandwidth.
+func connectClient(t *testing.T, handler Handler, unary bool) (*Client, func()) {
+
+	listener := bufconn.Listen(1 << 16)
+
+	serverCredentials := testCredentials{
+		TransportCredentials: insecure.NewCredentials(),
+		clientInfos:          &ClientInfos{Infos: []*hyper.ClientInfo{{Name: "op-1"}}},
+	}
+
+	server := &Server{
+		directory:        th.MakeMemoryDirectory("op-0"),
+		listener:         listener,
+		connectedClients: []*ConnectedClient{},
+		server:           grpc.NewServer(grpc.Creds(serverCredentials), grpc.MaxSendMsgSize(MaxMessageSize)),
+		handler:          handler,
+		stopped:          make(chan bool),
+	}
+
+	if unary {
+		protobuf.RegisterHyperServer(server.server, &unaryServer{server: server})
+	} else {
+		protobuf.RegisterHyperServer(server.server, server)
+	}
+
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	connection, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithInitialWindowSize(1<<16),
+		grpc.WithInitialConnWindowSize(1<<16),
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{
+		directory:  th.MakeMemoryDirectory("op-1"),
+		connection: connection,
+	}
+
+	return client, func() {
+		connection.Close()
+		server.Stop()
+	}
+}
+
+func TestStreamRequest(t *testing.T) {
+
+	var stream *th.MemoryStream
+
+	client, stop := connectClient(t, handlerFunc(func(request *hyper.Request, clientInfo *hyper.ClientInfo) (*hyper.Response, error) {
+		if clientInfo.Name != "op-1" || !request.Stream || request.Params["n"] != 3.0 {
+			return nil, fmt.Errorf("unexpected request")
+		}
+		stream = makeTestStream(request.Context(), 3, 10)
+		return &hyper.Response{ID: &request.ID, Stream: stream}, nil
+	}), false)
+
+	defer stop()
+
+	response, err := client.StreamRequest(&hyper.Request{
+		ID:     "op-0.list(1)",
+		Method: "op-0.list",
+		Params: map[string]interface{}{"n": 3},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Stream == nil {
+		t.Fatalf("expected a stream")
+	}
+
+	defer response.Stream.Close()
+
+	for i := 1; i <= 3; i++ {
+		chunk, err := response.Stream.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chunk.Result["chunk"] != float64(i) {
+			t.Fatalf("expected chunk %d, got %v", i, chunk.Result["chunk"])
+		}
+		// every chunk keeps its own signature
+		if chunk.Signature == nil || chunk.Signature.R != fmt.Sprintf("r%d", i) {
+			t.Fatalf("expected the signature of chunk %d", i)
+		}
+	}
+
+	if _, err := response.Stream.Next(); err != io.EOF {
+		t.Fatalf("expected the end of the stream, got %v", err)
+	}
+
+	select {
+	case <-stream.Closed():
+	case <-time.After(time.Second):
+		t.Fatalf("expected the server to close the stream")
+	}
+}
+
+func TestStreamRequestWithSingleResponse(t *testing.T) {
+
+	client, stop := connectClient(t, handlerFunc(func(request *hyper.Request, clientInfo *hyper.ClientInfo) (*hyper.Response, error) {
+		// the handler may answer without a stream
+		return &hyper.Response{ID: &request.ID, Result: map[string]interface{}{"sum": 3.0}}, nil
+	}), false)
+
+	defer stop()
+
+	response, err := client.StreamRequest(&hyper.Request{ID: "op-0.add(1)", Method: "op-0.add", Params: map[string]interface{}{}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer response.Stream.Close()
+
+	if chunk, err := response.Stream.Next(); err != nil {
+		t.Fatal(err)
+	} else if chunk.Result["sum"] != 3.0 {
+		t.Fatalf("expected the response as the only chunk")
+	}
+
+	if _, err := response.Stream.Next(); err != io.EOF {
+		t.Fatalf("expected the end of the stream, got %v", err)
+	}
+}
+
+func TestStreamRequestFallback(t *testing.T) {
+
+	client, stop := connectClient(t, handlerFunc(func(request *hyper.Request, clientInfo *hyper.ClientInfo) (*hyper.Response, error) {
+		if request.Stream {
+			return nil, fmt.Errorf("unexpected stream request")
+		}
+		return &hyper.Response{ID: &request.ID, Result: map[string]interface{}{"sum": 3.0}}, nil
+	}), true)
+
+	defer stop()
+
+	response, err := client.StreamRequest(&hyper.Request{ID: "op-0.add(1)", Method: "op-0.add", Params: map[string]interface{}{}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// servers without stream support give us a normal response
+	if response.Stream != nil {
+		t.Fatalf("expected a response without a stream")
+	}
+
+	if response.Error != nil || response.Result["sum"] != 3.0 {
+		t.Fatalf("expected the response of the unary call")
+	}
+}
+
+func TestStreamRequestClose(t *testing.T) {
+
+	streams := make(chan *th.MemoryStream, 1)
+
+	client, stop := connectClient(t, handlerFunc(func(request *hyper.Request, clientInfo *hyper.ClientInfo) (*hyper.Response, error) {
+		stream := makeTestStream(request.Context(), -1, 10)
+		streams <- stream
+		return &hyper.Response{ID: &request.ID, Stream: stream}, nil
+	}), false)
+
+	defer stop()
+
+	response, err := client.StreamRequest(&hyper.Request{ID: "op-0.list(1)", Method: "op-0.list", Params: map[string]interface{}{}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := response.Stream.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the consumer goes away in the middle of an endless stream
+	if err := response.Stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-(<-streams).Closed():
+	case <-time.After(time.Second):
+		t.Fatalf("expected the server to close the stream")
+	}
+}
+
+func TestStreamBackpressure(t *testing.T) {
+
+	const chunks = 32
+	const size = 1 << 17
+
+	var stream *th.MemoryStream
+
+	client, stop := connectClient(t, handlerFunc(func(request *hyper.Request, clientInfo *hyper.ClientInfo) (*hyper.Response, error) {
+		stream = makeTestStream(request.Context(), chunks, size)
+		return &hyper.Response{ID: &request.ID, Stream: stream}, nil
+	}), false)
+
+	defer stop()
+
+	response, err := client.StreamRequest(&hyper.Request{ID: "op-0.list(1)", Method: "op-0.list", Params: map[string]interface{}{}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer response.Stream.Close()
+
+	// the consumer stalls after the first chunk
+	time.Sleep(200 * time.Millisecond)
+
+	// the server only reads the chunks that fit into the flow control
+	// windows and buffers, instead of queueing the whole stream
+	if produced := stream.Produced(); produced > 8 {
+		t.Fatalf("expected the server to wait for the consumer, but it produced %d of %d chunks", produced, chunks)
+	}
+
+	received := 0
+
+	for {
+		if _, err := response.Stream.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		received++
+	}
+
+	if received != chunks {
+		t.Fatalf("expected %d chunks, got %d", chunks, received)
+	}
+}
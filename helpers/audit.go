@@ -0,0 +1,218 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package helpers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/hyper"
+	"sort"
+	"sync"
+)
+
+// An append-only audit log that chains its records via their hashes
+type ChainedAuditLog struct {
+	datastore hyper.Datastore
+	tip       *hyper.AuditChainRecord
+	mutex     sync.Mutex
+}
+
+func MakeChainedAuditLog(datastore hyper.Datastore) (*ChainedAuditLog, error) {
+
+	if err := datastore.Init(); err != nil {
+		return nil, fmt.Errorf("error initializing audit datastore: %w", err)
+	}
+
+	records, err := ReadAuditChain(datastore)
+
+	if err != nil {
+		return nil, fmt.Errorf("error loading audit records: %w", err)
+	}
+
+	auditLog := &ChainedAuditLog{
+		datastore: datastore,
+	}
+
+	if len(records) > 0 {
+		auditLog.tip = records[len(records)-1]
+	}
+
+	return auditLog, nil
+}
+
+func (a *ChainedAuditLog) Append(request *hyper.Request, record *hyper.AuditRecord) error {
+
+	digest, err := ParamsDigest(request.Params)
+
+	if err != nil {
+		return err
+	}
+
+	record.ParamsDigest = digest
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	chainRecord := &hyper.AuditChainRecord{
+		Record: record,
+	}
+
+	if a.tip != nil {
+		chainRecord.Index = a.tip.Index + 1
+		chainRecord.ParentHash = a.tip.Hash
+	}
+
+	if err := CalculateAuditRecordHash(chainRecord); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(chainRecord)
+
+	if err != nil {
+		return err
+	}
+
+	id, err := RandomID(16)
+
+	if err != nil {
+		return err
+	}
+
+	if err := a.datastore.Write(&hyper.DataEntry{
+		Type: hyper.AuditEntryType,
+		ID:   id,
+		Data: data,
+	}); err != nil {
+		return err
+	}
+
+	a.tip = chainRecord
+
+	return nil
+}
+
+// Returns a digest of the request parameters, leaving out the client info
+// that the broker adds to every request
+func ParamsDigest(params map[string]interface{}) (string, error) {
+
+	filteredParams := make(map[string]interface{}, len(params))
+
+	for k, v := range params {
+		if k == "_client" {
+			continue
+		}
+		filteredParams[k] = v
+	}
+
+	hash, err := StructuredHash(filteredParams)
+
+	if err != nil {
+		return "", fmt.Errorf("error calculating params digest: %w", err)
+	}
+
+	return hex.EncodeToString(hash), nil
+}
+
+// Calculates the hash of an audit record, which covers the parent hash and
+// the index as well
+func CalculateAuditRecordHash(record *hyper.AuditChainRecord) error {
+
+	// we always reset the hash before calculating the new one
+	record.Hash = ""
+
+	hash, err := StructuredHash(record)
+
+	if err != nil {
+		return fmt.Errorf("error calculating audit record hash: %w", err)
+	}
+
+	record.Hash = hex.EncodeToString(hash)
+
+	return nil
+}
+
+// Reads all audit records from the datastore, ordered by their index
+func ReadAuditChain(datastore hyper.Datastore) ([]*hyper.AuditChainRecord, error) {
+
+	dataEntries, err := datastore.Read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*hyper.AuditChainRecord, 0)
+
+	for _, dataEntry := range dataEntries {
+		if dataEntry.Type != hyper.AuditEntryType {
+			continue
+		}
+		record := &hyper.AuditChainRecord{}
+		if err := json.Unmarshal(dataEntry.Data, record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Index < records[j].Index
+	})
+
+	return records, nil
+}
+
+// Verifies that the given records form an unbroken chain. The records can
+// be a part of a longer chain, in which case the first record is trusted.
+func VerifyAuditChain(records []*hyper.AuditChainRecord) error {
+
+	for i, record := range records {
+
+		if record.Record == nil {
+			return fmt.Errorf("audit record %d is empty", record.Index)
+		}
+
+		hash := record.Hash
+
+		if err := CalculateAuditRecordHash(record); err != nil {
+			return err
+		}
+
+		// we restore the original hash in any case
+		calculatedHash := record.Hash
+		record.Hash = hash
+
+		if calculatedHash != hash {
+			return fmt.Errorf("hash mismatch for audit record %d", record.Index)
+		}
+
+		if i == 0 {
+			continue
+		}
+
+		parent := records[i-1]
+
+		if record.Index != parent.Index+1 {
+			return fmt.Errorf("audit record %d follows record %d", record.Index, parent.Index)
+		}
+
+		if record.ParentHash != parent.Hash {
+			return fmt.Errorf("parent hash mismatch for audit record %d", record.Index)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package helpers
+
+import (
+	"github.com/kiprotect/hyper"
+	th "github.com/kiprotect/hyper/testing"
+	"testing"
+	"time"
+)
+
+func TestAuditChain(t *testing.T) {
+
+	datastore := &th.MemoryDatastore{}
+
+	auditLog, err := MakeChainedAuditLog(datastore)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		request := &hyper.Request{ID: "op-2.add(1)", Params: map[string]interface{}{"a": float64(i)}}
+		record := &hyper.AuditRecord{
+			RequestID: request.ID,
+			Caller:    "op-1",
+			Callee:    "op-2",
+			Method:    "add",
+			StartedAt: hyper.HashableTime{Time: time.Now()},
+		}
+		if err := auditLog.Append(request, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := ReadAuditChain(datastore)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 3 || records[2].Index != 2 {
+		t.Fatalf("expected three records")
+	}
+
+	if err := VerifyAuditChain(records); err != nil {
+		t.Fatal(err)
+	}
+
+	// a reloaded log continues the existing chain
+	if reloadedLog, err := MakeChainedAuditLog(datastore); err != nil {
+		t.Fatal(err)
+	} else if reloadedLog.tip.Hash != records[2].Hash {
+		t.Fatalf("expected the reloaded log to continue the chain")
+	}
+
+	records[1].Record.Caller = "op-3"
+
+	if err := VerifyAuditChain(records); err == nil {
+		t.Fatalf("expected an error for a modified record")
+	}
+
+	records[1].Record.Caller = "op-1"
+
+	if err := VerifyAuditChain([]*hyper.AuditChainRecord{records[0], records[2]}); err == nil {
+		t.Fatalf("expected an error for a removed record")
+	}
+}
@@ -34,23 +34,46 @@ func GetChannelSettingsAndDefinition(settings *hyper.Settings, name string) (*hy
 func InitializeChannels(broker hyper.MessageBroker, directory hyper.Directory, settings *hyper.Settings) ([]hyper.Channel, error) {
 	channels := make([]hyper.Channel, 0)
 	for _, channel := range settings.Channels {
-		hyper.Log.Debugf("Initializing channel '%s' of type '%s'", channel.Name, channel.Type)
-		definition := settings.Definitions.ChannelDefinitions[channel.Type]
-		if channelObj, err := definition.Maker(channel.Settings); err != nil {
-			return nil, fmt.Errorf("error initializing channel '%s': %w", channel.Name, err)
+		if channelObj, err := InitializeChannel(broker, directory, settings, channel); err != nil {
+			return nil, err
 		} else {
-			if err := broker.AddChannel(channelObj); err != nil {
-				return nil, fmt.Errorf("error adding channel '%s': %w", channel.Name, err)
-			}
-			if err := channelObj.SetDirectory(directory); err != nil {
-				return nil, fmt.Errorf("error setting directory for channel '%s': %w", channel.Name, err)
-			}
 			channels = append(channels, channelObj)
 		}
 	}
 	return channels, nil
 }
 
+// Creates the channel with the given settings and adds it to the broker
+func InitializeChannel(broker hyper.MessageBroker, directory hyper.Directory, settings *hyper.Settings, channel *hyper.ChannelSettings) (hyper.Channel, error) {
+	if channelObj, err := MakeChannel(broker, directory, settings, channel); err != nil {
+		return nil, err
+	} else if err := broker.AddChannel(channelObj); err != nil {
+		return nil, fmt.Errorf("error adding channel '%s': %w", channel.Name, err)
+	} else {
+		return channelObj, nil
+	}
+}
+
+// Creates the channel with the given settings without adding it to the broker,
+// e.g. so that it can replace another channel once it is open
+func MakeChannel(broker hyper.MessageBroker, directory hyper.Directory, settings *hyper.Settings, channel *hyper.ChannelSettings) (hyper.Channel, error) {
+	hyper.Log.Debugf("Initializing channel '%s' of type '%s'", channel.Name, channel.Type)
+	definition := settings.Definitions.ChannelDefinitions[channel.Type]
+	if channelObj, err := definition.Maker(channel.Settings); err != nil {
+		return nil, fmt.Errorf("error initializing channel '%s': %w", channel.Name, err)
+	} else {
+		channelObj.SetName(channelName(channel))
+		channelObj.SetPriority(channel.Priority)
+		if err := channelObj.SetMessageBroker(broker); err != nil {
+			return nil, fmt.Errorf("error setting message broker for channel '%s': %w", channel.Name, err)
+		}
+		if err := channelObj.SetDirectory(directory); err != nil {
+			return nil, fmt.Errorf("error setting directory for channel '%s': %w", channel.Name, err)
+		}
+		return channelObj, nil
+	}
+}
+
 func OpenChannels(broker hyper.MessageBroker, directory hyper.Directory, settings *hyper.Settings) ([]hyper.Channel, error) {
 
 	channels, err := InitializeChannels(broker, directory, settings)
@@ -0,0 +1,416 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package helpers
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/hyper"
+	"golang.org/x/crypto/hkdf"
+	"io"
+)
+
+// the only algorithm we support right now: an ephemeral ECDH key agreement
+// with the recipient's key, HKDF-SHA256 and AES-256-GCM
+const PayloadEncryptionAlgorithm = "ECDH-ES+HKDF-SHA256+A256GCM"
+
+// Encrypts payloads to the encryption certificates that operators publish
+// in the directory. Every request uses a fresh ephemeral key, from which
+// both endpoints derive one key for the request and one for the response.
+type PayloadEncryption struct {
+	key         *ecdh.PrivateKey
+	fingerprint string
+	required    bool
+}
+
+func MakePayloadEncryption(settings *hyper.EncryptionSettings) (*PayloadEncryption, error) {
+
+	privateKey, err := LoadPrivateKey(settings.KeyFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("error loading encryption key: %w", err)
+	}
+
+	cert, err := LoadCertificate(settings.CertificateFile, false)
+
+	if err != nil {
+		return nil, fmt.Errorf("error loading encryption certificate: %w", err)
+	}
+
+	if publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey); !ok || !publicKey.Equal(&privateKey.PublicKey) {
+		return nil, fmt.Errorf("encryption certificate does not match the key")
+	}
+
+	key, err := privateKey.ECDH()
+
+	if err != nil {
+		return nil, fmt.Errorf("unsupported encryption key: %w", err)
+	}
+
+	hash := sha256.Sum256(cert.Raw)
+
+	return &PayloadEncryption{
+		key:         key,
+		fingerprint: hex.EncodeToString(hash[:]),
+		required:    settings.Required,
+	}, nil
+}
+
+func (p *PayloadEncryption) EncryptRequest(request *hyper.Request, recipient *hyper.DirectoryEntry) (func(*hyper.Response) error, error) {
+
+	publicKey, fingerprint, err := EncryptionKeyFor(recipient)
+
+	if err != nil {
+		return nil, err
+	} else if publicKey == nil {
+		if p.required {
+			return nil, fmt.Errorf("operator '%s' does not publish an encryption certificate", recipient.Name)
+		}
+		return nil, nil
+	}
+
+	ephemeralKey, err := publicKey.Curve().GenerateKey(rand.Reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := ephemeralKey.ECDH(publicKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeralPublicKey := ephemeralKey.PublicKey().Bytes()
+
+	requestKey, responseKey, err := derivePayloadKeys(secret, ephemeralPublicKey, fingerprint)
+
+	if err != nil {
+		return nil, err
+	}
+
+	params, clientInfo := splitParams(request.Params)
+
+	envelope, err := sealPayload(requestKey, params, request.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	envelope["alg"] = PayloadEncryptionAlgorithm
+	envelope["kid"] = fingerprint
+	envelope["epk"] = base64.StdEncoding.EncodeToString(ephemeralPublicKey)
+
+	request.Params = map[string]interface{}{hyper.EncryptedPayloadKey: envelope}
+
+	if clientInfo != nil {
+		request.Params["_client"] = clientInfo
+	}
+
+	id := request.ID
+
+	return func(response *hyper.Response) error {
+		return openResponse(responseKey, response, id)
+	}, nil
+}
+
+func (p *PayloadEncryption) DecryptRequest(request *hyper.Request) (func(*hyper.Response) error, error) {
+
+	params, clientInfo := splitParams(request.Params)
+
+	envelope, ok := params[hyper.EncryptedPayloadKey].(map[string]interface{})
+
+	if !ok {
+		if p.required {
+			return nil, fmt.Errorf("payload needs to be encrypted")
+		} else if hyper.HasEncryptedPayload(params) {
+			return nil, fmt.Errorf("malformed encrypted payload")
+		}
+		return nil, nil
+	}
+
+	if len(params) != 1 {
+		return nil, fmt.Errorf("encrypted payload must not be mixed with other parameters")
+	}
+
+	if envelope["alg"] != PayloadEncryptionAlgorithm {
+		return nil, fmt.Errorf("unsupported algorithm")
+	}
+
+	if envelope["kid"] != p.fingerprint {
+		return nil, fmt.Errorf("payload was encrypted to a different certificate")
+	}
+
+	ephemeralPublicKey, err := envelopeBytes(envelope, "epk")
+
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := p.key.Curve().NewPublicKey(ephemeralPublicKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %w", err)
+	}
+
+	secret, err := p.key.ECDH(publicKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	requestKey, responseKey, err := derivePayloadKeys(secret, ephemeralPublicKey, p.fingerprint)
+
+	if err != nil {
+		return nil, err
+	}
+
+	decryptedParams, err := openPayload(requestKey, envelope, request.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the client info always comes from our own broker
+	if clientInfo != nil {
+		decryptedParams["_client"] = clientInfo
+	}
+
+	request.Params = decryptedParams
+
+	id := request.ID
+
+	return func(response *hyper.Response) error {
+		return sealResponse(responseKey, response, id)
+	}, nil
+}
+
+// Returns the encryption key of the given operator from the first encryption
+// certificate that includes the full certificate, or nil if there is none
+func EncryptionKeyFor(entry *hyper.DirectoryEntry) (*ecdh.PublicKey, string, error) {
+	for _, certificate := range entry.Certificates {
+
+		if certificate.KeyUsage != "encryption" || certificate.Certificate == "" {
+			continue
+		}
+
+		cert, err := LoadCertificateFromString(certificate.Certificate, false)
+
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid encryption certificate for operator '%s': %w", entry.Name, err)
+		}
+
+		// the fingerprint is what the signed directory vouches for
+		if !VerifyFingerprint(cert, certificate.Fingerprint) {
+			return nil, "", fmt.Errorf("encryption certificate for operator '%s' does not match its fingerprint", entry.Name)
+		}
+
+		publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+
+		if !ok {
+			continue
+		}
+
+		key, err := publicKey.ECDH()
+
+		if err != nil {
+			continue
+		}
+
+		return key, certificate.Fingerprint, nil
+	}
+	return nil, "", nil
+}
+
+func derivePayloadKeys(secret, ephemeralPublicKey []byte, fingerprint string) ([]byte, []byte, error) {
+	reader := hkdf.New(sha256.New, secret, ephemeralPublicKey, []byte("hyper payload encryption "+fingerprint))
+	keys := make([]byte, 64)
+	if _, err := io.ReadFull(reader, keys); err != nil {
+		return nil, nil, err
+	}
+	return keys[:32], keys[32:], nil
+}
+
+// separates the client info, which the brokers need to see, from the payload
+func splitParams(params map[string]interface{}) (map[string]interface{}, interface{}) {
+	payload := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		payload[key] = value
+	}
+	clientInfo := payload["_client"]
+	delete(payload, "_client")
+	return payload, clientInfo
+}
+
+func envelopeBytes(envelope map[string]interface{}, key string) ([]byte, error) {
+	if value, ok := envelope[key].(string); !ok {
+		return nil, fmt.Errorf("'%s' missing in encrypted payload", key)
+	} else if data, err := base64.StdEncoding.DecodeString(value); err != nil {
+		return nil, fmt.Errorf("invalid '%s' in encrypted payload: %w", key, err)
+	} else {
+		return data, nil
+	}
+}
+
+func payloadCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypts the JSON representation of the given value, the additional data
+// binds the ciphertext to the request it was created for
+func sealPayload(key []byte, value map[string]interface{}, additionalData string) (map[string]interface{}, error) {
+
+	aead, err := payloadCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := json.Marshal(value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"nonce":      base64.StdEncoding.EncodeToString(nonce),
+		"ciphertext": base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, plaintext, []byte(additionalData))),
+	}, nil
+}
+
+func openPayload(key []byte, envelope map[string]interface{}, additionalData string) (map[string]interface{}, error) {
+
+	aead, err := payloadCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := envelopeBytes(envelope, "nonce")
+
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := envelopeBytes(envelope, "ciphertext")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce size")
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(additionalData))
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt payload: %w", err)
+	}
+
+	var value map[string]interface{}
+
+	if err := json.Unmarshal(plaintext, &value); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+
+	return value, nil
+}
+
+// encrypts the result and the error data of a response, the error code and
+// message stay in clear so that the nodes in between can act on them
+func sealResponse(key []byte, response *hyper.Response, id string) error {
+
+	if response.Stream != nil {
+		chunk := 0
+		response.Stream = hyper.TransformStream(response.Stream, func(response *hyper.Response) error {
+			chunk++
+			return sealResponse(key, response, fmt.Sprintf("%s/%d", id, chunk))
+		})
+	}
+
+	if response.Result != nil {
+		if envelope, err := sealPayload(key, response.Result, id+"/result"); err != nil {
+			return err
+		} else {
+			response.Result = map[string]interface{}{hyper.EncryptedPayloadKey: envelope}
+		}
+	}
+
+	if response.Error != nil && response.Error.Data != nil {
+		if envelope, err := sealPayload(key, response.Error.Data, id+"/error"); err != nil {
+			return err
+		} else {
+			response.Error.Data = map[string]interface{}{hyper.EncryptedPayloadKey: envelope}
+		}
+	}
+
+	return nil
+}
+
+func openResponse(key []byte, response *hyper.Response, id string) error {
+
+	if response.Stream != nil {
+		chunk := 0
+		response.Stream = hyper.TransformStream(response.Stream, func(response *hyper.Response) error {
+			chunk++
+			return openResponse(key, response, fmt.Sprintf("%s/%d", id, chunk))
+		})
+	}
+
+	// only the recipient can produce results, whereas errors can also
+	// stem from the nodes in between
+	if response.Result != nil {
+		if envelope, ok := response.Result[hyper.EncryptedPayloadKey].(map[string]interface{}); !ok {
+			return fmt.Errorf("expected an encrypted result")
+		} else if result, err := openPayload(key, envelope, id+"/result"); err != nil {
+			return err
+		} else {
+			response.Result = result
+		}
+	}
+
+	if response.Error != nil && hyper.HasEncryptedPayload(response.Error.Data) {
+		if envelope, ok := response.Error.Data[hyper.EncryptedPayloadKey].(map[string]interface{}); !ok {
+			return fmt.Errorf("malformed encrypted error data")
+		} else if data, err := openPayload(key, envelope, id+"/error"); err != nil {
+			return err
+		} else {
+			response.Error.Data = data
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,284 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package helpers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/kiprotect/hyper"
+	th "github.com/kiprotect/hyper/testing"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writes a self-signed certificate and its key to the directory
+func makeTestCertificate(t *testing.T, dir, keyUsage string) (string, string, *hyper.OperatorCertificate) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "op-2"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyAgreement,
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	keyFile := filepath.Join(dir, "op-2.key")
+	certFile := filepath.Join(dir, "op-2.crt")
+
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	hash := sha256.Sum256(certDER)
+
+	return keyFile, certFile, &hyper.OperatorCertificate{
+		Fingerprint: hex.EncodeToString(hash[:]),
+		KeyUsage:    keyUsage,
+		Certificate: string(certPEM),
+	}
+}
+
+func TestPayloadEncryption(t *testing.T) {
+
+	keyFile, certFile, certificate := makeTestCertificate(t, t.TempDir(), "encryption")
+
+	recipient, err := MakePayloadEncryption(&hyper.EncryptionSettings{KeyFile: keyFile, CertificateFile: certFile})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the sender only needs a key to receive encrypted requests itself
+	keyFile, certFile, _ = makeTestCertificate(t, t.TempDir(), "encryption")
+	sender, err := MakePayloadEncryption(&hyper.EncryptionSettings{KeyFile: keyFile, CertificateFile: certFile})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := &hyper.DirectoryEntry{Name: "op-2", Certificates: []*hyper.OperatorCertificate{certificate}}
+
+	request := &hyper.Request{
+		ID:     "op-2.add(1)",
+		Method: "op-2.add",
+		Params: map[string]interface{}{"a": 1.0, "_client": map[string]interface{}{"name": "op-1"}},
+	}
+
+	decryptResponse, err := sender.EncryptRequest(request, entry)
+
+	if err != nil {
+		t.Fatal(err)
+	} else if decryptResponse == nil {
+		t.Fatalf("expected an encrypted request")
+	}
+
+	if _, ok := request.Params["a"]; ok || !hyper.HasEncryptedPayload(request.Params) || request.Params["_client"] == nil {
+		t.Fatalf("expected encrypted params with the client info in clear")
+	}
+
+	// a payload that was moved to another request can't be decrypted
+	movedRequest := &hyper.Request{ID: "op-2.add(2)", Params: request.Params}
+
+	if _, err := recipient.DecryptRequest(movedRequest); err == nil {
+		t.Fatalf("expected an error for a moved payload")
+	}
+
+	encryptResponse, err := recipient.DecryptRequest(request)
+
+	if err != nil {
+		t.Fatal(err)
+	} else if request.Params["a"] != 1.0 || request.Params["_client"] == nil {
+		t.Fatalf("expected decrypted params")
+	}
+
+	response := &hyper.Response{Result: map[string]interface{}{"sum": 3.0}}
+
+	if err := encryptResponse(response); err != nil {
+		t.Fatal(err)
+	} else if _, ok := response.Result["sum"]; ok {
+		t.Fatalf("expected an encrypted result")
+	}
+
+	if err := decryptResponse(response); err != nil {
+		t.Fatal(err)
+	} else if response.Result["sum"] != 3.0 {
+		t.Fatalf("expected a decrypted result")
+	}
+
+	// without an encryption certificate requests stay in clear unless
+	// encryption is required
+	if decryptResponse, err := sender.EncryptRequest(&hyper.Request{ID: "op-3.add(1)"}, &hyper.DirectoryEntry{Name: "op-3"}); err != nil || decryptResponse != nil {
+		t.Fatalf("expected a request in clear")
+	}
+
+	sender.required = true
+
+	if _, err := sender.EncryptRequest(&hyper.Request{ID: "op-3.add(1)"}, &hyper.DirectoryEntry{Name: "op-3"}); err == nil {
+		t.Fatalf("expected an error for a required encryption")
+	}
+}
+
+// reads all chunks of the stream and sends them through JSON like the
+// transports do
+func readChunks(t *testing.T, stream hyper.ResponseStream) ([]*hyper.Response, error) {
+
+	chunks := make([]*hyper.Response, 0)
+
+	for {
+		chunk, err := stream.Next()
+
+		if err == io.EOF {
+			return chunks, nil
+		} else if err != nil {
+			return chunks, err
+		}
+
+		data, err := json.Marshal(chunk)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		transported := &hyper.Response{}
+
+		if err := json.Unmarshal(data, transported); err != nil {
+			t.Fatal(err)
+		}
+
+		chunks = append(chunks, transported)
+	}
+}
+
+func TestEncryptedStream(t *testing.T) {
+
+	keyFile, certFile, certificate := makeTestCertificate(t, t.TempDir(), "encryption")
+
+	recipient, err := MakePayloadEncryption(&hyper.EncryptionSettings{KeyFile: keyFile, CertificateFile: certFile})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyFile, certFile, _ = makeTestCertificate(t, t.TempDir(), "encryption")
+	sender, err := MakePayloadEncryption(&hyper.EncryptionSettings{KeyFile: keyFile, CertificateFile: certFile})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := &hyper.DirectoryEntry{Name: "op-2", Certificates: []*hyper.OperatorCertificate{certificate}}
+
+	request := &hyper.Request{ID: "op-2.list(1)", Method: "op-2.list", Params: map[string]interface{}{}, Stream: true}
+
+	decryptResponse, err := sender.EncryptRequest(request, entry)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encryptResponse, err := recipient.DecryptRequest(request)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := &hyper.Response{Stream: th.MakeMemoryStream(context.Background(), 3)}
+
+	if err := encryptResponse(response); err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := readChunks(t, response.Stream)
+
+	if err != nil {
+		t.Fatal(err)
+	} else if len(sealed) != 3 {
+		t.Fatalf("expected 3 chunks, got %d", len(sealed))
+	}
+
+	for _, chunk := range sealed {
+		if !hyper.HasEncryptedPayload(chunk.Result) {
+			t.Fatalf("expected encrypted chunks")
+		}
+	}
+
+	opened := &hyper.Response{Stream: th.MakeChunkStream(sealed...)}
+
+	if err := decryptResponse(opened); err != nil {
+		t.Fatal(err)
+	}
+
+	chunks, err := readChunks(t, opened.Stream)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, chunk := range chunks {
+		if chunk.Result["chunk"] != float64(i+1) {
+			t.Fatalf("expected chunk %d, got %v", i+1, chunk.Result)
+		}
+	}
+
+	// the chunks are numbered, so they can't be reordered or dropped
+	reordered := &hyper.Response{Stream: th.MakeChunkStream(sealed[1], sealed[0])}
+
+	if err := decryptResponse(reordered); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readChunks(t, reordered.Stream); err == nil {
+		t.Fatalf("expected an error for reordered chunks")
+	}
+}
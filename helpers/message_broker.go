@@ -17,9 +17,115 @@
 package helpers
 
 import (
+	"fmt"
 	"github.com/kiprotect/hyper"
+	"github.com/kiprotect/hyper/metrics"
 )
 
-func InitializeMessageBroker(settings *hyper.Settings, directory hyper.Directory) (hyper.MessageBroker, error) {
-	return hyper.MakeBasicMessageBroker(directory)
+func InitializeMessageBroker(settings *hyper.Settings, directory hyper.Directory) (*hyper.BasicMessageBroker, error) {
+
+	broker, err := hyper.MakeBasicMessageBroker(directory)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if settings.Outbox != nil {
+		if datastore, err := InitializeDatastore(settings.Outbox.Datastore, settings.Definitions); err != nil {
+			return nil, fmt.Errorf("error initializing outbox datastore: %w", err)
+		} else if outbox, err := hyper.MakeOutbox(settings.Outbox, datastore); err != nil {
+			return nil, fmt.Errorf("error initializing outbox: %w", err)
+		} else {
+			broker.SetOutbox(outbox)
+		}
+	}
+
+	if settings.Idempotency != nil {
+		var datastore hyper.Datastore
+		if settings.Idempotency.Datastore != nil {
+			if datastore, err = InitializeDatastore(settings.Idempotency.Datastore, settings.Definitions); err != nil {
+				return nil, fmt.Errorf("error initializing idempotency datastore: %w", err)
+			}
+		}
+		if cache, err := hyper.MakeIdempotencyCache(settings.Idempotency, datastore); err != nil {
+			return nil, fmt.Errorf("error initializing idempotency cache: %w", err)
+		} else {
+			broker.SetIdempotencyCache(cache)
+		}
+	}
+
+	if settings.Cache != nil {
+		var datastore hyper.Datastore
+		if settings.Cache.Datastore != nil {
+			if datastore, err = InitializeDatastore(settings.Cache.Datastore, settings.Definitions); err != nil {
+				return nil, fmt.Errorf("error initializing response cache datastore: %w", err)
+			}
+		}
+		if cache, err := hyper.MakeResponseCache(settings.Cache, datastore); err != nil {
+			return nil, fmt.Errorf("error initializing response cache: %w", err)
+		} else {
+			metrics.RegisterResponseCacheMetrics(cache)
+			broker.SetResponseCache(cache)
+		}
+	}
+
+	if settings.PubSub != nil && settings.PubSub.Datastore != nil {
+		if datastore, err := InitializeDatastore(settings.PubSub.Datastore, settings.Definitions); err != nil {
+			return nil, fmt.Errorf("error initializing subscription datastore: %w", err)
+		} else if err := broker.SetSubscriptionStore(datastore); err != nil {
+			return nil, err
+		}
+	}
+
+	broker.SetRateLimits(settings.RateLimits)
+
+	if settings.CircuitBreaker != nil {
+		broker.SetCircuitBreaker(hyper.MakeCircuitBreaker(settings.CircuitBreaker))
+	}
+
+	if settings.Retries != nil {
+		broker.SetRetries(settings.Retries)
+	}
+
+	if settings.Audit != nil {
+		if datastore, err := InitializeDatastore(settings.Audit.Datastore, settings.Definitions); err != nil {
+			return nil, fmt.Errorf("error initializing audit datastore: %w", err)
+		} else if auditLog, err := MakeChainedAuditLog(datastore); err != nil {
+			return nil, fmt.Errorf("error initializing audit log: %w", err)
+		} else {
+			broker.SetAuditLog(auditLog)
+		}
+	}
+
+	if settings.Encryption != nil {
+		if encryption, err := MakePayloadEncryption(settings.Encryption); err != nil {
+			return nil, fmt.Errorf("error initializing payload encryption: %w", err)
+		} else {
+			broker.SetPayloadEncryption(encryption)
+		}
+	}
+
+	if settings.Signatures != nil {
+		if settings.Signing == nil {
+			return nil, fmt.Errorf("signatures require signing settings")
+		} else if signer, err := MakeMessageSigner(settings.Signing, settings.Signatures); err != nil {
+			return nil, fmt.Errorf("error initializing message signer: %w", err)
+		} else {
+			broker.SetMessageSigner(signer)
+		}
+	}
+
+	for _, interceptorSettings := range settings.Interceptors {
+		definition, ok := settings.Definitions.InterceptorDefinitions[interceptorSettings.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown interceptor type: '%s'", interceptorSettings.Type)
+		}
+		if interceptor, err := definition.Maker(interceptorSettings.Settings); err != nil {
+			return nil, fmt.Errorf("error initializing interceptor of type '%s': %w", interceptorSettings.Type, err)
+		} else {
+			broker.AddInterceptor(interceptor)
+		}
+	}
+
+	return broker, nil
 }
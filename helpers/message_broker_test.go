@@ -0,0 +1,127 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package helpers_test
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/hyper"
+	"github.com/kiprotect/hyper/definitions"
+	"github.com/kiprotect/hyper/helpers"
+	th "github.com/kiprotect/hyper/testing"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type tagSettings struct {
+	Tag string `json:"tag"`
+}
+
+var tagSettingsForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "tag",
+			Validators: []forms.Validator{
+				forms.IsString{},
+			},
+		},
+	},
+}
+
+// an interceptor that records its tag for every hook
+type tagInterceptor struct {
+	tag   string
+	tags  *[]string
+	mutex *sync.Mutex
+}
+
+func (i *tagInterceptor) BeforeDelivery(request *hyper.Request, clientInfo *hyper.ClientInfo) (*hyper.Response, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	*i.tags = append(*i.tags, "before:"+i.tag)
+	return nil, nil
+}
+
+func (i *tagInterceptor) AfterDelivery(request *hyper.Request, clientInfo *hyper.ClientInfo, response *hyper.Response, err error) (*hyper.Response, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	*i.tags = append(*i.tags, "after:"+i.tag)
+	return response, err
+}
+
+func TestInitializeInterceptors(t *testing.T) {
+
+	var tags []string
+	var mutex sync.Mutex
+
+	testDefinitions := hyper.MergeDefinitions(definitions.Default, hyper.Definitions{
+		InterceptorDefinitions: hyper.InterceptorDefinitions{
+			"tag": hyper.InterceptorDefinition{
+				Name: "Tag Interceptor",
+				Maker: func(settings interface{}) (hyper.Interceptor, error) {
+					return &tagInterceptor{tag: settings.(tagSettings).Tag, tags: &tags, mutex: &mutex}, nil
+				},
+				SettingsValidator: func(settings map[string]interface{}) (interface{}, error) {
+					if params, err := tagSettingsForm.Validate(settings); err != nil {
+						return nil, err
+					} else {
+						validatedSettings := &tagSettings{}
+						if err := tagSettingsForm.Coerce(validatedSettings, params); err != nil {
+							return nil, err
+						}
+						return validatedSettings, nil
+					}
+				},
+			},
+		},
+	})
+
+	settings := loadSettingsWithDefinitions(t, `
+channels: []
+interceptors:
+  - type: tag
+    settings:
+      tag: first
+  - type: log
+    settings:
+      params: true
+  - type: tag
+    settings:
+      tag: second
+`, &testDefinitions)
+
+	broker, err := helpers.InitializeMessageBroker(settings, th.MakeMemoryDirectory("op-1", th.Entries("op-1", "op-2")...))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := broker.AddChannel(th.MakeMemoryChannel(func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+		return &hyper.Response{ID: &address.ID, Result: map[string]interface{}{}}, nil
+	}, "op-2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := broker.DeliverRequest(&hyper.Request{ID: "op-2.add(1)", Method: "op-2.add", Params: map[string]interface{}{}}, &hyper.ClientInfo{Name: "op-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the chain follows the order of the settings
+	if order := strings.Join(tags, ","); order != "before:first,before:second,after:second,after:first" {
+		t.Fatalf("unexpected order of interceptors: %s", order)
+	}
+}
@@ -0,0 +1,245 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package helpers
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/hyper"
+)
+
+// Signs requests and responses with the signing key of the operator and
+// verifies them against the signing certificates in the directory
+type MessageSigner struct {
+	key      *ecdsa.PrivateKey
+	cert     *x509.Certificate
+	required bool
+}
+
+func MakeMessageSigner(signingSettings *hyper.SigningSettings, settings *hyper.SignatureSettings) (*MessageSigner, error) {
+
+	key, err := LoadPrivateKey(signingSettings.KeyFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("error loading signing key: %w", err)
+	}
+
+	cert, err := LoadCertificate(signingSettings.CertificateFile, true)
+
+	if err != nil {
+		return nil, fmt.Errorf("error loading signing certificate: %w", err)
+	}
+
+	return &MessageSigner{
+		key:      key,
+		cert:     cert,
+		required: settings.Required,
+	}, nil
+}
+
+func (m *MessageSigner) SignRequest(request *hyper.Request, recipient *hyper.DirectoryEntry) (func(*hyper.Response) error, error) {
+
+	data, err := RequestSignatureData(request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if signedData, err := Sign(data, m.key, m.cert); err != nil {
+		return nil, err
+	} else {
+		request.Signature = signedData.Signature
+	}
+
+	return func(response *hyper.Response) error {
+		return m.verifyResponse(data, response, recipient, 0)
+	}, nil
+}
+
+func (m *MessageSigner) VerifyRequest(request *hyper.Request, sender *hyper.DirectoryEntry) (func(*hyper.Response) error, error) {
+
+	data, err := RequestSignatureData(request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Signature == nil {
+		if m.required {
+			return nil, fmt.Errorf("request is not signed")
+		}
+	} else if err := VerifyMessageSignature(data, request.Signature, sender); err != nil {
+		return nil, err
+	}
+
+	return func(response *hyper.Response) error {
+		return m.signResponse(data, response, 0)
+	}, nil
+}
+
+func (m *MessageSigner) signResponse(request *hyper.RequestSignatureData, response *hyper.Response, chunk int) error {
+
+	if response.Stream != nil {
+		chunks := 0
+		response.Stream = hyper.TransformStream(response.Stream, func(response *hyper.Response) error {
+			chunks++
+			return m.signResponse(request, response, chunks)
+		})
+	}
+
+	data, err := ResponseSignatureData(request, response, chunk)
+
+	if err != nil {
+		return err
+	}
+
+	if signedData, err := Sign(data, m.key, m.cert); err != nil {
+		return err
+	} else {
+		response.Signature = signedData.Signature
+	}
+
+	return nil
+}
+
+func (m *MessageSigner) verifyResponse(request *hyper.RequestSignatureData, response *hyper.Response, recipient *hyper.DirectoryEntry, chunk int) error {
+
+	if response.Stream != nil {
+		chunks := 0
+		response.Stream = hyper.TransformStream(response.Stream, func(response *hyper.Response) error {
+			chunks++
+			return m.verifyResponse(request, response, recipient, chunks)
+		})
+	}
+
+	if response.Signature == nil {
+		// errors can also stem from the nodes in between, which can't sign
+		// on behalf of the recipient
+		if m.required && response.Result != nil {
+			return fmt.Errorf("response is not signed")
+		}
+		return nil
+	}
+
+	data, err := ResponseSignatureData(request, response, chunk)
+
+	if err != nil {
+		return err
+	}
+
+	return VerifyMessageSignature(data, response.Signature, recipient)
+}
+
+// Verifies the signature of the given data and checks that the signing
+// certificate belongs to the given operator
+func VerifyMessageSignature(data interface{}, signature *hyper.Signature, entry *hyper.DirectoryEntry) error {
+
+	cert, err := LoadCertificateFromString(signature.Certificate, true)
+
+	if err != nil {
+		return fmt.Errorf("invalid signing certificate: %w", err)
+	}
+
+	found := false
+
+	for _, certificate := range entry.Certificates {
+		if certificate.KeyUsage == "signing" && VerifyFingerprint(cert, certificate.Fingerprint) {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("signing certificate does not belong to operator '%s'", entry.Name)
+	}
+
+	if ok, err := Verify(&hyper.SignedData{Data: data, Signature: signature}, nil, nil, entry.Name); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("invalid signature")
+	}
+
+	return nil
+}
+
+// Returns the data that the sender of the given request signs
+func RequestSignatureData(request *hyper.Request) (*hyper.RequestSignatureData, error) {
+
+	params, err := normalizedDigest(request.Params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &hyper.RequestSignatureData{
+		ID:           request.ID,
+		Method:       request.Method,
+		ParamsDigest: params,
+	}, nil
+}
+
+// Returns the data that the recipient of a request signs for its response
+func ResponseSignatureData(request *hyper.RequestSignatureData, response *hyper.Response, chunk int) (*hyper.ResponseSignatureData, error) {
+
+	data := &hyper.ResponseSignatureData{
+		ID:           request.ID,
+		ParamsDigest: request.ParamsDigest,
+		Chunk:        chunk,
+	}
+
+	if response.Result != nil {
+		if result, err := normalizedDigest(response.Result); err != nil {
+			return nil, err
+		} else {
+			data.ResultDigest = result
+		}
+	}
+
+	if response.Error != nil {
+		data.ErrorCode = response.Error.Code
+		data.ErrorMessage = response.Error.Message
+	}
+
+	return data, nil
+}
+
+// numbers arrive as floats at the other end, so we digest the values in the
+// form in which they are transmitted
+func normalizedDigest(value map[string]interface{}) (string, error) {
+
+	data, err := json.Marshal(value)
+
+	if err != nil {
+		return "", err
+	}
+
+	var normalizedValue map[string]interface{}
+
+	if err := json.Unmarshal(data, &normalizedValue); err != nil {
+		return "", err
+	}
+
+	digest, err := ParamsDigest(normalizedValue)
+
+	if err != nil {
+		return "", err
+	}
+
+	return digest, nil
+}
@@ -0,0 +1,166 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package helpers
+
+import (
+	"context"
+	"github.com/kiprotect/hyper"
+	th "github.com/kiprotect/hyper/testing"
+	"testing"
+)
+
+func TestMessageSigner(t *testing.T) {
+
+	makeSigner := func() (*MessageSigner, *hyper.DirectoryEntry) {
+		keyFile, certFile, certificate := makeTestCertificate(t, t.TempDir(), "signing")
+		signer, err := MakeMessageSigner(&hyper.SigningSettings{KeyFile: keyFile, CertificateFile: certFile}, &hyper.SignatureSettings{Required: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signer, &hyper.DirectoryEntry{Name: "op", Certificates: []*hyper.OperatorCertificate{certificate}}
+	}
+
+	sender, senderEntry := makeSigner()
+	recipient, recipientEntry := makeSigner()
+
+	request := &hyper.Request{
+		ID:     "op-2.add(1)",
+		Method: "op-2.add",
+		// numbers are floats after the transport
+		Params: map[string]interface{}{"a": 1},
+	}
+
+	verifyResponse, err := sender.SignRequest(request, recipientEntry)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the recipient's broker adds the client info
+	request.Params = map[string]interface{}{"a": 1.0, "_client": map[string]interface{}{"name": "op-1"}}
+
+	if _, err := recipient.VerifyRequest(request, recipientEntry); err == nil {
+		t.Fatalf("expected an error for a certificate of another operator")
+	}
+
+	signResponse, err := recipient.VerifyRequest(request, senderEntry)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := &hyper.Response{Result: map[string]interface{}{"sum": 3.0}}
+
+	if err := signResponse(response); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verifyResponse(response); err != nil {
+		t.Fatal(err)
+	}
+
+	response.Result["sum"] = 4.0
+
+	if err := verifyResponse(response); err == nil {
+		t.Fatalf("expected an error for a modified response")
+	}
+
+	if _, err := recipient.VerifyRequest(&hyper.Request{ID: "op-2.add(2)", Method: "op-2.add"}, senderEntry); err == nil {
+		t.Fatalf("expected an error for an unsigned request")
+	}
+}
+
+func TestSignedStream(t *testing.T) {
+
+	keyFile, certFile, certificate := makeTestCertificate(t, t.TempDir(), "signing")
+	signer, err := MakeMessageSigner(&hyper.SigningSettings{KeyFile: keyFile, CertificateFile: certFile}, &hyper.SignatureSettings{Required: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := &hyper.DirectoryEntry{Name: "op", Certificates: []*hyper.OperatorCertificate{certificate}}
+
+	request := &hyper.Request{ID: "op-2.list(1)", Method: "op-2.list", Params: map[string]interface{}{}, Stream: true}
+
+	verifyResponse, err := signer.SignRequest(request, entry)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signResponse, err := signer.VerifyRequest(request, entry)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := &hyper.Response{Stream: th.MakeMemoryStream(context.Background(), 3)}
+
+	if err := signResponse(response); err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := readChunks(t, response.Stream)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, chunk := range signed {
+		if chunk.Signature == nil {
+			t.Fatalf("expected signed chunks")
+		}
+	}
+
+	verified := &hyper.Response{Stream: th.MakeChunkStream(signed...)}
+
+	if err := verifyResponse(verified); err != nil {
+		t.Fatal(err)
+	}
+
+	if chunks, err := readChunks(t, verified.Stream); err != nil {
+		t.Fatal(err)
+	} else if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %d", len(chunks))
+	}
+
+	// a chunk's signature doesn't hold at another position of the stream
+	reordered := &hyper.Response{Stream: th.MakeChunkStream(signed[1], signed[0])}
+
+	if err := verifyResponse(reordered); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readChunks(t, reordered.Stream); err == nil {
+		t.Fatalf("expected an error for reordered chunks")
+	}
+
+	// a modified chunk doesn't verify either
+	signed[2].Result["chunk"] = 4.0
+	modified := &hyper.Response{Stream: th.MakeChunkStream(signed...)}
+
+	if err := verifyResponse(modified); err != nil {
+		t.Fatal(err)
+	}
+
+	if chunks, err := readChunks(t, modified.Stream); err == nil {
+		t.Fatalf("expected an error for a modified chunk")
+	} else if len(chunks) != 2 {
+		t.Fatalf("expected the chunks before the modified one")
+	}
+}
@@ -0,0 +1,293 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package helpers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/hyper"
+	"github.com/kiprotect/hyper/channels"
+	"github.com/kiprotect/hyper/helpers"
+	"github.com/kiprotect/hyper/jsonrpc"
+	th "github.com/kiprotect/hyper/testing"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a JSON-RPC endpoint that answers with its number, optionally blocking
+// until the test releases it
+func makeEndpoint(number int, received chan bool, release chan bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := &jsonrpc.Request{}
+		if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		if received != nil {
+			received <- true
+			<-release
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"result":  map[string]interface{}{"endpoint": number},
+			"id":      request.ID,
+		})
+	}))
+}
+
+func TestReloadClientChannelWithRequestInFlight(t *testing.T) {
+
+	received := make(chan bool, 1)
+	release := make(chan bool)
+
+	first := makeEndpoint(1, received, release)
+	defer first.Close()
+
+	second := makeEndpoint(2, nil, nil)
+	defer second.Close()
+
+	definitions := &hyper.Definitions{
+		DirectoryDefinitions: hyper.DirectoryDefinitions{
+			"memory": {
+				Maker: func(name string, settings interface{}) (hyper.Directory, error) {
+					return th.MakeMemoryDirectory(name, &hyper.DirectoryEntry{Name: name}), nil
+				},
+			},
+		},
+		ChannelDefinitions: hyper.ChannelDefinitions{
+			"jsonrpc_client": {
+				Maker: channels.MakeJSONRPCClientChannel,
+			},
+		},
+	}
+
+	makeSettings := func(endpoint string) *hyper.Settings {
+		return &hyper.Settings{
+			Name:        "op-1",
+			Definitions: definitions,
+			Directory:   &hyper.DirectorySettings{Type: "memory"},
+			Channels: []*hyper.ChannelSettings{
+				{Name: "client", Type: "jsonrpc_client", Settings: jsonrpc.JSONRPCClientSettings{Endpoint: endpoint}},
+			},
+		}
+	}
+
+	server, err := helpers.MakeServer(makeSettings(first.URL))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(n int) (*hyper.Response, error) {
+		return server.Broker().DeliverRequest(&hyper.Request{
+			ID:     fmt.Sprintf("op-1.add(%d)", n),
+			Method: "op-1.add",
+			Params: map[string]interface{}{},
+		}, &hyper.ClientInfo{Name: "op-1"})
+	}
+
+	type result struct {
+		response *hyper.Response
+		err      error
+	}
+
+	inFlight := make(chan result, 1)
+
+	go func() {
+		response, err := call(1)
+		inFlight <- result{response, err}
+	}()
+
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the request to reach the first endpoint")
+	}
+
+	reloaded := make(chan error, 1)
+
+	go func() {
+		reloaded <- server.Reload(makeSettings(second.URL))
+	}()
+
+	// the old channel doesn't hold up the reload, the request that it
+	// delivers just finishes
+	select {
+	case err := <-reloaded:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the reload to finish")
+	}
+
+	if brokerChannels := server.Broker().Channels(); len(brokerChannels) != 1 || brokerChannels[0].Name() != "client" {
+		t.Fatalf("expected a single channel")
+	}
+
+	// new requests go to the new endpoint right away
+	if response, err := call(2); err != nil {
+		t.Fatal(err)
+	} else if response.Error != nil {
+		t.Fatalf("unexpected error: %s", response.Error.Message)
+	} else if response.Result["endpoint"] != float64(2) {
+		t.Fatalf("expected a response from the second endpoint, got %v", response.Result)
+	}
+
+	close(release)
+
+	select {
+	case r := <-inFlight:
+		if r.err != nil {
+			t.Fatal(r.err)
+		} else if r.response.Error != nil {
+			t.Fatalf("unexpected error: %s", r.response.Error.Message)
+		} else if r.response.Result["endpoint"] != float64(1) {
+			t.Fatalf("expected a response from the first endpoint, got %v", r.response.Result)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the request in flight to finish")
+	}
+
+	if err := server.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadRateLimitsUnderTraffic(t *testing.T) {
+
+	definitions := &hyper.Definitions{
+		DirectoryDefinitions: hyper.DirectoryDefinitions{
+			"memory": {
+				Maker: func(name string, settings interface{}) (hyper.Directory, error) {
+					return th.MakeMemoryDirectory(name,
+						&hyper.DirectoryEntry{
+							Name: name,
+							Services: []*hyper.OperatorService{
+								{
+									Name:        "calc",
+									Permissions: []*hyper.Permission{{Group: "*", Rights: []string{"call"}}},
+									Methods:     []*hyper.ServiceMethod{{Name: "add"}},
+								},
+							},
+						},
+						&hyper.DirectoryEntry{Name: "op-2"},
+					), nil
+				},
+			},
+		},
+		ChannelDefinitions: hyper.ChannelDefinitions{
+			"memory": {
+				Maker: func(settings interface{}) (hyper.Channel, error) {
+					return th.MakeMemoryChannel(func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+						return &hyper.Response{ID: &address.ID, Result: map[string]interface{}{}}, nil
+					}, "op-1"), nil
+				},
+			},
+		},
+	}
+
+	makeSettings := func(limit int64) *hyper.Settings {
+		return &hyper.Settings{
+			Name:        "op-1",
+			Definitions: definitions,
+			Directory:   &hyper.DirectorySettings{Type: "memory"},
+			Channels:    []*hyper.ChannelSettings{{Name: "memory", Type: "memory"}},
+			RateLimits:  []*hyper.RateLimit{{Method: "add", Type: "second", Limit: limit}},
+		}
+	}
+
+	server, err := helpers.MakeServer(makeSettings(1000000))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan bool)
+	started := make(chan bool, 4)
+	var wg sync.WaitGroup
+
+	// other operators keep calling us while we reload the limits
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, err := server.Broker().DeliverRequest(&hyper.Request{
+					ID:     fmt.Sprintf("op-1.add(%d-%d)", i, j),
+					Method: "op-1.add",
+					Params: map[string]interface{}{},
+				}, &hyper.ClientInfo{Name: "op-2"}); err != nil {
+					t.Error(err)
+					return
+				}
+				if j == 0 {
+					started <- true
+				}
+			}
+		}(i)
+	}
+
+	for i := 0; i < 4; i++ {
+		<-started
+	}
+
+	for i := 0; i < 20; i++ {
+		if err := server.Reload(makeSettings(int64(1000000 + i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+
+	// the last reload takes effect for new calls
+	if err := server.Reload(makeSettings(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	if response, err := server.Broker().DeliverRequest(&hyper.Request{
+		ID:     "op-1.add(last)",
+		Method: "op-1.add",
+		Params: map[string]interface{}{},
+	}, &hyper.ClientInfo{Name: "op-2"}); err != nil {
+		t.Fatal(err)
+	} else if response.Error == nil || response.Error.Code != 429 {
+		t.Fatalf("expected the reloaded rate limit to apply")
+	}
+
+	if err := server.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
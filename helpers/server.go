@@ -0,0 +1,607 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package helpers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/hyper"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A directory whose implementation can be replaced while it is in use
+type ReloadableDirectory struct {
+	directory hyper.Directory
+	mutex     sync.RWMutex
+}
+
+func MakeReloadableDirectory(directory hyper.Directory) *ReloadableDirectory {
+	return &ReloadableDirectory{
+		directory: directory,
+	}
+}
+
+func (r *ReloadableDirectory) Replace(directory hyper.Directory) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.directory = directory
+}
+
+// Returns the directory that is currently in use
+func (r *ReloadableDirectory) Current() hyper.Directory {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.directory
+}
+
+func (r *ReloadableDirectory) Entries(query *hyper.DirectoryQuery) ([]*hyper.DirectoryEntry, error) {
+	return r.Current().Entries(query)
+}
+
+func (r *ReloadableDirectory) EntryFor(name string) (*hyper.DirectoryEntry, error) {
+	return r.Current().EntryFor(name)
+}
+
+func (r *ReloadableDirectory) OwnEntry() (*hyper.DirectoryEntry, error) {
+	return r.Current().OwnEntry()
+}
+
+func (r *ReloadableDirectory) Name() string {
+	return r.Current().Name()
+}
+
+type serverChannel struct {
+	channel hyper.Channel
+	key     string
+}
+
+// A running node with a directory, a message broker and channels, which can
+// take over new settings without a restart
+type Server struct {
+	settings  *hyper.Settings
+	directory *ReloadableDirectory
+	broker    *hyper.BasicMessageBroker
+	channels  map[string]*serverChannel
+	mutex     sync.Mutex
+	// serializes reloads and the shutdown, which close channels without
+	// holding the mutex
+	changeMutex sync.Mutex
+}
+
+func MakeServer(settings *hyper.Settings) (*Server, error) {
+
+	directory, err := InitializeDirectory(settings)
+
+	if err != nil {
+		return nil, fmt.Errorf("error initializing directory: %w", err)
+	}
+
+	reloadableDirectory := MakeReloadableDirectory(directory)
+
+	broker, err := InitializeMessageBroker(settings, reloadableDirectory)
+
+	if err != nil {
+		return nil, fmt.Errorf("error initializing message broker: %w", err)
+	}
+
+	return &Server{
+		settings:  settings,
+		directory: reloadableDirectory,
+		broker:    broker,
+		channels:  make(map[string]*serverChannel),
+	}, nil
+}
+
+func (s *Server) Broker() *hyper.BasicMessageBroker {
+	return s.broker
+}
+
+func (s *Server) Directory() *ReloadableDirectory {
+	return s.directory
+}
+
+type ChannelStatus struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Priority int64  `json:"priority"`
+	// 'open' or 'draining'
+	State string `json:"state"`
+	// the number of connected operators, for channels that accept them
+	Clients *int `json:"clients,omitempty"`
+}
+
+// Returns the status of the open channels, ordered by name
+func (s *Server) ChannelStatus() []*ChannelStatus {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	state := "open"
+
+	if s.broker.Stats().Draining {
+		state = "draining"
+	}
+
+	statuses := make([]*ChannelStatus, 0, len(s.channels))
+
+	for _, sc := range s.channels {
+		status := &ChannelStatus{
+			Name:     sc.channel.Name(),
+			Type:     sc.channel.Type(),
+			Priority: sc.channel.Priority(),
+			State:    state,
+		}
+		if clientsChannel, ok := sc.channel.(hyper.ClientsChannel); ok {
+			clients := len(clientsChannel.ClientConnections())
+			status.Clients = &clients
+		}
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	return statuses
+}
+
+// Opens all channels from the settings
+func (s *Server) Open() error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, channelSettings := range s.settings.Channels {
+		if err := s.openChannel(s.settings, channelSettings); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) openChannel(settings *hyper.Settings, channelSettings *hyper.ChannelSettings) error {
+
+	key, err := ChannelSettingsKey(channelSettings)
+
+	if err != nil {
+		return fmt.Errorf("error calculating key for channel '%s': %w", channelName(channelSettings), err)
+	}
+
+	channel, err := InitializeChannel(s.broker, s.directory, settings, channelSettings)
+
+	if err != nil {
+		return err
+	}
+
+	if err := channel.Open(); err != nil {
+		if err := s.broker.RemoveChannel(channel); err != nil {
+			hyper.Log.Error(err)
+		}
+		return fmt.Errorf("error opening channel '%s': %w", channel.Name(), err)
+	}
+
+	s.channels[channel.Name()] = &serverChannel{
+		channel: channel,
+		key:     key,
+	}
+
+	return nil
+}
+
+// opens a channel with the given settings and swaps it for the existing one,
+// which keeps delivering requests until then. Needs to be called with the
+// mutex held.
+func (s *Server) replaceChannel(settings *hyper.Settings, channelSettings *hyper.ChannelSettings, old *serverChannel) error {
+
+	key, err := ChannelSettingsKey(channelSettings)
+
+	if err != nil {
+		return fmt.Errorf("error calculating key for channel '%s': %w", channelName(channelSettings), err)
+	}
+
+	channel, err := MakeChannel(s.broker, s.directory, settings, channelSettings)
+
+	if err != nil {
+		return err
+	}
+
+	if err := channel.Open(); err != nil {
+		return fmt.Errorf("error opening channel '%s': %w", channel.Name(), err)
+	}
+
+	if err := s.broker.ReplaceChannel(old.channel, channel); err != nil {
+		if err := channel.Close(); err != nil {
+			hyper.Log.Error(err)
+		}
+		return err
+	}
+
+	s.channels[channel.Name()] = &serverChannel{
+		channel: channel,
+		key:     key,
+	}
+
+	return nil
+}
+
+// we stop routing requests to the channel before closing it, so that only
+// the requests that it is already handling need to finish. Needs to be
+// called with the mutex held.
+func (s *Server) detachChannel(name string) (hyper.Channel, error) {
+
+	sc := s.channels[name]
+	delete(s.channels, name)
+
+	if err := s.broker.RemoveChannel(sc.channel); err != nil {
+		return nil, err
+	}
+
+	return sc.channel, nil
+}
+
+// waits for the requests of a detached channel and closes it, which can take
+// up to the grace period, so the mutex must not be held
+func closeChannel(ctx context.Context, channel hyper.Channel) error {
+
+	if drainingChannel, ok := channel.(hyper.DrainingChannel); ok {
+		if err := drainingChannel.Drain(); err != nil {
+			return err
+		}
+		return drainingChannel.Shutdown(ctx)
+	}
+
+	return channel.Close()
+}
+
+func (s *Server) gracePeriod() time.Duration {
+	if s.settings.Shutdown == nil {
+		return hyper.DefaultShutdownGracePeriod * time.Second
+	}
+	return time.Duration(s.settings.Shutdown.GracePeriod) * time.Second
+}
+
+// Takes over the given settings: the directory is rebuilt, removed channels
+// are closed, added ones are opened and changed ones are replaced. Changes to
+// other settings require a restart.
+func (s *Server) Reload(settings *hyper.Settings) error {
+
+	s.changeMutex.Lock()
+	defer s.changeMutex.Unlock()
+
+	s.mutex.Lock()
+
+	if settings.Name != s.settings.Name {
+		s.mutex.Unlock()
+		return fmt.Errorf("the name of a running node cannot be changed")
+	}
+
+	if changed := restartSettingsChanged(s.settings, settings); len(changed) > 0 {
+		hyper.Log.Warningf("Changes to the following settings require a restart: %s", strings.Join(changed, ", "))
+	}
+
+	// we always rebuild the directory as files that it reads may have changed
+	if directory, err := InitializeDirectory(settings); err != nil {
+		s.mutex.Unlock()
+		return fmt.Errorf("error initializing directory: %w", err)
+	} else {
+		s.directory.Replace(directory)
+	}
+
+	s.broker.SetRateLimits(settings.RateLimits)
+
+	keys := make(map[string]string, len(settings.Channels))
+
+	for _, channelSettings := range settings.Channels {
+		if key, err := ChannelSettingsKey(channelSettings); err != nil {
+			s.mutex.Unlock()
+			return fmt.Errorf("error calculating key for channel '%s': %w", channelName(channelSettings), err)
+		} else {
+			keys[channelName(channelSettings)] = key
+		}
+	}
+
+	var lastErr error
+
+	names := make([]string, 0, len(s.channels))
+
+	for name := range s.channels {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	closedNames := make([]string, 0, len(names))
+	closedChannels := make([]hyper.Channel, 0, len(names))
+
+	// the old settings of listening channels that we close before opening
+	// their replacement, so that we can reopen them if that fails
+	released := make(map[string]*hyper.ChannelSettings)
+
+	for _, name := range names {
+		key, ok := keys[name]
+		if ok && key == s.channels[name].key {
+			continue
+		}
+		// changed channels keep delivering requests until their replacement
+		// is open, unless the replacement needs their address
+		if _, listening := s.channels[name].channel.(hyper.ListeningChannel); ok && !listening {
+			continue
+		}
+		if channel, err := s.detachChannel(name); err != nil {
+			lastErr = fmt.Errorf("error closing channel '%s': %w", name, err)
+			hyper.Log.Error(lastErr)
+		} else {
+			closedNames = append(closedNames, name)
+			closedChannels = append(closedChannels, channel)
+			if ok {
+				released[name] = channelSettingsFor(s.settings, name)
+			}
+		}
+	}
+
+	s.mutex.Unlock()
+
+	// the detached channels no longer get new requests, so we can wait for
+	// the remaining ones without blocking the status
+	if err := s.closeChannels(closedNames, closedChannels); err != nil {
+		lastErr = err
+	}
+
+	s.mutex.Lock()
+
+	replacedNames := make([]string, 0, len(names))
+	replacedChannels := make([]hyper.Channel, 0, len(names))
+
+	for _, channelSettings := range settings.Channels {
+		name := channelName(channelSettings)
+		if sc, ok := s.channels[name]; ok {
+			if sc.key == keys[name] {
+				continue
+			}
+			hyper.Log.Infof("Replacing channel '%s'...", name)
+			if err := s.replaceChannel(settings, channelSettings, sc); err != nil {
+				lastErr = fmt.Errorf("error replacing channel '%s', keeping the old one: %w", name, err)
+				hyper.Log.Error(lastErr)
+			} else {
+				replacedNames = append(replacedNames, name)
+				replacedChannels = append(replacedChannels, sc.channel)
+			}
+			continue
+		}
+		hyper.Log.Infof("Opening channel '%s'...", name)
+		if err := s.openChannel(settings, channelSettings); err != nil {
+			lastErr = err
+			hyper.Log.Error(lastErr)
+			if oldSettings := released[name]; oldSettings != nil {
+				hyper.Log.Warningf("Reopening channel '%s' with the previous settings...", name)
+				if err := s.openChannel(s.settings, oldSettings); err != nil {
+					lastErr = err
+					hyper.Log.Error(lastErr)
+				}
+			}
+		}
+	}
+
+	s.settings = settings
+
+	s.mutex.Unlock()
+
+	// the replaced channels only finish the requests that they still handle
+	if err := s.closeChannels(replacedNames, replacedChannels); err != nil {
+		lastErr = err
+	}
+
+	return lastErr
+}
+
+// waits for the requests of detached channels and closes them within the
+// grace period, the mutex must not be held
+func (s *Server) closeChannels(names []string, channels []hyper.Channel) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.gracePeriod())
+	defer cancel()
+
+	var lastErr error
+
+	for i, channel := range channels {
+		hyper.Log.Infof("Closing channel '%s'...", names[i])
+		if err := closeChannel(ctx, channel); err != nil {
+			lastErr = fmt.Errorf("error closing channel '%s': %w", names[i], err)
+			hyper.Log.Error(lastErr)
+		}
+	}
+
+	return lastErr
+}
+
+// Shuts the node down gracefully: connected peers are told that we're going
+// away, new requests are rejected and requests in flight get the grace period
+// to finish before the channels are closed
+func (s *Server) Shutdown() error {
+
+	s.changeMutex.Lock()
+	defer s.changeMutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.gracePeriod())
+	defer cancel()
+
+	s.mutex.Lock()
+
+	channels := make(map[string]hyper.Channel, len(s.channels))
+
+	for name, sc := range s.channels {
+		channels[name] = sc.channel
+	}
+
+	s.mutex.Unlock()
+
+	for name, channel := range channels {
+		if drainingChannel, ok := channel.(hyper.DrainingChannel); ok {
+			if err := drainingChannel.Drain(); err != nil {
+				hyper.Log.Errorf("Error draining channel '%s': %v", name, err)
+			}
+		}
+	}
+
+	// queued requests stay in the outbox until the next start
+	s.broker.Stop()
+
+	if err := s.broker.Drain(ctx); err != nil {
+		hyper.Log.Warningf("Not all requests were done within the grace period: %v", err)
+	}
+
+	var lastErr error
+
+	// requests in flight may still have needed the channels up to here
+	s.mutex.Lock()
+
+	for name := range channels {
+		if _, err := s.detachChannel(name); err != nil {
+			lastErr = fmt.Errorf("error closing channel '%s': %w", name, err)
+			hyper.Log.Error(lastErr)
+		}
+	}
+
+	s.mutex.Unlock()
+
+	for name, channel := range channels {
+		if err := closeChannel(ctx, channel); err != nil {
+			lastErr = fmt.Errorf("error closing channel '%s': %w", name, err)
+			hyper.Log.Error(lastErr)
+		}
+	}
+
+	return lastErr
+}
+
+// returns the settings of the channel with the given name
+func channelSettingsFor(settings *hyper.Settings, name string) *hyper.ChannelSettings {
+	for _, channelSettings := range settings.Channels {
+		if channelName(channelSettings) == name {
+			return channelSettings
+		}
+	}
+	return nil
+}
+
+// the broker uses the type as name for channels without a name
+func channelName(settings *hyper.ChannelSettings) string {
+	if settings.Name == "" {
+		return settings.Type
+	}
+	return settings.Name
+}
+
+// returns the names of the settings that we can't change on a running node
+func restartSettingsChanged(old, new *hyper.Settings) []string {
+
+	settings := map[string][2]interface{}{
+		"signing":         {old.Signing, new.Signing},
+		"metrics":         {old.Metrics, new.Metrics},
+		"outbox":          {old.Outbox, new.Outbox},
+		"interceptors":    {old.Interceptors, new.Interceptors},
+		"idempotency":     {old.Idempotency, new.Idempotency},
+		"audit":           {old.Audit, new.Audit},
+		"tracing":         {old.Tracing, new.Tracing},
+		"encryption":      {old.Encryption, new.Encryption},
+		"signatures":      {old.Signatures, new.Signatures},
+		"admin":           {old.Admin, new.Admin},
+		"circuit_breaker": {old.CircuitBreaker, new.CircuitBreaker},
+		"retries":         {old.Retries, new.Retries},
+		"cache":           {old.Cache, new.Cache},
+		"pubsub":          {old.PubSub, new.PubSub},
+	}
+
+	changed := make([]string, 0)
+
+	for name, values := range settings {
+		if !reflect.DeepEqual(values[0], values[1]) {
+			changed = append(changed, name)
+		}
+	}
+
+	sort.Strings(changed)
+
+	return changed
+}
+
+// Returns a key that changes whenever the settings of the channel or the
+// contents of the files that they refer to (e.g. certificates) change
+func ChannelSettingsKey(settings *hyper.ChannelSettings) (string, error) {
+
+	data, err := json.Marshal(settings)
+
+	if err != nil {
+		return "", err
+	}
+
+	var values interface{}
+
+	if err := json.Unmarshal(data, &values); err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	h.Write(data)
+
+	for _, path := range settingsFiles(values, "") {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			// a missing file will produce an error when opening the channel
+			content = nil
+		}
+		fileHash := sha256.Sum256(content)
+		h.Write([]byte(path))
+		h.Write(fileHash[:])
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// returns the paths in all settings with a '_file' or '_files' suffix
+func settingsFiles(value interface{}, key string) []string {
+	files := make([]string, 0)
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			files = append(files, settingsFiles(v[k], k)...)
+		}
+	case []interface{}:
+		for _, item := range v {
+			files = append(files, settingsFiles(item, key)...)
+		}
+	case string:
+		if strings.HasSuffix(key, "_file") || strings.HasSuffix(key, "_files") {
+			files = append(files, v)
+		}
+	}
+	return files
+}
@@ -0,0 +1,317 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"github.com/kiprotect/hyper"
+	th "github.com/kiprotect/hyper/testing"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChannelSettingsKey(t *testing.T) {
+
+	certFile := filepath.Join(t.TempDir(), "op-1.crt")
+
+	if err := os.WriteFile(certFile, []byte("first"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	settings := &hyper.ChannelSettings{
+		Name: "grpc_server",
+		Type: "grpc_server",
+		Settings: map[string]interface{}{
+			"tls": map[string]interface{}{"certificate_file": certFile},
+		},
+	}
+
+	key, err := ChannelSettingsKey(settings)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sameKey, err := ChannelSettingsKey(settings); err != nil || sameKey != key {
+		t.Fatalf("expected the same key for unchanged settings")
+	}
+
+	// a rotated certificate changes the key
+	if err := os.WriteFile(certFile, []byte("second"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if rotatedKey, err := ChannelSettingsKey(settings); err != nil || rotatedKey == key {
+		t.Fatalf("expected a different key for a changed file")
+	}
+
+	settings.Priority = 1
+
+	if changedKey, err := ChannelSettingsKey(settings); err != nil || changedKey == key {
+		t.Fatalf("expected a different key for changed settings")
+	}
+}
+
+// a channel that only shuts down once the test releases it
+type blockingChannel struct {
+	*th.MemoryChannel
+	shuttingDown chan bool
+	release      chan bool
+}
+
+// it listens like a server channel, so it is closed before it is replaced
+func (b *blockingChannel) BindAddress() string {
+	return "localhost:0"
+}
+
+func (b *blockingChannel) Drain() error {
+	return nil
+}
+
+func (b *blockingChannel) Shutdown(ctx context.Context) error {
+	b.shuttingDown <- true
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+func TestReloadDrainsWithoutLock(t *testing.T) {
+
+	var channels []*blockingChannel
+
+	definitions := &hyper.Definitions{
+		DirectoryDefinitions: hyper.DirectoryDefinitions{
+			"memory": {
+				Maker: func(name string, settings interface{}) (hyper.Directory, error) {
+					return th.MakeMemoryDirectory(name, &hyper.DirectoryEntry{Name: name}), nil
+				},
+			},
+		},
+		ChannelDefinitions: hyper.ChannelDefinitions{
+			"blocking": {
+				Maker: func(settings interface{}) (hyper.Channel, error) {
+					channel := &blockingChannel{
+						MemoryChannel: th.MakeMemoryChannel(nil),
+						shuttingDown:  make(chan bool, 1),
+						release:       make(chan bool),
+					}
+					channels = append(channels, channel)
+					return channel, nil
+				},
+			},
+		},
+	}
+
+	makeSettings := func(version int) *hyper.Settings {
+		return &hyper.Settings{
+			Name:        "op-1",
+			Definitions: definitions,
+			Directory:   &hyper.DirectorySettings{Type: "memory"},
+			Channels: []*hyper.ChannelSettings{
+				{Name: "blocking", Type: "blocking", Settings: map[string]interface{}{"version": version}},
+			},
+		}
+	}
+
+	server, err := MakeServer(makeSettings(1))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := make(chan error, 1)
+
+	// the changed settings replace the channel
+	go func() {
+		reloaded <- server.Reload(makeSettings(2))
+	}()
+
+	select {
+	case <-channels[0].shuttingDown:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the old channel to shut down")
+	}
+
+	status := make(chan []*ChannelStatus, 1)
+
+	go func() {
+		status <- server.ChannelStatus()
+	}()
+
+	// the status doesn't wait for the old channel
+	select {
+	case statuses := <-status:
+		if len(statuses) != 0 {
+			t.Fatalf("expected no open channels while the old one shuts down")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the status while the old channel shuts down")
+	}
+
+	close(channels[0].release)
+
+	if err := <-reloaded; err != nil {
+		t.Fatal(err)
+	}
+
+	if len(channels) != 2 {
+		t.Fatalf("expected a new channel")
+	}
+
+	if statuses := server.ChannelStatus(); len(statuses) != 1 || statuses[0].Name != "blocking" {
+		t.Fatalf("expected the new channel to be open")
+	}
+
+	if brokerChannels := server.Broker().Channels(); len(brokerChannels) != 1 || brokerChannels[0] != hyper.Channel(channels[1]) {
+		t.Fatalf("expected the broker to use the new channel only")
+	}
+
+	close(channels[1].release)
+
+	if err := server.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// a channel that fails to open if its settings say so
+type failingChannel struct {
+	*th.MemoryChannel
+	fail   bool
+	opened bool
+}
+
+func (f *failingChannel) Open() error {
+	if f.fail {
+		return fmt.Errorf("cannot open")
+	}
+	f.opened = true
+	return nil
+}
+
+type listeningFailingChannel struct {
+	*failingChannel
+}
+
+func (l *listeningFailingChannel) BindAddress() string {
+	return "localhost:0"
+}
+
+func TestReloadKeepsChannelsWhoseReplacementFails(t *testing.T) {
+
+	var channels []*failingChannel
+
+	makeChannel := func(settings interface{}) *failingChannel {
+		channel := &failingChannel{
+			MemoryChannel: th.MakeMemoryChannel(nil),
+			fail:          settings.(map[string]interface{})["fail"] == true,
+		}
+		channels = append(channels, channel)
+		return channel
+	}
+
+	definitions := &hyper.Definitions{
+		DirectoryDefinitions: hyper.DirectoryDefinitions{
+			"memory": {
+				Maker: func(name string, settings interface{}) (hyper.Directory, error) {
+					return th.MakeMemoryDirectory(name, &hyper.DirectoryEntry{Name: name}), nil
+				},
+			},
+		},
+		ChannelDefinitions: hyper.ChannelDefinitions{
+			"client": {
+				Maker: func(settings interface{}) (hyper.Channel, error) {
+					return makeChannel(settings), nil
+				},
+			},
+			"server": {
+				Maker: func(settings interface{}) (hyper.Channel, error) {
+					return &listeningFailingChannel{makeChannel(settings)}, nil
+				},
+			},
+		},
+	}
+
+	makeSettings := func(fail bool) *hyper.Settings {
+		return &hyper.Settings{
+			Name:        "op-1",
+			Definitions: definitions,
+			Directory:   &hyper.DirectorySettings{Type: "memory"},
+			Channels: []*hyper.ChannelSettings{
+				{Name: "client", Type: "client", Settings: map[string]interface{}{"fail": fail}},
+				{Name: "server", Type: "server", Settings: map[string]interface{}{"fail": fail}},
+			},
+		}
+	}
+
+	server, err := MakeServer(makeSettings(false))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	client, listening := channels[0], channels[1]
+
+	if err := server.Reload(makeSettings(true)); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	// both replacements failed and the server channel was reopened with the
+	// old settings
+	if len(channels) != 5 || channels[2].opened || channels[3].opened || !channels[4].opened {
+		t.Fatalf("expected failed replacements and a reopened channel")
+	}
+
+	brokerChannels := map[string]hyper.Channel{}
+
+	for _, channel := range server.Broker().Channels() {
+		brokerChannels[channel.Name()] = channel
+	}
+
+	if len(brokerChannels) != 2 {
+		t.Fatalf("expected two channels, got %d", len(brokerChannels))
+	}
+
+	// the client channel was never taken out of service
+	if brokerChannels["client"] != hyper.Channel(client) {
+		t.Fatalf("expected the old client channel")
+	}
+
+	if reopened, ok := brokerChannels["server"].(*listeningFailingChannel); !ok || reopened.failingChannel == listening || reopened.failingChannel != channels[4] {
+		t.Fatalf("expected the reopened server channel")
+	}
+
+	if statuses := server.ChannelStatus(); len(statuses) != 2 {
+		t.Fatalf("expected two open channels")
+	}
+
+	if err := server.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,94 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package helpers_test
+
+import (
+	"github.com/kiprotect/hyper"
+	"github.com/kiprotect/hyper/definitions"
+	"github.com/kiprotect/hyper/helpers"
+	"testing"
+	"testing/fstest"
+)
+
+// loads settings through the real settings form
+func loadSettings(t *testing.T, config string) *hyper.Settings {
+	return loadSettingsWithDefinitions(t, config, &definitions.Default)
+}
+
+func loadSettingsWithDefinitions(t *testing.T, config string, definitions *hyper.Definitions) *hyper.Settings {
+
+	config = `
+name: op-1
+directory:
+  type: json
+  settings:
+    path: directory.json
+` + config
+
+	fs := fstest.MapFS{
+		"settings/001_default.yml": &fstest.MapFile{Data: []byte(config)},
+	}
+
+	settings, err := helpers.Settings([]string{"settings"}, fs, definitions)
+
+	if err != nil {
+		t.Fatalf("cannot load settings: %v", err)
+	}
+
+	return settings
+}
+
+func TestChannelSettings(t *testing.T) {
+
+	settings := loadSettings(t, `
+channels:
+  - name: first
+    type: stdout
+    priority: 2
+    settings: {}
+  - name: second
+    type: stdout
+    settings: {}
+`)
+
+	if len(settings.Channels) != 2 || settings.Channels[0].Priority != 2 || settings.Channels[1].Priority != 0 {
+		t.Fatalf("unexpected channel settings")
+	}
+}
+
+func TestIdempotencySettings(t *testing.T) {
+
+	// the defaults of the form need to fit the settings struct as well
+	if settings := loadSettings(t, "channels: []\nidempotency: {}\n"); settings.Idempotency.MaxEntries != 10000 {
+		t.Fatalf("expected the default number of entries")
+	}
+
+	if settings := loadSettings(t, "channels: []\nidempotency:\n  max_entries: 5\n"); settings.Idempotency.MaxEntries != 5 {
+		t.Fatalf("expected the configured number of entries")
+	}
+}
+
+func TestResponseCacheSettings(t *testing.T) {
+
+	if settings := loadSettings(t, "channels: []\ncache: {}\n"); settings.Cache.MaxEntries != 10000 {
+		t.Fatalf("expected the default number of entries")
+	}
+
+	if settings := loadSettings(t, "channels: []\ncache:\n  max_entries: 5\n"); settings.Cache.MaxEntries != 5 {
+		t.Fatalf("expected the configured number of entries")
+	}
+}
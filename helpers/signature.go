@@ -79,7 +79,7 @@ func LoadCertificateFromString(data string, verifyUsage bool) (*x509.Certificate
 
 	block, _ := pem.Decode([]byte(data))
 
-	if block.Type != "CERTIFICATE" {
+	if block == nil || block.Type != "CERTIFICATE" {
 		return nil, fmt.Errorf("not a certificate")
 	}
 
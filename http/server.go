@@ -245,6 +245,23 @@ func (s *HTTPServer) Start() error {
 
 }
 
+// Tells clients to close their connections after the current request,
+// which is as close as HTTP/1.1 gets to a GOAWAY
+func (s *HTTPServer) Drain() {
+	s.server.SetKeepAlivesEnabled(false)
+}
+
+// Closes the listener and waits for the requests that are being handled,
+// or closes all connections right away if the context is done first
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	hyper.Log.Debugf("Shutting down HTTP server...")
+	if err := s.server.Shutdown(ctx); err != nil {
+		s.server.Close()
+		return err
+	}
+	return nil
+}
+
 func (s *HTTPServer) Stop() error {
 	hyper.Log.Debugf("Shutting down HTTP server...")
 	return s.server.Shutdown(context.TODO())
@@ -0,0 +1,352 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	IdempotencyEntryType uint8 = 2
+)
+
+var IdempotencyKeyReused = fmt.Errorf("the idempotency key was already used for a different request")
+
+type IdempotencySettings struct {
+	Datastore  *DatastoreSettings `json:"datastore"`
+	TTL        int64              `json:"ttl"`
+	MaxEntries int64              `json:"max_entries"`
+}
+
+type IdempotencyEntry struct {
+	Caller string `json:"caller"`
+	Key    string `json:"key"`
+	// the method and parameters of the request that the key was used for
+	Method       string    `json:"method"`
+	ParamsDigest string    `json:"params_digest"`
+	Response     *Response `json:"response"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// A bounded cache for the responses to requests with an idempotency key, so
+// that retries of a request do not execute it again
+type IdempotencyCache struct {
+	settings  *IdempotencySettings
+	datastore Datastore
+	entries   map[string]*list.Element
+	// least recently used entries are at the back
+	lru     *list.List
+	pending map[string]chan bool
+	// the number of records in the datastore
+	records int
+	mutex   sync.Mutex
+}
+
+// The datastore is optional, without it entries are only kept in memory
+func MakeIdempotencyCache(settings *IdempotencySettings, datastore Datastore) (*IdempotencyCache, error) {
+
+	cache := &IdempotencyCache{
+		settings:  settings,
+		datastore: datastore,
+		entries:   make(map[string]*list.Element),
+		lru:       list.New(),
+		pending:   make(map[string]chan bool),
+	}
+
+	if datastore != nil {
+
+		if err := datastore.Init(); err != nil {
+			return nil, fmt.Errorf("error initializing idempotency datastore: %w", err)
+		}
+
+		if err := cache.load(); err != nil {
+			return nil, fmt.Errorf("error loading idempotency entries: %w", err)
+		}
+	}
+
+	return cache, nil
+}
+
+func idempotencyKey(caller, key string) string {
+	return fmt.Sprintf("%s/%s", caller, key)
+}
+
+// Returns a digest of the parameters of a request, without the client info
+// that the broker adds to them
+func idempotencyParamsDigest(params map[string]interface{}) (string, error) {
+
+	filteredParams := make(map[string]interface{}, len(params))
+
+	for k, v := range params {
+		if k == "_client" {
+			continue
+		}
+		filteredParams[k] = v
+	}
+
+	// map keys are sorted, so equal parameters give the same data
+	data, err := json.Marshal(filteredParams)
+
+	if err != nil {
+		return "", fmt.Errorf("error serializing parameters: %w", err)
+	}
+
+	hash := sha256.Sum256(data)
+
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// loads all persisted entries, later entries replace earlier ones
+func (c *IdempotencyCache) load() error {
+
+	dataEntries, err := c.datastore.Read()
+
+	if err != nil {
+		return err
+	}
+
+	c.records = len(dataEntries)
+
+	for _, dataEntry := range dataEntries {
+		if dataEntry.Type != IdempotencyEntryType {
+			continue
+		}
+		entry := &IdempotencyEntry{}
+		if err := json.Unmarshal(dataEntry.Data, entry); err != nil {
+			return err
+		}
+		c.add(entry)
+	}
+
+	Log.Debugf("Loaded %d idempotency entries", c.lru.Len())
+
+	return nil
+}
+
+func idempotencyDataEntry(entry *IdempotencyEntry) (*DataEntry, error) {
+
+	data, err := json.Marshal(entry)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// a new response for the same key replaces the old one
+	return &DataEntry{
+		Type: IdempotencyEntryType,
+		ID:   []byte(idempotencyKey(entry.Caller, entry.Key)),
+		Data: data,
+	}, nil
+}
+
+// writes the entry to the datastore, needs to be called with the mutex held
+func (c *IdempotencyCache) persist(entry *IdempotencyEntry) error {
+
+	dataEntry, err := idempotencyDataEntry(entry)
+
+	if err != nil {
+		return err
+	}
+
+	if err := c.datastore.Write(dataEntry); err != nil {
+		return err
+	}
+
+	c.records++
+
+	return nil
+}
+
+// expired and evicted entries stay in the datastore, so we replace its
+// contents with the current entries from time to time. Needs to be called
+// with the mutex held.
+func (c *IdempotencyCache) compact() {
+
+	datastore, ok := c.datastore.(CompactableDatastore)
+
+	if !ok {
+		return
+	}
+
+	if c.records <= 2*c.lru.Len()+CompactionSlack {
+		return
+	}
+
+	now := time.Now()
+
+	// expired entries are only removed when they are retrieved, so we drop
+	// them here as well
+	for element := c.lru.Back(); element != nil; {
+		previous := element.Prev()
+		if entry := element.Value.(*IdempotencyEntry); now.After(entry.ExpiresAt) {
+			c.lru.Remove(element)
+			delete(c.entries, idempotencyKey(entry.Caller, entry.Key))
+		}
+		element = previous
+	}
+
+	dataEntries := make([]*DataEntry, 0, c.lru.Len())
+
+	// we write the least recently used entries first, so that they are
+	// evicted first when we load the datastore again
+	for element := c.lru.Back(); element != nil; element = element.Prev() {
+		if dataEntry, err := idempotencyDataEntry(element.Value.(*IdempotencyEntry)); err != nil {
+			Log.Errorf("Error serializing idempotency entry: %v", err)
+			return
+		} else {
+			dataEntries = append(dataEntries, dataEntry)
+		}
+	}
+
+	if err := datastore.Compact(dataEntries); err != nil {
+		Log.Errorf("Error compacting idempotency datastore: %v", err)
+		return
+	}
+
+	Log.Debugf("Compacted idempotency datastore from %d to %d records", c.records, len(dataEntries))
+
+	c.records = len(dataEntries)
+}
+
+func (c *IdempotencyCache) add(entry *IdempotencyEntry) {
+
+	if time.Now().After(entry.ExpiresAt) {
+		return
+	}
+
+	key := idempotencyKey(entry.Caller, entry.Key)
+
+	if element, ok := c.entries[key]; ok {
+		c.lru.Remove(element)
+	}
+
+	c.entries[key] = c.lru.PushFront(entry)
+
+	for int64(c.lru.Len()) > c.settings.MaxEntries {
+		oldest := c.lru.Remove(c.lru.Back()).(*IdempotencyEntry)
+		delete(c.entries, idempotencyKey(oldest.Caller, oldest.Key))
+	}
+}
+
+func (c *IdempotencyCache) get(key string) *IdempotencyEntry {
+
+	element, ok := c.entries[key]
+
+	if !ok {
+		return nil
+	}
+
+	entry := element.Value.(*IdempotencyEntry)
+
+	if time.Now().After(entry.ExpiresAt) {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+		return nil
+	}
+
+	c.lru.MoveToFront(element)
+
+	return entry
+}
+
+// Returns the stored response for the given caller and key. If there is
+// none, the caller should process the request and pass the response to the
+// returned function, which needs to be called in any case. Concurrent
+// requests with the same key wait until the first one is done. If the key
+// was used for a request with another method or other parameters, the error
+// is IdempotencyKeyReused.
+func (c *IdempotencyCache) Acquire(ctx context.Context, caller, key, method string, params map[string]interface{}) (*Response, func(*Response), error) {
+
+	cacheKey := idempotencyKey(caller, key)
+
+	paramsDigest, err := idempotencyParamsDigest(params)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for {
+
+		c.mutex.Lock()
+
+		if entry := c.get(cacheKey); entry != nil {
+			c.mutex.Unlock()
+			if entry.Method != method || entry.ParamsDigest != paramsDigest {
+				return nil, nil, IdempotencyKeyReused
+			}
+			return entry.Response, nil, nil
+		}
+
+		if wait, ok := c.pending[cacheKey]; ok {
+			c.mutex.Unlock()
+			select {
+			case <-wait:
+				// we check again for a stored response
+				continue
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			}
+		}
+
+		wait := make(chan bool)
+		c.pending[cacheKey] = wait
+
+		c.mutex.Unlock()
+
+		return nil, func(response *Response) {
+			c.store(&IdempotencyEntry{
+				Caller:       caller,
+				Key:          key,
+				Method:       method,
+				ParamsDigest: paramsDigest,
+				Response:     response,
+			})
+			close(wait)
+		}, nil
+	}
+}
+
+func (c *IdempotencyCache) store(entry *IdempotencyEntry) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.pending, idempotencyKey(entry.Caller, entry.Key))
+
+	// without a response the request may be retried
+	if entry.Response == nil {
+		return
+	}
+
+	entry.ExpiresAt = time.Now().Add(time.Duration(c.settings.TTL) * time.Second)
+
+	c.add(entry)
+
+	if c.datastore != nil {
+		if err := c.persist(entry); err != nil {
+			Log.Errorf("Error persisting idempotency entry: %v", err)
+		}
+		c.compact()
+	}
+}
@@ -0,0 +1,225 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/kiprotect/hyper"
+	th "github.com/kiprotect/hyper/testing"
+	"testing"
+)
+
+func TestIdempotencyCache(t *testing.T) {
+
+	settings := &hyper.IdempotencySettings{
+		TTL:        60,
+		MaxEntries: 2,
+	}
+
+	datastore := &th.MemoryDatastore{}
+
+	cache, err := hyper.MakeIdempotencyCache(settings, datastore)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	if response, done, err := cache.Acquire(ctx, "op-1", "a", "add", nil); err != nil {
+		t.Fatal(err)
+	} else if response != nil || done == nil {
+		t.Fatalf("expected no stored response")
+	} else {
+		done(&hyper.Response{Result: map[string]interface{}{"case": "a"}})
+	}
+
+	if response, _, err := cache.Acquire(ctx, "op-1", "a", "add", nil); err != nil {
+		t.Fatal(err)
+	} else if response == nil || response.Result["case"] != "a" {
+		t.Fatalf("expected the stored response")
+	}
+
+	// keys are scoped to the caller
+	if response, done, err := cache.Acquire(ctx, "op-2", "a", "add", nil); err != nil {
+		t.Fatal(err)
+	} else if response != nil {
+		t.Fatalf("expected no stored response for another caller")
+	} else {
+		// failed requests do not get stored
+		done(nil)
+	}
+
+	for _, key := range []string{"b", "c"} {
+		if _, done, err := cache.Acquire(ctx, "op-1", key, "add", nil); err != nil {
+			t.Fatal(err)
+		} else {
+			done(&hyper.Response{Result: map[string]interface{}{"case": key}})
+		}
+	}
+
+	// the least recently used entry got evicted
+	if response, done, _ := cache.Acquire(ctx, "op-1", "a", "add", nil); response != nil {
+		t.Fatalf("expected the entry to be evicted")
+	} else {
+		done(nil)
+	}
+
+	reloadedCache, err := hyper.MakeIdempotencyCache(settings, datastore)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response, _, _ := reloadedCache.Acquire(ctx, "op-1", "c", "add", nil); response == nil || response.Result["case"] != "c" {
+		t.Fatalf("expected a persisted response")
+	}
+}
+
+func TestIdempotencyKeyReuse(t *testing.T) {
+
+	cache, err := hyper.MakeIdempotencyCache(&hyper.IdempotencySettings{TTL: 60, MaxEntries: 10}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	params := map[string]interface{}{"a": 1, "_client": map[string]interface{}{"name": "op-1"}}
+
+	if _, done, err := cache.Acquire(ctx, "op-1", "a", "add", params); err != nil {
+		t.Fatal(err)
+	} else {
+		done(&hyper.Response{Result: map[string]interface{}{"sum": 1}})
+	}
+
+	// the client info is not part of the request
+	if response, _, err := cache.Acquire(ctx, "op-1", "a", "add", map[string]interface{}{"a": 1}); err != nil {
+		t.Fatal(err)
+	} else if response == nil || response.Result["sum"] != 1 {
+		t.Fatalf("expected the stored response")
+	}
+
+	if _, _, err := cache.Acquire(ctx, "op-1", "a", "add", map[string]interface{}{"a": 2}); err != hyper.IdempotencyKeyReused {
+		t.Fatalf("expected the key to be rejected for other parameters, got %v", err)
+	}
+
+	if _, _, err := cache.Acquire(ctx, "op-1", "a", "sub", params); err != hyper.IdempotencyKeyReused {
+		t.Fatalf("expected the key to be rejected for another method, got %v", err)
+	}
+}
+
+func TestIdempotencyCompaction(t *testing.T) {
+
+	settings := &hyper.IdempotencySettings{
+		TTL:        60,
+		MaxEntries: 2,
+	}
+
+	datastore := &th.MemoryDatastore{}
+
+	cache, err := hyper.MakeIdempotencyCache(settings, datastore)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	// most of these entries get evicted right away
+	for i := 0; i < 2*hyper.CompactionSlack; i++ {
+		if _, done, err := cache.Acquire(ctx, "op-1", fmt.Sprintf("%d", i), "add", nil); err != nil {
+			t.Fatal(err)
+		} else {
+			done(&hyper.Response{Result: map[string]interface{}{"i": i}})
+		}
+	}
+
+	dataEntries, err := datastore.Read()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dataEntries) > 2*int(settings.MaxEntries)+hyper.CompactionSlack {
+		t.Fatalf("expected the datastore to be compacted, got %d records", len(dataEntries))
+	}
+
+	for _, dataEntry := range dataEntries {
+		if string(dataEntry.ID) == "op-1/0" {
+			t.Fatalf("expected evicted entries to be dropped")
+		}
+	}
+
+	reloadedCache, err := hyper.MakeIdempotencyCache(settings, datastore)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last := fmt.Sprintf("%d", 2*hyper.CompactionSlack-1)
+
+	if response, _, _ := reloadedCache.Acquire(ctx, "op-1", last, "add", nil); response == nil {
+		t.Fatalf("expected the latest entry to survive the compaction")
+	}
+}
+
+func TestBrokerRejectsReusedIdempotencyKeys(t *testing.T) {
+
+	broker, channel := th.MakeMemoryBroker(t, th.MakeMemoryDirectory("op-1", th.Entries("op-1")...), func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+		return &hyper.Response{Result: map[string]interface{}{"a": request.Params["a"]}, ID: &address.ID}, nil
+	}, "op-1")
+
+	cache, err := hyper.MakeIdempotencyCache(&hyper.IdempotencySettings{TTL: 60, MaxEntries: 10}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker.SetIdempotencyCache(cache)
+
+	deliver := func(id string, a int) *hyper.Response {
+		response, err := broker.DeliverRequest(&hyper.Request{
+			ID:             id,
+			Method:         "op-1.add",
+			Params:         map[string]interface{}{"a": a},
+			IdempotencyKey: "add",
+		}, &hyper.ClientInfo{Name: "op-1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	if response := deliver("op-1.add(1)", 1); response.Error != nil {
+		t.Fatalf("unexpected error: %s", response.Error.Message)
+	}
+
+	// a retry gets the stored response
+	if response := deliver("op-1.add(2)", 1); response.Error != nil || response.Result["a"] != 1 {
+		t.Fatalf("expected the stored response")
+	}
+
+	if response := deliver("op-1.add(3)", 2); response.Error == nil || response.Error.Code != 422 {
+		t.Fatalf("expected the reused key to be rejected")
+	}
+
+	if len(channel.Requests()) != 1 {
+		t.Fatalf("expected a single delivery, got %d", len(channel.Requests()))
+	}
+}
@@ -0,0 +1,38 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+type InterceptorDefinition struct {
+	Name              string            `json:"name"`
+	Description       string            `json:"description"`
+	Maker             InterceptorMaker  `json:"-"`
+	SettingsValidator SettingsValidator `json:"-"`
+}
+
+type InterceptorDefinitions map[string]InterceptorDefinition
+type InterceptorMaker func(settings interface{}) (Interceptor, error)
+
+// An interceptor can inspect, modify or reject requests before the message
+// broker delivers them, and inspect or modify the responses afterwards
+type Interceptor interface {
+	// Called before the request is delivered. If a response is returned it
+	// will be passed to the caller instead of delivering the request.
+	BeforeDelivery(*Request, *ClientInfo) (*Response, error)
+	// Called with the outcome of every request that the interceptor has seen
+	// before, returns the response and error that should be passed on.
+	AfterDelivery(*Request, *ClientInfo, *Response, error) (*Response, error)
+}
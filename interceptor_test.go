@@ -0,0 +1,169 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper_test
+
+import (
+	"github.com/kiprotect/hyper"
+	th "github.com/kiprotect/hyper/testing"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// records the hooks that were called
+type hookLog struct {
+	hooks []string
+	mutex sync.Mutex
+}
+
+func (h *hookLog) add(hook string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.hooks = append(h.hooks, hook)
+}
+
+func (h *hookLog) String() string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return strings.Join(h.hooks, ",")
+}
+
+type testInterceptor struct {
+	name   string
+	log    *hookLog
+	before func(*hyper.Request) *hyper.Response
+	after  func(*hyper.Response) *hyper.Response
+}
+
+func (i *testInterceptor) BeforeDelivery(request *hyper.Request, clientInfo *hyper.ClientInfo) (*hyper.Response, error) {
+	i.log.add("before:" + i.name)
+	if i.before != nil {
+		return i.before(request), nil
+	}
+	return nil, nil
+}
+
+func (i *testInterceptor) AfterDelivery(request *hyper.Request, clientInfo *hyper.ClientInfo, response *hyper.Response, err error) (*hyper.Response, error) {
+	i.log.add("after:" + i.name)
+	if i.after != nil {
+		return i.after(response), err
+	}
+	return response, err
+}
+
+func makeInterceptorBroker(t *testing.T, log *hookLog, interceptors ...*testInterceptor) (*hyper.BasicMessageBroker, *th.MemoryChannel) {
+
+	broker, channel := th.MakeMemoryBroker(t, th.MakeMemoryDirectory("op-1", th.Entries("op-1", "op-2")...), func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+		log.add("deliver")
+		return &hyper.Response{ID: &address.ID, Result: map[string]interface{}{"delivered": true}}, nil
+	}, "op-2")
+
+	for _, interceptor := range interceptors {
+		broker.AddInterceptor(interceptor)
+	}
+
+	return broker, channel
+}
+
+func deliverIntercepted(t *testing.T, broker *hyper.BasicMessageBroker) *hyper.Response {
+
+	response, err := broker.DeliverRequest(&hyper.Request{
+		ID:     "op-2.add(1)",
+		Method: "op-2.add",
+		Params: map[string]interface{}{},
+	}, &hyper.ClientInfo{Name: "op-1"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return response
+}
+
+func TestInterceptorOrder(t *testing.T) {
+
+	log := &hookLog{}
+
+	broker, _ := makeInterceptorBroker(t, log,
+		&testInterceptor{name: "a", log: log},
+		&testInterceptor{name: "b", log: log},
+		&testInterceptor{name: "c", log: log},
+	)
+
+	if response := deliverIntercepted(t, broker); response.Result["delivered"] != true {
+		t.Fatalf("expected the response of the channel")
+	}
+
+	// interceptors wrap each other, so the first one sees the outcome last
+	if hooks := log.String(); hooks != "before:a,before:b,before:c,deliver,after:c,after:b,after:a" {
+		t.Fatalf("unexpected order of hooks: %s", hooks)
+	}
+}
+
+func TestInterceptorShortCircuit(t *testing.T) {
+
+	log := &hookLog{}
+
+	broker, channel := makeInterceptorBroker(t, log,
+		&testInterceptor{name: "a", log: log},
+		&testInterceptor{name: "b", log: log, before: func(request *hyper.Request) *hyper.Response {
+			return hyper.PermissionDenied(&request.ID, "rejected", nil)
+		}},
+		&testInterceptor{name: "c", log: log},
+	)
+
+	if response := deliverIntercepted(t, broker); response.Error == nil || response.Error.Code != 403 {
+		t.Fatalf("expected the response of the interceptor")
+	}
+
+	if len(channel.Requests()) != 0 {
+		t.Fatalf("expected no channel to be called")
+	}
+
+	// only the interceptors that saw the request see the outcome
+	if hooks := log.String(); hooks != "before:a,before:b,after:b,after:a" {
+		t.Fatalf("unexpected order of hooks: %s", hooks)
+	}
+}
+
+func TestInterceptorRewritesResponse(t *testing.T) {
+
+	log := &hookLog{}
+
+	broker, _ := makeInterceptorBroker(t, log,
+		&testInterceptor{name: "a", log: log, after: func(response *hyper.Response) *hyper.Response {
+			// the outer interceptor sees the rewritten response
+			if response.Result["rewritten"] != true {
+				return &hyper.Response{ID: response.ID, Error: &hyper.Error{Code: 500, Message: "expected a rewritten response"}}
+			}
+			return response
+		}},
+		&testInterceptor{name: "b", log: log, after: func(response *hyper.Response) *hyper.Response {
+			return &hyper.Response{ID: response.ID, Result: map[string]interface{}{"rewritten": true}}
+		}},
+	)
+
+	response := deliverIntercepted(t, broker)
+
+	if response.Error != nil {
+		t.Fatalf("unexpected error: %s", response.Error.Message)
+	}
+
+	if response.Result["rewritten"] != true || response.Result["delivered"] != nil {
+		t.Fatalf("expected the rewritten response")
+	}
+}
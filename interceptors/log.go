@@ -0,0 +1,107 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package interceptors
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/hyper"
+	"sync"
+	"time"
+)
+
+type LogSettings struct {
+	Params bool `json:"params"`
+}
+
+var LogSettingsForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			// whether to include the request parameters in the log
+			Name: "params",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
+			},
+		},
+	},
+}
+
+func LogSettingsValidator(settings map[string]interface{}) (interface{}, error) {
+	if params, err := LogSettingsForm.Validate(settings); err != nil {
+		return nil, err
+	} else {
+		validatedSettings := &LogSettings{}
+		if err := LogSettingsForm.Coerce(validatedSettings, params); err != nil {
+			return nil, err
+		}
+		return validatedSettings, nil
+	}
+}
+
+type LogInterceptor struct {
+	Settings LogSettings
+	started  map[*hyper.Request]time.Time
+	mutex    sync.Mutex
+}
+
+func MakeLogInterceptor(settings interface{}) (hyper.Interceptor, error) {
+	return &LogInterceptor{
+		Settings: settings.(LogSettings),
+		started:  make(map[*hyper.Request]time.Time),
+	}, nil
+}
+
+func (l *LogInterceptor) BeforeDelivery(request *hyper.Request, clientInfo *hyper.ClientInfo) (*hyper.Response, error) {
+
+	l.mutex.Lock()
+	l.started[request] = time.Now()
+	l.mutex.Unlock()
+
+	if l.Settings.Params {
+		hyper.Log.Infof("Request %s from '%s' with params %v", request.ID, clientInfo.Name, request.Params)
+	} else {
+		hyper.Log.Infof("Request %s from '%s'", request.ID, clientInfo.Name)
+	}
+
+	return nil, nil
+}
+
+func (l *LogInterceptor) AfterDelivery(request *hyper.Request, clientInfo *hyper.ClientInfo, response *hyper.Response, err error) (*hyper.Response, error) {
+
+	l.mutex.Lock()
+	started, ok := l.started[request]
+	delete(l.started, request)
+	l.mutex.Unlock()
+
+	var duration time.Duration
+
+	if ok {
+		duration = time.Since(started)
+	}
+
+	if err != nil {
+		hyper.Log.Infof("Request %s from '%s' failed: %v (%s)", request.ID, clientInfo.Name, err, duration)
+	} else if response == nil {
+		hyper.Log.Infof("Request %s from '%s' returned no response (%s)", request.ID, clientInfo.Name, duration)
+	} else if response.Error != nil {
+		hyper.Log.Infof("Request %s from '%s' failed with code %d: %s (%s)", request.ID, clientInfo.Name, response.Error.Code, response.Error.Message, duration)
+	} else {
+		hyper.Log.Infof("Request %s from '%s' succeeded (%s)", request.ID, clientInfo.Name, duration)
+	}
+
+	return response, err
+}
@@ -18,10 +18,14 @@ package jsonrpc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"github.com/kiprotect/hyper"
 	"github.com/kiprotect/hyper/tls"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 )
@@ -45,12 +49,154 @@ func (c *Client) SetEndpoint(endpoint string) {
 }
 
 func (c *Client) Call(request *Request) (*Response, error) {
+	return c.CallContext(context.Background(), request)
+}
+
+// Performs the call, giving up when the given context is done or when the
+// deadline of the request has passed
+func (c *Client) CallContext(ctx context.Context, request *Request) (*Response, error) {
+
+	if request.Deadline != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, *request.Deadline)
+		defer cancel()
+	}
+
 	data, err := json.Marshal(request)
 
 	if err != nil {
 		return nil, err
 	}
 
+	body, err := c.post(ctx, data, request.TraceParent)
+
+	if err != nil {
+		return nil, err
+	}
+
+	response := &Response{}
+
+	if err := json.Unmarshal(body, response); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// Reads the chunks of a streamed response
+type StreamReader struct {
+	body    io.ReadCloser
+	decoder *json.Decoder
+	cancel  context.CancelFunc
+}
+
+// Returns the next chunk, or io.EOF if there are no more chunks
+func (s *StreamReader) Next() (*Response, error) {
+	response := &Response{}
+	if err := s.decoder.Decode(response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (s *StreamReader) Close() error {
+	defer s.cancel()
+	return s.body.Close()
+}
+
+// Performs a call whose response can be streamed by the server. If the
+// server doesn't stream the response, the reader returns it as the only
+// chunk. The reader needs to be closed in any case.
+func (c *Client) CallStream(ctx context.Context, request *Request) (*StreamReader, error) {
+
+	var cancel context.CancelFunc
+
+	if request.Deadline != nil {
+		ctx, cancel = context.WithDeadline(ctx, *request.Deadline)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	data, err := json.Marshal(request)
+
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp, err := c.send(ctx, data, request.TraceParent, NDJSONContentType+", application/json")
+
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &StreamReader{
+		body: resp.Body,
+		// the decoder only reads from the body as chunks are requested
+		decoder: json.NewDecoder(resp.Body),
+		cancel:  cancel,
+	}, nil
+}
+
+// Sends several requests in a single batch. The responses are returned in
+// the order of the server, notifications do not receive a response.
+func (c *Client) CallBatch(ctx context.Context, requests []*Request) ([]*Response, error) {
+
+	data, err := json.Marshal(requests)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var traceParent string
+
+	if len(requests) > 0 {
+		traceParent = requests[0].TraceParent
+	}
+
+	body, err := c.post(ctx, data, traceParent)
+
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]*Response, 0, len(requests))
+
+	// the server returns no content if the batch consists of notifications
+	if len(body) == 0 {
+		return responses, nil
+	}
+
+	if err := json.Unmarshal(body, &responses); err != nil {
+		return nil, err
+	}
+
+	return responses, nil
+}
+
+func (c *Client) post(ctx context.Context, data []byte, traceParent string) ([]byte, error) {
+
+	resp, err := c.send(ctx, data, traceParent, "application/json")
+
+	if err != nil {
+		return nil, err
+	}
+
+	// to do: sanity checks...
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+func (c *Client) send(ctx context.Context, data []byte, traceParent, accept string) (*http.Response, error) {
+
 	client := &http.Client{}
 	transport := &http.Transport{
 		DisableKeepAlives: true, // removing this will cause connections to pile up
@@ -77,35 +223,30 @@ func (c *Client) Call(request *Request) (*Response, error) {
 
 	hyper.Log.Debugf("Generating request to endpoint %s...", c.settings.Endpoint)
 
-	req, err := http.NewRequest("POST", c.settings.Endpoint, bytes.NewReader(data))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.settings.Endpoint, bytes.NewReader(data))
 
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Accept", "application/json")
-
-	resp, err := client.Do(req)
+	req.Header.Add("Accept", accept)
 
-	if err != nil {
-		return nil, err
+	if traceParent != "" {
+		req.Header.Add("traceparent", traceParent)
 	}
 
-	// to do: sanity checks...
-
-	body, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
-
-	if err != nil {
-		return nil, err
+	if c.settings.Token != "" {
+		req.Header.Add("Authorization", "Bearer "+c.settings.Token)
 	}
 
-	response := &Response{}
+	resp, err := client.Do(req)
 
-	if err := json.Unmarshal(body, response); err != nil {
-		return nil, err
+	// if we can't connect to the server, it can't have seen the request
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return nil, hyper.NotSent(err)
 	}
 
-	return response, nil
+	return resp, err
 }
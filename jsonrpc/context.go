@@ -17,9 +17,12 @@
 package jsonrpc
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/kiprotect/hyper"
 	"github.com/kiprotect/hyper/http"
+	"io"
 	"regexp"
 	"strconv"
 	"strings"
@@ -54,6 +57,72 @@ func convertID(id interface{}) interface{} {
 	return id
 }
 
+// Returns a context that is done when the HTTP request is aborted or when
+// the deadline of the JSON-RPC request has passed
+func (c *Context) Context() (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	// notifications are processed after the HTTP request has been answered
+	if !c.Request.Notification && c.HTTPContext != nil && c.HTTPContext.Request != nil {
+		ctx = c.HTTPContext.Request.Context()
+	}
+	if c.Request.Deadline != nil {
+		return context.WithDeadline(ctx, *c.Request.Deadline)
+	}
+	return context.WithCancel(ctx)
+}
+
+// Writes the chunks of the stream as NDJSON, one JSON-RPC response per line.
+// The stream ends after the first error.
+func (c *Context) Stream(stream hyper.ResponseStream) *Response {
+
+	defer stream.Close()
+
+	writer := c.HTTPContext.Writer
+	flusher, _ := writer.(interface{ Flush() })
+
+	writer.Header().Set("Content-Type", NDJSONContentType)
+	writer.WriteHeader(200)
+	c.HTTPContext.HeaderWritten = true
+	// we make sure that nothing else gets written
+	c.HTTPContext.Abort()
+
+	for {
+
+		var response *Response
+
+		if chunk, err := stream.Next(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			hyper.Log.Errorf("Error reading response stream: %v", err)
+			response = c.Error(-32603, "error reading response stream", nil)
+		} else {
+			response = FromHyperResponse(chunk)
+			response.ID = convertID(c.Request.ID)
+		}
+
+		data, err := json.Marshal(response)
+
+		if err != nil {
+			hyper.Log.Error(err)
+			return nil
+		}
+
+		// this blocks if the client doesn't read fast enough
+		if _, err := writer.Write(append(data, '\n')); err != nil {
+			hyper.Log.Debugf("Cannot write response chunk: %v", err)
+			return nil
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if response.Error != nil {
+			return nil
+		}
+	}
+}
+
 func (c *Context) Result(data interface{}) *Response {
 
 	return &Response{
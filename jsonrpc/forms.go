@@ -78,6 +78,32 @@ var JSONRPCRequestForm = forms.Form{
 				},
 			},
 		},
+		{
+			Name: "deadline",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsTime{},
+			},
+		},
+		{
+			Name: "idempotency_key",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsString{
+					MinLength: 1,
+					MaxLength: 256,
+				},
+			},
+		},
+		{
+			Name: "traceparent",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsString{
+					MaxLength: 55,
+				},
+			},
+		},
 	},
 }
 
@@ -175,6 +201,13 @@ var JSONRPCClientSettingsForm = forms.Form{
 				forms.IsBoolean{},
 			},
 		},
+		{
+			Name: "token",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsString{},
+			},
+		},
 		{
 			Name: "tls",
 			Validators: []forms.Validator{
@@ -28,44 +28,51 @@ import (
 )
 
 var jsonContentTypeRegexp = regexp.MustCompile("(?i)^application/json(?:;.*)?$")
+var ndjsonContentTypeRegexp = regexp.MustCompile("(?i)(?:^|[,\\s])application/x-ndjson(?:[;,\\s]|$)")
 
-// extracts the request data from
-func ExtractJSONRequest(c *http.Context) {
-	hyper.Log.Debugf("Extracting JSON data...")
+const NDJSONContentType = "application/x-ndjson"
 
-	invalidJSONResponse := Response{JSONRPC: "2.0,", Error: &Error{Code: -32700, Message: "JSON required"}}
-	invalidRequestResponse := func(err error) *Response {
-		hyper.Log.Debugf("invalid JSON request: %v", err)
-		return &Response{JSONRPC: "2.0,", Error: &Error{Code: -32600, Message: fmt.Sprintf("invalid request: %v", err), Data: err}}
-	}
-	serverErrorResponse := Response{JSONRPC: "2.0,", Error: &Error{Code: -32603, Message: "internal server error"}}
+// the maximum number of requests in a batch
+const MaxBatchSize = 100
 
-	if !jsonContentTypeRegexp.MatchString(c.Request.Header.Get("content-type")) {
-		c.JSON(400, invalidJSONResponse)
-		return
-	}
+// the maximum number of requests of a batch that we handle at the same time
+const MaxBatchConcurrency = 10
 
-	var jsonData map[string]interface{}
+// an element of a batch request, either a valid request or an error response
+type batchElement struct {
+	Request  *Request
+	Response *Response
+}
 
-	if err := json.NewDecoder(c.Request.Body).Decode(&jsonData); err != nil {
-		c.JSON(400, invalidJSONResponse)
-		return
-	}
+func invalidRequestResponse(err error) *Response {
+	hyper.Log.Debugf("invalid JSON request: %v", err)
+	return &Response{JSONRPC: "2.0", Error: &Error{Code: -32600, Message: fmt.Sprintf("invalid request: %v", err), Data: err}}
+}
+
+func serverErrorResponse() *Response {
+	return &Response{JSONRPC: "2.0", Error: &Error{Code: -32603, Message: "internal server error"}}
+}
+
+// validates a single request object, returning an error response and status
+// code if the request is invalid
+func parseRequest(jsonData map[string]interface{}) (*Request, *Response, int) {
 
 	if validJSON, err := JSONRPCRequestForm.Validate(jsonData); err != nil {
 		// validation errors are safe to pass back to the client
-		c.JSON(400, invalidRequestResponse(err))
-		return
+		return nil, invalidRequestResponse(err), 400
 	} else {
 		var request Request
 
 		id, ok := validJSON["id"]
 
-		// if no ID is contained we generate a random UUID
+		// only a missing ID makes this a notification, the form drops empty
+		// IDs, so we check the raw data here
+		_, hasID := jsonData["id"]
+
+		// if no (or an empty) ID is contained we generate a random ID
 		if !ok {
 			if randomID, err := helpers.RandomBytes(16); err != nil {
-				c.JSON(500, serverErrorResponse)
-				return
+				return nil, serverErrorResponse(), 500
 			} else {
 				validJSON["id"] = hex.EncodeToString(randomID)
 			}
@@ -85,11 +92,74 @@ func ExtractJSONRequest(c *http.Context) {
 		// this should never happen if the form validation is correct...
 		if err := JSONRPCRequestForm.Coerce(&request, validJSON); err != nil {
 			hyper.Log.Error(err)
-			c.JSON(500, serverErrorResponse)
-			return
+			return nil, serverErrorResponse(), 500
 		}
 
-		c.Set("request", &request)
+		request.Notification = !hasID
+
+		return &request, nil, 200
+	}
+}
+
+// the trace context can also be sent as an HTTP header
+func setTraceParent(c *http.Context, request *Request) {
+	if request.TraceParent == "" {
+		request.TraceParent = c.Request.Header.Get("traceparent")
+	}
+}
+
+// extracts the request data from the body, which can contain a single request
+// or a batch of requests
+func ExtractJSONRequest(c *http.Context) {
+	hyper.Log.Debugf("Extracting JSON data...")
+
+	invalidJSONResponse := Response{JSONRPC: "2.0", Error: &Error{Code: -32700, Message: "JSON required"}}
+
+	if !jsonContentTypeRegexp.MatchString(c.Request.Header.Get("content-type")) {
+		c.JSON(400, invalidJSONResponse)
+		return
+	}
+
+	var jsonData interface{}
+
+	if err := json.NewDecoder(c.Request.Body).Decode(&jsonData); err != nil {
+		c.JSON(400, invalidJSONResponse)
+		return
+	}
+
+	switch v := jsonData.(type) {
+	case map[string]interface{}:
+		if request, response, status := parseRequest(v); response != nil {
+			c.JSON(status, response)
+		} else {
+			setTraceParent(c, request)
+			// the response to a single request can be streamed, there's no
+			// response to a notification though
+			request.Stream = !request.Notification && ndjsonContentTypeRegexp.MatchString(c.Request.Header.Get("accept"))
+			c.Set("request", request)
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			c.JSON(400, invalidRequestResponse(fmt.Errorf("empty batch")))
+			return
+		} else if len(v) > MaxBatchSize {
+			c.JSON(400, invalidRequestResponse(fmt.Errorf("batch too large (at most %d requests allowed)", MaxBatchSize)))
+			return
+		}
+		batch := make([]*batchElement, len(v))
+		for i, element := range v {
+			if mapElement, ok := element.(map[string]interface{}); !ok {
+				batch[i] = &batchElement{Response: invalidRequestResponse(fmt.Errorf("not an object"))}
+			} else if request, response, _ := parseRequest(mapElement); response != nil {
+				batch[i] = &batchElement{Response: response}
+			} else {
+				setTraceParent(c, request)
+				batch[i] = &batchElement{Request: request}
+			}
+		}
+		c.Set("batch", batch)
+	default:
+		c.JSON(400, invalidJSONResponse)
 	}
 
 }
@@ -17,8 +17,10 @@
 package jsonrpc
 
 import (
+	"context"
 	"fmt"
 	"github.com/kiprotect/hyper/http"
+	"sync"
 )
 
 type Handler func(*Context) *Response
@@ -29,26 +31,123 @@ type JSONRPCServer struct {
 	handler  Handler
 }
 
+func handle(handler Handler, request *Request, c *http.Context) *Response {
+
+	context := &Context{
+		Request:     request,
+		HTTPContext: c,
+	}
+
+	response := handler(context)
+
+	if response == nil {
+		response = context.Nil()
+	}
+
+	// people will forget this so we add it here in that case
+	if response.JSONRPC == "" {
+		response.JSONRPC = "2.0"
+	}
+
+	return response
+}
+
+// handles the requests concurrently, at most MaxBatchConcurrency at a time,
+// returning the responses in the order of the requests
+func handleConcurrently(handler Handler, requests []*Request, c *http.Context) []*Response {
+
+	var wg sync.WaitGroup
+
+	responses := make([]*Response, len(requests))
+	slots := make(chan bool, MaxBatchConcurrency)
+
+	for i, request := range requests {
+		wg.Add(1)
+		slots <- true
+		go func(i int, request *Request) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			responses[i] = handle(handler, request, c)
+		}(i, request)
+	}
+
+	wg.Wait()
+
+	return responses
+}
+
+// handles all requests of a batch except notifications, returning the
+// responses in the order of the requests
+func handleBatch(handler Handler, batch []*batchElement, c *http.Context) []*Response {
+
+	requests := make([]*Request, 0, len(batch))
+
+	for _, element := range batch {
+		if element.Request != nil && !element.Request.Notification {
+			requests = append(requests, element.Request)
+		}
+	}
+
+	requestResponses := handleConcurrently(handler, requests, c)
+	responses := make([]*Response, 0, len(batch))
+
+	for _, element := range batch {
+		if element.Request == nil {
+			responses = append(responses, element.Response)
+		} else if !element.Request.Notification {
+			responses = append(responses, requestResponses[0])
+			requestResponses = requestResponses[1:]
+		}
+	}
+
+	return responses
+}
+
+// we process notifications after responding as the caller does not wait for
+// them, so they get a copy of the HTTP request that outlives the handler
+func handleNotifications(handler Handler, notifications []*Request, c *http.Context) {
+
+	if len(notifications) == 0 {
+		return
+	}
+
+	detached := http.MakeContext(nil, c.Request.Clone(context.Background()))
+
+	go handleConcurrently(handler, notifications, detached)
+}
+
 func JSONRPC(handler Handler) http.Handler {
 	return func(c *http.Context) {
-		// the request data has been validated by the 'ExtractJSONRequest' handler
-		request := c.Get("request").(*Request)
 
-		context := &Context{
-			Request:     request,
-			HTTPContext: c,
+		// the request data has been validated by the 'ExtractJSONRequest' handler
+		if batch, ok := c.Get("batch").([]*batchElement); ok {
+			if responses := handleBatch(handler, batch, c); len(responses) == 0 {
+				// the batch consisted only of notifications
+				c.AbortWithStatus(204)
+			} else {
+				c.JSON(200, responses)
+			}
+			notifications := make([]*Request, 0, len(batch))
+			for _, element := range batch {
+				if element.Request != nil && element.Request.Notification {
+					notifications = append(notifications, element.Request)
+				}
+			}
+			handleNotifications(handler, notifications, c)
+			return
 		}
 
-		response := handler(context)
+		request := c.Get("request").(*Request)
 
-		if response == nil {
-			response = context.Nil()
+		if request.Notification {
+			c.AbortWithStatus(204)
+			handleNotifications(handler, []*Request{request}, c)
+			return
 		}
 
-		// people will forget this so we add it here in that case
-		if response.JSONRPC == "" {
-			response.JSONRPC = "2.0"
-		}
+		response := handle(handler, request, c)
 
 		code := 200
 
@@ -118,3 +217,11 @@ func (s *JSONRPCServer) Start() error {
 func (s *JSONRPCServer) Stop() error {
 	return s.server.Stop()
 }
+
+func (s *JSONRPCServer) Drain() {
+	s.server.Drain()
+}
+
+func (s *JSONRPCServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
@@ -0,0 +1,298 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/kiprotect/hyper"
+	"github.com/kiprotect/hyper/http"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runs the handlers of the JSON-RPC route and returns the recorded response
+func serve(t *testing.T, handler Handler, body string) *httptest.ResponseRecorder {
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/jsonrpc", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Test", "header")
+
+	c := http.MakeContext(recorder, request)
+
+	ExtractJSONRequest(c)
+
+	if !c.HeaderWritten && !c.Aborted {
+		JSONRPC(handler)(c)
+	}
+
+	// the handler is done with the HTTP request from here on
+	request.Header = nil
+
+	return recorder
+}
+
+// serves the JSON-RPC route via HTTP
+func testServer(handler Handler) *httptest.Server {
+	return httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		c := http.MakeContext(w, r)
+		ExtractJSONRequest(c)
+		if !c.HeaderWritten && !c.Aborted {
+			JSONRPC(handler)(c)
+		}
+	}))
+}
+
+func decodeBatch(t *testing.T, recorder *httptest.ResponseRecorder) []map[string]interface{} {
+	var responses []map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &responses); err != nil {
+		t.Fatalf("expected a batch response, got '%s': %v", recorder.Body.String(), err)
+	}
+	return responses
+}
+
+// answers requests with their method and records notifications
+func testHandler(notifications chan string) Handler {
+	return func(c *Context) *Response {
+		if c.Request.Notification {
+			notifications <- c.Request.Method + ":" + c.HTTPContext.Request.Header.Get("X-Test")
+			return nil
+		}
+		return c.Result(c.Request.Method)
+	}
+}
+
+func TestBatch(t *testing.T) {
+
+	notifications := make(chan string, 10)
+
+	recorder := serve(t, testHandler(notifications), `[
+		{"jsonrpc": "2.0", "method": "first", "params": {}, "id": 1},
+		{"jsonrpc": "2.0", "method": "notify", "params": {}},
+		"not an object",
+		{"jsonrpc": "2.0", "params": {}, "id": 3},
+		{"jsonrpc": "2.0", "method": "second", "params": {}, "id": "b"}
+	]`)
+
+	if recorder.Code != 200 {
+		t.Fatalf("expected a 200 status, got %d", recorder.Code)
+	}
+
+	responses := decodeBatch(t, recorder)
+
+	if len(responses) != 4 {
+		t.Fatalf("expected four responses, got %d", len(responses))
+	}
+
+	if responses[0]["result"] != "first" || responses[0]["id"] != 1.0 || responses[3]["result"] != "second" || responses[3]["id"] != "b" {
+		t.Fatalf("expected the responses in the order of the requests: %v", responses)
+	}
+
+	for _, response := range responses[1:3] {
+		if response["jsonrpc"] != "2.0" {
+			t.Fatalf("expected a valid version, got %v", response["jsonrpc"])
+		}
+		if err, ok := response["error"].(map[string]interface{}); !ok || err["code"] != -32600.0 {
+			t.Fatalf("expected an invalid request error, got %v", response)
+		}
+	}
+
+	// notifications see the headers even after the HTTP handler returned
+	select {
+	case notification := <-notifications:
+		if notification != "notify:header" {
+			t.Fatalf("unexpected notification '%s'", notification)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the notification to be handled")
+	}
+}
+
+func TestNotificationBatch(t *testing.T) {
+
+	notifications := make(chan string, 10)
+
+	recorder := serve(t, testHandler(notifications), `[
+		{"jsonrpc": "2.0", "method": "a", "params": {}},
+		{"jsonrpc": "2.0", "method": "b", "params": {}}
+	]`)
+
+	if recorder.Code != 204 || recorder.Body.Len() != 0 {
+		t.Fatalf("expected an empty response, got %d", recorder.Code)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-notifications:
+		case <-time.After(time.Second):
+			t.Fatalf("expected both notifications to be handled")
+		}
+	}
+}
+
+func TestEmptyID(t *testing.T) {
+
+	// an empty ID is still an ID, so this isn't a notification
+	recorder := serve(t, testHandler(nil), `{"jsonrpc": "2.0", "method": "empty", "params": {}, "id": ""}`)
+
+	var response map[string]interface{}
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("expected a response, got %d: %v", recorder.Code, err)
+	}
+
+	if recorder.Code != 200 || response["result"] != "empty" {
+		t.Fatalf("expected a result, got %d: %v", recorder.Code, response)
+	}
+}
+
+func TestEmptyBatch(t *testing.T) {
+
+	recorder := serve(t, testHandler(nil), `[]`)
+
+	var response map[string]interface{}
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	if err, ok := response["error"].(map[string]interface{}); recorder.Code != 400 || !ok || err["code"] != -32600.0 {
+		t.Fatalf("expected an invalid request error, got %d: %v", recorder.Code, response)
+	}
+}
+
+func TestBatchConcurrency(t *testing.T) {
+
+	var running, maxRunning int32
+
+	handler := func(c *Context) *Response {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return c.Acknowledge()
+	}
+
+	requests := make([]string, MaxBatchSize)
+
+	for i := range requests {
+		requests[i] = `{"jsonrpc": "2.0", "method": "a", "params": {}, "id": "x"}`
+	}
+
+	responses := decodeBatch(t, serve(t, handler, "["+strings.Join(requests, ",")+"]"))
+
+	if len(responses) != MaxBatchSize {
+		t.Fatalf("expected a response for every request")
+	}
+
+	if maxRunning > MaxBatchConcurrency {
+		t.Fatalf("expected at most %d concurrent requests, got %d", MaxBatchConcurrency, maxRunning)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+
+	deadlines := make(chan time.Time, 1)
+
+	server := testServer(func(c *Context) *Response {
+		if c.Request.Deadline == nil {
+			return c.Error(400, "expected a deadline", nil)
+		}
+		ctx, cancel := c.Context()
+		defer cancel()
+		if deadline, ok := ctx.Deadline(); !ok || !deadline.Equal(*c.Request.Deadline) {
+			return c.Error(400, "expected the deadline in the context", nil)
+		}
+		deadlines <- *c.Request.Deadline
+		// the handler takes longer than the caller is willing to wait
+		if c.Request.Method == "slow" {
+			<-ctx.Done()
+		}
+		return c.Acknowledge()
+	})
+
+	defer server.Close()
+
+	client := MakeClient(&JSONRPCClientSettings{Endpoint: server.URL})
+
+	deadline := time.Now().Add(time.Minute).Round(time.Millisecond)
+	request := MakeRequest("fast", "1", map[string]interface{}{})
+	request.Deadline = &deadline
+
+	if response, err := client.Call(request); err != nil {
+		t.Fatal(err)
+	} else if response.Error != nil {
+		t.Fatalf("unexpected error: %v", response.Error.Message)
+	}
+
+	// the deadline arrives unchanged
+	if received := <-deadlines; !received.Equal(deadline) {
+		t.Fatalf("expected deadline %v, got %v", deadline, received)
+	}
+
+	deadline = time.Now().Add(50 * time.Millisecond)
+	request = MakeRequest("slow", "2", map[string]interface{}{})
+	request.Deadline = &deadline
+
+	// both sides give up once the deadline has passed
+	if _, err := client.Call(request); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the call to time out, got %v", err)
+	}
+}
+
+func TestCallNotSent(t *testing.T) {
+
+	// nobody listens on the address once the listener is closed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener.Close()
+
+	client := MakeClient(&JSONRPCClientSettings{Endpoint: "http://" + listener.Addr().String()})
+
+	if _, err := client.Call(MakeRequest("add", "1", map[string]interface{}{})); !errors.Is(err, hyper.RequestNotSent) {
+		t.Fatalf("expected the request not to be sent, got %v", err)
+	}
+
+	server := testServer(func(c *Context) *Response {
+		// the request was sent, even though the connection fails afterwards
+		panic(nethttp.ErrAbortHandler)
+	})
+
+	defer server.Close()
+
+	client = MakeClient(&JSONRPCClientSettings{Endpoint: server.URL})
+
+	if _, err := client.Call(MakeRequest("add", "1", map[string]interface{}{})); err == nil || errors.Is(err, hyper.RequestNotSent) {
+		t.Fatalf("expected an error for a request that was sent, got %v", err)
+	}
+}
@@ -27,6 +27,8 @@ type JSONRPCClientSettings struct {
 	Endpoint string           `json:"endpoint"`
 	ProxyUrl string           `json:"proxy_url"`
 	Local    bool             `json:"local"`
+	// sent as bearer token in the 'Authorization' header if given
+	Token string `json:"token"`
 }
 
 type CorsSettings struct {
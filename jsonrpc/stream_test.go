@@ -0,0 +1,190 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jsonrpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	th "github.com/kiprotect/hyper/testing"
+	nethttp "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// serves the JSON-RPC route via HTTP, the handler gets the streams that it
+// should return
+func streamServer(streams func(*Context) *th.MemoryStream) *httptest.Server {
+	return testServer(func(c *Context) *Response {
+		return c.Stream(streams(c))
+	})
+}
+
+func postStream(t *testing.T, url string) *nethttp.Response {
+
+	request, err := nethttp.NewRequest("POST", url, bytes.NewReader([]byte(`{"jsonrpc": "2.0", "method": "list", "params": {}, "id": 1}`)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", NDJSONContentType)
+
+	response, err := nethttp.DefaultClient.Do(request)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return response
+}
+
+// reads the NDJSON lines of the response
+func readLines(t *testing.T, response *nethttp.Response) []map[string]interface{} {
+
+	lines := make([]map[string]interface{}, 0)
+	scanner := bufio.NewScanner(response.Body)
+
+	for scanner.Scan() {
+		line := map[string]interface{}{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("expected a JSON response per line, got '%s': %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return lines
+}
+
+func TestStream(t *testing.T) {
+
+	streams := make(chan *th.MemoryStream, 1)
+
+	server := streamServer(func(c *Context) *th.MemoryStream {
+		if !c.Request.Stream {
+			t.Errorf("expected a stream request")
+		}
+		stream := th.MakeMemoryStream(context.Background(), 3)
+		streams <- stream
+		return stream
+	})
+
+	defer server.Close()
+
+	response := postStream(t, server.URL)
+	defer response.Body.Close()
+
+	if contentType := response.Header.Get("Content-Type"); contentType != NDJSONContentType {
+		t.Fatalf("expected an NDJSON response, got '%s'", contentType)
+	}
+
+	lines := readLines(t, response)
+
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %d", len(lines))
+	}
+
+	for i, line := range lines {
+		result, _ := line["result"].(map[string]interface{})
+		if line["jsonrpc"] != "2.0" || line["id"] != 1.0 || result["chunk"] != float64(i+1) {
+			t.Fatalf("unexpected line %d: %v", i, line)
+		}
+	}
+
+	select {
+	case <-(<-streams).Closed():
+	case <-time.After(time.Second):
+		t.Fatalf("expected the stream to be closed")
+	}
+}
+
+func TestStreamError(t *testing.T) {
+
+	streams := make(chan *th.MemoryStream, 1)
+
+	server := streamServer(func(c *Context) *th.MemoryStream {
+		stream := th.MakeMemoryStream(context.Background(), -1)
+		stream.SetFailAfter(1)
+		streams <- stream
+		return stream
+	})
+
+	defer server.Close()
+
+	response := postStream(t, server.URL)
+	defer response.Body.Close()
+
+	lines := readLines(t, response)
+
+	// the stream ends with the error
+	if len(lines) != 2 || lines[0]["result"] == nil || lines[1]["error"] == nil {
+		t.Fatalf("expected a chunk and an error, got %v", lines)
+	}
+
+	select {
+	case <-(<-streams).Closed():
+	case <-time.After(time.Second):
+		t.Fatalf("expected the stream to be closed")
+	}
+}
+
+func TestStreamClientDisconnect(t *testing.T) {
+
+	streams := make(chan *th.MemoryStream, 1)
+
+	server := streamServer(func(c *Context) *th.MemoryStream {
+		// like the channels, the stream ends with the HTTP request
+		ctx, _ := c.Context()
+		stream := th.MakeMemoryStream(ctx, -1)
+		streams <- stream
+		return stream
+	})
+
+	defer server.Close()
+
+	client := MakeClient(&JSONRPCClientSettings{Endpoint: server.URL})
+
+	reader, err := client.CallStream(context.Background(), &Request{JSONRPC: "2.0", Method: "list", Params: map[string]interface{}{}, ID: "1"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if chunk, err := reader.Next(); err != nil {
+		t.Fatal(err)
+	} else if chunk.Result.(map[string]interface{})["chunk"] != 1.0 {
+		t.Fatalf("expected the first chunk")
+	}
+
+	// the consumer goes away in the middle of an endless stream
+	if err := reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-(<-streams).Closed():
+	case <-time.After(time.Second):
+		t.Fatalf("expected the stream to be closed when the client disconnects")
+	}
+}
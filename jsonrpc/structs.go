@@ -17,7 +17,9 @@
 package jsonrpc
 
 import (
+	"encoding/json"
 	"github.com/kiprotect/hyper"
+	"time"
 )
 
 // we always convert incoming IDs to strings
@@ -26,6 +28,16 @@ type Request struct {
 	Method  string                 `json:"method"`
 	Params  map[string]interface{} `json:"params"`
 	ID      string                 `json:"id"`
+	// optional, the caller is not interested in a response after this time
+	Deadline *time.Time `json:"deadline,omitempty"`
+	// optional, allows the recipient to detect duplicate requests
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// optional, W3C trace context (can also be sent as an HTTP header)
+	TraceParent string `json:"traceparent,omitempty"`
+	// notifications have no ID, the caller expects no response
+	Notification bool `json:"-"`
+	// the caller accepts a streamed (NDJSON) response
+	Stream bool `json:"-"`
 }
 
 func MakeRequest(method, id string, params map[string]interface{}) *Request {
@@ -38,11 +50,28 @@ func MakeRequest(method, id string, params map[string]interface{}) *Request {
 	}
 }
 
+func (r *Request) MarshalJSON() ([]byte, error) {
+	type request Request
+	if !r.Notification {
+		return json.Marshal((*request)(r))
+	}
+	// notifications are sent without an ID
+	return json.Marshal(&struct {
+		*request
+		ID string `json:"id,omitempty"`
+	}{request: (*request)(r)})
+}
+
 func (r *Request) FromHyperRequest(request *hyper.Request) {
 	r.JSONRPC = "2.0"
 	r.Method = request.Method
 	r.ID = request.ID
 	r.Params = request.Params
+	r.Deadline = request.Deadline
+	r.IdempotencyKey = request.IdempotencyKey
+	if request.Trace != nil {
+		r.TraceParent = request.Trace.TraceParent()
+	}
 }
 
 type Response struct {
@@ -17,50 +17,279 @@
 package hyper
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/kiprotect/go-helpers/forms"
+	"sort"
 	"sync"
 	"time"
 )
 
 type MessageBroker interface {
 	AddChannel(Channel) error
+	RemoveChannel(Channel) error
 	Channels() []Channel
 	DeliverRequest(*Request, *ClientInfo) (*Response, error)
 }
 
+// Message brokers that stop accepting requests while draining implement this
+// so that channels can still send the requests that they need to deliver the
+// accepted ones, e.g. to request a connection through a proxy
+type InternalMessageBroker interface {
+	DeliverInternalRequest(*Request, *ClientInfo) (*Response, error)
+}
+
 type BasicMessageBroker struct {
-	channels          []Channel
-	directory         Directory
-	mutex             sync.Mutex
-	requestsInTransit map[string]bool
+	channels     []Channel
+	directory    Directory
+	outbox       *Outbox
+	idempotency  *IdempotencyCache
+	rateLimits   []*RateLimit
+	rateLimiter  *RateLimiter
+	interceptors []Interceptor
+	reload       func() error
+	inFlight     inFlightRequests
+	auditLog     AuditLog
+	encryption   PayloadEncryption
+	signer       MessageSigner
+	breaker      *CircuitBreaker
+	cache        *ResponseCache
+	retries      *RetrySettings
+	// subscribers of our own topics, by topic and operator
+	subscribers map[string]map[string]*Subscription
+	// subscriptions of local services to topics of other operators
+	subscriptions map[string]*Subscription
+	// optional, persists both kinds of subscriptions
+	subscriptionStore   Datastore
+	subscriptionRecords int
+	// guards the subscriptions and their datastore, so that writing to it
+	// doesn't hold up deliveries
+	subscriptionsMutex sync.Mutex
+	mutex              sync.Mutex
+	requestsInTransit  map[string]*requestInTransit
+}
+
+type requestInTransit struct {
+	client string
+	cancel context.CancelFunc
 }
 
+var NoChannelCanDeliver = fmt.Errorf("no channel can deliver this request")
+
 func MakeBasicMessageBroker(directory Directory) (*BasicMessageBroker, error) {
 	return &BasicMessageBroker{
 		channels:          make([]Channel, 0),
-		requestsInTransit: make(map[string]bool),
+		requestsInTransit: make(map[string]*requestInTransit),
+		subscribers:       make(map[string]map[string]*Subscription),
+		subscriptions:     make(map[string]*Subscription),
+		rateLimiter:       MakeRateLimiter(),
 		directory:         directory,
 	}, nil
 }
 
 func (b *BasicMessageBroker) AddChannel(channel Channel) error {
 
+	if channel.Name() == "" {
+		channel.SetName(channel.Type())
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
 	for _, ec := range b.channels {
-		if ec.Type() == channel.Type() {
-			return fmt.Errorf("channel of same type already exists")
+		if ec.Name() == channel.Name() {
+			return fmt.Errorf("channel with name '%s' already exists", channel.Name())
 		}
 	}
 
-	b.channels = append(b.channels, channel)
 	// we tell the channel that it's part of the message broker
 	if err := channel.SetMessageBroker(b); err != nil {
-		b.channels = b.channels[:len(b.channels)-1]
 		return fmt.Errorf("error adding channel: %w", err)
 	}
+
+	// we never modify the slice in place as others may iterate over it
+	channels := append(b.channels[:len(b.channels):len(b.channels)], channel)
+
+	// channels with the same priority keep the order in which they were added
+	sort.SliceStable(channels, func(i, j int) bool {
+		return channels[i].Priority() > channels[j].Priority()
+	})
+
+	b.channels = channels
+
+	return nil
+}
+
+// Removes a channel from the broker, requests that it is delivering at the
+// moment are not affected
+func (b *BasicMessageBroker) RemoveChannel(channel Channel) error {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for i, ec := range b.channels {
+		if ec == channel {
+			b.channels = append(b.channels[:i:i], b.channels[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("channel '%s' not found", channel.Name())
+}
+
+// Replaces a channel of the broker with another one in a single step, so that
+// there's no moment without a channel for the requests that it delivers.
+// Requests that the old channel is delivering at the moment are not affected.
+func (b *BasicMessageBroker) ReplaceChannel(old, new Channel) error {
+
+	if new.Name() == "" {
+		new.SetName(new.Type())
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	index := -1
+
+	for i, ec := range b.channels {
+		if ec == old {
+			index = i
+		} else if ec.Name() == new.Name() {
+			return fmt.Errorf("channel with name '%s' already exists", new.Name())
+		}
+	}
+
+	if index == -1 {
+		return fmt.Errorf("channel '%s' not found", old.Name())
+	}
+
+	if err := new.SetMessageBroker(b); err != nil {
+		return fmt.Errorf("error adding channel: %w", err)
+	}
+
+	channels := make([]Channel, len(b.channels))
+	copy(channels, b.channels)
+	channels[index] = new
+
+	// the priority of the new channel may differ from the old one
+	sort.SliceStable(channels, func(i, j int) bool {
+		return channels[i].Priority() > channels[j].Priority()
+	})
+
+	b.channels = channels
+
 	return nil
 }
 
+// Sets the function that reloads the settings of the node when a local
+// client calls the '_reload' method
+func (b *BasicMessageBroker) SetReloadHandler(reload func() error) {
+	b.reload = reload
+}
+
+// Adds an interceptor to the chain. Interceptors see requests in the order
+// in which they were added and responses in the reverse order.
+func (b *BasicMessageBroker) AddInterceptor(interceptor Interceptor) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	// we never modify the slice in place as others may iterate over it
+	b.interceptors = append(b.interceptors[:len(b.interceptors):len(b.interceptors)], interceptor)
+}
+
+// Sets the circuit breaker that stops deliveries to operators that keep
+// failing
+func (b *BasicMessageBroker) SetCircuitBreaker(breaker *CircuitBreaker) {
+	b.breaker = breaker
+}
+
+// Sets the policy for retrying failed deliveries of retry-safe methods to
+// other operators
+func (b *BasicMessageBroker) SetRetries(retries *RetrySettings) {
+	b.retries = retries
+}
+
+// Sets the rate limits for calls from other operators, in addition to the
+// ones published in our directory entry. They can be replaced while requests
+// are delivered, e.g. when the settings are reloaded.
+func (b *BasicMessageBroker) SetRateLimits(rateLimits []*RateLimit) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.rateLimits = rateLimits
+}
+
+// Enables de-duplication of requests with an idempotency key
+func (b *BasicMessageBroker) SetIdempotencyCache(cache *IdempotencyCache) {
+	b.idempotency = cache
+}
+
+// Enables caching of responses of cacheable methods of other operators
+func (b *BasicMessageBroker) SetResponseCache(cache *ResponseCache) {
+	b.cache = cache
+}
+
+// Enables queueing of requests that cannot be delivered right away
+func (b *BasicMessageBroker) SetOutbox(outbox *Outbox) {
+	b.outbox = outbox
+	outbox.Start(b.deliverQueuedRequest)
+}
+
+// Stops the background work of the broker, i.e. retrying queued requests
+func (b *BasicMessageBroker) Stop() {
+	if b.outbox != nil {
+		b.outbox.Stop()
+	}
+}
+
+var OutboxRequestForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "id",
+			Validators: []forms.Validator{
+				forms.IsString{},
+			},
+		},
+	},
+}
+
+type OutboxRequest struct {
+	ID string `json:"id"`
+}
+
+var CancelRequestForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "id",
+			Validators: []forms.Validator{
+				forms.IsString{},
+			},
+		},
+	},
+}
+
+type CancelRequest struct {
+	ID string `json:"id"`
+}
+
+var CacheInvalidationForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "operator",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "method",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+	},
+}
+
 var DirectoryQueryForm = forms.Form{
 	Fields: []forms.Field{
 		{
@@ -87,10 +316,10 @@ var DirectoryQueryForm = forms.Form{
 	},
 }
 
-func (b *BasicMessageBroker) handleInternalRequest(address *Address, request *Request) (*Response, error) {
+func (b *BasicMessageBroker) handleInternalRequest(address *Address, request *Request, clientInfo *ClientInfo) (*Response, error) {
 	switch address.Method {
 	case "_connectionRequest":
-		for _, channel := range b.channels {
+		for _, channel := range b.Channels() {
 			if proxyChannel, ok := channel.(ProxyChannel); !ok {
 				continue
 			} else if response, err := proxyChannel.HandleConnectionRequest(address, request); err != nil {
@@ -106,6 +335,35 @@ func (b *BasicMessageBroker) handleInternalRequest(address *Address, request *Re
 		} else {
 			return &Response{Result: map[string]interface{}{"version": Version, "timestamp": time.Now().Format(time.RFC3339Nano), "params": request.Params, "serverInfo": ownEntry}, Error: nil, ID: &address.ID}, nil
 		}
+	case "_reload":
+		if ownEntry, err := b.directory.OwnEntry(); err != nil {
+			return nil, fmt.Errorf("error retrieving own entry: %w", err)
+		} else if clientInfo.Name != ownEntry.Name {
+			return PermissionDenied(&address.ID, "only local clients may reload the settings", nil), nil
+		} else if b.reload == nil {
+			return nil, fmt.Errorf("reloading is not supported")
+		} else if err := b.reload(); err != nil {
+			return &Response{Error: &Error{Code: 500, Message: fmt.Sprintf("error reloading settings: %v", err)}, ID: &address.ID}, nil
+		} else {
+			return &Response{Result: map[string]interface{}{"reloading": true}, ID: &address.ID}, nil
+		}
+	case "_describe":
+		// every caller only sees the part of the API that it may call
+		if ownEntry, err := b.directory.OwnEntry(); err != nil {
+			return nil, fmt.Errorf("error retrieving own entry: %w", err)
+		} else {
+			return &Response{Result: map[string]interface{}{"operator": ownEntry.Name, "services": DescribeServices(clientInfo.Entry, ownEntry)}, ID: &address.ID}, nil
+		}
+	case "_openrpc":
+		// like '_describe', the document only contains the methods that the
+		// caller may call
+		if ownEntry, err := b.directory.OwnEntry(); err != nil {
+			return nil, fmt.Errorf("error retrieving own entry: %w", err)
+		} else if document, err := OpenRPC(clientInfo.Entry, ownEntry).AsMap(); err != nil {
+			return nil, fmt.Errorf("error serializing OpenRPC document: %w", err)
+		} else {
+			return &Response{Result: document, ID: &address.ID}, nil
+		}
 	case "_directory":
 		query := &DirectoryQuery{}
 		if params, err := DirectoryQueryForm.Validate(request.Params); err != nil {
@@ -117,19 +375,325 @@ func (b *BasicMessageBroker) handleInternalRequest(address *Address, request *Re
 		} else {
 			return &Response{Result: map[string]interface{}{"entries": entries}, ID: &address.ID}, nil
 		}
+	case "_status", "_result":
+		if b.outbox == nil {
+			return nil, fmt.Errorf("outbox is not enabled")
+		}
+		outboxRequest := &OutboxRequest{}
+		if params, err := OutboxRequestForm.Validate(request.Params); err != nil {
+			return nil, err
+		} else if err := OutboxRequestForm.Coerce(outboxRequest, params); err != nil {
+			return nil, err
+		}
+		entry := b.outbox.Entry(outboxRequest.ID)
+		// This keeps other operators from reading our entries, but it is not
+		// an access control between local clients: they all share the name
+		// of our operator, so each of them can read the entries of the others
+		// if it knows the request ID.
+		if entry == nil || entry.ClientInfo.Name != clientInfo.Name {
+			return &Response{Error: &Error{Code: 404, Message: "no outbox entry found"}, ID: &address.ID}, nil
+		}
+		if address.Method == "_status" {
+			return &Response{Result: entry.StatusMap(), ID: &address.ID}, nil
+		}
+		if entry.Status != OutboxDelivered {
+			return &Response{Error: &Error{Code: 404, Message: "no result available", Data: entry.StatusMap()}, ID: &address.ID}, nil
+		}
+		// we return the response that we received from the recipient
+		return &Response{Result: entry.Response.Result, Error: entry.Response.Error, ID: &address.ID}, nil
+	case "_subscribe", "_unsubscribe", "_publish", "_event":
+		if ownEntry, err := b.directory.OwnEntry(); err != nil {
+			return nil, fmt.Errorf("error retrieving own entry: %w", err)
+		} else {
+			return b.handlePubSubRequest(address, request, clientInfo, ownEntry)
+		}
+	case "_invalidateCache":
+		if ownEntry, err := b.directory.OwnEntry(); err != nil {
+			return nil, fmt.Errorf("error retrieving own entry: %w", err)
+		} else if clientInfo.Name != ownEntry.Name {
+			return PermissionDenied(&address.ID, "only local clients may invalidate the cache", nil), nil
+		} else if b.cache == nil {
+			return nil, fmt.Errorf("response cache is not enabled")
+		}
+		invalidation := &ResponseCacheInvalidation{}
+		if params, err := CacheInvalidationForm.Validate(request.Params); err != nil {
+			return nil, err
+		} else if err := CacheInvalidationForm.Coerce(invalidation, params); err != nil {
+			return nil, err
+		}
+		invalidated, err := b.cache.Invalidate(invalidation)
+		if err != nil {
+			return nil, fmt.Errorf("error invalidating cache entries: %w", err)
+		}
+		return &Response{Result: map[string]interface{}{"invalidated": invalidated}, ID: &address.ID}, nil
+	case "_cancel":
+		cancelRequest := &CancelRequest{}
+		if params, err := CancelRequestForm.Validate(request.Params); err != nil {
+			return nil, err
+		} else if err := CancelRequestForm.Coerce(cancelRequest, params); err != nil {
+			return nil, err
+		}
+		b.mutex.Lock()
+		inTransit, ok := b.requestsInTransit[cancelRequest.ID]
+		b.mutex.Unlock()
+		// only the client that sent a request may cancel it
+		if !ok || inTransit.client != clientInfo.Name {
+			return &Response{Error: &Error{Code: 404, Message: "no request in transit found"}, ID: &address.ID}, nil
+		}
+		inTransit.cancel()
+		return &Response{Result: map[string]interface{}{"cancelled": true}, ID: &address.ID}, nil
 	}
 	return nil, nil
 }
 
+// Delivers the request via the first channel that can deliver it, in the
+// order of their priority. If a channel fails, we only try the next one if
+// the request wasn't sent or if the method may be called more than once.
+func (b *BasicMessageBroker) deliverToChannels(address *Address, request *Request, retrySafe bool) (*Response, error) {
+
+	var lastErr error
+
+	for _, channel := range b.Channels() {
+		Log.Debugf("Checking whether channel '%s' can deliver message with method '%s' to '%s'...", channel.Name(), address.Method, address.Operator)
+		if !channel.CanDeliverTo(address) {
+			continue
+		}
+		Log.Debug("Trying to deliver message...")
+		if response, err := deliverWithContext(channel, request); err != nil {
+			lastErr = fmt.Errorf("channel '%s' encountered an error delivering the message: %w", channel.Name(), err)
+			// there's no point in trying other channels if the caller gave up
+			if request.Context().Err() != nil {
+				return nil, lastErr
+			}
+			// the recipient may already have processed the request
+			if !retrySafe && !errors.Is(err, RequestNotSent) && !errors.Is(err, NoChannelCanDeliver) {
+				return nil, lastErr
+			}
+			Log.Warningf("%v, trying the next channel...", lastErr)
+		} else {
+			return response, nil
+		}
+	}
+
+	Log.Debug("Done checking channels...")
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+
+	return nil, NoChannelCanDeliver
+}
+
+// delivers a request via the given channel, giving up when the context of
+// the request is done
+func deliverWithContext(channel Channel, request *Request) (*Response, error) {
+
+	ctx := request.Context()
+
+	if ctx.Done() == nil {
+		return channel.DeliverRequest(request)
+	}
+
+	type result struct {
+		response *Response
+		err      error
+	}
+
+	results := make(chan result, 1)
+
+	go func() {
+		response, err := channel.DeliverRequest(request)
+		results <- result{response, err}
+	}()
+
+	select {
+	case r := <-results:
+		return r.response, r.err
+	case <-ctx.Done():
+		// nobody will consume a stream that arrives after this
+		go func() {
+			if r := <-results; r.response != nil && r.response.Stream != nil {
+				r.response.Stream.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// marks the context of requests that the outbox retries
+type outboxRetryKey struct{}
+
+func isOutboxRetry(request *Request) bool {
+	return request.Context().Value(outboxRetryKey{}) != nil
+}
+
+// delivers a request from the outbox like any other request, failed
+// deliveries are returned as errors so that the outbox retries them
+func (b *BasicMessageBroker) deliverQueuedRequest(ctx context.Context, request *Request, clientInfo *ClientInfo) (*Response, error) {
+
+	if !b.inFlight.enter() {
+		return nil, fmt.Errorf("shutting down")
+	}
+
+	defer b.inFlight.leave()
+
+	// the context of the original request is gone at this point
+	request.SetContext(context.WithValue(ctx, outboxRetryKey{}, true))
+
+	response, err := b.deliverAccepted(request, clientInfo)
+
+	if response != nil && response.Stream != nil {
+		response.Stream.Close()
+		return nil, fmt.Errorf("unexpected stream")
+	}
+
+	return response, err
+}
+
+// Returns how often we try to deliver a request with the given method to
+// the recipient
+func (b *BasicMessageBroker) deliveryAttempts(recipientEntry *DirectoryEntry, method string) int64 {
+	if b.retries == nil || b.retries.MaxAttempts <= 1 {
+		return 1
+	}
+	if retrySafe(recipientEntry, method) {
+		return b.retries.MaxAttempts
+	}
+	return 1
+}
+
+// Returns true if calling the method more than once has the same effect as
+// calling it once
+func retrySafe(recipientEntry *DirectoryEntry, method string) bool {
+	serviceMethod := MethodFor(recipientEntry, method)
+	return serviceMethod != nil && serviceMethod.RetrySafe
+}
+
+// Delivers a request to another operator, failing right away while the
+// circuit of the operator is open and retrying failed deliveries with a
+// backoff
+func (b *BasicMessageBroker) deliverToOperator(address *Address, request *Request, attempts int64, retrySafe bool) (*Response, error) {
+
+	for attempt := int64(1); ; attempt++ {
+
+		var done func(DeliveryOutcome)
+
+		if b.breaker != nil {
+			if done = b.breaker.Acquire(address.Operator); done == nil {
+				return nil, CircuitOpen
+			}
+		}
+
+		response, err := b.deliverToChannels(address, request, retrySafe)
+
+		outcome := DeliverySucceeded
+
+		if err == NoChannelCanDeliver || (err != nil && request.Context().Err() != nil) {
+			outcome = DeliveryAborted
+		} else if err != nil {
+			outcome = DeliveryFailed
+		}
+
+		if done != nil {
+			done(outcome)
+		}
+
+		if outcome != DeliveryFailed || attempt >= attempts {
+			return response, err
+		}
+
+		backoff := b.retries.Backoff(attempt)
+
+		Log.Debugf("Retrying request %s in %v: %v", request.ID, backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-request.Context().Done():
+			return response, err
+		}
+	}
+}
+
 func (b *BasicMessageBroker) DeliverRequest(request *Request, clientInfo *ClientInfo) (*Response, error) {
 
+	// we don't accept new requests while shutting down
+	if !b.inFlight.enter() {
+		return ServiceUnavailable(&request.ID, "shutting down", nil), nil
+	}
+
+	response, err := b.deliverAccepted(request, clientInfo)
+
+	if response != nil && response.Stream != nil {
+		response.Stream = FinalizeStream(response.Stream, func(error) {
+			b.inFlight.leave()
+		})
+	} else {
+		b.inFlight.leave()
+	}
+
+	return response, err
+}
+
+// Delivers a request that a channel sends on behalf of a request that we
+// accepted before, which is why it isn't rejected while draining
+func (b *BasicMessageBroker) DeliverInternalRequest(request *Request, clientInfo *ClientInfo) (*Response, error) {
+	return b.deliverAccepted(request, clientInfo)
+}
+
+// delivers a request that we accepted, requests that we send on behalf of
+// accepted ones take this path too so that they aren't rejected while draining
+func (b *BasicMessageBroker) deliverAccepted(request *Request, clientInfo *ClientInfo) (*Response, error) {
+
+	span := StartSpan(request, "broker.deliver", SpanKindInternal)
+
+	if clientInfo != nil {
+		span.SetAttribute("hyper.caller", clientInfo.Name)
+	}
+
+	// we drop requests whose caller is no longer interested in a response
+	if request.Expired() {
+		response := DeadlineExceeded(&request.ID, "deadline exceeded", nil)
+		span.Finish(response, nil)
+		return response, nil
+	}
+
+	ctx, cancel := request.DeadlineContext()
+	request.SetContext(ctx)
+
+	response, err := b.deliverRequest(request, clientInfo, cancel)
+
+	if response != nil && response.Stream != nil {
+		// the stream may depend on the request context, so we keep it
+		// alive until the stream is done
+		response.Stream = FinalizeStream(response.Stream, func(err error) {
+			cancel()
+			span.Finish(nil, err)
+		})
+		return response, nil
+	}
+
+	cancel()
+	span.Finish(response, err)
+
+	return response, err
+}
+
+func (b *BasicMessageBroker) deliverRequest(request *Request, clientInfo *ClientInfo, cancel context.CancelFunc) (*Response, error) {
+
+	if clientInfo == nil {
+		return nil, fmt.Errorf("client info missing")
+	}
+
 	b.mutex.Lock()
 
-	if inTransit, ok := b.requestsInTransit[request.ID]; ok && inTransit {
+	if _, ok := b.requestsInTransit[request.ID]; ok {
 		b.mutex.Unlock()
 		return nil, fmt.Errorf("request %s is already being processed (maybe a delivery loop)", request.ID)
 	} else {
-		b.requestsInTransit[request.ID] = true
+		b.requestsInTransit[request.ID] = &requestInTransit{
+			client: clientInfo.Name,
+			cancel: cancel,
+		}
 		defer func() {
 			b.mutex.Lock()
 			delete(b.requestsInTransit, request.ID)
@@ -139,10 +703,6 @@ func (b *BasicMessageBroker) DeliverRequest(request *Request, clientInfo *Client
 
 	b.mutex.Unlock()
 
-	if clientInfo == nil {
-		return nil, fmt.Errorf("client info missing")
-	}
-
 	Log.Tracef("Delivering request with method '%s' from client '%s'...", request.Method, clientInfo.Name)
 
 	var ownEntry, remoteEntry *DirectoryEntry
@@ -175,14 +735,39 @@ func (b *BasicMessageBroker) DeliverRequest(request *Request, clientInfo *Client
 		return nil, fmt.Errorf("error parsing address: %w", err)
 	}
 
-	if _, err := b.directory.EntryFor(address.Operator); err != nil {
+	if b.auditLog == nil {
+		return b.dispatch(address, request, clientInfo, ownEntry, remoteEntry)
+	}
+
+	startedAt := time.Now()
+	response, err := b.dispatch(address, request, clientInfo, ownEntry, remoteEntry)
+	b.audit(address, request, clientInfo, startedAt, response, err)
+
+	return response, err
+}
+
+func (b *BasicMessageBroker) dispatch(address *Address, request *Request, clientInfo *ClientInfo, ownEntry, remoteEntry *DirectoryEntry) (*Response, error) {
+
+	if address.Group != "" {
+		return b.deliverToGroup(address, request, clientInfo, ownEntry)
+	}
+
+	recipientEntry, err := b.directory.EntryFor(address.Operator)
+
+	if err != nil {
 		return nil, fmt.Errorf("error retrieving directory entry for recipient '%s': %w", address.Operator, err)
 	}
 
 	// if the remote entry isn't identical to the local one we check if the
 	// remote endpoint actually has the right to call the given service on
-	// this endpoint
-	if ownEntry.Name != remoteEntry.Name {
+	// this endpoint, pub/sub methods are permitted per topic
+	if ownEntry.Name != remoteEntry.Name && address.Operator == ownEntry.Name && pubSubMethods[address.Method] {
+		if !CanUseTopic(remoteEntry, ownEntry, address.Method, request.Params) {
+			msg := fmt.Sprintf("Permission denied for method '%s', topic '%v' and client '%s'", address.Method, request.Params["topic"], clientInfo.Name)
+			Log.Warningf(msg)
+			return PermissionDenied(&request.ID, msg, nil), nil
+		}
+	} else if ownEntry.Name != remoteEntry.Name {
 		if !CanCall(remoteEntry, ownEntry, address.Method) {
 			msg := fmt.Sprintf("Permission denied for method '%s' and client '%s'", address.Method, clientInfo.Name)
 			Log.Warningf(msg)
@@ -190,34 +775,263 @@ func (b *BasicMessageBroker) DeliverRequest(request *Request, clientInfo *Client
 		}
 	}
 
+	var response *Response
+
+	b.mutex.Lock()
+	interceptors := b.interceptors
+	b.mutex.Unlock()
+
+	// the number of interceptors that have seen the request, only those
+	// will see the outcome
+	intercepted := 0
+
+	for _, interceptor := range interceptors {
+		intercepted++
+		if response, err = interceptor.BeforeDelivery(request, clientInfo); err != nil {
+			err = fmt.Errorf("error intercepting request: %w", err)
+			break
+		} else if response != nil {
+			break
+		}
+	}
+
+	if err == nil && response == nil {
+		response, err = b.deliver(address, recipientEntry, ownEntry, request, clientInfo)
+	}
+
+	for i := intercepted - 1; i >= 0; i-- {
+		response, err = interceptors[i].AfterDelivery(request, clientInfo, response, err)
+	}
+
+	return response, err
+}
+
+func (b *BasicMessageBroker) deliver(address *Address, recipientEntry, ownEntry *DirectoryEntry, request *Request, clientInfo *ClientInfo) (*Response, error) {
+
+	// only requests from other operators to us can carry a payload for us
+	if address.Operator != ownEntry.Name || clientInfo.Name == ownEntry.Name {
+		return b.deliverPayload(address, recipientEntry, ownEntry, request, clientInfo)
+	}
+
+	var encryptResponse, signResponse func(*Response) error
+	var err error
+
+	if b.encryption != nil {
+		if encryptResponse, err = b.encryption.DecryptRequest(request); err != nil {
+			Log.Warningf("Cannot decrypt payload of request %s: %v", request.ID, err)
+			return InvalidParams(&request.ID, map[string]interface{}{EncryptedPayloadKey: err.Error()}), nil
+		}
+	} else if HasEncryptedPayload(request.Params) {
+		return InvalidParams(&request.ID, map[string]interface{}{EncryptedPayloadKey: "encrypted payloads are not supported"}), nil
+	}
+
+	// signatures cover the decrypted payload
+	if b.signer != nil {
+		if signResponse, err = b.signer.VerifyRequest(request, clientInfo.Entry); err != nil {
+			msg := fmt.Sprintf("Invalid signature for method '%s' and client '%s': %v", address.Method, clientInfo.Name, err)
+			Log.Warningf(msg)
+			return PermissionDenied(&request.ID, msg, nil), nil
+		}
+	}
+
+	response, err := b.deliverPayload(address, recipientEntry, ownEntry, request, clientInfo)
+
+	if err != nil || response == nil {
+		return response, err
+	}
+
+	if signResponse != nil {
+		if err := signResponse(response); err != nil {
+			return nil, fmt.Errorf("error signing response: %w", err)
+		}
+	}
+
+	if encryptResponse != nil {
+		if err := encryptResponse(response); err != nil {
+			return nil, fmt.Errorf("error encrypting response: %w", err)
+		}
+	}
+
+	return response, nil
+}
+
+func (b *BasicMessageBroker) deliverPayload(address *Address, recipientEntry, ownEntry *DirectoryEntry, request *Request, clientInfo *ClientInfo) (*Response, error) {
+
+	// we check the parameters against the ones declared by the recipient,
+	// which we can't do if only the recipient can read them
+	if serviceMethod := MethodFor(recipientEntry, address.Method); serviceMethod != nil && !HasEncryptedPayload(request.Params) {
+		if errors, err := serviceMethod.ValidateParams(request.Params); err != nil {
+			return nil, fmt.Errorf("error validating parameters for method '%s': %w", address.Method, err)
+		} else if errors != nil {
+			Log.Debugf("Invalid parameters for method '%s': %v", address.Method, errors)
+			return InvalidParams(&request.ID, errors), nil
+		}
+	}
+
+	// we enforce rate limits for calls from other operators
+	if address.Operator == ownEntry.Name && clientInfo.Name != ownEntry.Name {
+		directoryRateLimits, err := DirectoryRateLimits(ownEntry, clientInfo.Name)
+		if err != nil {
+			// an invalid directory entry shouldn't make us reject all calls
+			Log.Errorf("Invalid rate limits in directory entry, using the local ones: %v", err)
+		}
+		b.mutex.Lock()
+		rateLimits := append(directoryRateLimits, b.rateLimits...)
+		b.mutex.Unlock()
+		if rateLimit := b.rateLimiter.Allow(rateLimits, clientInfo.Entry, address.Method); rateLimit != nil {
+			msg := fmt.Sprintf("Rate limit exceeded for method '%s' and client '%s'", address.Method, clientInfo.Name)
+			Log.Warningf(msg)
+			return RateLimitExceeded(&request.ID, msg, map[string]interface{}{"type": rateLimit.Type, "limit": rateLimit.Limit}), nil
+		}
+	}
+
 	if address.Operator == ownEntry.Name {
-		if response, err := b.handleInternalRequest(address, request); err != nil {
+		if response, err := b.handleInternalRequest(address, request, clientInfo); err != nil {
 			return nil, fmt.Errorf("error handling internal request: %w", err)
 		} else if response != nil {
 			return response, nil
 		}
 	}
 
-	for i, channel := range b.channels {
-		Log.Debugf("Checking whether channel %d can deliver message with method '%s' to '%s'...", i, address.Method, address.Operator)
-		if !channel.CanDeliverTo(address) {
-			continue
+	var cacheKey string
+	var cacheHints *CacheHints
+
+	// we reuse the responses of cacheable methods of other operators
+	if b.cache != nil && address.Operator != ownEntry.Name && !request.Stream && !HasEncryptedPayload(request.Params) {
+		if serviceMethod := MethodFor(recipientEntry, address.Method); serviceMethod != nil && serviceMethod.Cache != nil && serviceMethod.Cache.TTL > 0 {
+			caller := clientInfo.Name
+			if serviceMethod.Cache.Shared {
+				caller = ""
+			}
+			var err error
+			if cacheKey, err = ResponseCacheKey(address.Operator, address.Method, caller, request.Params); err != nil {
+				return nil, err
+			} else if response := b.cache.Get(cacheKey, request.ID); response != nil {
+				Log.Debugf("Returning cached response for request %s", request.ID)
+				return response, nil
+			}
+			cacheHints = serviceMethod.Cache
 		}
-		Log.Debug("Trying to deliver message...")
-		if response, err := channel.DeliverRequest(request); err != nil {
-			msg := fmt.Sprintf("Channel %d encountered an error delivering the message: %v", i, err)
-			Log.Errorf(msg)
-			return ChannelError(&request.ID, msg, nil), nil
+	}
+
+	var verifyResponse, decryptResponse func(*Response) error
+
+	// we sign and encrypt our own requests to other operators if enabled
+	if clientInfo.Name == ownEntry.Name && address.Operator != ownEntry.Name {
+		var err error
+		if b.signer != nil {
+			if verifyResponse, err = b.signer.SignRequest(request, recipientEntry); err != nil {
+				return nil, fmt.Errorf("error signing request: %w", err)
+			}
+		}
+		if b.encryption != nil {
+			if decryptResponse, err = b.encryption.EncryptRequest(request, recipientEntry); err != nil {
+				return nil, fmt.Errorf("error encrypting request: %w", err)
+			}
+		}
+	}
+
+	var done func(*Response)
+
+	// we only execute requests with an idempotency key once, retries get
+	// the stored response
+	if request.IdempotencyKey != "" && b.idempotency != nil && address.Operator == ownEntry.Name {
+		if response, acquired, err := b.idempotency.Acquire(request.Context(), clientInfo.Name, request.IdempotencyKey, address.Method, request.Params); err == IdempotencyKeyReused {
+			msg := fmt.Sprintf("Idempotency key '%s' of client '%s' was already used for a different request", request.IdempotencyKey, clientInfo.Name)
+			Log.Warningf(msg)
+			return UnprocessableRequest(&request.ID, msg, nil), nil
+		} else if contextResponse := ContextError(&request.ID, err); contextResponse != nil {
+			return contextResponse, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("error retrieving stored response: %w", err)
+		} else if response != nil {
+			Log.Debugf("Returning stored response for request %s", request.ID)
+			return &Response{Result: response.Result, Error: response.Error, ID: &request.ID}, nil
 		} else {
-			return response, nil
+			done = acquired
 		}
 	}
 
-	Log.Debug("Done checking channels...")
+	var response *Response
+	var err error
+
+	if address.Operator == ownEntry.Name {
+		response, err = b.deliverToChannels(address, request, retrySafe(recipientEntry, address.Method))
+	} else {
+		attempts := b.deliveryAttempts(recipientEntry, address.Method)
+		if isOutboxRetry(request) {
+			attempts = 1
+		}
+		response, err = b.deliverToOperator(address, request, attempts, retrySafe(recipientEntry, address.Method))
+	}
 
-	return nil, fmt.Errorf("no channel can deliver this request")
+	if err == nil && response != nil {
+		if decryptResponse != nil {
+			if err := decryptResponse(response); err != nil {
+				return nil, fmt.Errorf("error decrypting response: %w", err)
+			}
+		}
+		if verifyResponse != nil {
+			if err := verifyResponse(response); err != nil {
+				return nil, fmt.Errorf("error verifying response: %w", err)
+			}
+		}
+	}
+
+	// only complete, successful responses are cached
+	if cacheHints != nil && err == nil && response != nil && response.Error == nil && response.Stream == nil {
+		if err := b.cache.Store(cacheKey, address.Operator, address.Method, response, time.Duration(cacheHints.TTL)*time.Second); err != nil {
+			Log.Errorf("Error caching response: %v", err)
+		}
+	}
+
+	if done != nil {
+		// we only store responses that we actually received, streams
+		// can only be consumed once
+		if err == nil && (response == nil || response.Stream == nil) {
+			done(response)
+		} else {
+			done(nil)
+		}
+	}
+
+	if err != nil && isOutboxRetry(request) {
+		// the outbox has its own backoff, so we make a single attempt
+		return nil, err
+	} else if err == NoChannelCanDeliver || err == CircuitOpen {
+		// we queue requests from this operator if the recipient is unreachable,
+		// except for encrypted ones whose response key only lives in this call
+		if b.outbox != nil && !request.Stream && decryptResponse == nil && clientInfo.Name == ownEntry.Name && address.Operator != ownEntry.Name {
+			if entry, err := b.outbox.Queue(request, clientInfo); err != nil {
+				return nil, fmt.Errorf("error queueing request: %w", err)
+			} else {
+				Log.Debugf("Queued request %s in the outbox", request.ID)
+				// this isn't a result, the caller can ask for it via '_result'
+				return RequestQueued(&request.ID, entry.StatusMap()), nil
+			}
+		}
+		if err == CircuitOpen {
+			return ServiceUnavailable(&request.ID, fmt.Sprintf("operator '%s' is unavailable", address.Operator), nil), nil
+		}
+		return nil, err
+	} else if contextResponse := ContextError(&request.ID, err); contextResponse != nil {
+		Log.Debugf("Request %s was not completed: %v", request.ID, err)
+		return contextResponse, nil
+	} else if err != nil {
+		Log.Errorf(err.Error())
+		return ChannelError(&request.ID, err.Error(), nil), nil
+	}
+
+	if clientInfo.Name == ownEntry.Name && response != nil && response.Error == nil {
+		b.trackSubscription(address, request, clientInfo)
+	}
+
+	return response, nil
 }
 
+// Returns the channels of the broker, the returned slice is never modified
 func (b *BasicMessageBroker) Channels() []Channel {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
 	return b.channels
 }
@@ -0,0 +1,50 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"github.com/kiprotect/hyper"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Exports the hit and miss counts of the cache, which are served by the
+// Prometheus metrics server
+func RegisterResponseCacheMetrics(cache *hyper.ResponseCache) {
+
+	collectors := []prometheus.Collector{
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "hyper_response_cache_hits_total",
+			Help: "Number of requests that were answered from the response cache.",
+		}, func() float64 { return float64(cache.Stats().Hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "hyper_response_cache_misses_total",
+			Help: "Number of requests to cacheable methods that were not found in the response cache.",
+		}, func() float64 { return float64(cache.Stats().Misses) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "hyper_response_cache_entries",
+			Help: "Number of responses in the response cache.",
+		}, func() float64 { return float64(cache.Stats().Entries) }),
+	}
+
+	for _, collector := range collectors {
+		// there's only one cache per process, so this only fails if the
+		// broker gets initialized again
+		if err := prometheus.Register(collector); err != nil {
+			hyper.Log.Warningf("Cannot register response cache metrics: %v", err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+import (
+	"fmt"
+	"sync"
+)
+
+// the maximum number of group members that we deliver a request to, and the
+// maximum number of subscribers that we deliver an event to, at the same time
+const MaxMulticastConcurrency = 10
+
+// Delivers a request with a group address to all members of the group that
+// permit the caller to call the given method. The responses are aggregated
+// per operator, failed deliveries are reported separately.
+func (b *BasicMessageBroker) deliverToGroup(address *Address, request *Request, clientInfo *ClientInfo, ownEntry *DirectoryEntry) (*Response, error) {
+
+	// we only fan out requests from this operator, as remote operators are
+	// not allowed to send requests on our behalf
+	if clientInfo.Name != ownEntry.Name {
+		msg := fmt.Sprintf("Client '%s' may not send requests to group '%s' via this operator", clientInfo.Name, address.Group)
+		Log.Warningf(msg)
+		return PermissionDenied(&request.ID, msg, nil), nil
+	}
+
+	members, err := b.directory.Entries(&DirectoryQuery{Group: address.Group})
+
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving members of group '%s': %w", address.Group, err)
+	}
+
+	results := map[string]interface{}{}
+	// members that we couldn't reach, with the status of their outbox entry
+	queued := map[string]interface{}{}
+	errors := map[string]interface{}{}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	slots := make(chan bool, MaxMulticastConcurrency)
+
+	for _, member := range members {
+
+		if !CanCall(clientInfo.Entry, member, address.Method) {
+			Log.Debugf("Skipping group member '%s' as it does not permit calling '%s'", member.Name, address.Method)
+			continue
+		}
+
+		params := make(map[string]interface{}, len(request.Params))
+
+		for key, value := range request.Params {
+			params[key] = value
+		}
+
+		// every member only sees its own request, so they can share the key
+		memberRequest := &Request{
+			Method:         fmt.Sprintf("%s.%s", member.Name, address.Method),
+			ID:             fmt.Sprintf("%s.%s(%s)", member.Name, address.Method, address.ID),
+			Params:         params,
+			Deadline:       request.Deadline,
+			IdempotencyKey: request.IdempotencyKey,
+		}
+
+		memberRequest.SetContext(request.Context())
+
+		wg.Add(1)
+		slots <- true
+
+		go func(name string) {
+
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			// we deliver the request like any other, so all checks apply
+			response, err := b.deliverAccepted(memberRequest, &ClientInfo{Name: clientInfo.Name})
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if err != nil {
+				errors[name] = map[string]interface{}{"code": 500, "message": err.Error()}
+			} else if response == nil {
+				results[name] = nil
+			} else if response.Error != nil && response.Error.Code == 202 {
+				queued[name] = response.Error.Data
+			} else if response.Error != nil {
+				errors[name] = map[string]interface{}{"code": response.Error.Code, "message": response.Error.Message, "data": response.Error.Data}
+			} else {
+				results[name] = response.Result
+			}
+
+		}(member.Name)
+	}
+
+	wg.Wait()
+
+	return &Response{
+		Result: map[string]interface{}{
+			"group":     address.Group,
+			"responses": results,
+			"queued":    queued,
+			"errors":    errors,
+		},
+		ID: &request.ID,
+	}, nil
+}
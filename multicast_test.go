@@ -0,0 +1,209 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper_test
+
+import (
+	"fmt"
+	"github.com/kiprotect/hyper"
+	th "github.com/kiprotect/hyper/testing"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMulticast(t *testing.T) {
+
+	calc := []*hyper.OperatorService{
+		{
+			Name:        "calc",
+			Permissions: []*hyper.Permission{{Group: "*", Rights: []string{"call"}}},
+			Methods:     []*hyper.ServiceMethod{{Name: "add"}},
+		},
+	}
+
+	entries := []*hyper.DirectoryEntry{{Name: "op-0"}}
+	operators := []string{}
+
+	// more members than we deliver to at the same time
+	for i := 1; i <= 2*hyper.MaxMulticastConcurrency; i++ {
+		name := fmt.Sprintf("op-%d", i)
+		entries = append(entries, &hyper.DirectoryEntry{Name: name, Groups: []string{"workers"}, Services: calc})
+		operators = append(operators, name)
+	}
+
+	// this member doesn't permit calling the method
+	entries = append(entries, &hyper.DirectoryEntry{Name: "op-100", Groups: []string{"workers"}})
+	operators = append(operators, "op-100")
+
+	var mutex sync.Mutex
+	var active, maxActive int
+
+	broker, channel := th.MakeMemoryBroker(t, th.MakeMemoryDirectory("op-0", entries...), func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+
+		mutex.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mutex.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mutex.Lock()
+		active--
+		mutex.Unlock()
+
+		switch address.Operator {
+		case "op-1":
+			return nil, fmt.Errorf("unreachable")
+		case "op-2":
+			return &hyper.Response{Error: &hyper.Error{Code: 404, Message: "not found", Data: map[string]interface{}{"method": "add"}}, ID: &address.ID}, nil
+		}
+
+		return &hyper.Response{Result: map[string]interface{}{"operator": address.Operator}, ID: &address.ID}, nil
+
+	}, operators...)
+
+	request := &hyper.Request{
+		ID:             "@workers.add(1)",
+		Method:         "@workers.add",
+		Params:         map[string]interface{}{"a": 1},
+		IdempotencyKey: "add-1",
+	}
+
+	response, err := broker.DeliverRequest(request, &hyper.ClientInfo{Name: "op-0"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Error != nil {
+		t.Fatalf("unexpected error: %s", response.Error.Message)
+	}
+
+	if maxActive > hyper.MaxMulticastConcurrency {
+		t.Fatalf("expected at most %d concurrent deliveries, got %d", hyper.MaxMulticastConcurrency, maxActive)
+	}
+
+	requests := channel.Requests()
+
+	// the member that doesn't permit the call doesn't get a request
+	if len(requests) != 2*hyper.MaxMulticastConcurrency {
+		t.Fatalf("expected %d requests, got %d", 2*hyper.MaxMulticastConcurrency, len(requests))
+	}
+
+	for _, memberRequest := range requests {
+		if memberRequest.IdempotencyKey != "add-1" {
+			t.Fatalf("expected the idempotency key in the request to %s", memberRequest.Method)
+		}
+		if memberRequest.Params["a"] != 1 {
+			t.Fatalf("expected the parameters in the request to %s", memberRequest.Method)
+		}
+	}
+
+	if response.Result["group"] != "workers" {
+		t.Fatalf("expected the group in the result")
+	}
+
+	responses, _ := response.Result["responses"].(map[string]interface{})
+	errors, _ := response.Result["errors"].(map[string]interface{})
+
+	if len(responses) != 2*hyper.MaxMulticastConcurrency-2 || len(errors) != 2 {
+		t.Fatalf("expected %d responses and 2 errors, got %d and %d", 2*hyper.MaxMulticastConcurrency-2, len(responses), len(errors))
+	}
+
+	if result, _ := responses["op-3"].(map[string]interface{}); result["operator"] != "op-3" {
+		t.Fatalf("expected the result of op-3")
+	}
+
+	if _, ok := responses["op-100"]; ok {
+		t.Fatalf("expected no response from op-100")
+	}
+
+	if err, _ := errors["op-1"].(map[string]interface{}); err["code"] != 500 {
+		t.Fatalf("expected a channel error for op-1, got %v", errors["op-1"])
+	}
+
+	if err, _ := errors["op-2"].(map[string]interface{}); err["code"] != 404 || err["message"] != "not found" {
+		t.Fatalf("expected the error response of op-2, got %v", errors["op-2"])
+	} else if data, _ := err["data"].(map[string]interface{}); data["method"] != "add" {
+		t.Fatalf("expected the error data of op-2")
+	}
+}
+
+func TestMulticastFromRemoteOperator(t *testing.T) {
+
+	directory := th.MakeMemoryDirectory(
+		"op-0",
+		&hyper.DirectoryEntry{Name: "op-0"},
+		&hyper.DirectoryEntry{Name: "op-1", Groups: []string{"workers"}},
+	)
+
+	broker, err := hyper.MakeBasicMessageBroker(directory)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// other operators can't send group requests on our behalf
+	if response, err := broker.DeliverRequest(&hyper.Request{ID: "@workers.add(1)", Method: "@workers.add"}, &hyper.ClientInfo{Name: "op-1"}); err != nil {
+		t.Fatal(err)
+	} else if response.Error == nil || response.Error.Code != 403 {
+		t.Fatalf("expected the request to be denied")
+	}
+}
+
+func TestMulticastQueuesRequests(t *testing.T) {
+
+	calc := []*hyper.OperatorService{
+		{
+			Name:        "calc",
+			Permissions: []*hyper.Permission{{Group: "*", Rights: []string{"call"}}},
+			Methods:     []*hyper.ServiceMethod{{Name: "add"}},
+		},
+	}
+
+	// no channel can deliver requests to op-2
+	broker, _ := th.MakeMemoryBroker(t, th.MakeMemoryDirectory("op-0",
+		&hyper.DirectoryEntry{Name: "op-0"},
+		&hyper.DirectoryEntry{Name: "op-1", Groups: []string{"workers"}, Services: calc},
+		&hyper.DirectoryEntry{Name: "op-2", Groups: []string{"workers"}, Services: calc},
+	), func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+		return &hyper.Response{Result: map[string]interface{}{"operator": address.Operator}, ID: &address.ID}, nil
+	}, "op-1")
+
+	enableOutbox(t, broker)
+
+	response, err := broker.DeliverRequest(&hyper.Request{ID: "@workers.add(1)", Method: "@workers.add", Params: map[string]interface{}{}}, &hyper.ClientInfo{Name: "op-0"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responses, _ := response.Result["responses"].(map[string]interface{})
+	queued, _ := response.Result["queued"].(map[string]interface{})
+	errors, _ := response.Result["errors"].(map[string]interface{})
+
+	if len(responses) != 1 || responses["op-1"] == nil || len(errors) != 0 {
+		t.Fatalf("expected a response from op-1 only, got %v", response.Result)
+	}
+
+	// queued members are neither answered nor failed
+	if status, _ := queued["op-2"].(map[string]interface{}); len(queued) != 1 || status["status"] != hyper.OutboxQueued {
+		t.Fatalf("expected the request to op-2 to be queued, got %v", queued)
+	}
+}
@@ -0,0 +1,269 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"reflect"
+)
+
+const OpenRPCVersion = "1.2.6"
+
+// An OpenRPC document (https://spec.open-rpc.org) that describes the
+// methods of an operator
+type OpenRPCDocument struct {
+	OpenRPC string           `json:"openrpc"`
+	Info    *OpenRPCInfo     `json:"info"`
+	Methods []*OpenRPCMethod `json:"methods"`
+}
+
+type OpenRPCInfo struct {
+	Title string `json:"title"`
+	// the hash of the latest change record of the operator
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type OpenRPCTag struct {
+	Name string `json:"name"`
+}
+
+type OpenRPCMethod struct {
+	Name           string                      `json:"name"`
+	Tags           []*OpenRPCTag               `json:"tags"`
+	ParamStructure string                      `json:"paramStructure"`
+	Params         []*OpenRPCContentDescriptor `json:"params"`
+	Result         *OpenRPCContentDescriptor   `json:"result"`
+	RetrySafe      bool                        `json:"x-retry-safe,omitempty"`
+	Cache          *CacheHints                 `json:"x-cache,omitempty"`
+}
+
+type OpenRPCContentDescriptor struct {
+	Name     string                 `json:"name"`
+	Required bool                   `json:"required,omitempty"`
+	Schema   map[string]interface{} `json:"schema"`
+}
+
+// Returns the document as a map, e.g. to use it as a response result
+func (d *OpenRPCDocument) AsMap() (map[string]interface{}, error) {
+	if data, err := json.Marshal(d); err != nil {
+		return nil, fmt.Errorf("error marshalling as JSON: %w", err)
+	} else {
+		var mapStruct map[string]interface{}
+		if err := json.Unmarshal(data, &mapStruct); err != nil {
+			return nil, fmt.Errorf("error unmarshaling JSON: %w", err)
+		} else {
+			return mapStruct, nil
+		}
+	}
+}
+
+// Returns an OpenRPC document for the methods of the callee. If a caller is
+// given, the document only contains the methods that it may call.
+func OpenRPC(caller, callee *DirectoryEntry) *OpenRPCDocument {
+
+	document := &OpenRPCDocument{
+		OpenRPC: OpenRPCVersion,
+		Info: &OpenRPCInfo{
+			Title:   callee.Name,
+			Version: "0",
+		},
+		Methods: make([]*OpenRPCMethod, 0),
+	}
+
+	if callee.Properties != nil {
+		document.Info.Description = callee.Properties.DisplayName
+	}
+
+	if len(callee.Records) > 0 {
+		document.Info.Version = callee.Records[len(callee.Records)-1].Hash
+	}
+
+	for _, service := range callee.Services {
+		for _, method := range service.Methods {
+
+			if caller != nil && !CanCall(caller, callee, method.Name) {
+				continue
+			}
+
+			params := make([]*OpenRPCContentDescriptor, 0, len(method.Parameters))
+
+			for _, parameter := range method.Parameters {
+				schema, required := ParameterSchema(parameter)
+				params = append(params, &OpenRPCContentDescriptor{
+					Name:     parameter.Name,
+					Required: required,
+					Schema:   schema,
+				})
+			}
+
+			document.Methods = append(document.Methods, &OpenRPCMethod{
+				Name:           method.Name,
+				Tags:           []*OpenRPCTag{{Name: service.Name}},
+				ParamStructure: "by-name",
+				Params:         params,
+				// the directory doesn't describe results
+				Result: &OpenRPCContentDescriptor{
+					Name:   "result",
+					Schema: map[string]interface{}{"type": "object"},
+				},
+				RetrySafe: method.RetrySafe,
+				Cache:     method.Cache,
+			})
+		}
+	}
+
+	return document
+}
+
+// Returns the JSON schema of the parameter and whether it is required.
+// Validators that aren't known to the forms module are left out.
+func ParameterSchema(parameter *ServiceParameter) (map[string]interface{}, bool) {
+
+	context := &forms.FormDescriptionContext{
+		Validators: forms.Validators,
+	}
+
+	validators := make([]forms.Validator, 0, len(parameter.Validators))
+
+	for _, serviceValidator := range parameter.Validators {
+		config := serviceValidator.Parameters
+		if config == nil {
+			config = map[string]interface{}{}
+		}
+		if validator, err := forms.ValidatorFromDescription(&forms.ValidatorDescription{
+			Type:   serviceValidator.Type,
+			Config: config,
+		}, context); err != nil {
+			Log.Debugf("Cannot describe validator '%s' of parameter '%s': %v", serviceValidator.Type, parameter.Name, err)
+		} else {
+			validators = append(validators, validator)
+		}
+	}
+
+	return validatorsSchema(validators)
+}
+
+// merges the schemas of the validators of a single value
+func validatorsSchema(validators []forms.Validator) (map[string]interface{}, bool) {
+
+	schema := map[string]interface{}{}
+	required := true
+
+	for _, validator := range validators {
+
+		// validators can be used as values or pointers
+		value := reflect.Indirect(reflect.ValueOf(validator))
+
+		if !value.IsValid() {
+			continue
+		}
+
+		switch v := value.Interface().(type) {
+		case forms.IsOptional:
+			required = false
+			if v.Default != nil {
+				schema["default"] = v.Default
+			}
+		case forms.IsString:
+			schema["type"] = "string"
+			if v.MinLength > 0 {
+				schema["minLength"] = v.MinLength
+			}
+			if v.MaxLength > 0 {
+				schema["maxLength"] = v.MaxLength
+			}
+		case forms.IsInteger:
+			schema["type"] = "integer"
+			if v.HasMin {
+				schema["minimum"] = v.Min
+			}
+			if v.HasMax {
+				schema["maximum"] = v.Max
+			}
+		case forms.IsFloat:
+			schema["type"] = "number"
+			if v.HasMin {
+				schema["minimum"] = v.Min
+			}
+			if v.HasMax {
+				schema["maximum"] = v.Max
+			}
+		case forms.IsBoolean:
+			schema["type"] = "boolean"
+		case forms.IsBytes:
+			schema["type"] = "string"
+			if v.Encoding != "" {
+				schema["contentEncoding"] = v.Encoding
+			}
+		case forms.IsHex:
+			schema["type"] = "string"
+			schema["pattern"] = "^[0-9a-fA-F]*$"
+		case forms.IsUUID:
+			schema["type"] = "string"
+			schema["format"] = "uuid"
+		case forms.IsTime:
+			schema["type"] = "string"
+			schema["format"] = "date-time"
+		case forms.MatchesRegex:
+			schema["type"] = "string"
+			schema["pattern"] = v.Source
+		case forms.IsIn:
+			schema["enum"] = v.Choices
+		case forms.IsNotIn:
+			schema["not"] = map[string]interface{}{"enum": v.Values}
+		case forms.IsStringList:
+			schema["type"] = "array"
+			items, _ := validatorsSchema(v.Validators)
+			items["type"] = "string"
+			schema["items"] = items
+		case forms.IsList:
+			schema["type"] = "array"
+			if len(v.Validators) > 0 {
+				schema["items"], _ = validatorsSchema(v.Validators)
+			}
+		case forms.IsStringMap:
+			schema["type"] = "object"
+			if v.Form != nil {
+				properties := map[string]interface{}{}
+				requiredProperties := make([]string, 0)
+				for _, field := range v.Form.Fields {
+					fieldSchema, fieldRequired := validatorsSchema(field.Validators)
+					properties[field.Name] = fieldSchema
+					if fieldRequired {
+						requiredProperties = append(requiredProperties, field.Name)
+					}
+				}
+				schema["properties"] = properties
+				if len(requiredProperties) > 0 {
+					schema["required"] = requiredProperties
+				}
+			}
+		case forms.Or:
+			options := make([]interface{}, 0, len(v.Options))
+			for _, option := range v.Options {
+				optionSchema, _ := validatorsSchema(option)
+				options = append(options, optionSchema)
+			}
+			schema["anyOf"] = options
+		}
+	}
+
+	return schema, required
+}
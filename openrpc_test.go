@@ -0,0 +1,103 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestOpenRPC(t *testing.T) {
+
+	callee := &DirectoryEntry{
+		Name: "op-2",
+		Services: []*OperatorService{
+			{
+				Name: "lookup",
+				Methods: []*ServiceMethod{
+					{
+						Name:        "countries",
+						Permissions: []*Permission{{Group: "partners", Rights: []string{"call"}}},
+						Parameters: []*ServiceParameter{
+							{
+								Name: "url",
+								Validators: []*ServiceValidator{
+									{Type: "IsString", Parameters: map[string]interface{}{"minLength": 1, "maxLength": 100}},
+									// unknown validators are left out
+									{Type: "IsURL"},
+								},
+							},
+							{
+								Name: "limit",
+								Validators: []*ServiceValidator{
+									{Type: "IsOptional", Parameters: map[string]interface{}{"default": 10}},
+									{Type: "IsInteger", Parameters: map[string]interface{}{"hasMin": true, "min": 1}},
+								},
+							},
+						},
+						RetrySafe: true,
+					},
+					{
+						Name:        "reset",
+						Permissions: []*Permission{{Group: "admins", Rights: []string{"call"}}},
+					},
+				},
+			},
+		},
+	}
+
+	document := OpenRPC(&DirectoryEntry{Name: "op-1", Groups: []string{"partners"}}, callee)
+
+	if document.Info.Title != "op-2" || len(document.Methods) != 1 {
+		t.Fatalf("expected only the countries method")
+	}
+
+	method := document.Methods[0]
+
+	if method.Name != "countries" || !method.RetrySafe || len(method.Params) != 2 || method.Tags[0].Name != "lookup" {
+		t.Fatalf("unexpected method description")
+	}
+
+	// we compare the serialized schemas to ignore the numeric types
+	schema := func(value interface{}) string {
+		data, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if url := method.Params[0]; !url.Required || schema(url.Schema) != `{"maxLength":100,"minLength":1,"type":"string"}` {
+		t.Fatalf("unexpected schema for 'url': %s", schema(url.Schema))
+	}
+
+	if limit := method.Params[1]; limit.Required || schema(limit.Schema) != `{"default":10,"minimum":1,"type":"integer"}` {
+		t.Fatalf("unexpected schema for 'limit': %s", schema(limit.Schema))
+	}
+
+	// without a caller we describe all methods
+	if document := OpenRPC(nil, callee); len(document.Methods) != 2 {
+		t.Fatalf("expected all methods")
+	}
+
+	if documentMap, err := document.AsMap(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(documentMap["openrpc"], OpenRPCVersion) {
+		t.Fatalf("expected the OpenRPC version")
+	}
+}
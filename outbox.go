@@ -0,0 +1,430 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	OutboxEntryType uint8 = 1
+)
+
+const (
+	OutboxQueued    = "queued"
+	OutboxDelivered = "delivered"
+	OutboxExpired   = "expired"
+)
+
+type OutboxSettings struct {
+	Datastore      *DatastoreSettings `json:"datastore"`
+	TTL            int64              `json:"ttl"`
+	InitialBackoff int64              `json:"initial_backoff"`
+	MaxBackoff     int64              `json:"max_backoff"`
+}
+
+// A request that could not be delivered right away
+type OutboxEntry struct {
+	Request     *Request    `json:"request"`
+	ClientInfo  *ClientInfo `json:"client_info"`
+	Status      string      `json:"status"`
+	Attempts    int         `json:"attempts"`
+	LastError   string      `json:"last_error,omitempty"`
+	Response    *Response   `json:"response,omitempty"`
+	QueuedAt    time.Time   `json:"queued_at"`
+	ExpiresAt   time.Time   `json:"expires_at"`
+	NextAttempt time.Time   `json:"next_attempt"`
+}
+
+// Returns the status of the entry without the request and response data
+func (o *OutboxEntry) StatusMap() map[string]interface{} {
+	status := map[string]interface{}{
+		"id":         o.Request.ID,
+		"status":     o.Status,
+		"attempts":   o.Attempts,
+		"queued_at":  o.QueuedAt.Format(time.RFC3339Nano),
+		"expires_at": o.ExpiresAt.Format(time.RFC3339Nano),
+	}
+	if o.Status == OutboxQueued {
+		status["next_attempt"] = o.NextAttempt.Format(time.RFC3339Nano)
+	}
+	if o.LastError != "" {
+		status["last_error"] = o.LastError
+	}
+	return status
+}
+
+// Delivers a queued request, the context is done when the outbox stops
+type OutboxDeliverer func(context.Context, *Request, *ClientInfo) (*Response, error)
+
+// A durable queue for requests to operators that are currently unreachable.
+// The datastore only holds outbox entries.
+type Outbox struct {
+	settings  *OutboxSettings
+	datastore Datastore
+	entries   map[string]*OutboxEntry
+	// the number of records in the datastore
+	records int
+	mutex   sync.Mutex
+	cancel  context.CancelFunc
+	done    chan bool
+}
+
+func MakeOutbox(settings *OutboxSettings, datastore Datastore) (*Outbox, error) {
+
+	outbox := &Outbox{
+		settings:  settings,
+		datastore: datastore,
+		entries:   make(map[string]*OutboxEntry),
+	}
+
+	if err := datastore.Init(); err != nil {
+		return nil, fmt.Errorf("error initializing outbox datastore: %w", err)
+	}
+
+	if err := outbox.load(); err != nil {
+		return nil, fmt.Errorf("error loading outbox entries: %w", err)
+	}
+
+	return outbox, nil
+}
+
+// loads all persisted entries, later entries replace earlier ones
+func (o *Outbox) load() error {
+
+	dataEntries, err := o.datastore.Read()
+
+	if err != nil {
+		return err
+	}
+
+	o.records = len(dataEntries)
+
+	for _, dataEntry := range dataEntries {
+		if dataEntry.Type != OutboxEntryType {
+			continue
+		}
+		entry := &OutboxEntry{}
+		if err := json.Unmarshal(dataEntry.Data, entry); err != nil {
+			return err
+		}
+		o.entries[entry.Request.ID] = entry
+	}
+
+	o.cleanUp()
+
+	Log.Debugf("Loaded %d outbox entries", len(o.entries))
+
+	return nil
+}
+
+func outboxDataEntry(entry *OutboxEntry) (*DataEntry, error) {
+
+	data, err := json.Marshal(entry)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataEntry{
+		Type: OutboxEntryType,
+		ID:   []byte(entry.Request.ID),
+		Data: data,
+	}, nil
+}
+
+// writes the entry to the datastore, needs to be called with the mutex held
+func (o *Outbox) persist(entry *OutboxEntry) error {
+
+	dataEntry, err := outboxDataEntry(entry)
+
+	if err != nil {
+		return err
+	}
+
+	if err := o.datastore.Write(dataEntry); err != nil {
+		return err
+	}
+
+	o.records++
+
+	return nil
+}
+
+// every attempt writes a new version of the entry, so we replace the
+// contents of the datastore with the current entries from time to time
+func (o *Outbox) compact() {
+
+	datastore, ok := o.datastore.(CompactableDatastore)
+
+	if !ok {
+		return
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.cleanUp()
+
+	if o.records <= 2*len(o.entries)+CompactionSlack {
+		return
+	}
+
+	dataEntries := make([]*DataEntry, 0, len(o.entries))
+
+	for _, entry := range o.entries {
+		if dataEntry, err := outboxDataEntry(entry); err != nil {
+			Log.Errorf("Error serializing outbox entry: %v", err)
+			return
+		} else {
+			dataEntries = append(dataEntries, dataEntry)
+		}
+	}
+
+	if err := datastore.Compact(dataEntries); err != nil {
+		Log.Errorf("Error compacting outbox datastore: %v", err)
+		return
+	}
+
+	Log.Debugf("Compacted outbox datastore from %d to %d records", o.records, len(dataEntries))
+
+	o.records = len(dataEntries)
+}
+
+func (o *Outbox) ttl() time.Duration {
+	return time.Duration(o.settings.TTL) * time.Second
+}
+
+func (o *Outbox) backoff(attempts int) time.Duration {
+	backoff := time.Duration(o.settings.InitialBackoff) * time.Second
+	maxBackoff := time.Duration(o.settings.MaxBackoff) * time.Second
+	for i := 1; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// Adds a request to the outbox
+func (o *Outbox) Queue(request *Request, clientInfo *ClientInfo) (*OutboxEntry, error) {
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if entry, ok := o.entries[request.ID]; ok && entry.Status == OutboxQueued {
+		return nil, fmt.Errorf("request %s is already queued", request.ID)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(o.ttl())
+
+	// there's no point in delivering a request after its deadline
+	if request.Deadline != nil && request.Deadline.Before(expiresAt) {
+		expiresAt = *request.Deadline
+	}
+
+	entry := &OutboxEntry{
+		Request: copyRequest(request),
+		// we only store the name, the entry will be updated on delivery. For
+		// local clients, this is the name of our own operator.
+		ClientInfo:  &ClientInfo{Name: clientInfo.Name},
+		Status:      OutboxQueued,
+		QueuedAt:    now,
+		ExpiresAt:   expiresAt,
+		NextAttempt: now.Add(o.backoff(1)),
+	}
+
+	if err := o.persist(entry); err != nil {
+		return nil, fmt.Errorf("error persisting outbox entry: %w", err)
+	}
+
+	o.entries[request.ID] = entry
+
+	return entry, nil
+}
+
+// Returns the entry for the given request ID
+func (o *Outbox) Entry(id string) *OutboxEntry {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.entries[id]
+}
+
+// Returns the number of requests that still wait for delivery
+func (o *Outbox) Queued() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	queued := 0
+	for _, entry := range o.entries {
+		if entry.Status == OutboxQueued {
+			queued++
+		}
+	}
+	return queued
+}
+
+// removes finished entries that are older than the TTL
+func (o *Outbox) cleanUp() {
+	now := time.Now()
+	for id, entry := range o.entries {
+		if entry.Status != OutboxQueued && now.After(entry.ExpiresAt.Add(o.ttl())) {
+			delete(o.entries, id)
+		}
+	}
+}
+
+func (o *Outbox) dueEntries() []*OutboxEntry {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.cleanUp()
+	now := time.Now()
+	entries := make([]*OutboxEntry, 0)
+	for _, entry := range o.entries {
+		if entry.Status == OutboxQueued && !now.Before(entry.NextAttempt) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func (o *Outbox) attempt(ctx context.Context, entry *OutboxEntry, deliver OutboxDeliverer) {
+
+	o.mutex.Lock()
+	// we work on a copy so that the entry doesn't change while we deliver it
+	updatedEntry := *entry
+	o.mutex.Unlock()
+
+	now := time.Now()
+
+	if now.After(updatedEntry.ExpiresAt) {
+		Log.Debugf("Outbox entry %s expired", updatedEntry.Request.ID)
+		updatedEntry.Status = OutboxExpired
+	} else {
+
+		updatedEntry.Attempts++
+
+		Log.Debugf("Trying to deliver outbox entry %s (attempt %d)...", updatedEntry.Request.ID, updatedEntry.Attempts)
+
+		// the delivery modifies the request (e.g. by adding the client info
+		// or encrypting the params), which must not affect the stored one
+		response, err := deliver(ctx, copyRequest(updatedEntry.Request), &ClientInfo{Name: updatedEntry.ClientInfo.Name})
+
+		// attempts that we aborted ourselves don't count
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			updatedEntry.LastError = err.Error()
+			updatedEntry.NextAttempt = now.Add(o.backoff(updatedEntry.Attempts + 1))
+		} else {
+			updatedEntry.Status = OutboxDelivered
+			updatedEntry.Response = response
+			updatedEntry.LastError = ""
+		}
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if err := o.persist(&updatedEntry); err != nil {
+		Log.Errorf("Error persisting outbox entry: %v", err)
+	}
+
+	o.entries[updatedEntry.Request.ID] = &updatedEntry
+
+}
+
+// returns a copy of the request that shares no params with the original
+func copyRequest(request *Request) *Request {
+	requestCopy := *request
+	if request.Params != nil {
+		requestCopy.Params = copyValue(request.Params).(map[string]interface{})
+	}
+	return &requestCopy
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		mapCopy := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			mapCopy[key] = copyValue(item)
+		}
+		return mapCopy
+	case []interface{}:
+		sliceCopy := make([]interface{}, len(v))
+		for i, item := range v {
+			sliceCopy[i] = copyValue(item)
+		}
+		return sliceCopy
+	default:
+		return value
+	}
+}
+
+// Starts retrying queued requests in the background
+func (o *Outbox) Start(deliver OutboxDeliverer) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+
+	o.mutex.Lock()
+	o.cancel, o.done = cancel, done
+	o.mutex.Unlock()
+
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(1 * time.Second):
+				for _, entry := range o.dueEntries() {
+					if ctx.Err() != nil {
+						return
+					}
+					o.attempt(ctx, entry, deliver)
+				}
+				o.compact()
+			}
+		}
+	}()
+}
+
+// Stops retrying queued requests and aborts the current attempt, queued
+// requests stay in the datastore
+func (o *Outbox) Stop() {
+
+	o.mutex.Lock()
+	cancel, done := o.cancel, o.done
+	o.cancel, o.done = nil, nil
+	o.mutex.Unlock()
+
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,253 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/kiprotect/hyper"
+	th "github.com/kiprotect/hyper/testing"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+
+	settings := &hyper.OutboxSettings{
+		TTL:            60,
+		InitialBackoff: 1,
+		MaxBackoff:     4,
+	}
+
+	datastore := &th.MemoryDatastore{}
+
+	outbox, err := hyper.MakeOutbox(settings, datastore)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if outbox.Backoff(1) != time.Second || outbox.Backoff(3) != 4*time.Second || outbox.Backoff(10) != 4*time.Second {
+		t.Fatalf("unexpected backoff values")
+	}
+
+	request := &hyper.Request{ID: "op-2.add(1)", Method: "op-2.add"}
+
+	if _, err := outbox.Queue(request, &hyper.ClientInfo{Name: "op-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := outbox.Queue(request, &hyper.ClientInfo{Name: "op-1"}); err == nil {
+		t.Fatalf("expected an error when queueing a request twice")
+	}
+
+	entry := outbox.Entry(request.ID)
+
+	// we make the entry due right away
+	entry.NextAttempt = time.Now()
+
+	outbox.Attempt(entry, func(context.Context, *hyper.Request, *hyper.ClientInfo) (*hyper.Response, error) {
+		return nil, fmt.Errorf("unreachable")
+	})
+
+	if entry := outbox.Entry(request.ID); entry.Status != hyper.OutboxQueued || entry.Attempts != 1 || entry.LastError != "unreachable" {
+		t.Fatalf("expected a failed delivery attempt")
+	}
+
+	outbox.Attempt(outbox.Entry(request.ID), func(context.Context, *hyper.Request, *hyper.ClientInfo) (*hyper.Response, error) {
+		return &hyper.Response{Result: map[string]interface{}{"sum": 3.0}}, nil
+	})
+
+	// we reload the outbox from the datastore
+	reloadedOutbox, err := hyper.MakeOutbox(settings, datastore)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry := reloadedOutbox.Entry(request.ID); entry == nil {
+		t.Fatalf("expected a persisted entry")
+	} else if entry.Status != hyper.OutboxDelivered || entry.Attempts != 2 || entry.Response.Result["sum"] != 3.0 {
+		t.Fatalf("expected a delivered entry")
+	}
+}
+
+func TestOutboxAttemptsUseCopies(t *testing.T) {
+
+	outbox, err := hyper.MakeOutbox(&hyper.OutboxSettings{TTL: 60, InitialBackoff: 1, MaxBackoff: 1}, &th.MemoryDatastore{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := &hyper.Request{
+		ID:     "op-2.add(1)",
+		Method: "op-2.add",
+		Params: map[string]interface{}{"numbers": map[string]interface{}{"a": 1.0}, "tags": []interface{}{"x"}},
+	}
+
+	if _, err := outbox.Queue(request, &hyper.ClientInfo{Name: "op-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the caller's request doesn't change the queued one
+	request.Params["numbers"].(map[string]interface{})["a"] = 2.0
+
+	// like the broker, the delivery modifies the request and the client info
+	deliver := func(ctx context.Context, request *hyper.Request, clientInfo *hyper.ClientInfo) (*hyper.Response, error) {
+		if request.Params["numbers"].(map[string]interface{})["a"] != 1.0 || request.Params["_client"] != nil || clientInfo.Entry != nil {
+			return nil, fmt.Errorf("modified request")
+		}
+		request.Params["numbers"].(map[string]interface{})["a"] = 3.0
+		request.Params["tags"].([]interface{})[0] = "y"
+		request.Params["_client"] = map[string]interface{}{"name": clientInfo.Name}
+		clientInfo.Entry = &hyper.DirectoryEntry{Name: clientInfo.Name}
+		return nil, fmt.Errorf("unreachable")
+	}
+
+	for i := 0; i < 2; i++ {
+		outbox.Attempt(outbox.Entry(request.ID), deliver)
+		if entry := outbox.Entry(request.ID); entry.LastError != "unreachable" {
+			t.Fatalf("expected attempt %d to see the queued request, got: %s", i+1, entry.LastError)
+		}
+	}
+
+	if entry := outbox.Entry(request.ID); entry.Request.Params["tags"].([]interface{})[0] != "x" || entry.ClientInfo.Entry != nil {
+		t.Fatalf("expected the queued request to be unchanged")
+	}
+}
+
+func TestOutboxCompaction(t *testing.T) {
+
+	settings := &hyper.OutboxSettings{
+		TTL:            60,
+		InitialBackoff: 1,
+		MaxBackoff:     1,
+	}
+
+	datastore := &th.MemoryDatastore{}
+
+	outbox, err := hyper.MakeOutbox(settings, datastore)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := &hyper.Request{ID: "op-2.add(1)", Method: "op-2.add"}
+
+	if _, err := outbox.Queue(request, &hyper.ClientInfo{Name: "op-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		outbox.Attempt(outbox.Entry(request.ID), func(context.Context, *hyper.Request, *hyper.ClientInfo) (*hyper.Response, error) {
+			return nil, fmt.Errorf("unreachable")
+		})
+	}
+
+	outbox.Compact()
+
+	if entries, _ := datastore.Read(); len(entries) != 1 {
+		t.Fatalf("expected a single record after compaction, got %d", len(entries))
+	}
+
+	reloadedOutbox, err := hyper.MakeOutbox(settings, datastore)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry := reloadedOutbox.Entry(request.ID); entry == nil || entry.Attempts != 200 {
+		t.Fatalf("expected the latest version of the entry")
+	}
+}
+
+func TestOutboxStop(t *testing.T) {
+
+	outbox, err := hyper.MakeOutbox(&hyper.OutboxSettings{TTL: 60}, &th.MemoryDatastore{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stopping an outbox that never started does nothing
+	outbox.Stop()
+
+	// without a backoff the request is due right away
+	if _, err := outbox.Queue(&hyper.Request{ID: "op-2.add(1)", Method: "op-2.add"}, &hyper.ClientInfo{Name: "op-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan bool)
+
+	outbox.Start(func(ctx context.Context, request *hyper.Request, clientInfo *hyper.ClientInfo) (*hyper.Response, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	<-started
+
+	// stopping aborts the attempt, which doesn't count
+	outbox.Stop()
+
+	if entry := outbox.Entry("op-2.add(1)"); entry.Status != hyper.OutboxQueued || entry.Attempts != 0 {
+		t.Fatalf("expected an untouched entry")
+	}
+}
+
+// gives the broker an outbox that keeps requests until the test ends
+func enableOutbox(t *testing.T, broker *hyper.BasicMessageBroker) {
+
+	outbox, err := hyper.MakeOutbox(&hyper.OutboxSettings{TTL: 60, InitialBackoff: 60, MaxBackoff: 60}, &th.MemoryDatastore{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker.SetOutbox(outbox)
+	t.Cleanup(broker.Stop)
+}
+
+func TestBrokerQueuesRequests(t *testing.T) {
+
+	// no channel can deliver requests to op-2
+	broker, _ := th.MakeMemoryBroker(t, th.MakeMemoryDirectory("op-1", th.Entries("op-1", "op-2")...), func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+		return &hyper.Response{ID: &address.ID, Result: map[string]interface{}{}}, nil
+	}, "op-3")
+
+	enableOutbox(t, broker)
+
+	response, err := broker.DeliverRequest(&hyper.Request{
+		ID:     "op-2.add(1)",
+		Method: "op-2.add",
+		Params: map[string]interface{}{},
+	}, &hyper.ClientInfo{Name: "op-1"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a queued request has no result yet
+	if response.Result != nil || response.Error == nil || response.Error.Code != 202 {
+		t.Fatalf("expected the request to be queued")
+	}
+
+	if response.Error.Data["id"] != "op-2.add(1)" || response.Error.Data["status"] != hyper.OutboxQueued {
+		t.Fatalf("expected the status of the outbox entry, got %v", response.Error.Data)
+	}
+}
@@ -26,6 +26,8 @@ type HyperClient interface {
 	Call(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error)
 	// client sends a response to the server and receives an acknowledgment
 	ServerCall(ctx context.Context, opts ...grpc.CallOption) (Hyper_ServerCallClient, error)
+	// client sends a request to the server and receives the result in chunks
+	StreamCall(ctx context.Context, in *Request, opts ...grpc.CallOption) (Hyper_StreamCallClient, error)
 }
 
 type hyperClient struct {
@@ -76,6 +78,38 @@ func (x *hyperServerCallClient) Recv() (*Request, error) {
 	return m, nil
 }
 
+func (c *hyperClient) StreamCall(ctx context.Context, in *Request, opts ...grpc.CallOption) (Hyper_StreamCallClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Hyper_ServiceDesc.Streams[1], "/Hyper/StreamCall", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &hyperStreamCallClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Hyper_StreamCallClient interface {
+	Recv() (*Response, error)
+	grpc.ClientStream
+}
+
+type hyperStreamCallClient struct {
+	grpc.ClientStream
+}
+
+func (x *hyperStreamCallClient) Recv() (*Response, error) {
+	m := new(Response)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // HyperServer is the server API for Hyper service.
 // All implementations must embed UnimplementedHyperServer
 // for forward compatibility
@@ -84,6 +118,8 @@ type HyperServer interface {
 	Call(context.Context, *Request) (*Response, error)
 	// client sends a response to the server and receives an acknowledgment
 	ServerCall(Hyper_ServerCallServer) error
+	// client sends a request to the server and receives the result in chunks
+	StreamCall(*Request, Hyper_StreamCallServer) error
 	mustEmbedUnimplementedHyperServer()
 }
 
@@ -97,6 +133,9 @@ func (UnimplementedHyperServer) Call(context.Context, *Request) (*Response, erro
 func (UnimplementedHyperServer) ServerCall(Hyper_ServerCallServer) error {
 	return status.Errorf(codes.Unimplemented, "method ServerCall not implemented")
 }
+func (UnimplementedHyperServer) StreamCall(*Request, Hyper_StreamCallServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamCall not implemented")
+}
 func (UnimplementedHyperServer) mustEmbedUnimplementedHyperServer() {}
 
 // UnsafeHyperServer may be embedded to opt out of forward compatibility for this service.
@@ -154,6 +193,27 @@ func (x *hyperServerCallServer) Recv() (*Response, error) {
 	return m, nil
 }
 
+func _Hyper_StreamCall_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Request)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(HyperServer).StreamCall(m, &hyperStreamCallServer{stream})
+}
+
+type Hyper_StreamCallServer interface {
+	Send(*Response) error
+	grpc.ServerStream
+}
+
+type hyperStreamCallServer struct {
+	grpc.ServerStream
+}
+
+func (x *hyperStreamCallServer) Send(m *Response) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // Hyper_ServiceDesc is the grpc.ServiceDesc for Hyper service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -173,6 +233,11 @@ var Hyper_ServiceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "StreamCall",
+			Handler:       _Hyper_StreamCall_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "protobuf/hyper.proto",
 }
@@ -165,7 +165,12 @@ func (c *PublicServer) requestConnection(context *jsonrpc.Context, params *Publi
 		"token":    randomStr,
 	})
 
-	if result, err := c.jsonrpcClient.Call(request); err != nil {
+	// we give up when the caller does
+	request.Deadline = context.Request.Deadline
+	ctx, cancel := context.Context()
+	defer cancel()
+
+	if result, err := c.jsonrpcClient.CallContext(ctx, request); err != nil {
 		hyper.Log.Errorf("RPC error when announcing connection request: %v", err)
 		return context.InternalError()
 	} else {
@@ -659,6 +664,10 @@ func (s *PublicServer) handleTlsConnection(conn net.Conn) {
 			"endpoint": s.settings.InternalEndpoint,
 		})
 
+		// the connection will be closed after the accept timeout anyway
+		deadline := time.Now().Add(time.Duration(s.settings.AcceptTimeout) * time.Second)
+		request.Deadline = &deadline
+
 		if result, err := s.jsonrpcClient.Call(request); err != nil {
 			hyper.Log.Errorf("RPC error when announcing incoming connection: %v", err)
 			close()
@@ -0,0 +1,555 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"regexp"
+	"sync"
+)
+
+const (
+	SubscriptionEntryType uint8 = 6
+)
+
+type PubSubSettings struct {
+	// without a datastore subscriptions are only kept in memory
+	Datastore *DatastoreSettings `json:"datastore"`
+}
+
+// internal methods that are permitted by the topic permissions instead of
+// the service permissions
+var pubSubMethods = map[string]bool{
+	"_subscribe":   true,
+	"_unsubscribe": true,
+	"_publish":     true,
+	"_event":       true,
+}
+
+// A subscription of an operator to a topic of another operator. Events are
+// delivered to the callback method of the subscribing operator.
+type Subscription struct {
+	Operator string `json:"operator"`
+	Owner    string `json:"owner"`
+	Topic    string `json:"topic"`
+	Callback string `json:"callback"`
+}
+
+var SubscribeRequestForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "topic",
+			Validators: []forms.Validator{
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "callback",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+				forms.MatchesRegex{
+					// only a method name, the operator is always the subscriber
+					Regexp: regexp.MustCompile(`^[^\.\(\)]*$`),
+				},
+			},
+		},
+	},
+}
+
+const (
+	// a subscription of another operator to one of our topics
+	SubscriberRecord = "subscriber"
+	// a subscription of a local service to a topic of another operator
+	SubscriptionRecord = "subscription"
+)
+
+// A change of a subscription, later records replace earlier ones
+type SubscriptionChange struct {
+	Kind         string        `json:"kind"`
+	Subscription *Subscription `json:"subscription"`
+	Active       bool          `json:"active"`
+}
+
+type SubscribeRequest struct {
+	Topic    string `json:"topic"`
+	Callback string `json:"callback"`
+}
+
+var PublishRequestForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "topic",
+			Validators: []forms.Validator{
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "event",
+			Validators: []forms.Validator{
+				forms.IsStringMap{},
+			},
+		},
+	},
+}
+
+type PublishRequest struct {
+	Topic string                 `json:"topic"`
+	Event map[string]interface{} `json:"event"`
+}
+
+func TopicFor(entry *DirectoryEntry, name string) *OperatorTopic {
+	for _, topic := range entry.Topics {
+		if topic.Name == name {
+			return topic
+		}
+	}
+	return nil
+}
+
+// Returns true if the caller has the given right ('subscribe' or 'publish')
+// for the given topic of the owner
+func HasTopicRight(caller, owner *DirectoryEntry, name, right string) bool {
+	topic := TopicFor(owner, name)
+	if topic == nil {
+		return false
+	}
+	callerGroups := map[string]bool{}
+	for _, group := range caller.Groups {
+		callerGroups[group] = true
+	}
+	for _, permission := range topic.Permissions {
+		if _, ok := callerGroups[permission.Group]; ok || permission.Group == "*" {
+			for _, permissionRight := range permission.Rights {
+				if permissionRight == right {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Returns true if the caller may call the pub/sub method of the callee for
+// the topic in the parameters. Events can only come from the owner of a topic
+// that the callee may subscribe to.
+func CanUseTopic(caller, callee *DirectoryEntry, method string, params map[string]interface{}) bool {
+	topic, _ := params["topic"].(string)
+	switch method {
+	case "_subscribe", "_unsubscribe":
+		return HasTopicRight(caller, callee, topic, "subscribe")
+	case "_publish":
+		return HasTopicRight(caller, callee, topic, "publish")
+	case "_event":
+		return HasTopicRight(callee, caller, topic, "subscribe")
+	}
+	return false
+}
+
+func subscriptionKey(owner, topic string) string {
+	return fmt.Sprintf("%s/%s", owner, topic)
+}
+
+func (b *BasicMessageBroker) handlePubSubRequest(address *Address, request *Request, clientInfo *ClientInfo, ownEntry *DirectoryEntry) (*Response, error) {
+
+	switch address.Method {
+	case "_subscribe", "_unsubscribe":
+
+		subscribeRequest := &SubscribeRequest{}
+		if params, err := SubscribeRequestForm.Validate(request.Params); err != nil {
+			return nil, err
+		} else if err := SubscribeRequestForm.Coerce(subscribeRequest, params); err != nil {
+			return nil, err
+		}
+
+		// the topic permissions of other operators are checked before
+		if clientInfo.Name == ownEntry.Name {
+			return PermissionDenied(&request.ID, "cannot subscribe to own topics", nil), nil
+		}
+
+		subscription := &Subscription{
+			Operator: clientInfo.Name,
+			Owner:    ownEntry.Name,
+			Topic:    subscribeRequest.Topic,
+			Callback: subscribeRequest.Callback,
+		}
+
+		subscribe := address.Method == "_subscribe"
+
+		if subscribe && subscription.Callback == "" {
+			return &Response{Error: &Error{Code: 400, Message: "callback missing"}, ID: &address.ID}, nil
+		}
+
+		b.subscriptionsMutex.Lock()
+		defer b.subscriptionsMutex.Unlock()
+
+		// subscribing again simply replaces the existing subscription
+		if err := b.changeSubscription(&SubscriptionChange{Kind: SubscriberRecord, Subscription: subscription, Active: subscribe}); err != nil {
+			return nil, err
+		}
+
+		return &Response{Result: map[string]interface{}{"topic": subscribeRequest.Topic, "subscribed": subscribe}, ID: &address.ID}, nil
+
+	case "_publish":
+
+		publishRequest := &PublishRequest{}
+		if params, err := PublishRequestForm.Validate(request.Params); err != nil {
+			return nil, err
+		} else if err := PublishRequestForm.Coerce(publishRequest, params); err != nil {
+			return nil, err
+		}
+
+		if TopicFor(ownEntry, publishRequest.Topic) == nil {
+			return &Response{Error: &Error{Code: 404, Message: "unknown topic"}, ID: &address.ID}, nil
+		}
+
+		// the owner may always publish to its own topics, the permissions of
+		// other operators are checked before
+		return b.publish(address, request, publishRequest, clientInfo, ownEntry)
+
+	case "_event":
+
+		publishRequest := &PublishRequest{}
+		if params, err := PublishRequestForm.Validate(request.Params); err != nil {
+			return nil, err
+		} else if err := PublishRequestForm.Coerce(publishRequest, params); err != nil {
+			return nil, err
+		}
+
+		b.subscriptionsMutex.Lock()
+		subscription, ok := b.subscriptions[subscriptionKey(clientInfo.Name, publishRequest.Topic)]
+		b.subscriptionsMutex.Unlock()
+
+		// we only accept events for topics that we have subscribed to, the
+		// topic permissions are checked before
+		if !ok {
+			return &Response{Error: &Error{Code: 404, Message: "no subscription found"}, ID: &address.ID}, nil
+		}
+
+		callbackAddress := &Address{
+			Operator: ownEntry.Name,
+			Method:   subscription.Callback,
+			ID:       address.ID,
+		}
+
+		callbackRequest := &Request{
+			Method: fmt.Sprintf("%s.%s", ownEntry.Name, subscription.Callback),
+			ID:     fmt.Sprintf("%s.%s(%s)", ownEntry.Name, subscription.Callback, address.ID),
+			Params: map[string]interface{}{
+				"topic":     publishRequest.Topic,
+				"publisher": clientInfo.Name,
+				"event":     publishRequest.Event,
+			},
+			Deadline: request.Deadline,
+		}
+
+		callbackRequest.SetContext(request.Context())
+
+		// the event goes directly to the service behind the callback
+		return b.deliverToChannels(callbackAddress, callbackRequest, retrySafe(ownEntry, subscription.Callback))
+	}
+
+	return nil, nil
+}
+
+// Delivers an event to all subscribers of the topic
+func (b *BasicMessageBroker) publish(address *Address, request *Request, publishRequest *PublishRequest, clientInfo *ClientInfo, ownEntry *DirectoryEntry) (*Response, error) {
+
+	b.subscriptionsMutex.Lock()
+	subscriptions := make([]*Subscription, 0, len(b.subscribers[publishRequest.Topic]))
+	for _, subscription := range b.subscribers[publishRequest.Topic] {
+		subscriptions = append(subscriptions, subscription)
+	}
+	b.subscriptionsMutex.Unlock()
+
+	permittedSubscriptions := make([]*Subscription, 0, len(subscriptions))
+
+	// permissions may have changed since the operators subscribed
+	for _, subscription := range subscriptions {
+		if entry, err := b.directory.EntryFor(subscription.Operator); err != nil || !HasTopicRight(entry, ownEntry, subscription.Topic, "subscribe") {
+			Log.Infof("Removing subscription of '%s' to topic '%s' as it is no longer permitted", subscription.Operator, subscription.Topic)
+			b.subscriptionsMutex.Lock()
+			if err := b.changeSubscription(&SubscriptionChange{Kind: SubscriberRecord, Subscription: subscription, Active: false}); err != nil {
+				Log.Errorf("Error removing subscription: %v", err)
+			}
+			b.subscriptionsMutex.Unlock()
+		} else {
+			permittedSubscriptions = append(permittedSubscriptions, subscription)
+		}
+	}
+
+	subscriptions = permittedSubscriptions
+
+	delivered := []interface{}{}
+	// subscribers that we couldn't reach, their events wait in the outbox
+	queued := []interface{}{}
+	errors := map[string]interface{}{}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	slots := make(chan bool, MaxMulticastConcurrency)
+
+	eventRequests := make([]*Request, len(subscriptions))
+
+	// we create all requests first, so that we don't return while events
+	// are being delivered
+	for i, subscription := range subscriptions {
+
+		id := make([]byte, 8)
+
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+
+		eventRequests[i] = &Request{
+			Method: fmt.Sprintf("%s._event", subscription.Operator),
+			ID:     fmt.Sprintf("%s._event(%s)", subscription.Operator, hex.EncodeToString(id)),
+			Params: map[string]interface{}{
+				"topic": publishRequest.Topic,
+				"event": publishRequest.Event,
+			},
+			Deadline: request.Deadline,
+		}
+
+		eventRequests[i].SetContext(request.Context())
+	}
+
+	for i, subscription := range subscriptions {
+
+		wg.Add(1)
+		slots <- true
+
+		go func(subscription *Subscription, eventRequest *Request) {
+
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			// events always originate from the owner of the topic
+			response, err := b.deliverAccepted(eventRequest, &ClientInfo{Name: ownEntry.Name})
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if err != nil {
+				errors[subscription.Operator] = err.Error()
+			} else if response != nil && response.Error != nil && response.Error.Code == 202 {
+				queued = append(queued, subscription.Operator)
+			} else if response != nil && response.Error != nil {
+				errors[subscription.Operator] = response.Error.Message
+			} else {
+				delivered = append(delivered, subscription.Operator)
+			}
+
+		}(subscription, eventRequests[i])
+	}
+
+	wg.Wait()
+
+	return &Response{
+		Result: map[string]interface{}{
+			"topic":     publishRequest.Topic,
+			"delivered": delivered,
+			"queued":    queued,
+			"errors":    errors,
+		},
+		ID: &address.ID,
+	}, nil
+}
+
+// Keeps track of subscriptions that local services have made at other
+// operators, so that we only accept events that we have asked for
+func (b *BasicMessageBroker) trackSubscription(address *Address, request *Request, clientInfo *ClientInfo) {
+
+	if address.Method != "_subscribe" && address.Method != "_unsubscribe" {
+		return
+	}
+
+	subscribeRequest := &SubscribeRequest{}
+	if params, err := SubscribeRequestForm.Validate(request.Params); err != nil {
+		return
+	} else if err := SubscribeRequestForm.Coerce(subscribeRequest, params); err != nil {
+		return
+	}
+
+	b.subscriptionsMutex.Lock()
+	defer b.subscriptionsMutex.Unlock()
+
+	if err := b.changeSubscription(&SubscriptionChange{
+		Kind: SubscriptionRecord,
+		Subscription: &Subscription{
+			Operator: clientInfo.Name,
+			Owner:    address.Operator,
+			Topic:    subscribeRequest.Topic,
+			Callback: subscribeRequest.Callback,
+		},
+		Active: address.Method == "_subscribe",
+	}); err != nil {
+		Log.Errorf("Error tracking subscription: %v", err)
+	}
+}
+
+// Persists subscriptions in the datastore and restores the ones that it
+// already contains
+func (b *BasicMessageBroker) SetSubscriptionStore(datastore Datastore) error {
+
+	if err := datastore.Init(); err != nil {
+		return fmt.Errorf("error initializing subscription datastore: %w", err)
+	}
+
+	dataEntries, err := datastore.Read()
+
+	if err != nil {
+		return fmt.Errorf("error reading subscriptions: %w", err)
+	}
+
+	b.subscriptionsMutex.Lock()
+	defer b.subscriptionsMutex.Unlock()
+
+	for _, dataEntry := range dataEntries {
+		if dataEntry.Type != SubscriptionEntryType {
+			continue
+		}
+		change := &SubscriptionChange{}
+		if err := json.Unmarshal(dataEntry.Data, change); err != nil {
+			return fmt.Errorf("error reading subscription: %w", err)
+		}
+		b.applySubscriptionChange(change)
+	}
+
+	b.subscriptionStore = datastore
+	b.subscriptionRecords = len(dataEntries)
+
+	return nil
+}
+
+// needs to be called with the subscriptions mutex held
+func (b *BasicMessageBroker) applySubscriptionChange(change *SubscriptionChange) {
+
+	subscription := change.Subscription
+
+	switch change.Kind {
+	case SubscriberRecord:
+		subscribers, ok := b.subscribers[subscription.Topic]
+		if !ok {
+			subscribers = make(map[string]*Subscription)
+			b.subscribers[subscription.Topic] = subscribers
+		}
+		if change.Active {
+			subscribers[subscription.Operator] = subscription
+		} else {
+			delete(subscribers, subscription.Operator)
+		}
+	case SubscriptionRecord:
+		key := subscriptionKey(subscription.Owner, subscription.Topic)
+		if change.Active {
+			b.subscriptions[key] = subscription
+		} else {
+			delete(b.subscriptions, key)
+		}
+	}
+}
+
+func subscriptionDataEntry(change *SubscriptionChange) (*DataEntry, error) {
+
+	data, err := json.Marshal(change)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataEntry{
+		Type: SubscriptionEntryType,
+		ID:   []byte(fmt.Sprintf("%s/%s", change.Subscription.Operator, subscriptionKey(change.Subscription.Owner, change.Subscription.Topic))),
+		Data: data,
+	}, nil
+}
+
+// applies and persists the change, needs to be called with the subscriptions mutex held
+func (b *BasicMessageBroker) changeSubscription(change *SubscriptionChange) error {
+
+	if b.subscriptionStore != nil {
+
+		dataEntry, err := subscriptionDataEntry(change)
+
+		if err != nil {
+			return err
+		}
+
+		if err := b.subscriptionStore.Write(dataEntry); err != nil {
+			return fmt.Errorf("error persisting subscription: %w", err)
+		}
+
+		b.subscriptionRecords++
+	}
+
+	b.applySubscriptionChange(change)
+	b.compactSubscriptions()
+
+	return nil
+}
+
+// replaces the records in the datastore with the active subscriptions once
+// there are enough outdated ones, needs to be called with the subscriptions mutex held
+func (b *BasicMessageBroker) compactSubscriptions() {
+
+	datastore, ok := b.subscriptionStore.(CompactableDatastore)
+
+	if !ok {
+		return
+	}
+
+	changes := make([]*SubscriptionChange, 0, len(b.subscriptions))
+
+	for _, subscribers := range b.subscribers {
+		for _, subscription := range subscribers {
+			changes = append(changes, &SubscriptionChange{Kind: SubscriberRecord, Subscription: subscription, Active: true})
+		}
+	}
+
+	for _, subscription := range b.subscriptions {
+		changes = append(changes, &SubscriptionChange{Kind: SubscriptionRecord, Subscription: subscription, Active: true})
+	}
+
+	if b.subscriptionRecords <= 2*len(changes)+CompactionSlack {
+		return
+	}
+
+	dataEntries := make([]*DataEntry, 0, len(changes))
+
+	for _, change := range changes {
+		if dataEntry, err := subscriptionDataEntry(change); err != nil {
+			Log.Errorf("Error serializing subscription: %v", err)
+			return
+		} else {
+			dataEntries = append(dataEntries, dataEntry)
+		}
+	}
+
+	if err := datastore.Compact(dataEntries); err != nil {
+		Log.Errorf("Error compacting subscription datastore: %v", err)
+		return
+	}
+
+	b.subscriptionRecords = len(dataEntries)
+}
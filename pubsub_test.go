@@ -0,0 +1,384 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper_test
+
+import (
+	"fmt"
+	"github.com/kiprotect/hyper"
+	th "github.com/kiprotect/hyper/testing"
+	"sync"
+	"testing"
+	"time"
+)
+
+// op-1 owns the 'news' topic, only members of the 'readers' group may
+// subscribe to it
+func makePubSubDirectory() *th.MemoryDirectory {
+	return th.MakeMemoryDirectory(
+		"op-1",
+		&hyper.DirectoryEntry{
+			Name: "op-1",
+			Topics: []*hyper.OperatorTopic{
+				{
+					Name: "news",
+					Permissions: []*hyper.Permission{
+						{Group: "readers", Rights: []string{"subscribe"}},
+					},
+				},
+			},
+		},
+		&hyper.DirectoryEntry{Name: "op-2", Groups: []string{"readers"}},
+		&hyper.DirectoryEntry{Name: "op-3", Groups: []string{"readers"}},
+		&hyper.DirectoryEntry{Name: "op-4"},
+	)
+}
+
+func makePubSubBroker(t *testing.T, directory hyper.Directory) (*hyper.BasicMessageBroker, *th.MemoryChannel) {
+	return th.MakeMemoryBroker(t, directory, func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+		return &hyper.Response{Result: map[string]interface{}{"received": true}, ID: &address.ID}, nil
+	}, "op-2", "op-3", "op-4")
+}
+
+func pubSubRequest(t *testing.T, broker hyper.MessageBroker, caller, method string, params map[string]interface{}) *hyper.Response {
+
+	request := &hyper.Request{
+		ID:     fmt.Sprintf("op-1.%s(%s-%s)", method, caller, params["topic"]),
+		Method: fmt.Sprintf("op-1.%s", method),
+		Params: params,
+	}
+
+	response, err := broker.DeliverRequest(request, &hyper.ClientInfo{Name: caller})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return response
+}
+
+func subscribe(t *testing.T, broker hyper.MessageBroker, caller string) *hyper.Response {
+	return pubSubRequest(t, broker, caller, "_subscribe", map[string]interface{}{"topic": "news", "callback": "onNews"})
+}
+
+// publishes an event as the owner of the topic and returns the operators
+// that received it
+func publish(t *testing.T, broker hyper.MessageBroker) []interface{} {
+
+	response := pubSubRequest(t, broker, "op-1", "_publish", map[string]interface{}{"topic": "news", "event": map[string]interface{}{"headline": "test"}})
+
+	if response.Error != nil {
+		t.Fatalf("unexpected error: %s", response.Error.Message)
+	}
+
+	if errors, _ := response.Result["errors"].(map[string]interface{}); len(errors) > 0 {
+		t.Fatalf("unexpected delivery errors: %v", errors)
+	}
+
+	delivered, _ := response.Result["delivered"].([]interface{})
+
+	return delivered
+}
+
+func TestSubscribePermissions(t *testing.T) {
+
+	broker, _ := makePubSubBroker(t, makePubSubDirectory())
+
+	// op-4 isn't a member of the readers group
+	if response := subscribe(t, broker, "op-4"); response.Error == nil || response.Error.Code != 403 {
+		t.Fatalf("expected the subscription to be denied")
+	}
+
+	// nobody may subscribe to an unknown topic
+	if response := pubSubRequest(t, broker, "op-2", "_subscribe", map[string]interface{}{"topic": "sports", "callback": "onSports"}); response.Error == nil || response.Error.Code != 403 {
+		t.Fatalf("expected the subscription to be denied")
+	}
+
+	// publishing requires the publish right
+	if response := pubSubRequest(t, broker, "op-2", "_publish", map[string]interface{}{"topic": "news", "event": map[string]interface{}{}}); response.Error == nil || response.Error.Code != 403 {
+		t.Fatalf("expected publishing to be denied")
+	}
+
+	if response := subscribe(t, broker, "op-2"); response.Error != nil {
+		t.Fatalf("unexpected error: %s", response.Error.Message)
+	}
+
+	if delivered := publish(t, broker); len(delivered) != 1 || delivered[0] != "op-2" {
+		t.Fatalf("expected the event to be delivered to op-2 only, got %v", delivered)
+	}
+}
+
+func TestPublishFanOut(t *testing.T) {
+
+	broker, channel := makePubSubBroker(t, makePubSubDirectory())
+
+	for _, caller := range []string{"op-2", "op-3"} {
+		if response := subscribe(t, broker, caller); response.Error != nil {
+			t.Fatalf("unexpected error: %s", response.Error.Message)
+		}
+	}
+
+	if delivered := publish(t, broker); len(delivered) != 2 {
+		t.Fatalf("expected the event to be delivered to both subscribers, got %v", delivered)
+	}
+
+	requests := channel.Requests()
+
+	if len(requests) != 2 {
+		t.Fatalf("expected two event requests, got %d", len(requests))
+	}
+
+	for _, request := range requests {
+		if request.Method != "op-2._event" && request.Method != "op-3._event" {
+			t.Fatalf("unexpected method: %s", request.Method)
+		}
+		if request.Params["topic"] != "news" {
+			t.Fatalf("expected the topic in the event")
+		}
+	}
+}
+
+func TestPublishConcurrency(t *testing.T) {
+
+	entries := []*hyper.DirectoryEntry{
+		{
+			Name: "op-1",
+			Topics: []*hyper.OperatorTopic{
+				{
+					Name:        "news",
+					Permissions: []*hyper.Permission{{Group: "readers", Rights: []string{"subscribe"}}},
+				},
+			},
+		},
+	}
+
+	operators := []string{}
+
+	// more subscribers than we deliver to at the same time
+	for i := 2; i < 2+2*hyper.MaxMulticastConcurrency; i++ {
+		name := fmt.Sprintf("op-%d", i)
+		entries = append(entries, &hyper.DirectoryEntry{Name: name, Groups: []string{"readers"}})
+		operators = append(operators, name)
+	}
+
+	var mutex sync.Mutex
+	var active, maxActive int
+
+	broker, _ := th.MakeMemoryBroker(t, th.MakeMemoryDirectory("op-1", entries...), func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+
+		mutex.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mutex.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mutex.Lock()
+		active--
+		mutex.Unlock()
+
+		return &hyper.Response{Result: map[string]interface{}{"received": true}, ID: &address.ID}, nil
+	}, operators...)
+
+	for _, operator := range operators {
+		if response := subscribe(t, broker, operator); response.Error != nil {
+			t.Fatalf("unexpected error: %s", response.Error.Message)
+		}
+	}
+
+	if delivered := publish(t, broker); len(delivered) != len(operators) {
+		t.Fatalf("expected the event to be delivered to all subscribers, got %v", delivered)
+	}
+
+	if maxActive > hyper.MaxMulticastConcurrency {
+		t.Fatalf("expected at most %d concurrent deliveries, got %d", hyper.MaxMulticastConcurrency, maxActive)
+	}
+}
+
+func TestPublishQueuesEvents(t *testing.T) {
+
+	// no channel can deliver events to op-3
+	broker, _ := th.MakeMemoryBroker(t, makePubSubDirectory(), func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+		return &hyper.Response{Result: map[string]interface{}{"received": true}, ID: &address.ID}, nil
+	}, "op-2")
+
+	enableOutbox(t, broker)
+
+	for _, operator := range []string{"op-2", "op-3"} {
+		if response := subscribe(t, broker, operator); response.Error != nil {
+			t.Fatalf("unexpected error: %s", response.Error.Message)
+		}
+	}
+
+	// queued events are reported separately instead of as delivered
+	if delivered := publish(t, broker); len(delivered) != 1 || delivered[0] != "op-2" {
+		t.Fatalf("expected the event to be delivered to op-2 only, got %v", delivered)
+	}
+
+	response := pubSubRequest(t, broker, "op-1", "_publish", map[string]interface{}{"topic": "news", "event": map[string]interface{}{"headline": "test"}})
+
+	if queued, _ := response.Result["queued"].([]interface{}); len(queued) != 1 || queued[0] != "op-3" {
+		t.Fatalf("expected the event to op-3 to be queued, got %v", response.Result)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+
+	broker, _ := makePubSubBroker(t, makePubSubDirectory())
+
+	if response := subscribe(t, broker, "op-2"); response.Error != nil {
+		t.Fatalf("unexpected error: %s", response.Error.Message)
+	}
+
+	if response := pubSubRequest(t, broker, "op-2", "_unsubscribe", map[string]interface{}{"topic": "news"}); response.Error != nil {
+		t.Fatalf("unexpected error: %s", response.Error.Message)
+	}
+
+	if delivered := publish(t, broker); len(delivered) != 0 {
+		t.Fatalf("expected no deliveries after unsubscribing, got %v", delivered)
+	}
+}
+
+func TestRevokedSubscription(t *testing.T) {
+
+	directory := makePubSubDirectory()
+	broker, _ := makePubSubBroker(t, directory)
+
+	if response := subscribe(t, broker, "op-2"); response.Error != nil {
+		t.Fatalf("unexpected error: %s", response.Error.Message)
+	}
+
+	entry, err := directory.EntryFor("op-2")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// op-2 leaves the readers group
+	entry.Groups = nil
+
+	if delivered := publish(t, broker); len(delivered) != 0 {
+		t.Fatalf("expected no deliveries after the right was revoked, got %v", delivered)
+	}
+
+	// the subscription is gone even if op-2 rejoins the group
+	entry.Groups = []string{"readers"}
+
+	if delivered := publish(t, broker); len(delivered) != 0 {
+		t.Fatalf("expected the subscription to be removed, got %v", delivered)
+	}
+}
+
+func TestPersistentSubscriptions(t *testing.T) {
+
+	directory := makePubSubDirectory()
+	datastore := &th.MemoryDatastore{}
+
+	broker, _ := makePubSubBroker(t, directory)
+
+	if err := broker.SetSubscriptionStore(datastore); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, caller := range []string{"op-2", "op-3"} {
+		if response := subscribe(t, broker, caller); response.Error != nil {
+			t.Fatalf("unexpected error: %s", response.Error.Message)
+		}
+	}
+
+	if response := pubSubRequest(t, broker, "op-3", "_unsubscribe", map[string]interface{}{"topic": "news"}); response.Error != nil {
+		t.Fatalf("unexpected error: %s", response.Error.Message)
+	}
+
+	// a restarted broker restores the subscriptions from the datastore
+	restartedBroker, _ := makePubSubBroker(t, directory)
+
+	if err := restartedBroker.SetSubscriptionStore(datastore); err != nil {
+		t.Fatal(err)
+	}
+
+	if delivered := publish(t, restartedBroker); len(delivered) != 1 || delivered[0] != "op-2" {
+		t.Fatalf("expected the event to be delivered to op-2 only, got %v", delivered)
+	}
+}
+
+// a datastore whose writes wait until the test releases them
+type slowDatastore struct {
+	th.MemoryDatastore
+	writing chan bool
+	release chan bool
+}
+
+func (d *slowDatastore) Write(entry *hyper.DataEntry) error {
+	d.writing <- true
+	<-d.release
+	return d.MemoryDatastore.Write(entry)
+}
+
+func TestSlowSubscriptionStore(t *testing.T) {
+
+	datastore := &slowDatastore{writing: make(chan bool, 1), release: make(chan bool)}
+
+	broker, _ := makePubSubBroker(t, makePubSubDirectory())
+
+	if err := broker.SetSubscriptionStore(datastore); err != nil {
+		t.Fatal(err)
+	}
+
+	deliver := func(request *hyper.Request, caller string, responses chan *hyper.Response) {
+		response, err := broker.DeliverRequest(request, &hyper.ClientInfo{Name: caller})
+		if err != nil {
+			response = &hyper.Response{Error: &hyper.Error{Code: 500, Message: err.Error()}}
+		}
+		responses <- response
+	}
+
+	subscribed := make(chan *hyper.Response, 1)
+
+	go deliver(&hyper.Request{
+		ID:     "op-1._subscribe(1)",
+		Method: "op-1._subscribe",
+		Params: map[string]interface{}{"topic": "news", "callback": "onNews"},
+	}, "op-2", subscribed)
+
+	select {
+	case <-datastore.writing:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the subscription to be written")
+	}
+
+	delivered := make(chan *hyper.Response, 1)
+
+	go deliver(&hyper.Request{ID: "op-3.add(1)", Method: "op-3.add", Params: map[string]interface{}{}}, "op-1", delivered)
+
+	// other requests don't wait for the datastore
+	select {
+	case response := <-delivered:
+		if response.Error != nil {
+			t.Fatalf("unexpected error: %s", response.Error.Message)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the request to be delivered while the subscription is written")
+	}
+
+	close(datastore.release)
+
+	if response := <-subscribed; response.Error != nil {
+		t.Fatalf("unexpected error: %s", response.Error.Message)
+	}
+}
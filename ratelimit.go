@@ -0,0 +1,230 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+import (
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"sync"
+	"time"
+)
+
+// Limits the number of calls per time window that a caller can make. Empty
+// operator, group and method fields match all callers and methods. Limits
+// for a group are shared by all members of the group.
+type RateLimit struct {
+	Operator string `json:"operator"`
+	Group    string `json:"group"`
+	Method   string `json:"method"`
+	Type     string `json:"type"`
+	Limit    int64  `json:"limit"`
+}
+
+var RateLimitForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "operator",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "group",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "method",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "type",
+			Validators: []forms.Validator{
+				forms.IsIn{Choices: []interface{}{"second", "minute", "hour", "day"}},
+			},
+		},
+		{
+			Name: "limit",
+			Validators: []forms.Validator{
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+	},
+}
+
+var RateLimitsForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "rate_limits",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: []interface{}{}},
+				forms.IsList{
+					Validators: []forms.Validator{
+						forms.IsStringMap{
+							Form: &RateLimitForm,
+						},
+					},
+				},
+			},
+		},
+	},
+}
+
+type RateLimits struct {
+	RateLimits []*RateLimit `json:"rate_limits"`
+}
+
+func (r *RateLimit) window() time.Duration {
+	switch r.Type {
+	case "second":
+		return time.Second
+	case "minute":
+		return time.Minute
+	case "hour":
+		return time.Hour
+	default:
+		return 24 * time.Hour
+	}
+}
+
+func (r *RateLimit) key(caller string) string {
+	// all members of a group count against the same window
+	if r.Group != "" {
+		caller = ""
+	}
+	return fmt.Sprintf("%s|%s|%s|%s|%d|%s", r.Operator, r.Group, r.Method, r.Type, r.Limit, caller)
+}
+
+func (r *RateLimit) Matches(caller *DirectoryEntry, method string) bool {
+	if r.Operator != "" && r.Operator != caller.Name {
+		return false
+	}
+	if r.Method != "" && r.Method != method {
+		return false
+	}
+	if r.Group != "" {
+		for _, group := range caller.Groups {
+			if group == r.Group {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Returns the rate limits that the operator has published for the given
+// caller in its directory entry
+func DirectoryRateLimits(entry *DirectoryEntry, caller string) ([]*RateLimit, error) {
+	rateLimits := []*RateLimit{}
+	for _, settings := range entry.Settings {
+		if settings.Operator != "" && settings.Operator != caller {
+			continue
+		}
+		if _, ok := settings.Settings["rate_limits"]; !ok {
+			continue
+		}
+		limits := &RateLimits{}
+		if params, err := RateLimitsForm.Validate(settings.Settings); err != nil {
+			return nil, err
+		} else if err := RateLimitsForm.Coerce(limits, params); err != nil {
+			return nil, err
+		}
+		rateLimits = append(rateLimits, limits.RateLimits...)
+	}
+	return rateLimits, nil
+}
+
+type rateWindow struct {
+	end   time.Time
+	count int64
+}
+
+// Counts calls in fixed time windows
+type RateLimiter struct {
+	windows     map[string]*rateWindow
+	lastCleanup time.Time
+	mutex       sync.Mutex
+}
+
+func MakeRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		windows:     make(map[string]*rateWindow),
+		lastCleanup: time.Now(),
+	}
+}
+
+// Returns the first limit that the call would exceed, or counts the call
+// against all matching limits and returns nil
+func (r *RateLimiter) Allow(rateLimits []*RateLimit, caller *DirectoryEntry, method string) *RateLimit {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(r.lastCleanup) > time.Minute {
+		for key, window := range r.windows {
+			if !now.Before(window.end) {
+				delete(r.windows, key)
+			}
+		}
+		r.lastCleanup = now
+	}
+
+	// identical limits (e.g. a local one that we also publish in our
+	// directory entry) share a window, which we count only once
+	windows := make(map[string]*rateWindow, len(rateLimits))
+
+	for _, rateLimit := range rateLimits {
+
+		if !rateLimit.Matches(caller, method) {
+			continue
+		}
+
+		key := rateLimit.key(caller.Name)
+
+		if _, ok := windows[key]; ok {
+			continue
+		}
+
+		window, ok := r.windows[key]
+
+		if !ok || !now.Before(window.end) {
+			window = &rateWindow{end: now.Truncate(rateLimit.window()).Add(rateLimit.window())}
+			r.windows[key] = window
+		}
+
+		if window.count >= rateLimit.Limit {
+			return rateLimit
+		}
+
+		windows[key] = window
+	}
+
+	for _, window := range windows {
+		window.count++
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper_test
+
+import (
+	"github.com/kiprotect/hyper"
+	th "github.com/kiprotect/hyper/testing"
+	"testing"
+)
+
+func TestRateLimiter(t *testing.T) {
+
+	rateLimits := []*hyper.RateLimit{
+		{Method: "add", Type: "hour", Limit: 2},
+		{Group: "partners", Type: "hour", Limit: 3},
+	}
+
+	limiter := hyper.MakeRateLimiter()
+
+	caller := &hyper.DirectoryEntry{Name: "op-1", Groups: []string{"partners"}}
+	otherCaller := &hyper.DirectoryEntry{Name: "op-2"}
+	groupMember := &hyper.DirectoryEntry{Name: "op-3", Groups: []string{"partners"}}
+
+	for i := 0; i < 2; i++ {
+		if rateLimit := limiter.Allow(rateLimits, caller, "add"); rateLimit != nil {
+			t.Fatalf("expected call %d to be allowed", i)
+		}
+	}
+
+	if rateLimit := limiter.Allow(rateLimits, caller, "add"); rateLimit != rateLimits[0] {
+		t.Fatalf("expected the method limit to be exceeded")
+	}
+
+	// the rejected call was not counted against the group limit
+	if rateLimit := limiter.Allow(rateLimits, caller, "lookup"); rateLimit != nil {
+		t.Fatalf("expected the call to be allowed")
+	}
+
+	if rateLimit := limiter.Allow(rateLimits, caller, "lookup"); rateLimit != rateLimits[1] {
+		t.Fatalf("expected the group limit to be exceeded")
+	}
+
+	// limits are counted per caller
+	if rateLimit := limiter.Allow(rateLimits, otherCaller, "add"); rateLimit != nil {
+		t.Fatalf("expected the call to be allowed")
+	}
+
+	// except for group limits, which all members share
+	if rateLimit := limiter.Allow(rateLimits, groupMember, "lookup"); rateLimit != rateLimits[1] {
+		t.Fatalf("expected the group limit to be exceeded for all members")
+	}
+}
+
+func TestDuplicateRateLimits(t *testing.T) {
+
+	// the same limit configured locally and published in the directory
+	rateLimits := []*hyper.RateLimit{
+		{Method: "add", Type: "hour", Limit: 2},
+		{Method: "add", Type: "hour", Limit: 2},
+	}
+
+	limiter := hyper.MakeRateLimiter()
+	caller := &hyper.DirectoryEntry{Name: "op-1"}
+
+	// every call counts once, not once per copy of the limit
+	for i := 0; i < 2; i++ {
+		if rateLimit := limiter.Allow(rateLimits, caller, "add"); rateLimit != nil {
+			t.Fatalf("expected call %d to be allowed", i)
+		}
+	}
+
+	if rateLimit := limiter.Allow(rateLimits, caller, "add"); rateLimit == nil {
+		t.Fatalf("expected the limit to be exceeded")
+	}
+}
+
+func TestDirectoryRateLimits(t *testing.T) {
+
+	entry := &hyper.DirectoryEntry{
+		Settings: []*hyper.OperatorSettings{
+			{Operator: "op-1", Settings: map[string]interface{}{"rate_limits": []interface{}{map[string]interface{}{"method": "add", "type": "minute", "limit": 100}}}},
+			{Operator: "op-2", Settings: map[string]interface{}{"rate_limits": []interface{}{map[string]interface{}{"type": "minute", "limit": 10}}}},
+		},
+	}
+
+	if rateLimits, err := hyper.DirectoryRateLimits(entry, "op-1"); err != nil {
+		t.Fatal(err)
+	} else if len(rateLimits) != 1 || rateLimits[0].Method != "add" || rateLimits[0].Limit != 100 {
+		t.Fatalf("unexpected rate limits")
+	}
+}
+
+func TestInvalidDirectoryRateLimits(t *testing.T) {
+
+	directory := th.MakeMemoryDirectory(
+		"op-1",
+		&hyper.DirectoryEntry{
+			Name: "op-1",
+			Services: []*hyper.OperatorService{
+				{
+					Name:        "calc",
+					Permissions: []*hyper.Permission{{Group: "*", Rights: []string{"call"}}},
+					Methods:     []*hyper.ServiceMethod{{Name: "add"}},
+				},
+			},
+			Settings: []*hyper.OperatorSettings{
+				// 'week' isn't a valid type
+				{Settings: map[string]interface{}{"rate_limits": []interface{}{map[string]interface{}{"type": "week", "limit": 100}}}},
+			},
+		},
+		&hyper.DirectoryEntry{Name: "op-2"},
+	)
+
+	broker, _ := th.MakeMemoryBroker(t, directory, func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+		return &hyper.Response{Result: map[string]interface{}{"sum": 3}, ID: &address.ID}, nil
+	}, "op-1")
+
+	broker.SetRateLimits([]*hyper.RateLimit{{Type: "hour", Limit: 1}})
+
+	call := func(id string) *hyper.Response {
+		response, err := broker.DeliverRequest(&hyper.Request{ID: "op-1.add(" + id + ")", Method: "op-1.add", Params: map[string]interface{}{}}, &hyper.ClientInfo{Name: "op-2"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	// invalid limits in the directory don't block calls...
+	if response := call("1"); response.Error != nil {
+		t.Fatalf("unexpected error: %s", response.Error.Message)
+	}
+
+	// ...and the local limits still apply
+	if response := call("2"); response.Error == nil || response.Error.Code != 429 {
+		t.Fatalf("expected the local rate limit to be exceeded")
+	}
+}
@@ -16,6 +16,10 @@
 
 package hyper
 
+import (
+	"github.com/kiprotect/hyper/tls"
+)
+
 type DatastoreSettings struct {
 	Type     string      `json:"type"`
 	Settings interface{} `json:"settings"`
@@ -24,10 +28,16 @@ type DatastoreSettings struct {
 type ChannelSettings struct {
 	Name     string      `json:"name"`
 	Type     string      `json:"type"`
+	Priority int64       `json:"priority"`
 	Services []string    `json:"services"`
 	Settings interface{} `json:"settings"`
 }
 
+type InterceptorSettings struct {
+	Type     string      `json:"type"`
+	Settings interface{} `json:"settings"`
+}
+
 type DirectorySettings struct {
 	Type     string      `json:"type"`
 	Settings interface{} `json:"settings"`
@@ -41,17 +51,49 @@ type SigningSettings struct {
 	KeyFile                        string   `json:"key_file"`
 }
 
+// used if there are no shutdown settings
+const DefaultShutdownGracePeriod = 30
+
+type ShutdownSettings struct {
+	// seconds to wait for requests in flight before closing the channels
+	GracePeriod int64 `json:"grace_period"`
+}
+
+// used if the admin settings don't specify a bind address
+const DefaultAdminBindAddress = "localhost:5559"
+
+type AdminSettings struct {
+	BindAddress string           `json:"bind_address"`
+	TLS         *tls.TLSSettings `json:"tls"`
+	// file with the bearer token that clients of the admin API need to send
+	TokenFile string `json:"token_file"`
+}
+
 type MetricsSettings struct {
 	BindAddress string `json:"bind_address"`
 }
 
 type Settings struct {
-	Signing     *SigningSettings   `json:"signing"`
-	Definitions *Definitions       `json:"definitions"`
-	Channels    []*ChannelSettings `json:"channels"`
-	Directory   *DirectorySettings `json:"directory"`
-	Metrics     *MetricsSettings   `json:"metrics"`
-	Name        string             `json:"name"`
+	Signing        *SigningSettings        `json:"signing"`
+	Definitions    *Definitions            `json:"definitions"`
+	Channels       []*ChannelSettings      `json:"channels"`
+	Directory      *DirectorySettings      `json:"directory"`
+	Metrics        *MetricsSettings        `json:"metrics"`
+	Outbox         *OutboxSettings         `json:"outbox"`
+	Interceptors   []*InterceptorSettings  `json:"interceptors"`
+	Idempotency    *IdempotencySettings    `json:"idempotency"`
+	RateLimits     []*RateLimit            `json:"rate_limits"`
+	Audit          *AuditSettings          `json:"audit"`
+	Tracing        *TracingSettings        `json:"tracing"`
+	Encryption     *EncryptionSettings     `json:"encryption"`
+	Signatures     *SignatureSettings      `json:"signatures"`
+	Shutdown       *ShutdownSettings       `json:"shutdown"`
+	Admin          *AdminSettings          `json:"admin"`
+	CircuitBreaker *CircuitBreakerSettings `json:"circuit_breaker"`
+	Retries        *RetrySettings          `json:"retries"`
+	Cache          *ResponseCacheSettings  `json:"cache"`
+	PubSub         *PubSubSettings         `json:"pubsub"`
+	Name           string                  `json:"name"`
 }
 
 type SettingsValidator func(settings map[string]interface{}) (interface{}, error)
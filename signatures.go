@@ -0,0 +1,60 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+type SignatureSettings struct {
+	// if set, we reject unsigned requests and results from other operators
+	Required bool `json:"required"`
+}
+
+// The data that the sender of a request signs. It refers to the parameters
+// by their digest, so the audit log can keep the signature as proof without
+// keeping the parameters.
+type RequestSignatureData struct {
+	ID           string `json:"id"`
+	Method       string `json:"method"`
+	ParamsDigest string `json:"params_digest"`
+}
+
+// The data that the recipient of a request signs for its response, which
+// includes the digest of the request parameters it answers
+type ResponseSignatureData struct {
+	ID           string `json:"id"`
+	ParamsDigest string `json:"params_digest"`
+	ResultDigest string `json:"result_digest"`
+	ErrorCode    int    `json:"error_code"`
+	ErrorMessage string `json:"error_message"`
+	// the position of the chunk in a streamed response
+	Chunk int `json:"chunk"`
+}
+
+// Signs the requests and responses that we exchange with other operators,
+// so that every operator can later prove what the other one asked for or
+// answered
+type MessageSigner interface {
+	// Signs a request to the recipient and returns a function that
+	// verifies the response
+	SignRequest(request *Request, recipient *DirectoryEntry) (func(*Response) error, error)
+	// Verifies the signature of a request from the sender and returns a
+	// function that signs the response
+	VerifyRequest(request *Request, sender *DirectoryEntry) (func(*Response) error, error)
+}
+
+// Enables signing of requests and responses exchanged with other operators
+func (b *BasicMessageBroker) SetMessageSigner(signer MessageSigner) {
+	b.signer = signer
+}
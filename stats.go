@@ -0,0 +1,71 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+type BrokerStats struct {
+	Channels int `json:"channels"`
+	// requests that the broker accepted and is still delivering
+	RequestsInFlight int `json:"requests_in_flight"`
+	// requests that can be cancelled by the client via '_cancel'
+	RequestsInTransit int  `json:"requests_in_transit"`
+	Subscribers       int  `json:"subscribers"`
+	Subscriptions     int  `json:"subscriptions"`
+	OutboxQueued      int  `json:"outbox_queued"`
+	Draining          bool `json:"draining"`
+	// operators that we currently don't deliver requests to
+	OpenCircuits []string `json:"open_circuits"`
+	// only set if the response cache is enabled
+	Cache *CacheStats `json:"cache,omitempty"`
+}
+
+// Returns a snapshot of the state of the broker
+func (b *BasicMessageBroker) Stats() *BrokerStats {
+
+	stats := &BrokerStats{
+		OpenCircuits: []string{},
+	}
+
+	stats.RequestsInFlight, stats.Draining = b.inFlight.status()
+
+	if b.outbox != nil {
+		stats.OutboxQueued = b.outbox.Queued()
+	}
+
+	if b.breaker != nil {
+		stats.OpenCircuits = b.breaker.OpenCircuits()
+	}
+
+	if b.cache != nil {
+		stats.Cache = b.cache.Stats()
+	}
+
+	b.mutex.Lock()
+	stats.Channels = len(b.channels)
+	stats.RequestsInTransit = len(b.requestsInTransit)
+	b.mutex.Unlock()
+
+	b.subscriptionsMutex.Lock()
+	defer b.subscriptionsMutex.Unlock()
+
+	stats.Subscriptions = len(b.subscriptions)
+
+	for _, subscribers := range b.subscribers {
+		stats.Subscribers += len(subscribers)
+	}
+
+	return stats
+}
@@ -0,0 +1,113 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+import (
+	"io"
+	"sync"
+)
+
+// A stream of result chunks that a response delivers instead of a single
+// result. Streams are pull-based, so a slow consumer slows down the producer
+// instead of making the nodes in between buffer the chunks.
+type ResponseStream interface {
+	// Returns the next chunk, or io.EOF if there are no more chunks
+	Next() (*Response, error)
+	// Releases the stream, the consumer needs to call this in any case
+	Close() error
+}
+
+// calls a function when the stream ends or is closed, whichever comes first
+type finalizedStream struct {
+	ResponseStream
+	once     sync.Once
+	finalize func(err error)
+}
+
+func (f *finalizedStream) Next() (*Response, error) {
+	chunk, err := f.ResponseStream.Next()
+	if err != nil {
+		f.once.Do(func() {
+			if err == io.EOF {
+				f.finalize(nil)
+			} else {
+				f.finalize(err)
+			}
+		})
+	}
+	return chunk, err
+}
+
+func (f *finalizedStream) Close() error {
+	err := f.ResponseStream.Close()
+	f.once.Do(func() { f.finalize(nil) })
+	return err
+}
+
+// Returns a stream that calls the given function once the stream is done
+func FinalizeStream(stream ResponseStream, finalize func(err error)) ResponseStream {
+	return &finalizedStream{
+		ResponseStream: stream,
+		finalize:       finalize,
+	}
+}
+
+// Returns a stream with the given response as its only chunk
+func SingleResponseStream(response *Response) ResponseStream {
+	return &sliceStream{chunks: []*Response{response}}
+}
+
+type sliceStream struct {
+	chunks []*Response
+}
+
+func (s *sliceStream) Next() (*Response, error) {
+	if len(s.chunks) == 0 {
+		return nil, io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return chunk, nil
+}
+
+func (s *sliceStream) Close() error {
+	return nil
+}
+
+type transformedStream struct {
+	ResponseStream
+	transform func(*Response) error
+}
+
+func (t *transformedStream) Next() (*Response, error) {
+	chunk, err := t.ResponseStream.Next()
+	if err != nil {
+		return nil, err
+	}
+	if err := t.transform(chunk); err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+// Returns a stream that applies the given function to every chunk
+func TransformStream(stream ResponseStream, transform func(*Response) error) ResponseStream {
+	return &transformedStream{
+		ResponseStream: stream,
+		transform:      transform,
+	}
+}
@@ -0,0 +1,130 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper_test
+
+import (
+	"context"
+	"github.com/kiprotect/hyper"
+	th "github.com/kiprotect/hyper/testing"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBrokerRelaysStreams(t *testing.T) {
+
+	stream := th.MakeMemoryStream(context.Background(), 3)
+
+	broker, _ := th.MakeMemoryBroker(t, th.MakeMemoryDirectory("op-1", th.Entries("op-1", "op-2")...), func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+		if !request.Stream {
+			t.Errorf("expected a stream request")
+		}
+		return &hyper.Response{ID: &address.ID, Stream: stream}, nil
+	}, "op-2")
+
+	response, err := broker.DeliverRequest(&hyper.Request{
+		ID:     "op-2.list(1)",
+		Method: "op-2.list",
+		Params: map[string]interface{}{},
+		Stream: true,
+	}, &hyper.ClientInfo{Name: "op-1"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Stream == nil {
+		t.Fatalf("expected a stream")
+	}
+
+	// the broker doesn't read ahead of the consumer
+	if produced := stream.Produced(); produced != 0 {
+		t.Fatalf("expected no chunks before the consumer asks for them, got %d", produced)
+	}
+
+	// the request stays in flight until the stream is done
+	if stats := broker.Stats(); stats.RequestsInFlight != 1 {
+		t.Fatalf("expected the request to be in flight")
+	}
+
+	for i := 1; i <= 3; i++ {
+		if chunk, err := response.Stream.Next(); err != nil {
+			t.Fatal(err)
+		} else if chunk.Result["chunk"] != float64(i) {
+			t.Fatalf("expected chunk %d, got %v", i, chunk.Result["chunk"])
+		} else if produced := stream.Produced(); produced != i {
+			t.Fatalf("expected %d produced chunks, got %d", i, produced)
+		}
+	}
+
+	if _, err := response.Stream.Next(); err != io.EOF {
+		t.Fatalf("expected the end of the stream, got %v", err)
+	}
+
+	if err := response.Stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-stream.Closed():
+	case <-time.After(time.Second):
+		t.Fatalf("expected the stream of the channel to be closed")
+	}
+
+	if stats := broker.Stats(); stats.RequestsInFlight != 0 {
+		t.Fatalf("expected no requests in flight")
+	}
+}
+
+func TestBrokerClosesAbandonedStreams(t *testing.T) {
+
+	stream := th.MakeMemoryStream(context.Background(), 3)
+
+	broker, _ := th.MakeMemoryBroker(t, th.MakeMemoryDirectory("op-1", th.Entries("op-1", "op-2")...), func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error) {
+		// the stream arrives after the caller gave up
+		<-request.Context().Done()
+		return &hyper.Response{ID: &address.ID, Stream: stream}, nil
+	}, "op-2")
+
+	deadline := time.Now().Add(50 * time.Millisecond)
+
+	response, err := broker.DeliverRequest(&hyper.Request{
+		ID:       "op-2.list(1)",
+		Method:   "op-2.list",
+		Params:   map[string]interface{}{},
+		Stream:   true,
+		Deadline: &deadline,
+	}, &hyper.ClientInfo{Name: "op-1"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Error == nil || response.Error.Code != 504 {
+		t.Fatalf("expected a deadline error")
+	}
+
+	select {
+	case <-stream.Closed():
+	case <-time.After(time.Second):
+		t.Fatalf("expected the abandoned stream to be closed")
+	}
+
+	if produced := stream.Produced(); produced != 0 {
+		t.Fatalf("expected no chunks to be read from the abandoned stream")
+	}
+}
@@ -17,9 +17,13 @@
 package hyper
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"regexp"
+	"strings"
+	"time"
 )
 
 // this variable gets updated using the build process
@@ -28,16 +32,58 @@ var Version = "development"
 var MethodNameRegexp = regexp.MustCompile(`(?i)^(.*)\.(.*)$`)
 var IDAddressRegexp = regexp.MustCompile(`(?i)^(.*)\.([^\(\.]+)\(([^\)]+)\)$`)
 
+// addresses starting with this prefix target all members of a group
+const GroupPrefix = "@"
+
 type Address struct {
 	Operator string `json:"operator"`
 	Method   string `json:"method"`
 	ID       string `json:"id"`
+	// set instead of the operator for group addresses
+	Group string `json:"group,omitempty"`
 }
 
 type Request struct {
-	Method string                 `json:"method"`
-	Params map[string]interface{} `json:"params"`
-	ID     string                 `json:"id"`
+	Method   string                 `json:"method"`
+	Params   map[string]interface{} `json:"params"`
+	ID       string                 `json:"id"`
+	Deadline *time.Time             `json:"deadline,omitempty"`
+	// retries of a request carry the same idempotency key
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// the trace context of the step that sent the request
+	Trace *TraceContext `json:"-"`
+	// the caller accepts a streamed response
+	Stream bool `json:"stream,omitempty"`
+	// the sending operator's signature of the request
+	Signature *Signature `json:"signature,omitempty"`
+	ctx       context.Context
+}
+
+// Returns the context of the request, which is done when the caller is
+// no longer interested in the response
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+func (r *Request) SetContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+// Returns true if the deadline of the request has passed
+func (r *Request) Expired() bool {
+	return r.Deadline != nil && !time.Now().Before(*r.Deadline)
+}
+
+// Returns a context that is done when the deadline of the request passes
+// or when the context of the request is done
+func (r *Request) DeadlineContext() (context.Context, context.CancelFunc) {
+	if r.Deadline == nil {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithDeadline(r.Context(), *r.Deadline)
 }
 
 type ClientInfo struct {
@@ -69,6 +115,12 @@ func (c *ClientInfo) AsStruct() (map[string]interface{}, error) {
 func GetAddress(id string) (*Address, error) {
 	if groups := IDAddressRegexp.FindStringSubmatch(id); groups == nil {
 		return nil, fmt.Errorf("invalid ID format")
+	} else if strings.HasPrefix(groups[1], GroupPrefix) {
+		return &Address{
+			Group:  groups[1][len(GroupPrefix):],
+			Method: groups[2],
+			ID:     groups[3],
+		}, nil
 	} else {
 		return &Address{
 			Operator: groups[1],
@@ -82,6 +134,10 @@ type Response struct {
 	Result map[string]interface{} `json:"result,omitempty"`
 	Error  *Error                 `json:"error,omitempty"`
 	ID     *string                `json:"id"`
+	// if set, the result is delivered in chunks by the stream
+	Stream ResponseStream `json:"-"`
+	// the answering operator's signature of the response
+	Signature *Signature `json:"signature,omitempty"`
 }
 
 type Error struct {
@@ -111,3 +167,93 @@ func PermissionDenied(id *string, message string, data map[string]interface{}) *
 		},
 	}
 }
+
+func InvalidParams(id *string, errors map[string]interface{}) *Response {
+	return &Response{
+		ID: id,
+		Error: &Error{
+			Code:    -32602,
+			Message: "invalid params",
+			Data:    errors,
+		},
+	}
+}
+
+func RateLimitExceeded(id *string, message string, data map[string]interface{}) *Response {
+	return &Response{
+		ID: id,
+		Error: &Error{
+			Code:    429,
+			Message: message,
+			Data:    data,
+		},
+	}
+}
+
+func UnprocessableRequest(id *string, message string, data map[string]interface{}) *Response {
+	return &Response{
+		ID: id,
+		Error: &Error{
+			Code:    422,
+			Message: message,
+			Data:    data,
+		},
+	}
+}
+
+func DeadlineExceeded(id *string, message string, data map[string]interface{}) *Response {
+	return &Response{
+		ID: id,
+		Error: &Error{
+			Code:    504,
+			Message: message,
+			Data:    data,
+		},
+	}
+}
+
+func ServiceUnavailable(id *string, message string, data map[string]interface{}) *Response {
+	return &Response{
+		ID: id,
+		Error: &Error{
+			Code:    503,
+			Message: message,
+			Data:    data,
+		},
+	}
+}
+
+// Tells the caller that the request couldn't be delivered yet and waits in
+// the outbox, the data holds the status of the outbox entry
+func RequestQueued(id *string, data map[string]interface{}) *Response {
+	return &Response{
+		ID: id,
+		Error: &Error{
+			Code:    202,
+			Message: "request queued",
+			Data:    data,
+		},
+	}
+}
+
+func RequestCancelled(id *string, message string, data map[string]interface{}) *Response {
+	return &Response{
+		ID: id,
+		Error: &Error{
+			Code:    499,
+			Message: message,
+			Data:    data,
+		},
+	}
+}
+
+// Returns an error response if the given error stems from a deadline or a
+// cancellation, and nil otherwise
+func ContextError(id *string, err error) *Response {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return DeadlineExceeded(id, "deadline exceeded", nil)
+	} else if errors.Is(err, context.Canceled) {
+		return RequestCancelled(id, "request cancelled", nil)
+	}
+	return nil
+}
@@ -42,3 +42,20 @@ func TestAddressRegexp(t *testing.T) {
 		t.Fatal("invalid ID")
 	}
 }
+
+func TestGroupAddress(t *testing.T) {
+
+	if address, err := GetAddress("@health-departments.refresh(1)"); err != nil {
+		t.Fatal(err)
+	} else if address.Group != "health-departments" || address.Operator != "" {
+		t.Fatalf("expected a group address, got %v", address)
+	} else if address.Method != "refresh" || address.ID != "1" {
+		t.Fatalf("invalid method or ID: %v", address)
+	}
+
+	if address, err := GetAddress("hd-1.refresh(1)"); err != nil {
+		t.Fatal(err)
+	} else if address.Group != "" || address.Operator != "hd-1" {
+		t.Fatalf("expected an operator address, got %v", address)
+	}
+}
@@ -0,0 +1,52 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package testing
+
+import (
+	"github.com/kiprotect/hyper"
+	gotesting "testing"
+)
+
+// Makes entries without services for the given operators
+func Entries(names ...string) []*hyper.DirectoryEntry {
+	entries := make([]*hyper.DirectoryEntry, len(names))
+	for i, name := range names {
+		entries[i] = &hyper.DirectoryEntry{Name: name}
+	}
+	return entries
+}
+
+// Makes a broker for the given directory with a memory channel that delivers
+// the requests for the given operators to the handler
+func MakeMemoryBroker(t gotesting.TB, directory hyper.Directory, handler ChannelHandler, operators ...string) (*hyper.BasicMessageBroker, *MemoryChannel) {
+
+	t.Helper()
+
+	broker, err := hyper.MakeBasicMessageBroker(directory)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	channel := MakeMemoryChannel(handler, operators...)
+
+	if err := broker.AddChannel(channel); err != nil {
+		t.Fatal(err)
+	}
+
+	return broker, channel
+}
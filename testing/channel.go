@@ -0,0 +1,86 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package testing
+
+import (
+	"github.com/kiprotect/hyper"
+	"sync"
+)
+
+// Answers the requests that a channel delivers
+type ChannelHandler func(address *hyper.Address, request *hyper.Request) (*hyper.Response, error)
+
+// A channel that delivers requests to the given operators by calling a
+// handler and records the delivered requests
+type MemoryChannel struct {
+	hyper.BaseChannel
+	operators map[string]bool
+	handler   ChannelHandler
+	requests  []*hyper.Request
+	mutex     sync.Mutex
+}
+
+func MakeMemoryChannel(handler ChannelHandler, operators ...string) *MemoryChannel {
+	channel := &MemoryChannel{
+		operators: make(map[string]bool),
+		handler:   handler,
+	}
+	for _, operator := range operators {
+		channel.operators[operator] = true
+	}
+	return channel
+}
+
+// Returns the requests that the channel delivered so far
+func (c *MemoryChannel) Requests() []*hyper.Request {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	requests := make([]*hyper.Request, len(c.requests))
+	copy(requests, c.requests)
+	return requests
+}
+
+func (c *MemoryChannel) Type() string {
+	return "memory"
+}
+
+func (c *MemoryChannel) CanDeliverTo(address *hyper.Address) bool {
+	return c.operators[address.Operator]
+}
+
+func (c *MemoryChannel) DeliverRequest(request *hyper.Request) (*hyper.Response, error) {
+
+	address, err := hyper.GetAddress(request.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	c.requests = append(c.requests, request)
+	c.mutex.Unlock()
+
+	return c.handler(address, request)
+}
+
+func (c *MemoryChannel) Open() error {
+	return nil
+}
+
+func (c *MemoryChannel) Close() error {
+	return nil
+}
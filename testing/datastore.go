@@ -0,0 +1,56 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package testing
+
+import (
+	"github.com/kiprotect/hyper"
+	"sync"
+)
+
+// A datastore that keeps its entries in memory, every read returns all of
+// them so that tests can load new instances from the same datastore
+type MemoryDatastore struct {
+	entries []*hyper.DataEntry
+	mutex   sync.Mutex
+}
+
+func (m *MemoryDatastore) Write(entry *hyper.DataEntry) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func (m *MemoryDatastore) Read() ([]*hyper.DataEntry, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	entries := make([]*hyper.DataEntry, len(m.entries))
+	copy(entries, m.entries)
+	return entries, nil
+}
+
+func (m *MemoryDatastore) Init() error {
+	return nil
+}
+
+func (m *MemoryDatastore) Compact(entries []*hyper.DataEntry) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.entries = make([]*hyper.DataEntry, len(entries))
+	copy(m.entries, entries)
+	return nil
+}
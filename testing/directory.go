@@ -0,0 +1,59 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package testing
+
+import (
+	"github.com/kiprotect/hyper"
+	"sync"
+)
+
+// A directory with fixed entries, tests can change the entries in place
+// to simulate directory updates
+type MemoryDirectory struct {
+	hyper.BaseDirectory
+	entries []*hyper.DirectoryEntry
+	mutex   sync.Mutex
+}
+
+func MakeMemoryDirectory(name string, entries ...*hyper.DirectoryEntry) *MemoryDirectory {
+	return &MemoryDirectory{
+		BaseDirectory: hyper.BaseDirectory{Name_: name},
+		entries:       entries,
+	}
+}
+
+func (d *MemoryDirectory) Entries(query *hyper.DirectoryQuery) ([]*hyper.DirectoryEntry, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	entries := make([]*hyper.DirectoryEntry, len(d.entries))
+	copy(entries, d.entries)
+	return hyper.FilterDirectoryEntriesByQuery(entries, query), nil
+}
+
+func (d *MemoryDirectory) EntryFor(name string) (*hyper.DirectoryEntry, error) {
+	if entries, err := d.Entries(&hyper.DirectoryQuery{Operator: name}); err != nil {
+		return nil, err
+	} else if len(entries) == 0 {
+		return nil, hyper.NoEntryFound
+	} else {
+		return entries[0], nil
+	}
+}
+
+func (d *MemoryDirectory) OwnEntry() (*hyper.DirectoryEntry, error) {
+	return d.EntryFor(d.Name())
+}
@@ -43,5 +43,8 @@ func (c MessageBroker) Setup(fixtures map[string]interface{}) (interface{}, erro
 }
 
 func (c MessageBroker) Teardown(fixture interface{}) error {
+	if broker, ok := fixture.(*hyper.BasicMessageBroker); ok {
+		broker.Stop()
+	}
 	return nil
 }
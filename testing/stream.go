@@ -0,0 +1,117 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package testing
+
+import (
+	"context"
+	"fmt"
+	"github.com/kiprotect/hyper"
+	"io"
+	"sync"
+)
+
+// Makes the chunk with the given number, starting at 1
+type ChunkMaker func(n int) *hyper.Response
+
+// A stream that makes its chunks when they are requested. It ends with the
+// error of its context, and records how many chunks it produced and whether
+// it was closed.
+type MemoryStream struct {
+	ctx       context.Context
+	chunks    int
+	makeChunk ChunkMaker
+	failAfter int
+	produced  int
+	closed    chan bool
+	once      sync.Once
+	mutex     sync.Mutex
+}
+
+// Makes a stream of the given number of chunks, or of an unlimited number
+// if it is negative. The chunks have a 'chunk' result with their number.
+func MakeMemoryStream(ctx context.Context, chunks int) *MemoryStream {
+	return &MemoryStream{
+		ctx:    ctx,
+		chunks: chunks,
+		makeChunk: func(n int) *hyper.Response {
+			return &hyper.Response{Result: map[string]interface{}{"chunk": float64(n)}}
+		},
+		closed: make(chan bool),
+	}
+}
+
+// Makes a stream of the given chunks
+func MakeChunkStream(chunks ...*hyper.Response) *MemoryStream {
+	stream := MakeMemoryStream(context.Background(), len(chunks))
+	stream.makeChunk = func(n int) *hyper.Response {
+		return chunks[n-1]
+	}
+	return stream
+}
+
+// Replaces the function that makes the chunks
+func (s *MemoryStream) SetChunkMaker(makeChunk ChunkMaker) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.makeChunk = makeChunk
+}
+
+// Makes the stream fail after the given number of chunks
+func (s *MemoryStream) SetFailAfter(chunks int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failAfter = chunks
+}
+
+func (s *MemoryStream) Next() (*hyper.Response, error) {
+
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.failAfter > 0 && s.produced >= s.failAfter {
+		return nil, fmt.Errorf("failed after %d chunks", s.failAfter)
+	}
+
+	if s.chunks >= 0 && s.produced >= s.chunks {
+		return nil, io.EOF
+	}
+
+	s.produced++
+
+	return s.makeChunk(s.produced), nil
+}
+
+// Returns the number of chunks that the stream produced so far
+func (s *MemoryStream) Produced() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.produced
+}
+
+// Returns a channel that is closed when the stream is closed
+func (s *MemoryStream) Closed() <-chan bool {
+	return s.closed
+}
+
+func (s *MemoryStream) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
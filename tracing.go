@@ -0,0 +1,272 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+type TracingSettings struct {
+	// OTLP/JSON file the spans get appended to
+	File string `json:"file"`
+	// OTLP/HTTP collector endpoint, e.g. http://localhost:4318/v1/traces
+	Endpoint string `json:"endpoint"`
+	// number of seconds between two exports
+	Interval int64 `json:"interval"`
+}
+
+type SpanKind int
+
+// these correspond to the OTLP span kinds
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// Trace context as defined by the W3C Trace Context specification
+type TraceContext struct {
+	TraceID string
+	SpanID  string
+	Flags   byte
+}
+
+var traceParentRegexp = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+func ParseTraceParent(traceParent string) (*TraceContext, error) {
+
+	groups := traceParentRegexp.FindStringSubmatch(traceParent)
+
+	if groups == nil {
+		return nil, fmt.Errorf("invalid traceparent: '%s'", traceParent)
+	}
+
+	// all-zero IDs are invalid according to the specification
+	if groups[1] == "00000000000000000000000000000000" || groups[2] == "0000000000000000" {
+		return nil, fmt.Errorf("invalid traceparent: '%s'", traceParent)
+	}
+
+	flags, err := hex.DecodeString(groups[3])
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &TraceContext{
+		TraceID: groups[1],
+		SpanID:  groups[2],
+		Flags:   flags[0],
+	}, nil
+}
+
+func (t *TraceContext) TraceParent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", t.TraceID, t.SpanID, t.Flags)
+}
+
+type Span struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	Kind         SpanKind
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{}
+	// empty if the step succeeded
+	Error string
+	flags byte
+}
+
+func (s *Span) TraceContext() *TraceContext {
+	return &TraceContext{
+		TraceID: s.TraceID,
+		SpanID:  s.SpanID,
+		Flags:   s.flags,
+	}
+}
+
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.Attributes[key] = value
+}
+
+// Ends the span, recording the error (if any) and the response code
+func (s *Span) Finish(response *Response, err error) {
+	s.End = time.Now()
+	if err != nil {
+		s.Error = err.Error()
+	} else if response != nil && response.Error != nil {
+		s.SetAttribute("hyper.code", response.Error.Code)
+		s.Error = response.Error.Message
+	}
+	tracer.record(s)
+}
+
+type spanContextKey struct{}
+
+func SpanFromContext(ctx context.Context) *Span {
+	if span, ok := ctx.Value(spanContextKey{}).(*Span); ok {
+		return span
+	}
+	return nil
+}
+
+func randomHex(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// Starts a new span for the given request. The span becomes a child of the
+// span in the request context or, if there is none, of the trace context
+// that was sent along with the request. The request context and trace
+// context are updated so that later steps (including remote ones) become
+// children of the new span.
+func StartSpan(request *Request, name string, kind SpanKind) *Span {
+
+	span := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: map[string]interface{}{"hyper.method": request.Method},
+		// we sample everything by default
+		flags: 1,
+	}
+
+	if parent := SpanFromContext(request.Context()); parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentSpanID = parent.SpanID
+		span.flags = parent.flags
+	} else if request.Trace != nil {
+		span.TraceID = request.Trace.TraceID
+		span.ParentSpanID = request.Trace.SpanID
+		span.flags = request.Trace.Flags
+	} else if traceID, err := randomHex(16); err != nil {
+		Log.Errorf("Error generating trace ID: %v", err)
+	} else {
+		span.TraceID = traceID
+	}
+
+	if spanID, err := randomHex(8); err != nil {
+		Log.Errorf("Error generating span ID: %v", err)
+	} else {
+		span.SpanID = spanID
+	}
+
+	request.SetContext(context.WithValue(request.Context(), spanContextKey{}, span))
+	request.Trace = span.TraceContext()
+
+	return span
+}
+
+type SpanExporter interface {
+	Export(spans []*Span) error
+	Close() error
+}
+
+// collects finished spans and exports them in batches
+type spanTracer struct {
+	exporter SpanExporter
+	spans    []*Span
+	mutex    sync.Mutex
+	stop     chan bool
+}
+
+var tracer = &spanTracer{}
+
+func (t *spanTracer) record(span *Span) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	// we only keep spans if they can be exported
+	if t.exporter == nil || span.flags&1 == 0 {
+		return
+	}
+	t.spans = append(t.spans, span)
+}
+
+func (t *spanTracer) flush() {
+
+	t.mutex.Lock()
+	spans := t.spans
+	exporter := t.exporter
+	t.spans = nil
+	t.mutex.Unlock()
+
+	if len(spans) == 0 || exporter == nil {
+		return
+	}
+
+	if err := exporter.Export(spans); err != nil {
+		Log.Errorf("Error exporting %d spans: %v", len(spans), err)
+	}
+}
+
+// Starts exporting spans with the given exporter in the background. Without
+// an exporter the trace context is still propagated but spans are dropped.
+func StartTracing(exporter SpanExporter, interval time.Duration) {
+
+	tracer.mutex.Lock()
+	tracer.exporter = exporter
+	tracer.stop = make(chan bool)
+	stop := tracer.stop
+	tracer.mutex.Unlock()
+
+	go func() {
+		for {
+			select {
+			case <-stop:
+				tracer.flush()
+				stop <- true
+				return
+			case <-time.After(interval):
+				tracer.flush()
+			}
+		}
+	}()
+}
+
+// Exports all remaining spans and closes the exporter
+func StopTracing() {
+
+	tracer.mutex.Lock()
+	stop := tracer.stop
+	exporter := tracer.exporter
+	tracer.mutex.Unlock()
+
+	if stop == nil {
+		return
+	}
+
+	stop <- true
+	<-stop
+
+	tracer.mutex.Lock()
+	tracer.exporter = nil
+	tracer.stop = nil
+	tracer.mutex.Unlock()
+
+	if err := exporter.Close(); err != nil {
+		Log.Errorf("Error closing span exporter: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/hyper"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Appends spans to a file, one OTLP/JSON export request per line
+type FileExporter struct {
+	serviceName string
+	file        *os.File
+	mutex       sync.Mutex
+}
+
+func MakeFileExporter(filename, serviceName string) (*FileExporter, error) {
+
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+
+	if err != nil {
+		return nil, fmt.Errorf("error opening trace file: %w", err)
+	}
+
+	return &FileExporter{
+		serviceName: serviceName,
+		file:        file,
+	}, nil
+}
+
+func (f *FileExporter) Export(spans []*hyper.Span) error {
+
+	data, err := json.Marshal(OTLPTraces(f.serviceName, spans))
+
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	_, err = f.file.Write(append(data, '\n'))
+
+	return err
+}
+
+func (f *FileExporter) Close() error {
+	return f.file.Close()
+}
+
+// Sends spans to an OTLP/HTTP collector using the JSON encoding
+type CollectorExporter struct {
+	serviceName string
+	endpoint    string
+	client      *http.Client
+}
+
+func MakeCollectorExporter(endpoint, serviceName string) *CollectorExporter {
+	return &CollectorExporter{
+		serviceName: serviceName,
+		endpoint:    endpoint,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *CollectorExporter) Export(spans []*hyper.Span) error {
+
+	data, err := json.Marshal(OTLPTraces(c.serviceName, spans))
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Post(c.endpoint, "application/json", bytes.NewReader(data))
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	// we read the body so that the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (c *CollectorExporter) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+func MakeSpanExporter(settings *hyper.TracingSettings, serviceName string) (hyper.SpanExporter, error) {
+	if settings.File != "" {
+		return MakeFileExporter(settings.File, serviceName)
+	} else if settings.Endpoint != "" {
+		return MakeCollectorExporter(settings.Endpoint, serviceName), nil
+	}
+	return nil, fmt.Errorf("either a file or an endpoint is required for tracing")
+}
+
+// Starts exporting spans as configured in the settings
+func StartTracing(settings *hyper.TracingSettings, serviceName string) error {
+
+	exporter, err := MakeSpanExporter(settings, serviceName)
+
+	if err != nil {
+		return err
+	}
+
+	hyper.StartTracing(exporter, time.Duration(settings.Interval)*time.Second)
+
+	return nil
+}
@@ -0,0 +1,243 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/kiprotect/hyper"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testSpans() []*hyper.Span {
+	start := time.Unix(1700000000, 0)
+	return []*hyper.Span{
+		{
+			TraceID:    "4bf92f3577b34da6a3ce929d0e0e4736",
+			SpanID:     "00f067aa0ba902b7",
+			Name:       "broker.deliver",
+			Kind:       hyper.SpanKindInternal,
+			Start:      start,
+			End:        start.Add(time.Second),
+			Attributes: map[string]interface{}{"hyper.method": "op-1.add"},
+		},
+		{
+			TraceID:      "4bf92f3577b34da6a3ce929d0e0e4736",
+			SpanID:       "b7ad6b7169203331",
+			ParentSpanID: "00f067aa0ba902b7",
+			Name:         "channel.deliver",
+			Kind:         hyper.SpanKindClient,
+			Start:        start,
+			End:          start.Add(time.Second),
+			Attributes:   map[string]interface{}{"hyper.code": 500},
+			Error:        "failed",
+		},
+	}
+}
+
+// returns the spans of an OTLP/JSON export request
+func exportedSpans(t *testing.T, data []byte) []interface{} {
+
+	var request struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []map[string]interface{} `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []interface{} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+
+	if err := json.Unmarshal(data, &request); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(request.ResourceSpans) != 1 || len(request.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("expected a single resource and scope")
+	}
+
+	resource := request.ResourceSpans[0]
+
+	if attributes := resource.Resource.Attributes; len(attributes) != 1 || attributes[0]["key"] != "service.name" {
+		t.Fatalf("expected the service name")
+	} else if value, _ := attributes[0]["value"].(map[string]interface{}); value["stringValue"] != "op-1" {
+		t.Fatalf("expected the service name 'op-1'")
+	}
+
+	return resource.ScopeSpans[0].Spans
+}
+
+func TestFileExporter(t *testing.T) {
+
+	filename := filepath.Join(t.TempDir(), "traces.json")
+
+	exporter, err := MakeFileExporter(filename, "op-1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := testSpans()
+
+	if err := exporter.Export(spans[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := exporter.Export(spans); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(filename)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	// every export is a separate line
+	lines := [][]byte{}
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		lines = append(lines, append([]byte{}, scanner.Bytes()...))
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(lines) != 2 {
+		t.Fatalf("expected two lines, got %d", len(lines))
+	}
+
+	if exported := exportedSpans(t, lines[0]); len(exported) != 1 {
+		t.Fatalf("expected one span in the first export")
+	}
+
+	exported := exportedSpans(t, lines[1])
+
+	if len(exported) != 2 {
+		t.Fatalf("expected two spans in the second export")
+	}
+
+	span, _ := exported[1].(map[string]interface{})
+
+	if span["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" || span["spanId"] != "b7ad6b7169203331" || span["parentSpanId"] != "00f067aa0ba902b7" {
+		t.Fatalf("unexpected span IDs: %v", span)
+	}
+
+	if span["name"] != "channel.deliver" || span["kind"] != 3.0 {
+		t.Fatalf("unexpected span name or kind: %v", span)
+	}
+
+	if span["startTimeUnixNano"] != "1700000000000000000" || span["endTimeUnixNano"] != "1700000001000000000" {
+		t.Fatalf("unexpected span times: %v", span)
+	}
+
+	if status, _ := span["status"].(map[string]interface{}); status["code"] != 2.0 || status["message"] != "failed" {
+		t.Fatalf("expected an error status: %v", span["status"])
+	}
+
+	// the exporter appends to existing files
+	exporter, err = MakeFileExporter(filename, "op-1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := exporter.Export(spans); err != nil {
+		t.Fatal(err)
+	}
+
+	exporter.Close()
+
+	if data, err := ioutil.ReadFile(filename); err != nil {
+		t.Fatal(err)
+	} else if lines := bytes.Count(data, []byte("\n")); lines != 3 {
+		t.Fatalf("expected three lines, got %d", lines)
+	}
+}
+
+func TestCollectorExporter(t *testing.T) {
+
+	bodies := make(chan []byte, 1)
+	status := http.StatusOK
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		code := status
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- body
+		w.WriteHeader(code)
+	}))
+
+	defer server.Close()
+
+	exporter := MakeCollectorExporter(server.URL, "op-1")
+	defer exporter.Close()
+
+	if err := exporter.Export(testSpans()); err != nil {
+		t.Fatal(err)
+	}
+
+	if exported := exportedSpans(t, <-bodies); len(exported) != 2 {
+		t.Fatalf("expected two spans")
+	}
+
+	status = http.StatusInternalServerError
+
+	if err := exporter.Export(testSpans()); err == nil {
+		t.Fatalf("expected an error for a failed export")
+	}
+}
+
+func TestMakeSpanExporter(t *testing.T) {
+
+	if _, err := MakeSpanExporter(&hyper.TracingSettings{}, "op-1"); err == nil {
+		t.Fatalf("expected an error without a file or endpoint")
+	}
+
+	if exporter, err := MakeSpanExporter(&hyper.TracingSettings{Endpoint: "http://localhost:4318/v1/traces"}, "op-1"); err != nil {
+		t.Fatal(err)
+	} else if _, ok := exporter.(*CollectorExporter); !ok {
+		t.Fatalf("expected a collector exporter")
+	}
+
+	if exporter, err := MakeSpanExporter(&hyper.TracingSettings{File: filepath.Join(t.TempDir(), "traces.json")}, "op-1"); err != nil {
+		t.Fatal(err)
+	} else if _, ok := exporter.(*FileExporter); !ok {
+		t.Fatalf("expected a file exporter")
+	} else {
+		exporter.Close()
+	}
+}
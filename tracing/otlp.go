@@ -0,0 +1,110 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package tracing
+
+import (
+	"fmt"
+	"github.com/kiprotect/hyper"
+	"strconv"
+)
+
+// OTLP status codes
+const (
+	statusUnset = 0
+	statusError = 2
+)
+
+func otlpValue(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": v}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	case int:
+		// 64 bit integers are encoded as strings in OTLP/JSON
+		return map[string]interface{}{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": v}
+	default:
+		return map[string]interface{}{"stringValue": fmt.Sprintf("%v", v)}
+	}
+}
+
+func otlpAttributes(attributes map[string]interface{}) []map[string]interface{} {
+	otlpAttributes := make([]map[string]interface{}, 0, len(attributes))
+	for key, value := range attributes {
+		otlpAttributes = append(otlpAttributes, map[string]interface{}{
+			"key":   key,
+			"value": otlpValue(value),
+		})
+	}
+	return otlpAttributes
+}
+
+func otlpSpan(span *hyper.Span) map[string]interface{} {
+
+	status := map[string]interface{}{"code": statusUnset}
+
+	if span.Error != "" {
+		status = map[string]interface{}{"code": statusError, "message": span.Error}
+	}
+
+	otlpSpan := map[string]interface{}{
+		"traceId":           span.TraceID,
+		"spanId":            span.SpanID,
+		"name":              span.Name,
+		"kind":              int(span.Kind),
+		"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+		"attributes":        otlpAttributes(span.Attributes),
+		"status":            status,
+	}
+
+	if span.ParentSpanID != "" {
+		otlpSpan["parentSpanId"] = span.ParentSpanID
+	}
+
+	return otlpSpan
+}
+
+// Returns an OTLP/JSON trace export request with the given spans
+func OTLPTraces(serviceName string, spans []*hyper.Span) map[string]interface{} {
+
+	otlpSpans := make([]map[string]interface{}, 0, len(spans))
+
+	for _, span := range spans {
+		otlpSpans = append(otlpSpans, otlpSpan(span))
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]interface{}{"service.name": serviceName}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "hyper"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,101 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package tracing
+
+import (
+	"github.com/kiprotect/hyper"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOTLPValue(t *testing.T) {
+
+	for _, test := range []struct {
+		value    interface{}
+		expected map[string]interface{}
+	}{
+		{"test", map[string]interface{}{"stringValue": "test"}},
+		{true, map[string]interface{}{"boolValue": true}},
+		// 64 bit integers are strings in OTLP/JSON
+		{404, map[string]interface{}{"intValue": "404"}},
+		{int64(-1) << 40, map[string]interface{}{"intValue": "-1099511627776"}},
+		{1.5, map[string]interface{}{"doubleValue": 1.5}},
+		{[]string{"a"}, map[string]interface{}{"stringValue": "[a]"}},
+		{nil, map[string]interface{}{"stringValue": "<nil>"}},
+	} {
+		if value := otlpValue(test.value); !reflect.DeepEqual(value, test.expected) {
+			t.Errorf("expected %v for %v, got %v", test.expected, test.value, value)
+		}
+	}
+}
+
+func TestOTLPSpan(t *testing.T) {
+
+	start := time.Unix(0, 1500)
+
+	span := otlpSpan(&hyper.Span{
+		TraceID:    "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:     "00f067aa0ba902b7",
+		Name:       "broker.deliver",
+		Kind:       hyper.SpanKindServer,
+		Start:      start,
+		End:        start.Add(time.Millisecond),
+		Attributes: map[string]interface{}{"hyper.method": "op-1.add"},
+	})
+
+	// root spans have no parent
+	if _, ok := span["parentSpanId"]; ok {
+		t.Fatalf("expected no parent span ID")
+	}
+
+	if span["startTimeUnixNano"] != "1500" || span["endTimeUnixNano"] != "1001500" || span["kind"] != 2 {
+		t.Fatalf("unexpected span: %v", span)
+	}
+
+	if !reflect.DeepEqual(span["status"], map[string]interface{}{"code": statusUnset}) {
+		t.Fatalf("expected an unset status, got %v", span["status"])
+	}
+
+	expectedAttributes := []map[string]interface{}{{"key": "hyper.method", "value": map[string]interface{}{"stringValue": "op-1.add"}}}
+
+	if !reflect.DeepEqual(span["attributes"], expectedAttributes) {
+		t.Fatalf("unexpected attributes: %v", span["attributes"])
+	}
+}
+
+func TestOTLPTraces(t *testing.T) {
+
+	traces := OTLPTraces("op-1", nil)
+
+	resourceSpans, _ := traces["resourceSpans"].([]interface{})
+
+	if len(resourceSpans) != 1 {
+		t.Fatalf("expected a single resource")
+	}
+
+	scopeSpans, _ := resourceSpans[0].(map[string]interface{})["scopeSpans"].([]interface{})
+
+	if len(scopeSpans) != 1 {
+		t.Fatalf("expected a single scope")
+	}
+
+	// an empty export still contains a (non-null) list of spans
+	if spans, ok := scopeSpans[0].(map[string]interface{})["spans"].([]map[string]interface{}); !ok || spans == nil || len(spans) != 0 {
+		t.Fatalf("expected an empty list of spans")
+	}
+}
@@ -0,0 +1,187 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTraceParent(t *testing.T) {
+
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	trace, err := ParseTraceParent(traceParent)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if trace.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || trace.SpanID != "00f067aa0ba902b7" || trace.Flags != 1 {
+		t.Fatalf("unexpected trace context: %+v", trace)
+	}
+
+	if trace.TraceParent() != traceParent {
+		t.Fatalf("expected the same traceparent, got '%s'", trace.TraceParent())
+	}
+
+	for _, invalid := range []string{
+		"",
+		"00",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		// unknown version
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		// upper case hex digits
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		// short trace ID
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		// long span ID
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7aa-01",
+		// invalid flags
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1",
+		// all-zero IDs
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		// additional fields and whitespace
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-00",
+		" 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\n",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7_01",
+	} {
+		if trace, err := ParseTraceParent(invalid); err == nil || trace != nil {
+			t.Errorf("expected an error for traceparent %q", invalid)
+		}
+	}
+}
+
+func TestStartSpan(t *testing.T) {
+
+	trace, err := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := &Request{Method: "op-1.add", Trace: trace}
+
+	// the span continues the trace that came with the request
+	span := StartSpan(request, "server", SpanKindServer)
+
+	if span.TraceID != trace.TraceID || span.ParentSpanID != trace.SpanID || span.flags != 0 {
+		t.Fatalf("expected the span to continue the trace")
+	}
+
+	if len(span.SpanID) != 16 || span.SpanID == trace.SpanID {
+		t.Fatalf("expected a new span ID")
+	}
+
+	if span.Attributes["hyper.method"] != "op-1.add" {
+		t.Fatalf("expected the method attribute")
+	}
+
+	// later steps become children of the span
+	child := StartSpan(request, "client", SpanKindClient)
+
+	if child.TraceID != span.TraceID || child.ParentSpanID != span.SpanID {
+		t.Fatalf("expected a child span")
+	}
+
+	if request.Trace.SpanID != child.SpanID || SpanFromContext(request.Context()) != child {
+		t.Fatalf("expected the request to carry the child span")
+	}
+
+	// without a trace context we start a new trace
+	root := StartSpan(&Request{Method: "op-1.add"}, "server", SpanKindServer)
+
+	if len(root.TraceID) != 32 || root.TraceID == trace.TraceID || root.ParentSpanID != "" {
+		t.Fatalf("expected a new trace")
+	}
+
+	if _, err := ParseTraceParent(root.TraceContext().TraceParent()); err != nil {
+		t.Fatalf("expected a valid traceparent: %v", err)
+	}
+}
+
+type recordingExporter struct {
+	spans  []*Span
+	closed bool
+	mutex  sync.Mutex
+}
+
+func (r *recordingExporter) Export(spans []*Span) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *recordingExporter) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.closed = true
+	return nil
+}
+
+func TestTracingExport(t *testing.T) {
+
+	exporter := &recordingExporter{}
+
+	StartTracing(exporter, time.Hour)
+
+	request := &Request{Method: "op-1.add"}
+	request.SetContext(context.Background())
+
+	span := StartSpan(request, "broker.deliver", SpanKindInternal)
+	span.Finish(InvalidParams(nil, nil), nil)
+
+	failed := StartSpan(request, "channel.deliver", SpanKindClient)
+	failed.Finish(nil, context.DeadlineExceeded)
+
+	// spans that aren't sampled are dropped
+	unsampled := StartSpan(&Request{Trace: &TraceContext{TraceID: span.TraceID, SpanID: span.SpanID}}, "dropped", SpanKindInternal)
+	unsampled.Finish(nil, nil)
+
+	// the remaining spans are exported when tracing stops
+	StopTracing()
+
+	if !exporter.closed {
+		t.Fatalf("expected the exporter to be closed")
+	}
+
+	if len(exporter.spans) != 2 || exporter.spans[0] != span || exporter.spans[1] != failed {
+		t.Fatalf("expected two exported spans, got %d", len(exporter.spans))
+	}
+
+	if span.Attributes["hyper.code"] != -32602 || span.Error == "" || span.End.Before(span.Start) {
+		t.Fatalf("expected the error response to be recorded")
+	}
+
+	if !strings.Contains(failed.Error, "deadline exceeded") {
+		t.Fatalf("expected the error to be recorded")
+	}
+
+	// without an exporter spans are dropped
+	StartSpan(&Request{}, "dropped", SpanKindInternal).Finish(nil, nil)
+
+	if len(tracer.spans) != 0 {
+		t.Fatalf("expected no spans to be kept without an exporter")
+	}
+}
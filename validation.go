@@ -0,0 +1,120 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+import (
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+)
+
+// Returns the definition of the given method from the services of the entry
+func MethodFor(entry *DirectoryEntry, method string) *ServiceMethod {
+	for _, service := range entry.Services {
+		for _, serviceMethod := range service.Methods {
+			if serviceMethod.Name == method {
+				return serviceMethod
+			}
+		}
+	}
+	return nil
+}
+
+// Returns a form that validates parameters against the parameter definitions
+// of the method. The validator types map to the validators of the forms module.
+func (s *ServiceMethod) ParamsForm() (*forms.Form, error) {
+
+	context := &forms.FormDescriptionContext{
+		Validators: forms.Validators,
+	}
+
+	form := &forms.Form{
+		ErrorMsg: "invalid params",
+		Fields:   make([]forms.Field, 0, len(s.Parameters)),
+	}
+
+	for _, parameter := range s.Parameters {
+		validators := make([]forms.Validator, 0, len(parameter.Validators))
+		for _, serviceValidator := range parameter.Validators {
+			config := serviceValidator.Parameters
+			if config == nil {
+				config = map[string]interface{}{}
+			}
+			if validator, err := forms.ValidatorFromDescription(&forms.ValidatorDescription{
+				Type:   serviceValidator.Type,
+				Config: config,
+			}, context); err != nil {
+				return nil, fmt.Errorf("invalid validator for parameter '%s': %w", parameter.Name, err)
+			} else {
+				validators = append(validators, validator)
+			}
+		}
+		form.Fields = append(form.Fields, forms.Field{
+			Name:       parameter.Name,
+			Validators: validators,
+		})
+	}
+
+	return form, nil
+}
+
+// Validates the given parameters against the parameter definitions of the
+// method. Returns the validation error for each invalid parameter, or nil if
+// all parameters are valid.
+func (s *ServiceMethod) ValidateParams(params map[string]interface{}) (map[string]interface{}, error) {
+
+	if len(s.Parameters) == 0 {
+		return nil, nil
+	}
+
+	form, err := s.ParamsForm()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+
+	_, err = form.Validate(params)
+
+	if err == nil {
+		return nil, nil
+	}
+
+	formError, ok := err.(*forms.FormError)
+
+	if !ok {
+		return nil, err
+	}
+
+	errors := map[string]interface{}{}
+
+	if data, ok := formError.Data().(map[string]interface{}); ok {
+		for key, value := range data {
+			// we only include the messages so that the errors can be
+			// serialized for all channels
+			if valueErr, ok := value.(error); ok {
+				errors[key] = valueErr.Error()
+			} else {
+				errors[key] = fmt.Sprint(value)
+			}
+		}
+	}
+
+	return errors, nil
+}
@@ -0,0 +1,61 @@
+// KIProtect Hyper
+// Copyright (C) 2021-2023 KIProtect GmbH
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hyper
+
+import (
+	"testing"
+)
+
+func TestValidateParams(t *testing.T) {
+
+	method := &ServiceMethod{
+		Name: "add",
+		Parameters: []*ServiceParameter{
+			{
+				Name: "name",
+				Validators: []*ServiceValidator{
+					{Type: "IsString", Parameters: map[string]interface{}{"maxLength": 5}},
+				},
+			},
+			{
+				Name: "count",
+				Validators: []*ServiceValidator{
+					{Type: "IsOptional"},
+					{Type: "IsInteger"},
+				},
+			},
+		},
+	}
+
+	if errors, err := method.ValidateParams(map[string]interface{}{"name": "foo", "_client": map[string]interface{}{}}); err != nil {
+		t.Fatal(err)
+	} else if errors != nil {
+		t.Fatalf("expected valid parameters, got %v", errors)
+	}
+
+	if errors, err := method.ValidateParams(map[string]interface{}{"name": "foobar", "count": "x"}); err != nil {
+		t.Fatal(err)
+	} else if errors["name"] == nil || errors["count"] == nil {
+		t.Fatalf("expected errors for both parameters, got %v", errors)
+	}
+
+	method.Parameters[0].Validators[0].Type = "IsUnknown"
+
+	if _, err := method.ValidateParams(map[string]interface{}{}); err == nil {
+		t.Fatalf("expected an error for an unknown validator type")
+	}
+}